@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/mj41/stai-vscode/internal/setup"
+)
+
+// SkipCheckFlag implements flag.Value for --skip-check=name,name
+type SkipCheckFlag map[string]bool
+
+func (s SkipCheckFlag) String() string {
+	var names []string
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func (s SkipCheckFlag) Set(value string) error {
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !slices.Contains(setup.CheckNames, name) {
+			return fmt.Errorf("unknown check '%s', must be one of: %s", name, strings.Join(setup.CheckNames, ", "))
+		}
+		s[name] = true
+	}
+	return nil
+}
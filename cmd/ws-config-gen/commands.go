@@ -0,0 +1,481 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sync"
+
+	"github.com/mj41/stai-vscode/internal/flags"
+	"github.com/mj41/stai-vscode/internal/setup"
+	"github.com/mj41/stai-vscode/internal/version"
+)
+
+// Command represents a ws-config-gen subcommand
+type Command struct {
+	Name        string
+	Usage       string
+	Description string
+	Run         func(args []string) error
+	// Args returns known positional arguments for shell completion
+	Args func() []string
+}
+
+// commands returns all available subcommands
+func commands() []Command {
+	return []Command{
+		{
+			Name:        "add-repo",
+			Usage:       "add-repo --name NAME --url URL [--type git-repo|local-git-repo|dir]",
+			Description: "Add a repository to the user config, clone it and regenerate the workspace file",
+			Run:         runAddRepo,
+		},
+		{
+			Name:        "audit",
+			Usage:       "audit [--output text|json]",
+			Description: "Show the audit trail of all changes made by setup runs and commands",
+			Run:         runAudit,
+		},
+		{
+			Name:        "compare",
+			Usage:       "compare LOCKFILE|SNAPSHOT [--output text|json]",
+			Description: "Show how the environment differs from a revision lock or snapshot of another one",
+			Run:         runCompare,
+		},
+		{
+			Name:        "completion",
+			Usage:       "completion bash|zsh|fish",
+			Description: "Print shell completion script",
+			Run:         runCompletion,
+			Args:        func() []string { return flags.CompletionShells },
+		},
+		{
+			Name:        "diff",
+			Usage:       "diff",
+			Description: "Show changes regenerating the workspace file would make",
+			Run:         runDiff,
+		},
+		{
+			Name:        "discover",
+			Usage:       "discover --github-org ORG|--gitlab-group GROUP [--topic TOPIC] [--name-filter PATTERN] [FILE]",
+			Description: "Write or update a config with the repositories of a GitHub organization or GitLab group",
+			Run:         runDiscover,
+		},
+		{
+			Name:        "doctor",
+			Usage:       "doctor",
+			Description: "Run environment and preflight checks without changing anything",
+			Run:         runDoctor,
+		},
+		{
+			Name:        "env",
+			Usage:       "env list|create NAME [--base-dir DIR]|switch NAME",
+			Description: "List, create or switch named environments with their own base directory and config overlay",
+			Run:         runEnv,
+			Args:        func() []string { return []string{"list", "create", "switch"} },
+		},
+		{
+			Name:        "export",
+			Usage:       "export [ARCHIVE]",
+			Description: "Archive configs, revisions, stai-temp, local repositories and workspace files for another machine",
+			Run:         runExport,
+		},
+		{
+			Name:        "import",
+			Usage:       "import [FILE] | import --archive ARCHIVE",
+			Description: "Write a config describing the repositories found in the base directory, or restore an exported environment",
+			Run:         runImport,
+		},
+		{
+			Name:        "install-service",
+			Usage:       "install-service [--schedule CALENDAR]",
+			Description: "Install a systemd user timer keeping the environment in sync with the config",
+			Run:         runInstallService,
+		},
+		{
+			Name:        "list",
+			Usage:       "list [--output text|json]",
+			Description: "List configured repositories and whether they are checked out",
+			Run:         runList,
+		},
+		{
+			Name:        "mirror",
+			Usage:       "mirror sync",
+			Description: "Create or refresh bare mirrors in --mirror-dir",
+			Run:         runMirror,
+			Args:        func() []string { return []string{"sync"} },
+		},
+		{
+			Name:        "remote-setup",
+			Usage:       "remote-setup [USER@]HOST [--base-dir DIR] [--remote-workspace FILE]",
+			Description: "Copy this binary to a machine and run the setup there over SSH",
+			Run:         runRemoteSetup,
+		},
+		{
+			Name:        "remove-repo",
+			Usage:       "remove-repo NAME [--delete]",
+			Description: "Remove a repository from the user config and workspace file, archiving its checkout",
+			Run:         runRemoveRepo,
+			Args:        configRepoNames,
+		},
+		{
+			Name:        "restore",
+			Usage:       "restore SNAPSHOT",
+			Description: "Check out the branches and commits recorded by a snapshot",
+			Run:         runRestore,
+		},
+		{
+			Name:        "self-update",
+			Usage:       "self-update [--channel stable|insiders]",
+			Description: "Replace this binary with the latest verified GitHub release",
+			Run:         runSelfUpdate,
+		},
+		{
+			Name:        "snapshot",
+			Usage:       "snapshot [FILE]",
+			Description: "Record the branch, commit and uncommitted files of every repository",
+			Run:         runSnapshot,
+		},
+		{
+			Name:        "verify",
+			Usage:       "verify",
+			Description: "Check that directories, repositories and workspace folders match the config",
+			Run:         runVerify,
+		},
+		{
+			Name:        "version",
+			Usage:       "version [deps] [--verbose] [--output json|cyclonedx]",
+			Description: "Show version information or module dependencies",
+			Run:         runVersion,
+			Args:        func() []string { return []string{"deps"} },
+		},
+	}
+}
+
+// findCommand returns the subcommand with the given name
+func findCommand(name string) (Command, bool) {
+	for _, cmd := range commands() {
+		if cmd.Name == name {
+			return cmd, true
+		}
+	}
+	return Command{}, false
+}
+
+// runCommand dispatches positional arguments to a subcommand.
+// Global flags are also accepted after the command name.
+func runCommand(args []string) error {
+	cmd, ok := findCommand(args[0])
+	if !ok {
+		return setup.ValidationErrorf("unknown command '%s'", args[0])
+	}
+
+	fs := flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	positional, err := parseInterspersed(fs, args[1:])
+	if err != nil {
+		return &setup.ValidationError{Err: err}
+	}
+
+	return cmd.Run(positional)
+}
+
+// parseInterspersed parses flags mixed with positional arguments,
+// everything after "--" is positional
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		// fs.Parse consumes a "--" terminator, keep the remainder as is
+		if parsed := len(args) - len(rest); parsed > 0 && args[parsed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+func runAddRepo(args []string) error {
+	if len(args) != 0 {
+		return setup.ValidationErrorf("usage: ws-config-gen add-repo --name NAME --url URL [--type git-repo|local-git-repo|dir]")
+	}
+	s := newSetup()
+	s.Interactive = false
+	return s.AddRepo(repoNameFlag, repoURLFlag, repoTypeFlag)
+}
+
+func runAudit(args []string) error {
+	if len(args) != 0 {
+		return setup.ValidationErrorf("usage: ws-config-gen audit [--output text|json]")
+	}
+	s := newSetup()
+	s.Interactive = false
+	return s.Audit(commonFlags.Output)
+}
+
+func runCompare(args []string) error {
+	if len(args) != 1 {
+		return setup.ValidationErrorf("usage: ws-config-gen compare LOCKFILE|SNAPSHOT [--output text|json]")
+	}
+	s := newSetup()
+	s.Interactive = false
+	return s.Compare(args[0], commonFlags.Output)
+}
+
+func runDiff(args []string) error {
+	if len(args) != 0 {
+		return setup.ValidationErrorf("usage: ws-config-gen diff")
+	}
+	s := newSetup()
+	s.Interactive = false
+	return s.Diff()
+}
+
+func runDiscover(args []string) error {
+	if len(args) > 1 {
+		return setup.ValidationErrorf("usage: ws-config-gen discover --github-org ORG|--gitlab-group GROUP [--topic TOPIC] [--name-filter PATTERN] [FILE]")
+	}
+	file := "-"
+	if len(args) == 1 {
+		file = args[0]
+	}
+	s := newSetup()
+	s.Interactive = false
+	return s.Discover(setup.DiscoverOptions{
+		GitHubOrg:   githubOrgFlag,
+		GitLabGroup: gitlabGroupFlag,
+		APIURL:      apiURLFlag,
+		Topics:      topicFlag,
+		NameFilter:  nameFilterFlag,
+	}, file)
+}
+
+func runDoctor(args []string) error {
+	if len(args) != 0 {
+		return setup.ValidationErrorf("usage: ws-config-gen doctor")
+	}
+	s := newSetup()
+	s.Interactive = false
+	return s.Doctor()
+}
+
+func runEnv(args []string) error {
+	usage := setup.ValidationErrorf("usage: ws-config-gen env list [--output text|json]|create NAME [--base-dir DIR]|switch NAME")
+	if len(args) == 0 {
+		return usage
+	}
+	s := newSetup()
+	s.Interactive = false
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		return s.ListEnvironments(commonFlags.Output)
+	case args[0] == "create" && len(args) == 2:
+		return s.CreateEnvironment(args[1], baseDirFlag)
+	case args[0] == "switch" && len(args) == 2:
+		return s.SwitchEnvironment(args[1])
+	}
+	return usage
+}
+
+func runExport(args []string) error {
+	if len(args) > 1 {
+		return setup.ValidationErrorf("usage: ws-config-gen export [ARCHIVE]")
+	}
+	archive := ""
+	if len(args) == 1 {
+		archive = args[0]
+	}
+	s := newSetup()
+	s.Interactive = false
+	return s.Export(archive)
+}
+
+func runImport(args []string) error {
+	if archiveFlag != "" {
+		return runImportArchive(args)
+	}
+	if len(args) > 1 {
+		return setup.ValidationErrorf("usage: ws-config-gen import [FILE]")
+	}
+	path := "-"
+	if len(args) == 1 {
+		path = args[0]
+	}
+	s := newSetup()
+	s.Interactive = false
+	return s.Import(path)
+}
+
+// runImportArchive restores an exported environment and sets it up,
+// cloning the repositories not included in the archive
+func runImportArchive(args []string) error {
+	if len(args) != 0 {
+		return setup.ValidationErrorf("usage: ws-config-gen import --archive ARCHIVE")
+	}
+	s := newSetup()
+	if err := s.ImportArchive(archiveFlag); err != nil {
+		return err
+	}
+	err := s.Run()
+	fmt.Println()
+	s.PrintSummary()
+	return err
+}
+
+func runInstallService(args []string) error {
+	if len(args) != 0 {
+		return setup.ValidationErrorf("usage: ws-config-gen install-service [--schedule CALENDAR]")
+	}
+	s := newSetup()
+	s.Interactive = false
+	return s.InstallService(scheduleFlag)
+}
+
+func runList(args []string) error {
+	if len(args) != 0 {
+		return setup.ValidationErrorf("usage: ws-config-gen list [--output text|json]")
+	}
+	s := newSetup()
+	s.Interactive = false
+	return s.List(commonFlags.Output)
+}
+
+func runMirror(args []string) error {
+	if len(args) != 1 || args[0] != "sync" {
+		return setup.ValidationErrorf("usage: ws-config-gen mirror sync --mirror-dir DIR")
+	}
+	s := newSetup()
+	s.Interactive = false
+	return s.SyncMirrors()
+}
+
+func runRemoteSetup(args []string) error {
+	if len(args) != 1 {
+		return setup.ValidationErrorf("usage: ws-config-gen remote-setup [USER@]HOST [--base-dir DIR] [--remote-workspace FILE]")
+	}
+	s := newSetup()
+	s.Interactive = false
+	return s.RemoteSetup(args[0], remoteWorkspaceFlag)
+}
+
+func runRemoveRepo(args []string) error {
+	if len(args) != 1 {
+		return setup.ValidationErrorf("usage: ws-config-gen remove-repo NAME [--delete]")
+	}
+	s := newSetup()
+	s.Interactive = false
+	return s.RemoveRepo(args[0], deleteFlag)
+}
+
+func runRestore(args []string) error {
+	if len(args) != 1 {
+		return setup.ValidationErrorf("usage: ws-config-gen restore SNAPSHOT")
+	}
+	s := newSetup()
+	s.Interactive = false
+	return s.Restore(args[0])
+}
+
+func runSnapshot(args []string) error {
+	if len(args) > 1 {
+		return setup.ValidationErrorf("usage: ws-config-gen snapshot [FILE]")
+	}
+	path := ""
+	if len(args) == 1 {
+		path = args[0]
+	}
+	s := newSetup()
+	s.Interactive = false
+	return s.Snapshot(path)
+}
+
+func runVerify(args []string) error {
+	if len(args) != 0 {
+		return setup.ValidationErrorf("usage: ws-config-gen verify")
+	}
+	s := newSetup()
+	s.Interactive = false
+	return s.Verify()
+}
+
+func runVersion(args []string) error {
+	switch {
+	case len(args) == 0:
+		flags.PrintVersion(commonFlags, "ws-config-gen")
+	case len(args) == 1 && args[0] == "deps":
+		flags.PrintDependencies(commonFlags, "ws-config-gen")
+	default:
+		return setup.ValidationErrorf("usage: ws-config-gen version [deps] [--verbose] [--output json|cyclonedx]")
+	}
+	return nil
+}
+
+func runCompletion(args []string) error {
+	if len(args) != 1 {
+		return setup.ValidationErrorf("usage: ws-config-gen completion bash|zsh|fish")
+	}
+
+	flagValues := map[string][]string{
+		"folder-paths": {setup.FolderPathsRelative, setup.FolderPathsAbsolute},
+		"editor":       setup.EditorNames(),
+		"output":       flags.OutputFormats,
+		"channel":      {version.ChannelStable, version.ChannelInsiders},
+		"skip-check":   setup.CheckNames,
+		"from-step":    setup.StepNames,
+		"only-step":    setup.StepNames,
+		"enable":       configRepoNames(),
+		"disable":      configRepoNames(),
+		"with":         configRepoNames(),
+		"type":         {"git-repo", "local-git-repo", "dir"},
+	}
+
+	spec := flags.CompletionSpec{
+		ToolName: "ws-config-gen",
+		Flags:    flags.CompletionFlags(flag.CommandLine, flagValues),
+	}
+	for _, cmd := range commands() {
+		var cmdArgs []string
+		if cmd.Args != nil {
+			cmdArgs = cmd.Args()
+		}
+		spec.Commands = append(spec.Commands, flags.CompletionCommand{
+			Name:        cmd.Name,
+			Description: cmd.Description,
+			Args:        cmdArgs,
+		})
+	}
+
+	script, err := flags.GenerateCompletion(args[0], spec)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(script)
+	return nil
+}
+
+// configRepoNames returns the repository names of the merged config
+// layers, those of the embedded config when they cannot be loaded. The
+// config is loaded once, completion asks for the names several times.
+var configRepoNames = sync.OnceValue(func() []string {
+	if names, err := newSetup().RepoNames(); err == nil {
+		return names
+	}
+	var config setup.Config
+	if err := json.Unmarshal(embeddedConfig, &config); err != nil {
+		return nil
+	}
+	var names []string
+	for _, repo := range config.Repos {
+		names = append(names, repo.Name)
+	}
+	return names
+})
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/mj41/stai-vscode/internal/flags"
+)
+
+// Embedded documentation for the --readme flag.
+
+//go:embed docs/ws-config-gen.md
+var readmeDoc string
+
+// getReadmeDoc returns the embedded full documentation.
+func getReadmeDoc() string {
+	return readmeDoc
+}
+
+// buildHelpContent returns the full --help output including
+// subcommands and all registered flags with their defaults.
+func buildHelpContent(config flags.FlagConfig) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Usage: %s\n", config.Usage)
+	fmt.Fprintf(&b, "%s\n", config.Description)
+
+	b.WriteString("\nCommands:\n")
+	for _, cmd := range commands() {
+		fmt.Fprintf(&b, "  %-28s %s\n", cmd.Usage, cmd.Description)
+	}
+
+	var defaults bytes.Buffer
+	flags.PrintDefaults(flag.CommandLine, &defaults)
+
+	b.WriteString("\nFlags:\n")
+	b.WriteString(defaults.String())
+
+	fmt.Fprintf(&b, "\nAll flags can also be set via %sNAME environment variables, command line flags take precedence\n", config.EnvPrefix)
+	b.WriteString("\nUse --readme to show full documentation\n")
+
+	return b.String()
+}
@@ -2,50 +2,17 @@ package main
 
 import (
 	_ "embed"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
-	"os/exec"
-	"os/user"
-	"path/filepath"
 	"strconv"
 	"strings"
-	"text/template"
+	"time"
 
 	"github.com/mj41/stai-vscode/internal/flags"
+	"github.com/mj41/stai-vscode/internal/setup"
 )
 
-//go:embed config/repos.json
-var embeddedConfig []byte
-
-// Default directory permissions for created directories
-const defaultDirPerms = 0750
-
-// Config represents the repositories configuration
-type Config struct {
-	Repos []Repository `json:"repos"`
-}
-
-// Repository represents a single repository configuration
-type Repository struct {
-	Name    string  `json:"name"`
-	GitRepo *string `json:"git-repo"`
-	Type    string  `json:"type"`
-}
-
-// TemplateData contains data for template processing
-type TemplateData struct {
-	Folders     string
-	BaseWorkDir string
-}
-
-// FolderEntry represents a folder in the VS Code workspace
-type FolderEntry struct {
-	Name string `json:"name,omitempty"`
-	Path string `json:"path"`
-}
-
 // ForceFlag implements flag.Value to handle --force and --force=N syntax
 type ForceFlag struct {
 	enabled bool
@@ -92,357 +59,286 @@ func (f *ForceFlag) IsBoolFlag() bool {
 	return true
 }
 
+// Level returns the number of warnings to ignore, 0 when --force is not
+// set and -1 for unlimited
+func (f *ForceFlag) Level() int {
+	if !f.enabled {
+		return 0
+	}
+	return f.level
+}
+
+// ListFlag implements flag.Value for comma separated names, the flag
+// can be repeated
+type ListFlag []string
+
+func (l *ListFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *ListFlag) Set(value string) error {
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			*l = append(*l, name)
+		}
+	}
+	return nil
+}
+
 var (
-	forceFlag    ForceFlag
-	warningCount int
+	forceFlag              ForceFlag
+	skipCheckFlag          = SkipCheckFlag{}
+	expectedUserFlag       string
+	baseDirFlag            string
+	workDirFlag            string
+	envFlag                string
+	containerFlag          string
+	containerToolFlag      string
+	folderPathsFlag        string
+	scheduleFlag           string
+	configFlag             string
+	configSHA256Flag       string
+	ciFlag                 bool
+	noColorFlag            bool
+	quietFlag              bool
+	changesOnlyFlag        bool
+	mirrorDirFlag          string
+	skipPreflightFlag      bool
+	cloneAttemptsFlag      int
+	cloneBackoffFlag       time.Duration
+	jobsFlag               int
+	maxRateFlag            string
+	timeoutFlag            time.Duration
+	strictPermsFlag        bool
+	logFileFlag            string
+	rollbackFlag           bool
+	archiveFlag            string
+	remoteWorkspaceFlag    string
+	overwriteWorkspaceFlag bool
+	noBackupFlag           bool
+	backupKeepFlag         int
+	openFlag               bool
+	installExtensionsFlag  bool
+	editorFlag             string
+	channelFlag            string
+	summaryJSONFlag        string
+	planOutFlag            string
+	watchFlag              bool
+	applyFlag              string
+	fromStepFlag           string
+	onlyStepFlag           string
+	enableFlag             ListFlag
+	disableFlag            ListFlag
+	withFlag               ListFlag
+	repoNameFlag           string
+	repoURLFlag            string
+	repoTypeFlag           string
+	deleteFlag             bool
+	githubOrgFlag          string
+	gitlabGroupFlag        string
+	apiURLFlag             string
+	topicFlag              ListFlag
+	nameFilterFlag         string
+	commonFlags            *flags.CommonFlags
 )
 
 func main() {
 	// Setup command line flags
 	flagConfig := flags.FlagConfig{
-		ToolName:    "ws-config-gen",
-		Usage:       "ws-config-gen [--force[=N|-1]] [--version] [--help]",
-		Description: "Generate Visual Studio Code workspace configuration for Tate AI development environment",
-		HasReadme:   false,
+		ToolName:      "ws-config-gen",
+		Usage:         "ws-config-gen [flags] [command [args]]",
+		Description:   "Generate Visual Studio Code workspace configuration for Tate AI development environment",
+		HasReadme:     true,
+		ReadmeContent: getReadmeDoc(),
+		EnvPrefix:     "STAI_WS_",
+		Aliases:       map[string]string{"f": "force"},
+		Deprecated: map[string]flags.Deprecation{
+			"skip-preflight": {Replacement: "--skip-check=" + setup.CheckPreflight},
+		},
 	}
 
-	commonFlags := flags.SetupCommonFlags(flagConfig)
+	commonFlags = flags.SetupCommonFlags(flagConfig)
 
 	// Add tool-specific flags
 	flag.Var(&forceFlag, "force", "Force execution, ignore warnings. Default ignores 1 warning. Use --force=N for specific count, --force=-1 for unlimited")
+	flag.Var(skipCheckFlag, "skip-check", "Comma separated checks to skip: "+strings.Join(setup.CheckNames, ", "))
+	flag.BoolVar(&ciFlag, "ci", false, "Non-interactive CI mode: no prompts, plain output, git fails instead of asking for credentials")
+	flag.BoolVar(&quietFlag, "quiet", false, "Print no progress, only the summary and errors, e.g. for scheduled runs")
+	flag.BoolVar(&changesOnlyFlag, "changes-only", false, "Print the summary only when the run changed something, warned or failed")
+	flag.BoolVar(&noColorFlag, "no-color", false, "Plain output without colors, also set by the NO_COLOR environment variable")
+	flag.StringVar(&configFlag, "config", "", "Path or https:// URL of a repositories config file (default: embedded config)")
+	flag.StringVar(&configSHA256Flag, "config-sha256", "", "Expected SHA-256 of a remote --config (default: read from <url>.sha256)")
+	flag.StringVar(&expectedUserFlag, "expected-user", "", "Username the tool is expected to run as (overrides config 'user', default 'stai')")
+	flag.StringVar(&baseDirFlag, "base-dir", "", "Base directory for the environment (default: parent of the working directory)")
+	flag.StringVar(&workDirFlag, "work-dir", "", "Path to the stai-vscode checkout (default: current directory, which must be named 'stai-vscode')")
+	flag.StringVar(&envFlag, "env", "", "Named environment to use, see 'env list' (default: the current one of 'env switch')")
+	flag.StringVar(&containerFlag, "container", "", "Set up the environment inside this toolbox or distrobox container, created when missing")
+	flag.StringVar(&containerToolFlag, "container-tool", "", "Container tool of --container: 'toolbox' or 'distrobox' (default: the one found in PATH)")
+	flag.StringVar(&mirrorDirFlag, "mirror-dir", "", "Directory with bare repository mirrors to clone from (see 'mirror sync')")
+	flag.BoolVar(&skipPreflightFlag, "skip-preflight", false, "Deprecated, use --skip-check="+setup.CheckPreflight)
+	flag.IntVar(&cloneAttemptsFlag, "clone-attempts", 0, "Number of clone attempts on failure (default 3, overrides config 'clone-retry')")
+	flag.DurationVar(&cloneBackoffFlag, "clone-backoff", 0, "Initial wait between clone attempts, doubled after each failure (default 2s)")
+	flag.IntVar(&jobsFlag, "jobs", 1, "Number of repositories cloned in parallel")
+	flag.StringVar(&maxRateFlag, "max-rate", "", "Bandwidth limit of all HTTP(S) clones together, e.g. 2MB or 500KB (per second)")
+	flag.DurationVar(&timeoutFlag, "timeout", 0, "Timeout for every external command, e.g. 10m (overrides config 'timeouts')")
+	flag.BoolVar(&strictPermsFlag, "strict-perms", false, "Set the configured permissions on existing environment and repository directories in setup and verify")
+	flag.StringVar(&logFileFlag, "log-file", "", "Log all output and external commands with their complete output to this file, 'off' for none (default: ~/.local/state/stai-vscode/ws-config-gen.log)")
+	flag.BoolVar(&rollbackFlag, "rollback-on-failure", false, "Undo the changes of a failed run without asking: remove created directories, files and clones, restore overwritten files")
+	flag.BoolVar(&overwriteWorkspaceFlag, "overwrite-workspace", false, "Replace an existing workspace file instead of merging user edits into it")
+	flag.BoolVar(&noBackupFlag, "no-backup", false, "Do not back up the existing workspace file before overwriting it")
+	flag.IntVar(&backupKeepFlag, "backup-keep", -1, "Number of workspace file backups to keep (default 10, overrides config 'backup-keep')")
+	flag.BoolVar(&openFlag, "open", false, "Open the generated workspace in the editor after setup")
+	flag.BoolVar(&installExtensionsFlag, "install-extensions", false, "Install recommended extensions missing in the editor after setup or in doctor")
+	flag.StringVar(&editorFlag, "editor", "", "Editor binary: code, code-insiders (default), codium or cursor (overrides config 'editor')")
+	flag.StringVar(&channelFlag, "channel", "", "Release channel for self-update: 'stable' or 'insiders' (default: channel of this binary)")
+	flag.StringVar(&summaryJSONFlag, "summary-json", "", "Write the end-of-run summary as JSON to this file, '-' for stdout")
+	flag.StringVar(&planOutFlag, "plan-out", "", "Write the planned actions as JSON to this file for review instead of running them")
+	flag.BoolVar(&watchFlag, "watch", false, "Regenerate the workspace file whenever a config file changes, printing the diff")
+	flag.StringVar(&applyFlag, "apply", "", "Execute a plan written by --plan-out, fails when the environment changed since")
+	flag.StringVar(&fromStepFlag, "from-step", "", "Run from this step on, ignoring an unfinished run: "+strings.Join(setup.StepNames, ", "))
+	flag.StringVar(&onlyStepFlag, "only-step", "", "Run only this step, ignoring an unfinished run")
+	flag.Var(&enableFlag, "enable", "Comma separated repositories to set up even when disabled in the config")
+	flag.Var(&disableFlag, "disable", "Comma separated repositories to leave out, as with config 'enabled': false")
+	flag.Var(&withFlag, "with", "Comma separated optional repositories to set up without asking")
+	flag.StringVar(&repoNameFlag, "name", "", "Repository name for add-repo")
+	flag.StringVar(&repoURLFlag, "url", "", "Repository git URL for add-repo")
+	flag.StringVar(&repoTypeFlag, "type", "", "Repository type for add-repo: 'git-repo' (default), 'local-git-repo' or 'dir'")
+	flag.BoolVar(&deleteFlag, "delete", false, "Delete the checkout in remove-repo instead of archiving it, only when it has no uncommitted changes")
+	flag.StringVar(&githubOrgFlag, "github-org", "", "GitHub organization to discover repositories of")
+	flag.StringVar(&gitlabGroupFlag, "gitlab-group", "", "GitLab group to discover projects of, including subgroups")
+	flag.StringVar(&apiURLFlag, "api-url", "", "API base URL for discover (default https://api.github.com or https://gitlab.com/api/v4)")
+	flag.Var(&topicFlag, "topic", "Comma separated topics discovered repositories must all have")
+	flag.StringVar(&nameFilterFlag, "name-filter", "", "Shell pattern discovered repository names must match, e.g. 'stai-*'")
+	flag.StringVar(&archiveFlag, "archive", "", "Environment archive written by export to restore with import")
+	flag.StringVar(&remoteWorkspaceFlag, "remote-workspace", "", "Local workspace file remote-setup writes to open the remote folders with VS Code Remote-SSH")
+	flag.StringVar(&scheduleFlag, "schedule", "", "systemd OnCalendar schedule of install-service, e.g. hourly or 'Mon..Fri 08:00' (default daily)")
+	flag.StringVar(&folderPathsFlag, "folder-paths", "", "Workspace folder paths style: 'relative' (default) or 'absolute' (overrides config 'folder-paths')")
+
+	flags.RegisterAliases(flagConfig)
+	flags.RegisterDeprecated(flagConfig)
 
 	flag.Parse()
 
 	// Force flag parsing is handled automatically by the ForceFlag.Set method
 
+	// Full help is built after all flags are registered
+	flagConfig.HelpContent = buildHelpContent(flagConfig)
+
 	// Handle common flags
 	flags.HandleCommonFlags(commonFlags, flagConfig)
 
-	// Main execution
-	if err := run(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
-	}
-
-	fmt.Println("✓ Setup complete")
-}
-
-func run() error {
-	fmt.Println("Checking user and environment...")
-
-	// Check current user
-	if err := checkUser(); err != nil {
-		return err
-	}
-
-	// Check required binaries
-	if err := checkBinaries(); err != nil {
-		return err
-	}
-
-	// Validate current directory
-	workDir, err := validateWorkingDirectory()
-	if err != nil {
-		return err
-	}
-
-	// Determine base directory
-	baseDir := filepath.Dir(workDir)
-
-	// Validate base directory
-	if err := validateBaseDirectory(baseDir); err != nil {
-		return err
-	}
-
-	fmt.Println("Creating directories...")
-
-	// Create required directories
-	if err := createDirectories(baseDir); err != nil {
-		return err
-	}
-
-	// Initialize stai-temp git repository
-	if err := initStaiTempRepo(baseDir); err != nil {
-		return err
-	}
-
-	fmt.Println("Cloning repositories...")
-
-	// Load repository configuration
-	config, err := loadConfig()
-	if err != nil {
-		return err
-	}
-
-	// Clone repositories
-	if err := cloneRepositories(baseDir, config); err != nil {
-		return err
-	}
-
-	fmt.Println("Generating workspace file...")
-
-	// Generate workspace file
-	if err := generateWorkspace(baseDir, config); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-// canSkipWarning checks if we can skip a warning based on force level
-func canSkipWarning() bool {
-	if !forceFlag.enabled {
-		return false // no force flag
-	}
-	if forceFlag.level == -1 {
-		return true // unlimited warnings
-	}
-	if warningCount < forceFlag.level {
-		warningCount++
-		return true
-	}
-	return false
-}
-
-func checkUser() error {
-	currentUser, err := user.Current()
-	if err != nil {
-		return fmt.Errorf("failed to get current user: %w", err)
-	}
-
-	if currentUser.Username != "stai" {
-		if canSkipWarning() {
-			fmt.Printf("Warning: Current user is '%s', expected 'stai' (continuing due to --force)\n", currentUser.Username)
-		} else {
-			return fmt.Errorf("current user is '%s', expected 'stai'. Use --force to ignore this check", currentUser.Username)
+	// Subcommands
+	if args := flag.Args(); len(args) > 0 {
+		if err := runCommand(args); err != nil {
+			newSetup().PrintError(err)
+			os.Exit(setup.ExitCode(err))
 		}
+		return
 	}
 
-	return nil
-}
+	s := newSetup()
 
-func checkBinaries() error {
-	binaries := []string{"git", "code-insiders"}
-
-	for _, binary := range binaries {
-		if _, err := exec.LookPath(binary); err != nil {
-			if canSkipWarning() {
-				fmt.Printf("Warning: Binary '%s' not found in PATH (continuing due to --force)\n", binary)
-			} else {
-				return fmt.Errorf("required binary '%s' not found in PATH. Use --force to ignore this check", binary)
-			}
+	if watchFlag {
+		if err := s.Watch(); err != nil {
+			s.PrintError(err)
+			os.Exit(setup.ExitCode(err))
 		}
+		return
 	}
 
-	return nil
-}
-
-func validateWorkingDirectory() (string, error) {
-	workDir, err := os.Getwd()
-	if err != nil {
-		return "", fmt.Errorf("failed to get current directory: %w", err)
-	}
-
-	if filepath.Base(workDir) != "stai-vscode" {
-		return "", fmt.Errorf("current directory must be named 'stai-vscode', got '%s'", filepath.Base(workDir))
-	}
-
-	return workDir, nil
-}
-
-func validateBaseDirectory(baseDir string) error {
-	// Check that base directory is not $HOME
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return fmt.Errorf("failed to get home directory: %w", err)
-	}
-
-	if baseDir == homeDir {
-		return fmt.Errorf("base directory cannot be the home directory (%s)", homeDir)
-	}
-
-	// Check that base directory is under home directory
-	absBaseDir, err := filepath.Abs(baseDir)
-	if err != nil {
-		return fmt.Errorf("failed to get absolute path for base directory: %w", err)
-	}
-
-	absHomeDir, err := filepath.Abs(homeDir)
-	if err != nil {
-		return fmt.Errorf("failed to get absolute path for home directory: %w", err)
-	}
-
-	relPath, err := filepath.Rel(absHomeDir, absBaseDir)
-	if err != nil || strings.HasPrefix(relPath, "..") {
-		return fmt.Errorf("base directory must be under home directory (%s), got %s", homeDir, baseDir)
-	}
-
-	// Check that base directory is empty except for stai-vscode
-	entries, err := os.ReadDir(baseDir)
-	if err != nil {
-		return fmt.Errorf("failed to read base directory: %w", err)
-	}
-
-	for _, entry := range entries {
-		if entry.Name() != "stai-vscode" {
-			if canSkipWarning() {
-				fmt.Printf("Warning: Base directory contains additional files/directories (continuing due to --force)\n")
-				break
-			} else {
-				return fmt.Errorf("base directory must be empty except for 'stai-vscode' directory. Found: %s. Use --force to ignore this check", entry.Name())
-			}
+	if containerFlag != "" && !setup.InContainer() {
+		code, err := s.RunInContainer(os.Args[1:])
+		if err != nil {
+			s.PrintError(err)
+			os.Exit(setup.ExitCode(err))
 		}
+		os.Exit(code)
 	}
 
-	return nil
-}
-
-func createDirectories(baseDir string) error {
-	dirs := []string{
-		filepath.Join(baseDir, "vscode"),
-		filepath.Join(baseDir, "stai-temp"),
-		filepath.Join(baseDir, "stai-temp", "aitsk"),
-	}
-
-	for _, dir := range dirs {
-		if err := os.MkdirAll(dir, defaultDirPerms); err != nil {
-			return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	if planOutFlag != "" {
+		if err := s.PlanOut(); err != nil {
+			s.PrintError(err)
+			os.Exit(setup.ExitCode(err))
 		}
+		s.PrintDone("Plan written to " + planOutFlag)
+		return
 	}
 
-	return nil
-}
-
-func initStaiTempRepo(baseDir string) error {
-	staiTempDir := filepath.Join(baseDir, "stai-temp")
-
-	// Check if already a git repository
-	if _, err := os.Stat(filepath.Join(staiTempDir, ".git")); err == nil {
-		fmt.Printf("stai-temp is already a git repository, skipping initialization\n")
-		return nil
-	}
-
-	// Initialize git repository
-	cmd := exec.Command("git", "init")
-	cmd.Dir = staiTempDir
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to initialize git repository in stai-temp: %w", err)
-	}
-
-	// Create readme.md from template
-	readmeContent := getReadmeTemplate()
-	readmePath := filepath.Join(staiTempDir, "readme.md")
-	if err := os.WriteFile(readmePath, []byte(readmeContent), 0644); err != nil {
-		return fmt.Errorf("failed to create readme.md: %w", err)
-	}
-
-	// Add and commit
-	cmd = exec.Command("git", "add", "readme.md")
-	cmd.Dir = staiTempDir
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to add readme.md to git: %w", err)
-	}
-
-	cmd = exec.Command("git", "commit", "-m", "Initial commit - stai-temp workspace")
-	cmd.Dir = staiTempDir
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to commit initial files: %w", err)
-	}
-
-	return nil
-}
-
-func loadConfig() (*Config, error) {
-	var config Config
-	if err := json.Unmarshal(embeddedConfig, &config); err != nil {
-		return nil, fmt.Errorf("failed to parse embedded config: %w", err)
-	}
-
-	return &config, nil
-}
-
-func cloneRepositories(baseDir string, config *Config) error {
-	for _, repo := range config.Repos {
-		repoDir := filepath.Join(baseDir, repo.Name)
-
-		// Skip if directory already exists
-		if _, err := os.Stat(repoDir); err == nil {
-			fmt.Printf("Repository %s already exists, skipping\n", repo.Name)
-			continue
-		}
-
-		switch repo.Type {
-		case "git-repo":
-			if repo.GitRepo == nil {
-				return fmt.Errorf("git-repo type requires git-repo URL for %s", repo.Name)
-			}
-
-			cmd := exec.Command("git", "clone", *repo.GitRepo, repoDir)
-			if err := cmd.Run(); err != nil {
-				return fmt.Errorf("failed to clone repository %s: %w", repo.Name, err)
-			}
-
-		case "local-git-repo":
-			// For local-git-repo, we already handled stai-temp above
-			if repo.Name == "stai-temp" {
-				continue
-			}
-
-			if err := os.MkdirAll(repoDir, defaultDirPerms); err != nil {
-				return fmt.Errorf("failed to create directory for %s: %w", repo.Name, err)
-			}
-
-			cmd := exec.Command("git", "init")
-			cmd.Dir = repoDir
-			if err := cmd.Run(); err != nil {
-				return fmt.Errorf("failed to initialize git repository for %s: %w", repo.Name, err)
-			}
+	// Main execution
+	err := s.Run()
 
-		default:
-			return fmt.Errorf("unknown repository type %s for %s", repo.Type, repo.Name)
+	if !changesOnlyFlag || err != nil || s.Changed() {
+		if !quietFlag {
+			fmt.Println()
 		}
+		s.PrintSummary()
 	}
-
-	return nil
-}
-
-func generateWorkspace(baseDir string, config *Config) error {
-	// Use embedded workspace template
-	tmpl, err := template.New("workspace").Parse(getWorkspaceTemplate())
-	if err != nil {
-		return fmt.Errorf("failed to parse workspace template: %w", err)
-	}
-
-	// Generate folders JSON
-	var folders []FolderEntry
-	for _, repo := range config.Repos {
-		folders = append(folders, FolderEntry{
-			Path: "../" + repo.Name,
-		})
-	}
-
-	foldersJSON, err := json.MarshalIndent(folders, "\t", "\t")
-	if err != nil {
-		return fmt.Errorf("failed to marshal folders JSON: %w", err)
-	}
-
-	// Prepare template data
-	data := TemplateData{
-		Folders:     string(foldersJSON),
-		BaseWorkDir: baseDir,
+	if summaryJSONFlag != "" {
+		if jsonErr := s.WriteSummaryJSON(summaryJSONFlag, err); jsonErr != nil {
+			s.PrintError(jsonErr)
+		}
 	}
 
-	// Generate workspace file
-	workspacePath := filepath.Join(baseDir, "vscode", "stai-all.code-workspace")
-	file, err := os.Create(workspacePath)
 	if err != nil {
-		return fmt.Errorf("failed to create workspace file: %w", err)
+		s.PrintError(err)
+		os.Exit(setup.ExitCode(err))
 	}
-	defer file.Close()
 
-	if err := tmpl.Execute(file, data); err != nil {
-		return fmt.Errorf("failed to execute workspace template: %w", err)
+	if !quietFlag {
+		s.PrintDone("Setup complete")
 	}
+}
 
-	return nil
+// newSetup returns a Setup configured from the command line flags
+func newSetup() *setup.Setup {
+	if skipPreflightFlag {
+		skipCheckFlag[setup.CheckPreflight] = true
+	}
+	opts := setup.Options{
+		ForceLevel:         forceFlag.Level(),
+		SkipChecks:         skipCheckFlag,
+		ExpectedUser:       expectedUserFlag,
+		BaseDir:            baseDirFlag,
+		WorkDir:            workDirFlag,
+		Env:                envFlag,
+		Container:          containerFlag,
+		ContainerTool:      containerToolFlag,
+		Config:             configFlag,
+		ConfigSHA256:       configSHA256Flag,
+		CI:                 ciFlag,
+		NoColor:            noColorFlag,
+		Quiet:              quietFlag && len(flag.Args()) == 0, // Output of subcommands is their result
+		MirrorDir:          mirrorDirFlag,
+		CloneAttempts:      cloneAttemptsFlag,
+		CloneBackoff:       cloneBackoffFlag,
+		Jobs:               jobsFlag,
+		MaxRate:            maxRateFlag,
+		Timeout:            timeoutFlag,
+		StrictPerms:        strictPermsFlag,
+		LogFile:            logFileFlag,
+		RollbackOnFailure:  rollbackFlag,
+		FolderPaths:        folderPathsFlag,
+		OverwriteWorkspace: overwriteWorkspaceFlag,
+		NoBackup:           noBackupFlag,
+		BackupKeep:         backupKeepFlag,
+		Open:               openFlag,
+		InstallExtensions:  installExtensionsFlag,
+		Editor:             editorFlag,
+		PlanOut:            planOutFlag,
+		Apply:              applyFlag,
+		FromStep:           fromStepFlag,
+		OnlyStep:           onlyStepFlag,
+		Enable:             enableFlag,
+		Disable:            disableFlag,
+		With:               withFlag,
+	}
+	assets := setup.Assets{
+		Config:            embeddedConfig,
+		WorkspaceTemplate: getWorkspaceTemplate(),
+		ReadmeTemplate:    getReadmeTemplate(),
+		Editorconfig:      getEditorconfigTemplate(),
+		Gitignores:        getGitignoreTemplates(),
+		Presets:           getPresets(),
+	}
+	return setup.New(opts, assets)
 }
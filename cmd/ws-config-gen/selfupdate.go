@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/mj41/stai-vscode/internal/setup"
+	"github.com/mj41/stai-vscode/internal/version"
+)
+
+// GitHub releases of this repository
+const releasesURL = "https://api.github.com/repos/mj41/stai-vscode/releases"
+
+// Release asset names, the checksum file uses sha256sum format and is
+// signed with the release key
+const (
+	checksumsAsset = "checksums.txt"
+	signatureAsset = "checksums.txt.sig"
+)
+
+// Limits for downloading release assets
+const (
+	selfUpdateTimeout = 5 * time.Minute
+	selfUpdateMaxSize = 200 << 20
+	checksumsMaxSize  = 1 << 20
+)
+
+// releasePublicKey is the base64 ed25519 key verifying checksums.txt.sig,
+// set at build time with -ldflags "-X main.releasePublicKey=..."
+var releasePublicKey string
+
+type githubRelease struct {
+	TagName    string `json:"tag_name"`
+	Draft      bool   `json:"draft"`
+	Prerelease bool   `json:"prerelease"`
+	Assets     []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// assetURL returns the download URL of the named release asset
+func (r githubRelease) assetURL(name string) (string, bool) {
+	for _, asset := range r.Assets {
+		if asset.Name == name {
+			return asset.URL, true
+		}
+	}
+	return "", false
+}
+
+// binaryAssetName returns the release asset name for this platform
+func binaryAssetName() string {
+	name := fmt.Sprintf("ws-config-gen_%s_%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// updateChannel returns the release channel to update from, defaulting
+// to the channel of the running binary
+func updateChannel() (string, error) {
+	switch channelFlag {
+	case "":
+		return version.ChannelOf(version.Version), nil
+	case version.ChannelStable, version.ChannelInsiders:
+		return channelFlag, nil
+	default:
+		return "", setup.ValidationErrorf("invalid channel '%s', must be '%s' or '%s'", channelFlag, version.ChannelStable, version.ChannelInsiders)
+	}
+}
+
+// latestRelease returns the newest release of the channel. Insiders
+// also follow stable releases.
+func latestRelease(client *http.Client, channel string) (*githubRelease, error) {
+	req, err := http.NewRequest(http.MethodGet, releasesURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list releases: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to list releases: GitHub API: %s", resp.Status)
+	}
+
+	var releases []githubRelease
+	if err := json.NewDecoder(resp.Body).Decode(&releases); err != nil {
+		return nil, fmt.Errorf("failed to list releases: GitHub API: %w", err)
+	}
+
+	var latest *githubRelease
+	for i, release := range releases {
+		if release.Draft || (release.Prerelease && channel != version.ChannelInsiders) {
+			continue
+		}
+		if latest == nil || version.Compare(release.TagName, latest.TagName) > 0 {
+			latest = &releases[i]
+		}
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("no %s release found", channel)
+	}
+	return latest, nil
+}
+
+// verifyChecksums checks the checksum file signature with the release key
+func verifyChecksums(checksums, signature []byte) error {
+	key, err := base64.StdEncoding.DecodeString(releasePublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid release public key")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(signature)))
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", signatureAsset, err)
+	}
+
+	if !ed25519.Verify(key, checksums, sig) {
+		return fmt.Errorf("signature of %s does not match the release key", checksumsAsset)
+	}
+	return nil
+}
+
+// expectedChecksum returns the SHA-256 of name from sha256sum output
+func expectedChecksum(checksums []byte, name string) (string, bool) {
+	for _, line := range strings.Split(string(checksums), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return fields[0], true
+		}
+	}
+	return "", false
+}
+
+// replaceExecutable atomically replaces the running binary with data
+func replaceExecutable(data []byte) (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate the running binary: %w", err)
+	}
+	exe, err = filepath.EvalSymlinks(exe)
+	if err != nil {
+		return "", fmt.Errorf("failed to locate the running binary: %w", err)
+	}
+
+	// Write next to the binary so the rename stays on one filesystem
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".ws-config-gen-update-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to write update: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to write update: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to write update: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return "", fmt.Errorf("failed to write update: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), exe); err != nil {
+		return "", fmt.Errorf("failed to replace %s: %w", exe, err)
+	}
+	return exe, nil
+}
+
+func runSelfUpdate(args []string) error {
+	if len(args) != 0 {
+		return setup.ValidationErrorf("usage: ws-config-gen self-update [--channel stable|insiders]")
+	}
+
+	s := newSetup()
+
+	channel, err := updateChannel()
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: selfUpdateTimeout}
+
+	fmt.Printf("Checking %s releases...\n", channel)
+
+	release, err := latestRelease(client, channel)
+	if err != nil {
+		return err
+	}
+	if version.Compare(release.TagName, version.Version) <= 0 {
+		s.PrintDone(fmt.Sprintf("Already up to date (%s)", version.Version))
+		return nil
+	}
+
+	assetName := binaryAssetName()
+	binaryURL, ok := release.assetURL(assetName)
+	if !ok {
+		return fmt.Errorf("release %s has no %s asset", release.TagName, assetName)
+	}
+	checksumsURL, ok := release.assetURL(checksumsAsset)
+	if !ok {
+		return fmt.Errorf("release %s has no %s asset", release.TagName, checksumsAsset)
+	}
+
+	checksums, err := setup.HTTPGet(client, checksumsURL, checksumsMaxSize)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", checksumsAsset, err)
+	}
+
+	if releasePublicKey != "" {
+		signatureURL, ok := release.assetURL(signatureAsset)
+		if !ok {
+			return fmt.Errorf("release %s has no %s asset", release.TagName, signatureAsset)
+		}
+		signature, err := setup.HTTPGet(client, signatureURL, checksumsMaxSize)
+		if err != nil {
+			return fmt.Errorf("failed to download %s: %w", signatureAsset, err)
+		}
+		if err := verifyChecksums(checksums, signature); err != nil {
+			return err
+		}
+	} else if skippedBy, ok := s.CanSkipWarning(setup.CheckReleaseSignature); ok {
+		s.Warnf("Binary built without release key, %s signature not verified (continuing due to %s)", checksumsAsset, skippedBy)
+	} else {
+		return setup.ValidationErrorf("binary built without release key, cannot verify the %s signature. Use --skip-check=%s to rely on the checksum only", checksumsAsset, setup.CheckReleaseSignature)
+	}
+
+	expectedSum, ok := expectedChecksum(checksums, assetName)
+	if !ok {
+		return fmt.Errorf("%s of release %s has no entry for %s", checksumsAsset, release.TagName, assetName)
+	}
+
+	fmt.Printf("Downloading %s %s...\n", assetName, release.TagName)
+
+	data, err := setup.HTTPGet(client, binaryURL, selfUpdateMaxSize)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", assetName, err)
+	}
+
+	sum := sha256.Sum256(data)
+	if actualSum := hex.EncodeToString(sum[:]); !strings.EqualFold(actualSum, expectedSum) {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", assetName, expectedSum, actualSum)
+	}
+
+	exe, err := replaceExecutable(data)
+	if err != nil {
+		return err
+	}
+
+	s.PrintDone(fmt.Sprintf("Updated %s to %s", exe, release.TagName))
+	return nil
+}
@@ -1,19 +1,33 @@
 package main
 
 import (
-	_ "embed"
+	"embed"
+	"path"
+	"strings"
 )
 
 // Embedded template files for the workspace generation tool.
 // These templates are embedded into the binary at build time,
 // eliminating the need for external template files.
 
+//go:embed config/repos.json
+var embeddedConfig []byte
+
 //go:embed templates/stai-all.code-workspace.tmpl
 var workspaceTemplate string
 
 //go:embed templates/readme.md.tmpl
 var readmeTemplate string
 
+//go:embed templates/editorconfig.tmpl
+var editorconfigTemplate string
+
+//go:embed templates/gitignore/*.gitignore
+var gitignoreTemplates embed.FS
+
+//go:embed templates/presets/*.json
+var presetTemplates embed.FS
+
 // getWorkspaceTemplate returns the embedded VS Code workspace template.
 // This template is used to generate the .code-workspace file with
 // proper folder structure and VS Code settings.
@@ -27,3 +41,39 @@ func getWorkspaceTemplate() string {
 func getReadmeTemplate() string {
 	return readmeTemplate
 }
+
+// getEditorconfigTemplate returns the embedded .editorconfig template of
+// stai-temp and locally initialized repositories
+func getEditorconfigTemplate() string {
+	return editorconfigTemplate
+}
+
+// getGitignoreTemplates returns the embedded .gitignore templates keyed
+// by language, "common" applies to every locally initialized repository.
+func getGitignoreTemplates() map[string]string {
+	templates := map[string]string{}
+	entries, _ := gitignoreTemplates.ReadDir("templates/gitignore")
+	for _, entry := range entries {
+		data, err := gitignoreTemplates.ReadFile(path.Join("templates/gitignore", entry.Name()))
+		if err != nil {
+			continue
+		}
+		templates[strings.TrimSuffix(entry.Name(), ".gitignore")] = string(data)
+	}
+	return templates
+}
+
+// getPresets returns the embedded extension and settings presets keyed
+// by name, e.g. "go" or "docs"
+func getPresets() map[string]string {
+	presets := map[string]string{}
+	entries, _ := presetTemplates.ReadDir("templates/presets")
+	for _, entry := range entries {
+		data, err := presetTemplates.ReadFile(path.Join("templates/presets", entry.Name()))
+		if err != nil {
+			continue
+		}
+		presets[strings.TrimSuffix(entry.Name(), ".json")] = string(data)
+	}
+	return presets
+}
@@ -0,0 +1,148 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultContext is the number of unchanged lines shown around changes
+const DefaultContext = 3
+
+// op is a single line of an edit script
+type op struct {
+	kind byte // ' ' unchanged, '-' removed, '+' added
+	line string
+}
+
+// Unified returns a unified diff of a and b, or "" when they are equal
+func Unified(aName, bName, a, b string, context int) string {
+	if a == b {
+		return ""
+	}
+
+	ops := editScript(splitLines(a), splitLines(b))
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", aName, bName)
+
+	// Walk the edit script collecting hunks of changes with context
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		start := max(i-context, 0)
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			// Extend over unchanged lines only when another change follows closely
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next < len(ops) && next-end <= 2*context {
+				end = next
+				continue
+			}
+			end = min(end+context, len(ops))
+			break
+		}
+
+		writeHunk(&out, ops, start, end)
+		i = end
+	}
+
+	return out.String()
+}
+
+// writeHunk writes ops[start:end] with a @@ header
+func writeHunk(out *strings.Builder, ops []op, start, end int) {
+	aStart, bStart := 1, 1
+	for _, o := range ops[:start] {
+		if o.kind != '+' {
+			aStart++
+		}
+		if o.kind != '-' {
+			bStart++
+		}
+	}
+
+	aCount, bCount := 0, 0
+	for _, o := range ops[start:end] {
+		if o.kind != '+' {
+			aCount++
+		}
+		if o.kind != '-' {
+			bCount++
+		}
+	}
+	if aCount == 0 {
+		aStart--
+	}
+	if bCount == 0 {
+		bStart--
+	}
+
+	fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+	for _, o := range ops[start:end] {
+		out.WriteByte(o.kind)
+		out.WriteString(o.line)
+		out.WriteByte('\n')
+	}
+}
+
+// splitLines splits s into lines without line terminators
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// editScript returns the shortest edit script turning a into b based
+// on the longest common subsequence of lines
+func editScript(a, b []string) []op {
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var ops []op
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, op{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, op{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, op{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, op{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, op{'+', b[j]})
+	}
+
+	return ops
+}
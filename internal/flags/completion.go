@@ -0,0 +1,251 @@
+package flags
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CompletionShells lists the shells supported by GenerateCompletion
+var CompletionShells = []string{"bash", "zsh", "fish"}
+
+// CompletionFlag describes a flag for shell completion
+type CompletionFlag struct {
+	Name       string
+	Usage      string
+	TakesValue bool     // Whether the flag expects a separate value argument
+	Values     []string // Known values offered after the flag
+}
+
+// CompletionCommand describes a subcommand for shell completion
+type CompletionCommand struct {
+	Name        string
+	Description string
+	Args        []string // Known positional arguments offered after the command
+}
+
+// CompletionSpec contains everything needed to generate a completion script
+type CompletionSpec struct {
+	ToolName string
+	Flags    []CompletionFlag
+	Commands []CompletionCommand
+}
+
+// boolFlag matches flag values which do not require an argument
+// (same interface the flag package uses internally)
+type boolFlag interface {
+	flag.Value
+	IsBoolFlag() bool
+}
+
+// CompletionFlags returns completion descriptions for all flags in fs,
+// values maps flag names to their known values
+func CompletionFlags(fs *flag.FlagSet, values map[string][]string) []CompletionFlag {
+	var result []CompletionFlag
+	fs.VisitAll(func(f *flag.Flag) {
+		if IsAlias(f.Name) || IsDeprecated(f.Name) {
+			return
+		}
+		takesValue := true
+		if bf, ok := f.Value.(boolFlag); ok && bf.IsBoolFlag() {
+			takesValue = false
+		}
+		result = append(result, CompletionFlag{
+			Name:       f.Name,
+			Usage:      f.Usage,
+			TakesValue: takesValue,
+			Values:     values[f.Name],
+		})
+	})
+	return result
+}
+
+// GenerateCompletion returns a completion script for the given shell
+func GenerateCompletion(shell string, spec CompletionSpec) (string, error) {
+	switch shell {
+	case "bash":
+		return generateBashCompletion(spec), nil
+	case "zsh":
+		return generateZshCompletion(spec), nil
+	case "fish":
+		return generateFishCompletion(spec), nil
+	default:
+		return "", fmt.Errorf("unsupported shell '%s', must be one of: %s", shell, strings.Join(CompletionShells, ", "))
+	}
+}
+
+// funcName returns a shell function name derived from the tool name
+func funcName(toolName string) string {
+	return "_" + strings.NewReplacer("-", "_", ".", "_").Replace(toolName)
+}
+
+func flagWords(spec CompletionSpec) string {
+	var words []string
+	for _, f := range spec.Flags {
+		words = append(words, "--"+f.Name)
+	}
+	return strings.Join(words, " ")
+}
+
+func commandWords(spec CompletionSpec) string {
+	var words []string
+	for _, c := range spec.Commands {
+		words = append(words, c.Name)
+	}
+	return strings.Join(words, " ")
+}
+
+func valueFlagWords(spec CompletionSpec) string {
+	var words []string
+	for _, f := range spec.Flags {
+		if f.TakesValue {
+			words = append(words, "--"+f.Name)
+		}
+	}
+	return strings.Join(words, "|")
+}
+
+// sortedFlagValues returns flags which have known values in a stable order
+func sortedFlagValues(spec CompletionSpec) []CompletionFlag {
+	var result []CompletionFlag
+	for _, f := range spec.Flags {
+		if len(f.Values) > 0 {
+			result = append(result, f)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+func generateBashCompletion(spec CompletionSpec) string {
+	var b strings.Builder
+	fn := funcName(spec.ToolName)
+
+	fmt.Fprintf(&b, "# bash completion for %s\n", spec.ToolName)
+	fmt.Fprintf(&b, "%s() {\n", fn)
+	b.WriteString("\tlocal cur prev cmd i\n")
+	b.WriteString("\tcur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	b.WriteString("\tprev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n\n")
+
+	b.WriteString("\tcase \"$prev\" in\n")
+	for _, f := range sortedFlagValues(spec) {
+		fmt.Fprintf(&b, "\t--%s)\n\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n\t\treturn\n\t\t;;\n", f.Name, strings.Join(f.Values, " "))
+	}
+	if vf := valueFlagWords(spec); vf != "" {
+		fmt.Fprintf(&b, "\t%s)\n\t\tCOMPREPLY=($(compgen -f -- \"$cur\"))\n\t\treturn\n\t\t;;\n", vf)
+	}
+	b.WriteString("\tesac\n\n")
+
+	fmt.Fprintf(&b, "\tif [[ \"$cur\" == -* ]]; then\n\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n\t\treturn\n\tfi\n\n", flagWords(spec))
+
+	b.WriteString("\tcmd=\"\"\n")
+	b.WriteString("\tfor ((i = 1; i < COMP_CWORD; i++)); do\n")
+	b.WriteString("\t\tcase \"${COMP_WORDS[i]}\" in\n")
+	if vf := valueFlagWords(spec); vf != "" {
+		fmt.Fprintf(&b, "\t\t%s)\n\t\t\t((i++))\n\t\t\t;;\n", vf)
+	}
+	b.WriteString("\t\t-*) ;;\n")
+	b.WriteString("\t\t*)\n\t\t\tcmd=\"${COMP_WORDS[i]}\"\n\t\t\tbreak\n\t\t\t;;\n")
+	b.WriteString("\t\tesac\n")
+	b.WriteString("\tdone\n\n")
+
+	b.WriteString("\tcase \"$cmd\" in\n")
+	fmt.Fprintf(&b, "\t\"\")\n\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n\t\t;;\n", commandWords(spec))
+	for _, c := range spec.Commands {
+		if len(c.Args) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\t%s)\n\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n\t\t;;\n", c.Name, strings.Join(c.Args, " "))
+	}
+	b.WriteString("\tesac\n")
+	b.WriteString("}\n")
+	fmt.Fprintf(&b, "complete -F %s %s\n", fn, spec.ToolName)
+
+	return b.String()
+}
+
+func generateZshCompletion(spec CompletionSpec) string {
+	var b strings.Builder
+	fn := funcName(spec.ToolName)
+
+	fmt.Fprintf(&b, "#compdef %s\n\n", spec.ToolName)
+	fmt.Fprintf(&b, "%s() {\n", fn)
+	b.WriteString("\tlocal cmd=\"\" prev=\"${words[CURRENT-1]}\" i\n\n")
+
+	b.WriteString("\tcase \"$prev\" in\n")
+	for _, f := range sortedFlagValues(spec) {
+		fmt.Fprintf(&b, "\t--%s)\n\t\tcompadd -- %s\n\t\treturn\n\t\t;;\n", f.Name, strings.Join(f.Values, " "))
+	}
+	if vf := valueFlagWords(spec); vf != "" {
+		fmt.Fprintf(&b, "\t%s)\n\t\t_files\n\t\treturn\n\t\t;;\n", vf)
+	}
+	b.WriteString("\tesac\n\n")
+
+	fmt.Fprintf(&b, "\tif [[ \"${words[CURRENT]}\" == -* ]]; then\n\t\tcompadd -- %s\n\t\treturn\n\tfi\n\n", flagWords(spec))
+
+	b.WriteString("\tfor ((i = 2; i < CURRENT; i++)); do\n")
+	b.WriteString("\t\tcase \"${words[i]}\" in\n")
+	if vf := valueFlagWords(spec); vf != "" {
+		fmt.Fprintf(&b, "\t\t%s)\n\t\t\t((i++))\n\t\t\t;;\n", vf)
+	}
+	b.WriteString("\t\t-*) ;;\n")
+	b.WriteString("\t\t*)\n\t\t\tcmd=\"${words[i]}\"\n\t\t\tbreak\n\t\t\t;;\n")
+	b.WriteString("\t\tesac\n")
+	b.WriteString("\tdone\n\n")
+
+	b.WriteString("\tcase \"$cmd\" in\n")
+	fmt.Fprintf(&b, "\t\"\")\n\t\tcompadd -- %s\n\t\t;;\n", commandWords(spec))
+	for _, c := range spec.Commands {
+		if len(c.Args) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\t%s)\n\t\tcompadd -- %s\n\t\t;;\n", c.Name, strings.Join(c.Args, " "))
+	}
+	b.WriteString("\tesac\n")
+	b.WriteString("}\n\n")
+	fmt.Fprintf(&b, "compdef %s %s\n", fn, spec.ToolName)
+
+	return b.String()
+}
+
+// fishQuote quotes a string for use in a fish script
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+func generateFishCompletion(spec CompletionSpec) string {
+	var b strings.Builder
+	tool := spec.ToolName
+
+	fmt.Fprintf(&b, "# fish completion for %s\n", tool)
+	fmt.Fprintf(&b, "complete -c %s -f\n", tool)
+
+	for _, f := range spec.Flags {
+		line := fmt.Sprintf("complete -c %s -l %s", tool, f.Name)
+		if f.TakesValue {
+			if len(f.Values) > 0 {
+				line += " -x -a " + fishQuote(strings.Join(f.Values, " "))
+			} else {
+				line += " -r -F"
+			}
+		}
+		if f.Usage != "" {
+			line += " -d " + fishQuote(f.Usage)
+		}
+		b.WriteString(line + "\n")
+	}
+
+	for _, c := range spec.Commands {
+		line := fmt.Sprintf("complete -c %s -n '__fish_use_subcommand' -a %s", tool, c.Name)
+		if c.Description != "" {
+			line += " -d " + fishQuote(c.Description)
+		}
+		b.WriteString(line + "\n")
+		if len(c.Args) > 0 {
+			fmt.Fprintf(&b, "complete -c %s -n '__fish_seen_subcommand_from %s' -a %s\n", tool, c.Name, fishQuote(strings.Join(c.Args, " ")))
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,72 @@
+package flags
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+)
+
+// Deprecation describes a deprecated flag that keeps working
+type Deprecation struct {
+	Replacement string // What to use instead, e.g. "--new-flag" or "the 'mirror sync' command"
+	AliasOf     string // When set, the deprecated name is defined as another name of this flag
+}
+
+// deprecated maps deprecated flag names to their deprecation
+var deprecated = map[string]Deprecation{}
+
+// deprecatedValue wraps a flag value and warns once on the output of its
+// flag set when it is set
+type deprecatedValue struct {
+	flag.Value
+	fs          *flag.FlagSet
+	name        string
+	replacement string
+	once        sync.Once
+}
+
+func (d *deprecatedValue) Set(value string) error {
+	d.once.Do(func() {
+		fmt.Fprintf(d.fs.Output(), "Warning: --%s is deprecated, use %s instead\n", d.name, d.replacement)
+	})
+	return d.Value.Set(value)
+}
+
+// IsBoolFlag keeps boolean flags usable without a value
+func (d *deprecatedValue) IsBoolFlag() bool {
+	bf, ok := d.Value.(boolFlag)
+	return ok && bf.IsBoolFlag()
+}
+
+// RegisterDeprecated registers the config's deprecated flags, call it
+// after the tool flags are defined
+func RegisterDeprecated(config FlagConfig) {
+	registerDeprecated(flag.CommandLine, config.Deprecated)
+}
+
+// registerDeprecated defines deprecated aliases and wraps existing
+// deprecated flags so that setting them prints a one-time warning
+func registerDeprecated(fs *flag.FlagSet, deprecations map[string]Deprecation) {
+	for name, dep := range deprecations {
+		if dep.AliasOf != "" {
+			target := fs.Lookup(dep.AliasOf)
+			if target == nil {
+				panic(fmt.Sprintf("flags: deprecated --%s for undefined flag --%s", name, dep.AliasOf))
+			}
+			fs.Var(&deprecatedValue{Value: target.Value, fs: fs, name: name, replacement: dep.Replacement}, name, "Deprecated, use "+dep.Replacement)
+		} else {
+			f := fs.Lookup(name)
+			if f == nil {
+				panic(fmt.Sprintf("flags: deprecated flag --%s is not defined", name))
+			}
+			f.Value = &deprecatedValue{Value: f.Value, fs: fs, name: name, replacement: dep.Replacement}
+		}
+		deprecated[name] = dep
+	}
+}
+
+// IsDeprecated reports whether name is a registered deprecated flag
+func IsDeprecated(name string) bool {
+	_, ok := deprecated[name]
+	return ok
+}
@@ -0,0 +1,100 @@
+package flags
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"strings"
+	"testing"
+)
+
+// newDeprecatedFlagSet returns a flag set with --new-name, --old-name as
+// its deprecated alias and the deprecated bool flag --no-check. The
+// warnings are written to out.
+func newDeprecatedFlagSet(out io.Writer) (*flag.FlagSet, *string, *bool) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(out)
+	name := fs.String("new-name", "", "")
+	noCheck := fs.Bool("no-check", false, "")
+	registerDeprecated(fs, map[string]Deprecation{
+		"old-name": {Replacement: "--new-name", AliasOf: "new-name"},
+		"no-check": {Replacement: "--skip-check=all"},
+	})
+	return fs, name, noCheck
+}
+
+// Warnings of the deprecated flags of newDeprecatedFlagSet
+const (
+	warnOldName = "Warning: --old-name is deprecated, use --new-name instead\n"
+	warnNoCheck = "Warning: --no-check is deprecated, use --skip-check=all instead\n"
+)
+
+func TestDeprecated(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		env         map[string]string
+		wantName    string
+		wantNoCheck bool
+		wantWarn    string
+	}{
+		{name: "current flags", args: []string{"-new-name=a"}, wantName: "a"},
+		{name: "alias", args: []string{"-old-name=a"}, wantName: "a", wantWarn: warnOldName},
+		{name: "alias warns once", args: []string{"-old-name=a", "-old-name", "b"}, wantName: "b", wantWarn: warnOldName},
+		{name: "bool flag without value", args: []string{"-no-check"}, wantNoCheck: true, wantWarn: warnNoCheck},
+		{name: "alias from env", env: map[string]string{"TEST_OLD_NAME": "a"}, wantName: "a", wantWarn: warnOldName},
+		{name: "bool flag from env", env: map[string]string{"TEST_NO_CHECK": "true"}, wantNoCheck: true, wantWarn: warnNoCheck},
+		{name: "alias env below the command line", args: []string{"-new-name=a"}, env: map[string]string{"TEST_OLD_NAME": "b"}, wantName: "a"},
+		{name: "alias env below the alias", args: []string{"-old-name=a"}, env: map[string]string{"TEST_OLD_NAME": "b"}, wantName: "a", wantWarn: warnOldName},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			var out bytes.Buffer
+			fs, name, noCheck := newDeprecatedFlagSet(&out)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if err := ApplyEnv(fs, "TEST_"); err != nil {
+				t.Fatalf("ApplyEnv: %v", err)
+			}
+
+			if *name != tt.wantName || *noCheck != tt.wantNoCheck {
+				t.Errorf("--new-name %q, --no-check %t, want %q, %t", *name, *noCheck, tt.wantName, tt.wantNoCheck)
+			}
+			if out.String() != tt.wantWarn {
+				t.Errorf("warnings %q, want %q", out.String(), tt.wantWarn)
+			}
+		})
+	}
+}
+
+func TestDeprecatedHidden(t *testing.T) {
+	fs, _, _ := newDeprecatedFlagSet(io.Discard)
+	if !IsDeprecated("old-name") || !IsDeprecated("no-check") || IsDeprecated("new-name") {
+		t.Error("IsDeprecated does not match the registered flags")
+	}
+	var out bytes.Buffer
+	PrintDefaults(fs, &out)
+	if got := out.String(); !strings.Contains(got, "-new-name") || strings.Contains(got, "old-name") || strings.Contains(got, "no-check") {
+		t.Errorf("PrintDefaults shows deprecated flags or misses current ones:\n%s", got)
+	}
+}
+
+func TestDeprecatedUndefined(t *testing.T) {
+	for name, dep := range map[string]Deprecation{
+		"gone":     {Replacement: "--new-name"},
+		"old-name": {Replacement: "--missing", AliasOf: "missing"},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("registering --%s for an undefined flag did not panic", name)
+				}
+			}()
+			registerDeprecated(flag.NewFlagSet("test", flag.ContinueOnError), map[string]Deprecation{name: dep})
+		}()
+	}
+}
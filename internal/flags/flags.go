@@ -1,9 +1,14 @@
 package flags
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
+	"slices"
+	"strings"
 
 	"github.com/mj41/stai-vscode/internal/version"
 )
@@ -13,8 +18,20 @@ type CommonFlags struct {
 	ShowVersion bool
 	ShowHelp    bool
 	ShowReadme  bool
+	Output      string // Output format, one of OutputFormats
+	Verbose     bool
 }
 
+// Supported --output formats
+const (
+	OutputText      = "text"
+	OutputJSON      = "json"
+	OutputCycloneDX = "cyclonedx" // CycloneDX SBOM of the binary
+)
+
+// OutputFormats lists all supported --output formats
+var OutputFormats = []string{OutputText, OutputJSON, OutputCycloneDX}
+
 // FlagConfig contains configuration for setting up common flags
 type FlagConfig struct {
 	ToolName      string
@@ -22,20 +39,36 @@ type FlagConfig struct {
 	Description   string
 	HasReadme     bool // Whether this tool has embedded documentation
 	ReadmeContent string
-	HelpContent   string // Full help content for --help flag
+	HelpContent   string                 // Full help content for --help flag
+	EnvPrefix     string                 // Prefix for environment variables backing flags, e.g. "STAI_WS_"
+	Aliases       map[string]string      // Short aliases of tool flags, e.g. "f": "force", see RegisterAliases
+	Deprecated    map[string]Deprecation // Deprecated flag names, see RegisterDeprecated
+}
+
+// commonAliases are the short aliases every tool supports
+var commonAliases = map[string]string{
+	"v": "version",
+	"h": "help",
 }
 
+// aliasOf maps long flag names to their registered short alias
+var aliasOf = map[string]string{}
+
 // SetupCommonFlags sets up standard flags for a tool
 func SetupCommonFlags(config FlagConfig) *CommonFlags {
 	flags := &CommonFlags{}
 
 	flag.BoolVar(&flags.ShowVersion, "version", false, "Show version information")
 	flag.BoolVar(&flags.ShowHelp, "help", false, "Show usage information")
+	flag.StringVar(&flags.Output, "output", OutputText, "Output format of --version and commands: 'text', 'json' or 'cyclonedx' (SBOM)")
+	flag.BoolVar(&flags.Verbose, "verbose", false, "Include module dependencies in --version output")
 
 	if config.HasReadme {
 		flag.BoolVar(&flags.ShowReadme, "readme", false, "Show full documentation")
 	}
 
+	registerAliases(flag.CommandLine, commonAliases)
+
 	// Set up custom usage function
 	flag.Usage = func() {
 		ShowHelp(config.ToolName, config.Usage, config.Description)
@@ -43,15 +76,97 @@ func SetupCommonFlags(config FlagConfig) *CommonFlags {
 			fmt.Println("\nUse --readme to show full documentation")
 		}
 		fmt.Println("\nUse --version to show version information")
+		if config.EnvPrefix != "" {
+			fmt.Printf("\nAll flags can also be set via %sNAME environment variables (e.g. %sFORCE=2), command line flags take precedence\n", config.EnvPrefix, config.EnvPrefix)
+		}
 	}
 
 	return flags
 }
 
+// RegisterAliases registers the config's short aliases of tool flags,
+// call it after the tool flags are defined
+func RegisterAliases(config FlagConfig) {
+	registerAliases(flag.CommandLine, config.Aliases)
+}
+
+// registerAliases defines each short name as another name of its long
+// flag, sharing the flag value
+func registerAliases(fs *flag.FlagSet, aliases map[string]string) {
+	for short, long := range aliases {
+		f := fs.Lookup(long)
+		if f == nil {
+			panic(fmt.Sprintf("flags: alias -%s for undefined flag --%s", short, long))
+		}
+		fs.Var(f.Value, short, "Alias for --"+long)
+		aliasOf[long] = short
+	}
+}
+
+// IsAlias reports whether name is a registered short alias
+func IsAlias(name string) bool {
+	for _, short := range aliasOf {
+		if short == name {
+			return true
+		}
+	}
+	return false
+}
+
+// PrintDefaults prints the flags of fs like flag.PrintDefaults, with
+// short aliases shown next to their long flag instead of separately.
+// Deprecated flags are not shown.
+func PrintDefaults(fs *flag.FlagSet, w io.Writer) {
+	fs.VisitAll(func(f *flag.Flag) {
+		if IsAlias(f.Name) || IsDeprecated(f.Name) {
+			return
+		}
+
+		var b strings.Builder
+		if short, ok := aliasOf[f.Name]; ok {
+			fmt.Fprintf(&b, "  -%s, -%s", short, f.Name)
+		} else {
+			fmt.Fprintf(&b, "  -%s", f.Name)
+		}
+
+		typeName, usage := flag.UnquoteUsage(f)
+		if typeName != "" {
+			b.WriteString(" " + typeName)
+		}
+		b.WriteString("\n    \t")
+		b.WriteString(strings.ReplaceAll(usage, "\n", "\n    \t"))
+
+		switch f.DefValue {
+		case "", "0", "false", "0s":
+		default:
+			if typeName == "string" {
+				fmt.Fprintf(&b, " (default %q)", f.DefValue)
+			} else {
+				fmt.Fprintf(&b, " (default %s)", f.DefValue)
+			}
+		}
+
+		fmt.Fprintln(w, b.String())
+	})
+}
+
 // HandleCommonFlags processes common flags and exits if appropriate
 func HandleCommonFlags(flags *CommonFlags, config FlagConfig) {
+	if config.EnvPrefix != "" {
+		if err := ApplyEnv(flag.CommandLine, config.EnvPrefix); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	if !slices.Contains(OutputFormats, flags.Output) {
+		fmt.Fprintf(os.Stderr, "Error: invalid output format '%s', must be one of: %s\n", flags.Output, strings.Join(OutputFormats, ", "))
+		os.Exit(2)
+	}
+
 	if flags.ShowVersion {
-		version.ShowVersionAndExit(config.ToolName)
+		PrintVersion(flags, config.ToolName)
+		os.Exit(0)
 	}
 
 	if flags.ShowHelp {
@@ -67,7 +182,7 @@ func HandleCommonFlags(flags *CommonFlags, config FlagConfig) {
 
 	if flags.ShowReadme {
 		if config.ReadmeContent != "" {
-			fmt.Print(config.ReadmeContent)
+			ShowPaged(config.ReadmeContent)
 		} else {
 			fmt.Printf("No documentation available for %s\n", config.ToolName)
 		}
@@ -75,8 +190,120 @@ func HandleCommonFlags(flags *CommonFlags, config FlagConfig) {
 	}
 }
 
+// PrintVersion prints version information in the format selected by
+// --output and --verbose. JSON output always lists dependencies.
+func PrintVersion(flags *CommonFlags, toolName string) {
+	switch {
+	case flags.Output == OutputJSON:
+		fmt.Println(version.FormatVersionJSON(toolName))
+	case flags.Output == OutputCycloneDX:
+		fmt.Println(version.FormatCycloneDX(toolName))
+	case flags.Verbose:
+		fmt.Println(version.FormatVersionVerbose(toolName))
+	default:
+		fmt.Println(version.FormatVersion(toolName))
+	}
+}
+
+// PrintDependencies prints the module dependencies of the binary in
+// the format selected by --output
+func PrintDependencies(flags *CommonFlags, toolName string) {
+	switch flags.Output {
+	case OutputJSON:
+		deps := version.GetBuildInfo().Dependencies
+		if deps == nil {
+			deps = []version.Dependency{}
+		}
+		data, err := json.MarshalIndent(deps, "", "  ")
+		if err != nil {
+			panic(err) // Only plain strings, marshaling cannot fail
+		}
+		fmt.Println(string(data))
+	case OutputCycloneDX:
+		fmt.Println(version.FormatCycloneDX(toolName))
+	default:
+		fmt.Println(version.FormatDependencies())
+	}
+}
+
+// ShowPaged prints content through $PAGER (default "less -R") when
+// stdout is a terminal, otherwise or on pager failure prints it directly
+func ShowPaged(content string) {
+	if !isTerminal(os.Stdout) {
+		fmt.Print(content)
+		return
+	}
+
+	pager := os.Getenv("PAGER")
+	if pager == "" {
+		pager = "less -R"
+	}
+	args := strings.Fields(pager)
+	if len(args) == 0 {
+		fmt.Print(content)
+		return
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(content)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Print(content)
+	}
+}
+
+// isTerminal reports whether f is a character device (terminal)
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
 // ShowHelp displays help information consistently across tools
 func ShowHelp(tool, usage, description string) {
 	fmt.Printf("Usage: %s\n", usage)
 	fmt.Printf("%s\n", description)
 }
+
+// EnvName returns the environment variable name backing a flag,
+// e.g. "base-dir" with prefix "STAI_WS_" becomes "STAI_WS_BASE_DIR"
+func EnvName(prefix, flagName string) string {
+	return prefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// ApplyEnv sets flags that were not given on the command line from
+// their environment variables, so command line flags take precedence
+func ApplyEnv(fs *flag.FlagSet, prefix string) error {
+	setOnCommandLine := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		setOnCommandLine[f.Name] = true
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || setOnCommandLine[f.Name] || IsAlias(f.Name) {
+			return
+		}
+		// A flag given by its short alias keeps the command line value and
+		// deprecated aliases must not override their flag given there
+		if short, ok := aliasOf[f.Name]; ok && setOnCommandLine[short] {
+			return
+		}
+		if dep, ok := deprecated[f.Name]; ok && setOnCommandLine[dep.AliasOf] {
+			return
+		}
+		name := EnvName(prefix, f.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid value %q for %s: %w", value, name, setErr)
+		}
+	})
+
+	return err
+}
@@ -0,0 +1,70 @@
+package flags
+
+import (
+	"flag"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestEnvName(t *testing.T) {
+	for flagName, want := range map[string]string{
+		"force":               "STAI_WS_FORCE",
+		"base-dir":            "STAI_WS_BASE_DIR",
+		"rollback-on-failure": "STAI_WS_ROLLBACK_ON_FAILURE",
+	} {
+		if got := EnvName("STAI_WS_", flagName); got != want {
+			t.Errorf("EnvName(%q) = %q, want %q", flagName, got, want)
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		env      map[string]string
+		wantDir  string
+		wantJobs int
+		wantCI   bool
+		wantErr  string
+	}{
+		{name: "defaults", wantDir: "/work", wantJobs: 1},
+		{name: "env", env: map[string]string{"TEST_BASE_DIR": "/env", "TEST_JOBS": "4", "TEST_CI": "true"}, wantDir: "/env", wantJobs: 4, wantCI: true},
+		{name: "command line first", args: []string{"-base-dir=/cli", "-jobs=2"}, env: map[string]string{"TEST_BASE_DIR": "/env", "TEST_JOBS": "4"}, wantDir: "/cli", wantJobs: 2},
+		{name: "short alias on the command line", args: []string{"-j=2"}, env: map[string]string{"TEST_JOBS": "4"}, wantDir: "/work", wantJobs: 2},
+		{name: "no env of short aliases", env: map[string]string{"TEST_J": "4"}, wantDir: "/work", wantJobs: 1},
+		{name: "empty value", env: map[string]string{"TEST_BASE_DIR": ""}, wantJobs: 1},
+		{name: "invalid value", env: map[string]string{"TEST_JOBS": "many"}, wantErr: `invalid value "many" for TEST_JOBS`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			dir := fs.String("base-dir", "/work", "")
+			jobs := fs.Int("jobs", 1, "")
+			ci := fs.Bool("ci", false, "")
+			registerAliases(fs, map[string]string{"j": "jobs"})
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+
+			err := ApplyEnv(fs, "TEST_")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ApplyEnv error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ApplyEnv: %v", err)
+			}
+			if *dir != tt.wantDir || *jobs != tt.wantJobs || *ci != tt.wantCI {
+				t.Errorf("--base-dir %q, --jobs %d, --ci %t, want %q, %d, %t", *dir, *jobs, *ci, tt.wantDir, tt.wantJobs, tt.wantCI)
+			}
+		})
+	}
+}
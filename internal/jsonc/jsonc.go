@@ -0,0 +1,124 @@
+// Package jsonc parses and prints JSON with comments and trailing commas
+// (JSONC) as used by VS Code. Comments stay attached to the object member
+// or array element they belong to, so they survive a parse and print
+// round trip.
+package jsonc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Kind is the type of a Value
+type Kind int
+
+const (
+	Scalar Kind = iota // string, number, true, false or null
+	Object
+	Array
+)
+
+// Value is a parsed JSONC value
+type Value struct {
+	Kind Kind
+	Raw  string // JSON text of a scalar
+	// Object members or array elements, Key is empty for elements
+	Members []*Member
+	// Comments after the last member
+	EndComments []string
+	// Comments before the value, only set for the document root
+	Comments []string
+}
+
+// Member is an object member or array element with its comments
+type Member struct {
+	Comments    []string // Comments on the lines before the member
+	Key         string
+	Value       *Value
+	LineComment string // Comment following the member on the same line
+}
+
+// SyntaxError reports invalid JSONC input
+type SyntaxError struct {
+	Line int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// Get returns the object member with the given key
+func (v *Value) Get(key string) *Member {
+	if v == nil || v.Kind != Object {
+		return nil
+	}
+	for _, m := range v.Members {
+		if m.Key == key {
+			return m
+		}
+	}
+	return nil
+}
+
+// Set replaces the object member with the same key in place or appends m
+func (v *Value) Set(m *Member) {
+	for i, existing := range v.Members {
+		if existing.Key == m.Key {
+			v.Members[i] = m
+			return
+		}
+	}
+	v.Members = append(v.Members, m)
+}
+
+// JSON returns the value as compact plain JSON without comments
+func (v *Value) JSON() []byte {
+	var b bytes.Buffer
+	v.writeJSON(&b)
+	return b.Bytes()
+}
+
+func (v *Value) writeJSON(b *bytes.Buffer) {
+	switch v.Kind {
+	case Object:
+		b.WriteByte('{')
+		for i, m := range v.Members {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			key, _ := json.Marshal(m.Key)
+			b.Write(key)
+			b.WriteByte(':')
+			m.Value.writeJSON(b)
+		}
+		b.WriteByte('}')
+	case Array:
+		b.WriteByte('[')
+		for i, m := range v.Members {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			m.Value.writeJSON(b)
+		}
+		b.WriteByte(']')
+	default:
+		b.WriteString(v.Raw)
+	}
+}
+
+// Equal reports whether a and b hold the same data, ignoring formatting,
+// comments and key order
+func Equal(a, b *Value) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	var va, vb any
+	if json.Unmarshal(a.JSON(), &va) != nil || json.Unmarshal(b.JSON(), &vb) != nil {
+		return bytes.Equal(a.JSON(), b.JSON())
+	}
+	ja, _ := json.Marshal(va)
+	jb, _ := json.Marshal(vb)
+	return bytes.Equal(ja, jb)
+}
@@ -0,0 +1,68 @@
+package jsonc
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseMarshalRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{"empty object", `{}`},
+		{"scalars", "{\n\t\"s\": \"x\",\n\t\"n\": 1.5,\n\t\"b\": true,\n\t\"z\": null\n}"},
+		{"nested", "{\n\t\"folders\": [\n\t\t{\n\t\t\t\"path\": \"../app\"\n\t\t}\n\t],\n\t\"settings\": {}\n}"},
+		{"comments", "// header\n{\n\t// before\n\t\"a\": 1, // after a\n\t\"b\": [\n\t\t1 /* one */\n\t]\n\t// end\n}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := Parse([]byte(tt.in))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if got := string(v.Marshal()); got != tt.in {
+				t.Errorf("Marshal() = %q, want %q", got, tt.in)
+			}
+		})
+	}
+}
+
+func TestParseTrailingCommas(t *testing.T) {
+	v, err := Parse([]byte("{\n\t\"a\": [1, 2,],\n\t\"b\": 2, // last\n}"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want := "{\n\t\"a\": [\n\t\t1,\n\t\t2\n\t],\n\t\"b\": 2 // last\n}"
+	if got := string(v.Marshal()); got != want {
+		t.Errorf("Marshal() = %q, want %q", got, want)
+	}
+	if got := string(v.JSON()); got != `{"a":[1,2],"b":2}` {
+		t.Errorf("JSON() = %s", got)
+	}
+}
+
+func TestParseSyntaxError(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		line int
+	}{
+		{"missing value", "{\n\t\"a\":\n}", 3},
+		{"missing comma", "{\n\t\"a\": 1\n\t\"b\": 2\n}", 3},
+		{"unterminated string", "{\n\t\"a\": \"x\n}", 2},
+		{"unclosed object", "{\n\t\"a\": 1", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.in))
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("Parse(%q) error = %v, want a SyntaxError", tt.in, err)
+			}
+			if syntaxErr.Line != tt.line {
+				t.Errorf("Parse(%q) error line = %d, want %d", tt.in, syntaxErr.Line, tt.line)
+			}
+		})
+	}
+}
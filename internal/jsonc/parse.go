@@ -0,0 +1,242 @@
+package jsonc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// maxDepth bounds nesting of parsed values
+const maxDepth = 1000
+
+type parser struct {
+	data  []byte
+	pos   int
+	depth int
+}
+
+// Parse parses a JSONC document
+func Parse(data []byte) (*Value, error) {
+	p := &parser{data: data}
+
+	comments, err := p.comments()
+	if err != nil {
+		return nil, err
+	}
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	v.Comments = comments
+
+	if _, err := p.comments(); err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.data) {
+		return nil, p.errorf("unexpected %q after the document", p.data[p.pos])
+	}
+	return v, nil
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	line := bytes.Count(p.data[:min(p.pos, len(p.data))], []byte("\n")) + 1
+	return &SyntaxError{Line: line, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) peek() byte {
+	if p.pos < len(p.data) {
+		return p.data[p.pos]
+	}
+	return 0
+}
+
+func (p *parser) atComment() bool {
+	return p.peek() == '/' && p.pos+1 < len(p.data) && (p.data[p.pos+1] == '/' || p.data[p.pos+1] == '*')
+}
+
+// comment reads a comment, // comments end before the line break
+func (p *parser) comment() (string, error) {
+	start := p.pos
+	if p.data[p.pos+1] == '/' {
+		end := bytes.IndexByte(p.data[p.pos:], '\n')
+		if end < 0 {
+			p.pos = len(p.data)
+		} else {
+			p.pos += end
+		}
+		return string(bytes.TrimRight(p.data[start:p.pos], " \t\r")), nil
+	}
+
+	end := bytes.Index(p.data[p.pos+2:], []byte("*/"))
+	if end < 0 {
+		return "", p.errorf("unterminated comment")
+	}
+	p.pos += 2 + end + 2
+	return string(p.data[start:p.pos]), nil
+}
+
+// comments skips white space and returns the comments in it
+func (p *parser) comments() ([]string, error) {
+	var comments []string
+	for p.pos < len(p.data) {
+		switch c := p.data[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			p.pos++
+		case p.atComment():
+			comment, err := p.comment()
+			if err != nil {
+				return nil, err
+			}
+			comments = append(comments, comment)
+		default:
+			return comments, nil
+		}
+	}
+	return comments, nil
+}
+
+// after reads an optional comma and a comment on the line of member m
+func (p *parser) after(m *Member) (bool, error) {
+	comma := false
+	for {
+		for p.peek() == ' ' || p.peek() == '\t' || p.peek() == '\r' {
+			p.pos++
+		}
+		switch {
+		case p.peek() == ',' && !comma:
+			p.pos++
+			comma = true
+		case p.atComment() && m.LineComment == "":
+			comment, err := p.comment()
+			if err != nil {
+				return false, err
+			}
+			m.LineComment = comment
+		default:
+			return comma, nil
+		}
+	}
+}
+
+func (p *parser) value() (*Value, error) {
+	switch c := p.peek(); {
+	case c == '{' || c == '[':
+		if p.depth++; p.depth > maxDepth {
+			return nil, p.errorf("nesting too deep")
+		}
+		defer func() { p.depth-- }()
+		return p.container()
+	case c == '"':
+		raw, err := p.string()
+		if err != nil {
+			return nil, err
+		}
+		return &Value{Kind: Scalar, Raw: raw}, nil
+	case c == '-' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z':
+		start := p.pos
+		for p.pos < len(p.data) && bytes.IndexByte([]byte("+-.0123456789Eeabcdefghijklmnopqrstuvwxyz"), p.data[p.pos]) >= 0 {
+			p.pos++
+		}
+		raw := string(p.data[start:p.pos])
+		if !json.Valid([]byte(raw)) {
+			p.pos = start
+			return nil, p.errorf("invalid value %q", raw)
+		}
+		return &Value{Kind: Scalar, Raw: raw}, nil
+	case p.pos >= len(p.data):
+		return nil, p.errorf("unexpected end of input")
+	default:
+		return nil, p.errorf("unexpected %q", c)
+	}
+}
+
+// string reads a JSON string and returns its raw text
+func (p *parser) string() (string, error) {
+	start := p.pos
+	for p.pos++; p.pos < len(p.data); p.pos++ {
+		switch p.data[p.pos] {
+		case '\\':
+			p.pos++
+		case '\n':
+			return "", p.errorf("line break in string")
+		case '"':
+			p.pos++
+			raw := string(p.data[start:p.pos])
+			if !json.Valid([]byte(raw)) {
+				return "", p.errorf("invalid string %s", raw)
+			}
+			return raw, nil
+		}
+	}
+	p.pos = start
+	return "", p.errorf("unterminated string")
+}
+
+// container reads an object or array
+func (p *parser) container() (*Value, error) {
+	v := &Value{Kind: Array}
+	closing := byte(']')
+	if p.peek() == '{' {
+		v.Kind = Object
+		closing = '}'
+	}
+	p.pos++
+
+	for {
+		comments, err := p.comments()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() == closing {
+			p.pos++
+			v.EndComments = comments
+			return v, nil
+		}
+
+		m := &Member{Comments: comments}
+		if v.Kind == Object {
+			if p.peek() != '"' {
+				return nil, p.errorf("expected object key")
+			}
+			raw, err := p.string()
+			if err != nil {
+				return nil, err
+			}
+			if err := json.Unmarshal([]byte(raw), &m.Key); err != nil {
+				return nil, p.errorf("invalid key %s", raw)
+			}
+			if _, err := p.comments(); err != nil {
+				return nil, err
+			}
+			if p.peek() != ':' {
+				return nil, p.errorf("expected ':' after key %s", raw)
+			}
+			p.pos++
+			if _, err := p.comments(); err != nil {
+				return nil, err
+			}
+		}
+
+		if m.Value, err = p.value(); err != nil {
+			return nil, err
+		}
+		v.Members = append(v.Members, m)
+
+		comma, err := p.after(m)
+		if err != nil {
+			return nil, err
+		}
+		if !comma {
+			end, err := p.comments()
+			if err != nil {
+				return nil, err
+			}
+			if p.peek() != closing {
+				return nil, p.errorf("expected ',' or '%c'", closing)
+			}
+			p.pos++
+			v.EndComments = end
+			return v, nil
+		}
+	}
+}
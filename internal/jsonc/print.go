@@ -0,0 +1,59 @@
+package jsonc
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// Marshal prints v with tab indentation and its comments. The layout
+// matches json.MarshalIndent with a tab, trailing commas are dropped.
+func (v *Value) Marshal() []byte {
+	var b bytes.Buffer
+	for _, comment := range v.Comments {
+		b.WriteString(comment + "\n")
+	}
+	v.write(&b, 0)
+	return b.Bytes()
+}
+
+func (v *Value) write(b *bytes.Buffer, depth int) {
+	if v.Kind == Scalar {
+		b.WriteString(v.Raw)
+		return
+	}
+
+	open, closing := "[", "]"
+	if v.Kind == Object {
+		open, closing = "{", "}"
+	}
+	b.WriteString(open)
+	if len(v.Members) == 0 && len(v.EndComments) == 0 {
+		b.WriteString(closing)
+		return
+	}
+
+	indent := strings.Repeat("\t", depth+1)
+	for i, m := range v.Members {
+		for _, comment := range m.Comments {
+			b.WriteString("\n" + indent + comment)
+		}
+		b.WriteString("\n" + indent)
+		if v.Kind == Object {
+			key, _ := json.Marshal(m.Key)
+			b.Write(key)
+			b.WriteString(": ")
+		}
+		m.Value.write(b, depth+1)
+		if i < len(v.Members)-1 {
+			b.WriteString(",")
+		}
+		if m.LineComment != "" {
+			b.WriteString(" " + m.LineComment)
+		}
+	}
+	for _, comment := range v.EndComments {
+		b.WriteString("\n" + indent + comment)
+	}
+	b.WriteString("\n" + strings.Repeat("\t", depth) + closing)
+}
@@ -0,0 +1,61 @@
+// Package paths locates the per-user config, state and cache directories
+// following the XDG base directory specification
+package paths
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// App is the directory name of ws-config-gen inside the base directories
+const App = "stai-vscode"
+
+// ConfigHome returns $XDG_CONFIG_HOME, by default ~/.config on Unix and
+// the platform config directory elsewhere
+func ConfigHome() (string, error) {
+	return os.UserConfigDir()
+}
+
+// StateHome returns $XDG_STATE_HOME, by default ~/.local/state on Unix and
+// the local application data directory on Windows
+func StateHome() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(dir) {
+		return dir, nil
+	}
+	if runtime.GOOS == "windows" {
+		if dir := os.Getenv("LocalAppData"); dir != "" {
+			return dir, nil
+		}
+		return "", errors.New("%LocalAppData% is not defined")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state"), nil
+}
+
+// CacheHome returns $XDG_CACHE_HOME, by default ~/.cache on Unix and the
+// platform cache directory elsewhere
+func CacheHome() (string, error) {
+	return os.UserCacheDir()
+}
+
+// EnvironmentKey returns a directory name identifying the environment in
+// baseDir: its base name and a hash of the absolute path, so environments
+// with the same name do not share state
+func EnvironmentKey(baseDir string) string {
+	sum := sha256.Sum256([]byte(filepath.Clean(baseDir)))
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' {
+			return '_'
+		}
+		return r
+	}, filepath.Base(baseDir))
+	return name + "-" + hex.EncodeToString(sum[:4])
+}
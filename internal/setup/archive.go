@@ -0,0 +1,469 @@
+package setup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Names in environment archives: the revision lock, the user config and
+// the files below the base directory
+const (
+	archiveConfig  = "config/config.json"
+	archiveBaseDir = "base/"
+)
+
+// archiveTimeout bounds a zstd run of export and import
+const archiveTimeout = 10 * time.Minute
+
+// archiveMaxSize bounds the uncompressed size of an imported archive
+const archiveMaxSize = 4 << 30
+
+// archiveFile is a file, directory or symlink of an environment archive
+type archiveFile struct {
+	name   string // Slash separated archive name
+	mode   fs.FileMode
+	data   []byte
+	target string // Symlink target
+}
+
+// archiveDirs returns the directories with local work an export keeps:
+// stai-temp, the local-git-repo repositories and the workspace files. Repositories
+// cloned from upstream are cloned again after import.
+func archiveDirs(baseDir string, config *Config) []string {
+	dirs := []string{filepath.Join(baseDir, "stai-temp"), filepath.Join(baseDir, "vscode")}
+	for _, repo := range config.Repos {
+		if dir := repoPath(baseDir, repo); repo.Type == "local-git-repo" && !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// Export writes an archive of the environment to path: the revision lock
+// of all repositories, the user config, stai-temp, local repositories and
+// the workspace files. The compression follows the name, .tar.zst (zstd
+// binary needed), .tar.gz or .tgz, or .tar. Without a path the archive is
+// stai-env-<base name>-<time>.tar.zst in the current directory.
+func (s *Setup) Export(path string) error {
+	config, err := s.loadEffectiveConfig()
+	if err != nil {
+		return err
+	}
+	workDir, err := s.validateWorkingDirectory(s.opts.WorkDir)
+	if err != nil {
+		return err
+	}
+	baseDir, err := s.resolveBaseDirectory(s.opts.BaseDir, workDir)
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		path = fmt.Sprintf("stai-env-%s-%s.tar.zst", filepath.Base(baseDir), time.Now().Format("20060102-150405"))
+	}
+	if err := s.checkArchiveName(path); err != nil {
+		return err
+	}
+
+	lock, err := marshalLock(s.environmentLock(baseDir, config))
+	if err != nil {
+		return err
+	}
+	files := []archiveFile{{name: envLockName, mode: 0644, data: lock}}
+	if userConfig, err := s.userConfigPath(); err == nil {
+		data, err := s.readOptional(userConfig)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", userConfig, err)
+		}
+		if data != nil {
+			files = append(files, archiveFile{name: archiveConfig, mode: 0644, data: data})
+		}
+	}
+	for _, dir := range archiveDirs(baseDir, config) {
+		if !s.exists(dir) {
+			continue
+		}
+		rel, err := filepath.Rel(baseDir, dir)
+		if err != nil {
+			return err
+		}
+		if files, err = s.collectArchiveFiles(dir, archiveBaseDir+filepath.ToSlash(rel), files); err != nil {
+			return err
+		}
+	}
+
+	var tarball bytes.Buffer
+	tw := tar.NewWriter(&tarball)
+	for _, file := range files {
+		header := &tar.Header{Name: file.name, Mode: int64(file.mode.Perm()), ModTime: time.Now()}
+		switch {
+		case file.mode.IsDir():
+			header.Typeflag = tar.TypeDir
+			header.Name += "/"
+		case file.mode&fs.ModeSymlink != 0:
+			header.Typeflag = tar.TypeSymlink
+			header.Linkname = file.target
+		default:
+			header.Typeflag = tar.TypeReg
+			header.Size = int64(len(file.data))
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to archive %s: %w", file.name, err)
+		}
+		if _, err := tw.Write(file.data); err != nil {
+			return fmt.Errorf("failed to archive %s: %w", file.name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to archive the environment: %w", err)
+	}
+
+	if err := s.writeArchive(path, tarball.Bytes()); err != nil {
+		return err
+	}
+	fmt.Fprintf(s.Out, "Exported %d files and directories of %s to %s, repositories cloned from upstream are not included\n", len(files), baseDir, path)
+	return nil
+}
+
+// collectArchiveFiles appends dir and everything below it as name
+func (s *Setup) collectArchiveFiles(dir, name string, files []archiveFile) ([]archiveFile, error) {
+	info, err := s.FS.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	files = append(files, archiveFile{name: name, mode: fs.ModeDir | info.Mode().Perm()})
+
+	entries, err := s.FS.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	for _, entry := range entries {
+		entryPath := filepath.Join(dir, entry.Name())
+		entryName := name + "/" + entry.Name()
+		switch {
+		case entry.Type()&fs.ModeSymlink != 0:
+			target, err := s.FS.Readlink(entryPath)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", entryPath, err)
+			}
+			files = append(files, archiveFile{name: entryName, mode: fs.ModeSymlink | 0777, target: target})
+		case entry.IsDir():
+			if files, err = s.collectArchiveFiles(entryPath, entryName, files); err != nil {
+				return nil, err
+			}
+		case entry.Type().IsRegular():
+			info, err := entry.Info()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", entryPath, err)
+			}
+			data, err := s.FS.ReadFile(entryPath)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", entryPath, err)
+			}
+			files = append(files, archiveFile{name: entryName, mode: info.Mode().Perm(), data: data})
+		default:
+			s.Warnf("Skipping %s, only files, directories and symlinks are exported", entryPath)
+		}
+	}
+	return files, nil
+}
+
+// archiveCompression returns the compression of an archive name: zstd,
+// gzip or none
+func archiveCompression(name string) (string, bool) {
+	switch {
+	case strings.HasSuffix(name, ".tar.zst"):
+		return "zstd", true
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "gzip", true
+	case strings.HasSuffix(name, ".tar"):
+		return "", true
+	}
+	return "", false
+}
+
+// checkArchiveName verifies the compression of an archive name is
+// supported, zstd needs its binary
+func (s *Setup) checkArchiveName(name string) error {
+	compression, ok := archiveCompression(name)
+	if !ok {
+		return ValidationErrorf("unsupported archive name '%s', use .tar.zst, .tar.gz, .tgz or .tar", name)
+	}
+	if compression == "zstd" {
+		if _, err := s.Runner.LookPath("zstd"); err != nil {
+			return ValidationErrorf("zstd not found in PATH for %s. Install zstd or use a .tar.gz name", name)
+		}
+	}
+	return nil
+}
+
+// writeArchive compresses tarball as the name of path requests and
+// writes it
+func (s *Setup) writeArchive(path string, tarball []byte) error {
+	compression, _ := archiveCompression(path)
+	switch compression {
+	case "gzip":
+		var compressed bytes.Buffer
+		zw := gzip.NewWriter(&compressed)
+		if _, err := zw.Write(tarball); err != nil {
+			return fmt.Errorf("failed to compress %s: %w", path, err)
+		}
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("failed to compress %s: %w", path, err)
+		}
+		tarball = compressed.Bytes()
+	case "zstd":
+		// The tarball stays out of the command transcript
+		tmp := path + ".tmp"
+		if err := s.FS.WriteFile(tmp, tarball, 0600); err != nil {
+			return fmt.Errorf("failed to write %s: %w", tmp, err)
+		}
+		defer s.FS.Remove(tmp)
+		ctx, cancel := context.WithTimeout(context.Background(), archiveTimeout)
+		defer cancel()
+		if err := s.runCommand(ctx, Command{Name: "zstd", Args: []string{"-q", "-f", "-o", path, tmp}}); err != nil {
+			return fmt.Errorf("failed to compress %s: %w", path, err)
+		}
+		return nil
+	}
+	if err := s.FS.WriteFile(path, tarball, s.perms.files); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// readArchive returns the uncompressed tarball of an archive
+func (s *Setup) readArchive(path string) ([]byte, error) {
+	compression, _ := archiveCompression(path)
+	switch compression {
+	case "gzip":
+		data, err := s.FS.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, ValidationErrorf("invalid archive %s: %w", path, err)
+		}
+		tarball, err := io.ReadAll(io.LimitReader(zr, archiveMaxSize+1))
+		if err != nil {
+			return nil, ValidationErrorf("invalid archive %s: %w", path, err)
+		}
+		return tarball, nil
+	case "zstd":
+		tmp := path + ".tar.tmp"
+		defer s.FS.Remove(tmp)
+		ctx, cancel := context.WithTimeout(context.Background(), archiveTimeout)
+		defer cancel()
+		if err := s.runCommand(ctx, Command{Name: "zstd", Args: []string{"-d", "-q", "-f", "-o", tmp, path}}); err != nil {
+			return nil, fmt.Errorf("failed to decompress %s: %w", path, err)
+		}
+		return s.FS.ReadFile(tmp)
+	}
+	return s.FS.ReadFile(path)
+}
+
+// ImportArchive restores an archive written by Export: the files below
+// the base directory, the user config and the revision lock, kept in the
+// state directory of the environment. Nothing is written when an
+// existing file differs from the archived one, identical ones are kept.
+// A following Run accepts the restored entries in the base directory.
+func (s *Setup) ImportArchive(archive string) error {
+	workDir, err := s.validateWorkingDirectory(s.opts.WorkDir)
+	if err != nil {
+		return err
+	}
+	baseDir, err := s.resolveBaseDirectory(s.opts.BaseDir, workDir)
+	if err != nil {
+		return err
+	}
+	if err := s.checkArchiveName(archive); err != nil {
+		return err
+	}
+	tarball, err := s.readArchive(archive)
+	if err != nil {
+		return err
+	}
+	if len(tarball) > archiveMaxSize {
+		return ValidationErrorf("archive %s is larger than %dGB uncompressed", archive, archiveMaxSize>>30)
+	}
+
+	userConfig, err := s.userConfigPath()
+	if err != nil {
+		return fmt.Errorf("failed to get user config directory: %w", err)
+	}
+	lockPath, err := s.envStatePath(baseDir, envLockName)
+	if err != nil {
+		return err
+	}
+
+	// Read everything and check for conflicts before writing
+	type restored struct {
+		archiveFile
+		dest string
+	}
+	var files []restored
+	var conflicts []string
+	seen := map[string]bool{}
+	tr := tar.NewReader(bytes.NewReader(tarball))
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return ValidationErrorf("invalid archive %s: %w", archive, err)
+		}
+		dest, err := archiveDest(header.Name, baseDir, userConfig, lockPath)
+		if err != nil {
+			return ValidationErrorf("invalid archive %s: %w", archive, err)
+		}
+		// A second entry could write through the symlink of the first
+		if seen[dest] {
+			return ValidationErrorf("invalid archive %s: duplicate entry %s", archive, header.Name)
+		}
+		seen[dest] = true
+		file := restored{archiveFile: archiveFile{name: header.Name, mode: fs.FileMode(header.Mode).Perm()}, dest: dest}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			file.mode |= fs.ModeDir
+		case tar.TypeSymlink:
+			file.mode = fs.ModeSymlink
+			file.target = header.Linkname
+			if target, err := s.FS.Readlink(dest); err == nil && target != file.target {
+				conflicts = append(conflicts, dest)
+			}
+		case tar.TypeReg:
+			if file.data, err = io.ReadAll(tr); err != nil {
+				return ValidationErrorf("invalid archive %s: %w", archive, err)
+			}
+			existing, err := s.readOptional(dest)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", dest, err)
+			}
+			if existing != nil && !bytes.Equal(existing, file.data) && dest != lockPath {
+				conflicts = append(conflicts, dest)
+			}
+		default:
+			return ValidationErrorf("invalid archive %s: unsupported entry %s", archive, header.Name)
+		}
+		if err := s.writeThroughSymlink(file.dest, baseDir, file.mode&fs.ModeSymlink != 0); err != nil {
+			return ValidationErrorf("invalid archive %s: %w", archive, err)
+		}
+		files = append(files, file)
+	}
+	// Nothing may be written through an archived symlink, names are
+	// compared in the cleaned form the destinations are built from
+	symlinks := map[string]bool{}
+	for _, file := range files {
+		if file.mode&fs.ModeSymlink != 0 {
+			symlinks[path.Clean(file.name)] = true
+		}
+	}
+	for _, file := range files {
+		for dir := path.Dir(path.Clean(file.name)); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			if symlinks[dir] {
+				return ValidationErrorf("invalid archive %s: %s is inside the symlink %s", archive, file.name, dir)
+			}
+		}
+	}
+	if len(conflicts) > 0 {
+		return ValidationErrorf("archive %s would replace files that differ, move them away first: %s", archive, strings.Join(conflicts, ", "))
+	}
+
+	written := 0
+	for _, file := range files {
+		// Nor through a symlink already on disk, checked again right
+		// before each write
+		if err := s.writeThroughSymlink(file.dest, baseDir, file.mode&fs.ModeSymlink != 0); err != nil {
+			return ValidationErrorf("invalid archive %s: %w", archive, err)
+		}
+		switch {
+		case file.mode.IsDir():
+			if err := s.FS.MkdirAll(file.dest, s.perms.dirs); err != nil {
+				return fmt.Errorf("failed to create %s: %w", file.dest, err)
+			}
+		case file.mode&fs.ModeSymlink != 0:
+			if _, err := s.FS.Lstat(file.dest); err == nil {
+				continue
+			}
+			if err := s.FS.Symlink(file.target, file.dest); err != nil {
+				return fmt.Errorf("failed to create %s: %w", file.dest, err)
+			}
+			written++
+		default:
+			if existing, _ := s.readOptional(file.dest); existing != nil && bytes.Equal(existing, file.data) {
+				continue
+			}
+			if err := s.FS.MkdirAll(filepath.Dir(file.dest), s.perms.dirs); err != nil {
+				return fmt.Errorf("failed to create %s: %w", filepath.Dir(file.dest), err)
+			}
+			if err := s.FS.WriteFile(file.dest, file.data, file.mode|0600); err != nil {
+				return fmt.Errorf("failed to write %s: %w", file.dest, err)
+			}
+			written++
+		}
+	}
+	for _, file := range files {
+		if rel, ok := strings.CutPrefix(file.name, archiveBaseDir); ok {
+			if top, _, _ := strings.Cut(rel, "/"); !slices.Contains(s.imported, top) {
+				s.imported = append(s.imported, top)
+			}
+		}
+	}
+	fmt.Fprintf(s.Out, "Imported %d files from %s into %s\n", written, archive, baseDir)
+	return nil
+}
+
+// archiveDest returns where an archive entry is restored, entries must
+// stay inside the base directory
+func archiveDest(name, baseDir, userConfig, lockPath string) (string, error) {
+	switch name {
+	case envLockName:
+		return lockPath, nil
+	case archiveConfig:
+		return userConfig, nil
+	}
+	rel, ok := strings.CutPrefix(strings.TrimSuffix(name, "/"), archiveBaseDir)
+	clean := path.Clean(rel)
+	if !ok || rel == "" || rel != clean || path.IsAbs(rel) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("unexpected entry %s", name)
+	}
+	return filepath.Join(baseDir, filepath.FromSlash(clean)), nil
+}
+
+// writeThroughSymlink returns an error when restoring an entry at dest
+// would follow a symlink below baseDir: one of its parents or, unless the
+// entry is a symlink itself, dest
+func (s *Setup) writeThroughSymlink(dest, baseDir string, symlink bool) error {
+	if !symlink && isInside(dest, baseDir) {
+		if info, err := s.FS.Lstat(dest); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("%s is a symlink", dest)
+		}
+	}
+	return s.symlinkParent(dest, baseDir)
+}
+
+// symlinkParent returns an error when a directory between baseDir and
+// dest is a symlink, destinations outside baseDir are not checked
+func (s *Setup) symlinkParent(dest, baseDir string) error {
+	if !isInside(dest, baseDir) {
+		return nil
+	}
+	for dir := filepath.Dir(dest); isInside(dir, baseDir); dir = filepath.Dir(dir) {
+		if info, err := s.FS.Lstat(dir); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("%s is inside the symlink %s", dest, dir)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package setup
+
+import (
+	"archive/tar"
+	"bytes"
+	"maps"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestArchiveDest(t *testing.T) {
+	const (
+		baseDir    = "/home/stai/work-stai"
+		userConfig = "/home/stai/.config/stai-vscode/config.json"
+		lockPath   = "/home/stai/.local/state/stai-vscode/envs/work-stai/stai-env.lock.json"
+	)
+	tests := []struct {
+		name string
+		want string // Empty when the entry is rejected
+	}{
+		{envLockName, lockPath},
+		{archiveConfig, userConfig},
+		{"base/app/readme.md", baseDir + "/app/readme.md"},
+		{"base/app/", baseDir + "/app"},
+		{"base/vscode/.stai-all.code-workspace.generated", baseDir + "/vscode/.stai-all.code-workspace.generated"},
+		{"base/", ""},
+		{"base/..", ""},
+		{"base/../etc/passwd", ""},
+		{"base/app/../../etc/passwd", ""},
+		{"base/app/../lib/file", ""},
+		{"base/./app/file", ""},
+		{"base/app//file", ""},
+		{"base//etc/passwd", ""},
+		{"other/file", ""},
+		{"/etc/passwd", ""},
+		{"config/other.json", ""},
+	}
+	for _, tt := range tests {
+		got, err := archiveDest(tt.name, baseDir, userConfig, lockPath)
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("archiveDest(%q) = %q, want an error", tt.name, got)
+		case tt.want != "" && err != nil:
+			t.Errorf("archiveDest(%q) error: %v", tt.name, err)
+		case got != filepath.FromSlash(tt.want):
+			t.Errorf("archiveDest(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSymlinkParent(t *testing.T) {
+	const baseDir = "/home/stai/work-stai"
+	ts := newTestSetup(t, Options{}, Assets{})
+	if err := ts.fs.MkdirAll(baseDir+"/app/src", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, link := range []string{baseDir + "/link", baseDir + "/app/nested"} {
+		if err := ts.fs.Symlink("/etc", link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		dest    string
+		wantErr bool
+	}{
+		{baseDir + "/app/src/main.go", false},
+		{baseDir + "/new/dir/file", false},
+		{baseDir + "/link", false}, // The symlink itself is no parent
+		{baseDir + "/link/file", true},
+		{baseDir + "/app/nested/deep/file", true},
+		{"/etc/file", false}, // Outside baseDir, not checked
+	}
+	for _, tt := range tests {
+		err := ts.symlinkParent(tt.dest, baseDir)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("symlinkParent(%s) error = %v, want error %t", tt.dest, err, tt.wantErr)
+		}
+	}
+}
+
+// tarEntry is an entry of a test archive, a symlink when target is set
+type tarEntry struct {
+	name, content, target string
+}
+
+// writeTar stores a tar archive of entries in the fake filesystem
+func (ts *testSetup) writeTar(t *testing.T, path string, entries []tarEntry) {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.content)), Typeflag: tar.TypeReg}
+		switch {
+		case entry.target != "":
+			header.Typeflag, header.Linkname, header.Size = tar.TypeSymlink, entry.target, 0
+		case strings.HasSuffix(entry.name, "/"):
+			header.Typeflag, header.Mode = tar.TypeDir, 0755
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(entry.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ts.writeFile(t, path, buf.String())
+}
+
+func TestImportArchive(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		links   map[string]string // Symlinks below the base directory before the import
+		wantErr string            // Empty when the import succeeds
+	}{
+		{
+			name:    "files and symlinks below the base directory",
+			entries: []tarEntry{{name: "base/app/"}, {name: "base/app/readme.md", content: "hi"}, {name: "base/app/latest", target: "readme.md"}},
+		},
+		{
+			name:    "parent directory",
+			entries: []tarEntry{{name: "base/../escape", content: "x"}},
+			wantErr: "unexpected entry",
+		},
+		{
+			name:    "unclean name",
+			entries: []tarEntry{{name: "base/./app/readme.md", content: "x"}},
+			wantErr: "unexpected entry",
+		},
+		{
+			name:    "absolute name",
+			entries: []tarEntry{{name: "/etc/passwd", content: "x"}},
+			wantErr: "unexpected entry",
+		},
+		{
+			name:    "write through an archived symlink",
+			entries: []tarEntry{{name: "base/link", target: "/etc"}, {name: "base/link/passwd", content: "x"}},
+			wantErr: "inside the symlink",
+		},
+		{
+			name:    "write through an archived directory symlink",
+			entries: []tarEntry{{name: "base/app/", target: "/etc"}, {name: "base/app/passwd", content: "x"}},
+			wantErr: "inside the symlink",
+		},
+		{
+			name:    "write onto an archived symlink",
+			entries: []tarEntry{{name: "base/x", target: "/etc/passwd"}, {name: "base/x", content: "evil"}},
+			wantErr: "duplicate entry base/x",
+		},
+		{
+			name:    "duplicate directory entry",
+			entries: []tarEntry{{name: "base/app/"}, {name: "base/app"}},
+			wantErr: "duplicate entry base/app",
+		},
+		{
+			name:    "write onto a symlink on disk",
+			entries: []tarEntry{{name: "base/x", content: "evil"}},
+			links:   map[string]string{"x": "/etc/passwd"},
+			wantErr: "is a symlink",
+		},
+		{
+			name:    "write below a symlink on disk",
+			entries: []tarEntry{{name: "base/app/x", content: "evil"}},
+			links:   map[string]string{"app": "/etc"},
+			wantErr: "inside the symlink",
+		},
+		{
+			name:    "directory onto a symlink on disk",
+			entries: []tarEntry{{name: "base/app/"}},
+			links:   map[string]string{"app": "/etc"},
+			wantErr: "is a symlink",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			baseDir := "/home/stai/work-stai"
+			ts := newTestSetup(t, Options{BaseDir: baseDir, WorkDir: baseDir + "/stai-vscode"}, Assets{})
+			if err := ts.fs.MkdirAll(baseDir+"/stai-vscode", 0755); err != nil {
+				t.Fatal(err)
+			}
+			ts.writeFile(t, "/etc/passwd", "root")
+			for name, target := range tt.links {
+				if err := ts.fs.Symlink(target, baseDir+"/"+name); err != nil {
+					t.Fatal(err)
+				}
+			}
+			ts.writeTar(t, "/tmp/env.tar", tt.entries)
+			before := ts.fs.regularFiles()
+
+			err := ts.ImportArchive("/tmp/env.tar")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ImportArchive: %v", err)
+				}
+				if got := ts.readFile(t, baseDir+"/app/latest"); got != "hi" {
+					t.Errorf("imported app/latest = %q, want %q", got, "hi")
+				}
+				if target, err := ts.fs.Readlink(baseDir + "/app/latest"); err != nil || target != "readme.md" {
+					t.Errorf("imported app/latest links to %q (%v), want readme.md", target, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ImportArchive error = %v, want %q", err, tt.wantErr)
+			}
+			if ExitCode(err) != exitValidation {
+				t.Errorf("exit code %d, want %d", ExitCode(err), exitValidation)
+			}
+			if after := ts.fs.regularFiles(); !maps.Equal(before, after) {
+				t.Errorf("rejected archive changed files: %v, before %v", after, before)
+			}
+			if got := ts.readFile(t, "/etc/passwd"); got != "root" {
+				t.Errorf("/etc/passwd = %q after a rejected import", got)
+			}
+		})
+	}
+}
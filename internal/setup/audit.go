@@ -0,0 +1,263 @@
+package setup
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mj41/stai-vscode/internal/paths"
+)
+
+// auditFileName is the audit trail in $XDG_STATE_HOME/stai-vscode
+const auditFileName = "audit.jsonl"
+
+// Audited actions
+const (
+	auditMkdir     = "mkdir"
+	auditWrite     = "write"     // a new file
+	auditOverwrite = "overwrite" // an existing file
+	auditRemove    = "remove"
+	auditRename    = "rename"
+	auditChmod     = "chmod"
+	auditClone     = "clone"
+	auditInit      = "init"
+	auditCommit    = "commit"
+)
+
+// AuditEntry is one mutating action of the audit trail
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	User   string    `json:"user,omitempty"`
+	PID    int       `json:"pid"`
+	Action string    `json:"action"`
+	Path   string    `json:"path"`
+	Detail string    `json:"detail,omitempty"` // e.g. the clone URL or rename target
+	Result string    `json:"result"`           // "ok" or "failed"
+	Error  string    `json:"error,omitempty"`
+}
+
+// auditPath returns the audit trail file
+func (s *Setup) auditPath() (string, error) {
+	dir, err := s.Users.StateDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get state directory: %w", err)
+	}
+	return filepath.Join(dir, paths.App, auditFileName), nil
+}
+
+// audit appends a finished action to the audit trail. The trail is only
+// ever appended to, concurrent runs write whole lines. A trail that
+// cannot be written is a warning, the action itself already happened.
+func (s *Setup) audit(action, path, detail string, actionErr error) {
+	entry := AuditEntry{
+		Time:   time.Now().UTC(),
+		PID:    os.Getpid(),
+		Action: action,
+		Path:   path,
+		Detail: detail,
+		Result: "ok",
+	}
+	if u, err := s.Users.Current(); err == nil {
+		entry.User = u.Username
+	}
+	if actionErr != nil {
+		entry.Result = "failed"
+		entry.Error = actionErr.Error()
+	}
+
+	if err := s.appendAudit(entry); err != nil {
+		s.auditOnce.Do(func() { s.Warnf("Failed to write the audit trail: %v", err) })
+	}
+}
+
+// appendAudit writes entry as one line of the audit trail
+func (s *Setup) appendAudit(entry AuditEntry) error {
+	path, err := s.auditPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := s.FS.MkdirAll(filepath.Dir(path), defaultDirPerms); err != nil {
+		return err
+	}
+	return s.FS.AppendFile(path, append(data, '\n'), 0600)
+}
+
+// auditExcluded reports whether path holds files of the tool itself, the
+// state and cache directories including the audit trail, which are not
+// audited
+func (s *Setup) auditExcluded(path string) bool {
+	for _, dir := range []func() (string, error){s.Users.StateDir, s.Users.CacheDir} {
+		base, err := dir()
+		if err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(filepath.Join(base, paths.App), path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// auditFS records the changes made through a FileSystem in the audit
+// trail of s and, while a run tracks them, for its rollback. Appends are
+// not recorded, they only write the audit trail and log file of the tool.
+type auditFS struct {
+	FileSystem
+	s *Setup
+}
+
+func (a auditFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	action := auditWrite
+	if info, err := a.FileSystem.Stat(name); err == nil {
+		action = auditOverwrite
+		if a.tracked(name) {
+			if previous, err := a.FileSystem.ReadFile(name); err == nil {
+				a.s.track(change{path: name, previous: previous, mode: info.Mode().Perm()})
+			}
+		}
+	} else if a.tracked(name) {
+		a.s.trackCreated(name)
+	}
+	err := a.FileSystem.WriteFile(name, data, perm)
+	a.record(action, name, "", err)
+	return err
+}
+
+func (a auditFS) CreateExclusive(name string, data []byte, perm fs.FileMode) error {
+	err := a.FileSystem.CreateExclusive(name, data, perm)
+	if !errors.Is(err, fs.ErrExist) {
+		if err == nil && a.tracked(name) {
+			a.s.trackCreated(name)
+		}
+		a.record(auditWrite, name, "", err)
+	}
+	return err
+}
+
+func (a auditFS) Symlink(target, name string) error {
+	err := a.FileSystem.Symlink(target, name)
+	if err == nil && a.tracked(name) {
+		a.s.trackCreated(name)
+	}
+	a.record(auditWrite, name, target, err)
+	return err
+}
+
+func (a auditFS) MkdirAll(path string, perm fs.FileMode) error {
+	if _, err := a.FileSystem.Stat(path); err == nil {
+		return a.FileSystem.MkdirAll(path, perm)
+	}
+	if a.tracked(path) {
+		a.s.trackCreated(missingAncestor(a.FileSystem, path))
+	}
+	err := a.FileSystem.MkdirAll(path, perm)
+	a.record(auditMkdir, path, fmt.Sprintf("%04o", perm), err)
+	return err
+}
+
+func (a auditFS) Chmod(name string, mode fs.FileMode) error {
+	if info, err := a.FileSystem.Stat(name); err == nil && a.tracked(name) {
+		a.s.track(change{path: name, chmod: true, mode: info.Mode().Perm()})
+	}
+	err := a.FileSystem.Chmod(name, mode)
+	a.record(auditChmod, name, fmt.Sprintf("%04o", mode), err)
+	return err
+}
+
+func (a auditFS) Remove(name string) error {
+	err := a.FileSystem.Remove(name)
+	if !errors.Is(err, fs.ErrNotExist) {
+		a.record(auditRemove, name, "", err)
+	}
+	return err
+}
+
+func (a auditFS) RemoveAll(path string) error {
+	if _, err := a.FileSystem.Stat(path); err != nil {
+		return a.FileSystem.RemoveAll(path)
+	}
+	err := a.FileSystem.RemoveAll(path)
+	a.record(auditRemove, path, "", err)
+	return err
+}
+
+func (a auditFS) Rename(oldpath, newpath string) error {
+	err := a.FileSystem.Rename(oldpath, newpath)
+	a.record(auditRename, oldpath, newpath, err)
+	return err
+}
+
+// tracked reports whether a change of path is recorded for rollback
+func (a auditFS) tracked(path string) bool {
+	return a.s.tracking() && !a.s.auditExcluded(path)
+}
+
+// record audits an action unless it concerns the files of the tool
+func (a auditFS) record(action, path, detail string, err error) {
+	if !a.s.auditExcluded(path) {
+		a.s.audit(action, path, detail, err)
+	}
+}
+
+// Audit prints the audit trail, oldest entries first
+func (s *Setup) Audit(format string) error {
+	if format != ListText && format != ListJSON {
+		return ValidationErrorf("invalid audit output format '%s', must be %s or %s", format, ListText, ListJSON)
+	}
+
+	path, err := s.auditPath()
+	if err != nil {
+		return err
+	}
+	entries := []AuditEntry{}
+	data, err := s.readOptional(path)
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("invalid audit entry in %s line %d: %w", path, line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	if format == ListJSON {
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal audit trail: %w", err)
+		}
+		_, err = fmt.Fprintln(s.Out, string(data))
+		return err
+	}
+
+	w := tabwriter.NewWriter(s.Out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tUSER\tACTION\tPATH\tDETAIL\tRESULT")
+	for _, entry := range entries {
+		result := entry.Result
+		if entry.Error != "" {
+			result += ": " + entry.Error
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", entry.Time.Local().Format("2006-01-02 15:04:05"), orDash(entry.User), entry.Action, entry.Path, orDash(entry.Detail), result)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,70 @@
+package setup
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestAuditTrail(t *testing.T) {
+	ts := newTestSetup(t, Options{}, Assets{})
+	const dir = "/home/stai/work-stai/app"
+
+	steps := []struct {
+		run      func() error
+		action   string
+		wantFail bool
+	}{
+		{func() error { return ts.FS.MkdirAll(dir, 0755) }, auditMkdir, false},
+		{func() error { return ts.FS.WriteFile(dir+"/a", []byte("1"), 0644) }, auditWrite, false},
+		{func() error { return ts.FS.WriteFile(dir+"/a", []byte("2"), 0644) }, auditOverwrite, false},
+		{func() error { return ts.FS.Rename(dir+"/a", dir+"/b") }, auditRename, false},
+		{func() error { return ts.FS.Chmod(dir+"/b", 0600) }, auditChmod, false},
+		{func() error { return ts.FS.Symlink("b", dir+"/c") }, auditWrite, false},
+		{func() error { return ts.FS.Remove(dir + "/b") }, auditRemove, false},
+		{func() error {
+			ts.audit(auditClone, dir, "https://example.com/app.git", errors.New("exit status 128"))
+			return nil
+		}, auditClone, true},
+	}
+	for _, step := range steps {
+		if err := step.run(); err != nil {
+			t.Fatalf("%s: %v", step.action, err)
+		}
+	}
+	// Files of the tool itself are not audited
+	state, _ := ts.Users.StateDir()
+	if err := ts.FS.WriteFile(state+"/stai-vscode/envs/x/state.json", []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	ts.Out = &out
+	if err := ts.Audit(ListJSON); err != nil {
+		t.Fatalf("Audit: %v", err)
+	}
+	var entries []AuditEntry
+	if err := json.Unmarshal(out.Bytes(), &entries); err != nil {
+		t.Fatalf("invalid audit output: %v\n%s", err, out.String())
+	}
+	if len(entries) != len(steps) {
+		t.Fatalf("got %d audit entries, want %d:\n%s", len(entries), len(steps), out.String())
+	}
+	for i, step := range steps {
+		entry := entries[i]
+		if entry.Action != step.action || (entry.Result == "failed") != step.wantFail || entry.User != defaultExpectedUser {
+			t.Errorf("entry %d = %+v, want action %s failed %t", i, entry, step.action, step.wantFail)
+		}
+	}
+	if entries[3].Detail != dir+"/b" || entries[5].Detail != "b" {
+		t.Errorf("rename detail %q, symlink detail %q", entries[3].Detail, entries[5].Detail)
+	}
+}
+
+func TestAuditInvalidFormat(t *testing.T) {
+	ts := newTestSetup(t, Options{}, Assets{})
+	if err := ts.Audit("yaml"); ExitCode(err) != exitValidation {
+		t.Errorf("Audit(yaml) = %v, want a validation error", err)
+	}
+}
@@ -0,0 +1,212 @@
+package setup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// binaryVersionTimeout bounds each version command of the binary check
+const binaryVersionTimeout = 15 * time.Second
+
+// minGitVersion is the oldest git setup works with: clone --sparse needs
+// 2.25, the init.defaultBranch of new repositories 2.28
+const minGitVersion = "2.28"
+
+// gitUpgradeHint is added to the warning of an old git
+const gitUpgradeHint = "upgrade git, e.g. from https://git-scm.com/downloads or the git-core PPA on Ubuntu"
+
+// versionPattern matches the first dotted version number of a version
+// command output, e.g. 2.43.0 in "git version 2.43.0"
+var versionPattern = regexp.MustCompile(`\d+(\.\d+)+`)
+
+// constraintVersionPattern matches the versions of min-version constraints
+var constraintVersionPattern = regexp.MustCompile(`^\d+(\.\d+)*$`)
+
+// RequiredBinary is a binary checked to be installed before setup
+type RequiredBinary struct {
+	Name string `json:"name"`
+	// Version constraints separated by commas, e.g. ">=1.22" or
+	// ">=2.0, <3", a plain version means at least that one
+	MinVersion  string   `json:"min-version,omitempty"`
+	VersionArgs []string `json:"version-args,omitempty"` // Arguments printing the version, default --version
+	Hint        string   `json:"hint,omitempty"`         // How to install or upgrade it
+}
+
+// requiredBinaries returns the binaries the checks verify: git of at
+// least minGitVersion, the editor, git-lfs when a repository uses LFS, then
+// the binaries of the config. The fields set in a config entry with a
+// built-in name replace the built-in ones.
+func (s *Setup) requiredBinaries(config *Config) ([]RequiredBinary, error) {
+	editor, err := s.resolveEditor(config)
+	if err != nil {
+		return nil, err
+	}
+
+	binaries := []RequiredBinary{
+		{Name: "git", MinVersion: ">=" + minGitVersion, Hint: gitUpgradeHint},
+		{Name: editor.Command[0]}, // flatpak for a Flatpak editor
+	}
+	for _, repo := range config.Repos {
+		if repo.LFS {
+			binaries = append(binaries, RequiredBinary{Name: "git-lfs"})
+			break
+		}
+	}
+
+	for _, binary := range config.Binaries {
+		replaced := false
+		for i := range binaries {
+			if binaries[i].Name != binary.Name {
+				continue
+			}
+			if binary.MinVersion != "" {
+				binaries[i].MinVersion = binary.MinVersion
+			}
+			if binary.VersionArgs != nil {
+				binaries[i].VersionArgs = binary.VersionArgs
+			}
+			if binary.Hint != "" {
+				binaries[i].Hint = binary.Hint
+			}
+			replaced = true
+		}
+		if !replaced {
+			binaries = append(binaries, binary)
+		}
+	}
+	return binaries, nil
+}
+
+// validateBinaries checks binary names and version constraints
+func validateBinaries(config *Config) error {
+	for _, binary := range config.Binaries {
+		if binary.Name == "" || strings.ContainsAny(binary.Name, "/\\") {
+			return configErrorf("invalid binary name '%s', use the name looked up in PATH", binary.Name)
+		}
+		if _, err := parseVersionConstraints(binary.MinVersion); err != nil {
+			return configErrorf("invalid min-version of binary %s: %w", binary.Name, err)
+		}
+	}
+	return nil
+}
+
+// versionConstraint is one comparison of a min-version expression
+type versionConstraint struct {
+	op      string
+	version string
+}
+
+// parseVersionConstraints parses a comma separated list of constraints
+// like ">=1.22", "<3" or "1.2", an empty expression has none
+func parseVersionConstraints(expr string) ([]versionConstraint, error) {
+	var constraints []versionConstraint
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+	for _, part := range strings.Split(expr, ",") {
+		part = strings.TrimSpace(part)
+		op := ">="
+		for _, candidate := range []string{">=", "<=", ">", "<", "="} {
+			if strings.HasPrefix(part, candidate) {
+				op = candidate
+				part = strings.TrimSpace(strings.TrimPrefix(part, candidate))
+				break
+			}
+		}
+		if !constraintVersionPattern.MatchString(part) {
+			return nil, fmt.Errorf("'%s' is not a version", part)
+		}
+		constraints = append(constraints, versionConstraint{op: op, version: part})
+	}
+	return constraints, nil
+}
+
+// satisfies reports whether version meets all constraints
+func satisfies(version string, constraints []versionConstraint) bool {
+	for _, c := range constraints {
+		cmp := compareVersions(version, c.version)
+		ok := false
+		switch c.op {
+		case ">=":
+			ok = cmp >= 0
+		case "<=":
+			ok = cmp <= 0
+		case ">":
+			ok = cmp > 0
+		case "<":
+			ok = cmp < 0
+		case "=":
+			ok = cmp == 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// compareVersions compares dotted versions like 1.21 and 1.22.3, missing
+// parts count as zero
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < max(len(as), len(bs)); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			return x - y
+		}
+	}
+	return 0
+}
+
+// binaryVersion runs the version command of binary and returns the first
+// version number of its output
+func (s *Setup) binaryVersion(binary RequiredBinary) (string, error) {
+	args := binary.VersionArgs
+	if args == nil {
+		args = []string{"--version"}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), binaryVersionTimeout)
+	defer cancel()
+
+	var stdout bytes.Buffer
+	if err := s.runCommand(ctx, Command{Name: binary.Name, Args: args, Stdout: &stdout}); err != nil {
+		return "", fmt.Errorf("'%s %s' failed: %w", binary.Name, strings.Join(args, " "), err)
+	}
+	version := versionPattern.FindString(stdout.String())
+	if version == "" {
+		return "", fmt.Errorf("no version in the output of '%s %s'", binary.Name, strings.Join(args, " "))
+	}
+	return version, nil
+}
+
+// checkBinaryVersion verifies binary meets its min-version, a problem is
+// described by the returned message following "binary", empty when it is
+// fine
+func (s *Setup) checkBinaryVersion(binary RequiredBinary) (string, error) {
+	constraints, err := parseVersionConstraints(binary.MinVersion)
+	if err != nil {
+		return "", configErrorf("invalid min-version of binary %s: %w", binary.Name, err)
+	}
+	if len(constraints) == 0 {
+		return "", nil
+	}
+	version, err := s.binaryVersion(binary)
+	if err != nil {
+		return fmt.Sprintf("'%s' version could not be read: %v", binary.Name, err), nil
+	}
+	if !satisfies(version, constraints) {
+		return fmt.Sprintf("'%s' version %s does not satisfy '%s'", binary.Name, version, binary.MinVersion), nil
+	}
+	return "", nil
+}
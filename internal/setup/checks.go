@@ -0,0 +1,47 @@
+package setup
+
+import "fmt"
+
+// Names of checks that can be skipped with --skip-check
+const (
+	CheckUser             = "user"
+	CheckBinaries         = "binaries"
+	CheckBaseDirEmpty     = "base-dir-empty"
+	CheckDiskSpace        = "disk-space"
+	CheckReleaseSignature = "release-signature"
+	CheckSSH              = "ssh"
+	CheckContainerRuntime = "container-runtime"
+	CheckContainerDaemon  = "container-daemon"
+	CheckEditor           = "editor"
+	CheckPreflight        = "preflight" // All preflight checks, skipped entirely
+)
+
+// CheckNames lists all skippable checks
+var CheckNames = []string{CheckUser, CheckBinaries, CheckBaseDirEmpty, CheckDiskSpace, CheckReleaseSignature, CheckSSH, CheckContainerRuntime, CheckContainerDaemon, CheckEditor, CheckPreflight}
+
+// CanSkipWarning reports whether a failed check may be skipped and the
+// flag allowing it. Named --skip-check entries are used first, then the
+// --force warning budget.
+func (s *Setup) CanSkipWarning(check string) (string, bool) {
+	if s.opts.SkipChecks[check] {
+		return "--skip-check=" + check, true
+	}
+	if s.opts.ForceLevel == 0 {
+		return "", false // no force flag
+	}
+	if s.opts.ForceLevel == -1 {
+		return "--force", true // unlimited warnings
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.warningCount < s.opts.ForceLevel {
+		s.warningCount++
+		return "--force", true
+	}
+	return "", false
+}
+
+// skipHint returns the error message suffix naming how to skip a check
+func skipHint(check string) string {
+	return fmt.Sprintf("Use --skip-check=%s to ignore this check", check)
+}
@@ -0,0 +1,62 @@
+package setup
+
+import (
+	"fmt"
+	"os"
+)
+
+// ANSI styles of terminal output
+const (
+	styleBold   = "1"
+	styleRed    = "31"
+	styleGreen  = "32"
+	styleYellow = "33"
+	styleCyan   = "36"
+)
+
+// Status markers, left out in CI mode
+const (
+	markDone    = "✓"
+	markWarning = "⚠"
+	markError   = "✗"
+)
+
+// colorEnabled reports whether output to f may be colored: f is a
+// terminal, neither CI mode, --no-color nor NO_COLOR is set and TERM is
+// not "dumb"
+func colorEnabled(f *os.File, opts Options) bool {
+	if opts.CI || opts.NoColor || os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return isTerminal(f)
+}
+
+// paint returns text in the ANSI styles when enabled
+func paint(enabled bool, text string, styles ...string) string {
+	if !enabled || len(styles) == 0 {
+		return text
+	}
+	code := styles[0]
+	for _, style := range styles[1:] {
+		code += ";" + style
+	}
+	return "\033[" + code + "m" + text + "\033[0m"
+}
+
+// marked returns msg with a status marker, the plain message in CI mode
+func (s *Setup) marked(color bool, mark, style, msg string) string {
+	if s.opts.CI {
+		return msg
+	}
+	return paint(color, mark+" "+msg, style)
+}
+
+// printSection prints the header of a phase of the run
+func (s *Setup) printSection(title string) {
+	fmt.Fprintln(s.Out, paint(s.color, title, styleBold, styleCyan))
+}
+
+// PrintError prints err to Err with the error marker
+func (s *Setup) PrintError(err error) {
+	fmt.Fprintln(s.Err, s.marked(s.errColor, markError, styleRed, "Error: "+err.Error()))
+}
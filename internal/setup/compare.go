@@ -0,0 +1,190 @@
+package setup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"text/tabwriter"
+	"time"
+)
+
+// Kinds of differences between two environments
+const (
+	diffMissingRepo   = "missing-repo"   // in the other environment only
+	diffExtraRepo     = "extra-repo"     // in this environment only
+	diffSHA           = "sha"            // checked out at different commits
+	diffBranch        = "branch"         // on different branches
+	diffMissingFolder = "missing-folder" // a workspace folder of the other environment only
+	diffExtraFolder   = "extra-folder"   // a workspace folder of this environment only
+	diffSetting       = "setting"        // a workspace setting with different values
+)
+
+// EnvironmentDifference is one difference between this environment and
+// another one, Local and Other are empty where a side lacks the item
+type EnvironmentDifference struct {
+	Kind  string `json:"kind"`
+	Name  string `json:"name"` // Repository name, folder path or setting key
+	Local string `json:"local,omitempty"`
+	Other string `json:"other,omitempty"`
+}
+
+// EnvironmentComparison is the result of comparing this environment to a
+// revision lock or snapshot of another one
+type EnvironmentComparison struct {
+	Other       string                  `json:"other"` // The lock or snapshot file
+	OtherHost   string                  `json:"other-host,omitempty"`
+	Generated   time.Time               `json:"other-generated"`
+	Differences []EnvironmentDifference `json:"differences"`
+}
+
+// Compare reports how the environment on disk differs from a revision
+// lock or snapshot, e.g. the stai-env.lock.json of an export from another
+// machine: repositories missing on either side, different commits and
+// branches, workspace folders and settings. Any difference fails with a
+// validation error after the report, so scripts can gate on it.
+func (s *Setup) Compare(name, format string) error {
+	if format != ListText && format != ListJSON {
+		return ValidationErrorf("invalid compare output format '%s', must be %s or %s", format, ListText, ListJSON)
+	}
+	config, err := s.loadEffectiveConfig()
+	if err != nil {
+		return err
+	}
+	if err := s.configureTimeouts(config); err != nil {
+		return err
+	}
+	workDir, err := s.validateWorkingDirectory(s.opts.WorkDir)
+	if err != nil {
+		return err
+	}
+	baseDir, err := s.resolveBaseDirectory(s.opts.BaseDir, workDir)
+	if err != nil {
+		return err
+	}
+	other, path, err := s.readLock(baseDir, name)
+	if err != nil {
+		return err
+	}
+
+	comparison := EnvironmentComparison{
+		Other:       path,
+		OtherHost:   other.Host,
+		Generated:   other.Generated,
+		Differences: compareLocks(s.environmentLock(baseDir, config), other),
+	}
+
+	if format == ListJSON {
+		data, err := json.MarshalIndent(comparison, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal comparison: %w", err)
+		}
+		if _, err := fmt.Fprintln(s.Out, string(data)); err != nil {
+			return err
+		}
+	} else if err := s.printComparison(comparison); err != nil {
+		return err
+	}
+
+	if len(comparison.Differences) > 0 {
+		return ValidationErrorf("environment differs from %s: %d differences found", path, len(comparison.Differences))
+	}
+	return nil
+}
+
+// compareLocks returns the differences of local to other: repositories
+// first in the order of other, then folders and settings
+func compareLocks(local, other *EnvironmentLock) []EnvironmentDifference {
+	differences := []EnvironmentDifference{}
+	add := func(kind, name, localValue, otherValue string) {
+		differences = append(differences, EnvironmentDifference{Kind: kind, Name: name, Local: localValue, Other: otherValue})
+	}
+
+	localRepos := map[string]RepoRevision{}
+	for _, repo := range local.Repos {
+		localRepos[repo.Name] = repo
+	}
+	otherRepos := map[string]bool{}
+	for _, repo := range other.Repos {
+		otherRepos[repo.Name] = true
+		mine, ok := localRepos[repo.Name]
+		switch {
+		case !ok:
+			add(diffMissingRepo, repo.Name, "", repo.Path)
+		case mine.SHA != repo.SHA:
+			add(diffSHA, repo.Name, mine.SHA, repo.SHA)
+		}
+		if ok && mine.Branch != repo.Branch {
+			add(diffBranch, repo.Name, mine.Branch, repo.Branch)
+		}
+	}
+	for _, repo := range local.Repos {
+		if !otherRepos[repo.Name] {
+			add(diffExtraRepo, repo.Name, repo.Path, "")
+		}
+	}
+
+	for _, folder := range other.Folders {
+		if !slices.Contains(local.Folders, folder) {
+			add(diffMissingFolder, folder, "", folder)
+		}
+	}
+	for _, folder := range local.Folders {
+		if !slices.Contains(other.Folders, folder) {
+			add(diffExtraFolder, folder, folder, "")
+		}
+	}
+
+	keys := map[string]bool{}
+	for key := range local.Settings {
+		keys[key] = true
+	}
+	for key := range other.Settings {
+		keys[key] = true
+	}
+	for _, key := range slices.Sorted(maps.Keys(keys)) {
+		mine, theirs := local.Settings[key], other.Settings[key]
+		if !jsonEqual(mine, theirs) {
+			add(diffSetting, key, string(mine), string(theirs))
+		}
+	}
+	return differences
+}
+
+// jsonEqual reports whether two JSON values are the same apart from
+// formatting, nil values are missing and only equal each other
+func jsonEqual(a, b json.RawMessage) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	var compactA, compactB bytes.Buffer
+	if json.Compact(&compactA, a) != nil || json.Compact(&compactB, b) != nil {
+		return bytes.Equal(a, b)
+	}
+	return bytes.Equal(compactA.Bytes(), compactB.Bytes())
+}
+
+// printComparison prints the differences as a table
+func (s *Setup) printComparison(comparison EnvironmentComparison) error {
+	host := comparison.OtherHost
+	if host == "" {
+		host = "unknown host"
+	}
+	fmt.Fprintf(s.Out, "Comparing to %s (%s, %s)\n", comparison.Other, host, comparison.Generated.Local().Format(time.DateTime))
+	if len(comparison.Differences) == 0 {
+		_, err := fmt.Fprintln(s.Out, "Environments match")
+		return err
+	}
+
+	w := tabwriter.NewWriter(s.Out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tNAME\tHERE\tOTHER")
+	for _, d := range comparison.Differences {
+		localValue, otherValue := d.Local, d.Other
+		if d.Kind == diffSHA {
+			localValue, otherValue = shortSHA(localValue), shortSHA(otherValue)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", d.Kind, d.Name, orDash(localValue), orDash(otherValue))
+	}
+	return w.Flush()
+}
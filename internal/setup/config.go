@@ -0,0 +1,642 @@
+package setup
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mj41/stai-vscode/internal/paths"
+)
+
+// Supported values for the folder-paths option
+const (
+	FolderPathsRelative = "relative"
+	FolderPathsAbsolute = "absolute"
+)
+
+// Supported values for the folder-sort option
+const (
+	folderSortConfig = "config"
+	folderSortName   = "name"
+)
+
+// System-wide config overlay location
+const systemConfigPath = "/etc/stai-vscode/config.json"
+
+// Limits for downloading a remote config
+const (
+	remoteConfigTimeout = 30 * time.Second
+	remoteConfigMaxSize = 1 << 20
+)
+
+// Config represents the repositories configuration
+type Config struct {
+	User             string             `json:"user,omitempty"`
+	FolderPaths      string             `json:"folder-paths,omitempty"`
+	FolderSort       string             `json:"folder-sort,omitempty"`
+	Editor           string             `json:"editor,omitempty"`
+	Proxy            string             `json:"proxy,omitempty"`
+	GitUser          *GitUserConfig     `json:"git-user,omitempty"`
+	SignCommits      bool               `json:"sign-commits,omitempty"`
+	CloneRetry       *RetryConfig       `json:"clone-retry,omitempty"`
+	Timeouts         *TimeoutsConfig    `json:"timeouts,omitempty"`
+	MinFreeSpace     string             `json:"min-free-space,omitempty"`
+	BackupKeep       *int               `json:"backup-keep,omitempty"`
+	SeedFiles        []SeedFile         `json:"seed-files,omitempty"`
+	Editorconfig     string             `json:"editorconfig,omitempty"`      // Template replacing the embedded .editorconfig
+	GoWork           *bool              `json:"go-work,omitempty"`           // Generate go.work for checkouts with Go modules, default true
+	Services         []Service          `json:"services,omitempty"`          // Local services run with docker compose
+	ContainerRuntime string             `json:"container-runtime,omitempty"` // docker or podman for the services, detected by default
+	Binaries         []RequiredBinary   `json:"binaries,omitempty"`          // Prerequisites checked besides git and the editor
+	Permissions      *PermissionsConfig `json:"permissions,omitempty"`
+	Workspace        *WorkspaceSections `json:"workspace,omitempty"` // Sections merged into the workspace file
+	Repos            []Repository       `json:"repos"`
+	// Enabled remote type entries, moved out of Repos by selectRepos as
+	// only the workspace file uses them
+	RemoteFolders []Repository `json:"remote-folders,omitempty"`
+
+	// Git servers by host name, github.com and gitlab.com are built in
+	GitHosts map[string]GitHostConfig `json:"git-hosts,omitempty"`
+}
+
+// Repository represents a single repository configuration
+type Repository struct {
+	Name          string   `json:"name"`
+	Path          string   `json:"path,omitempty"`   // Relative to the base directory, default name
+	Subdir        string   `json:"subdir,omitempty"` // Directory added as the workspace folder
+	DisplayName   string   `json:"display-name,omitempty"`
+	GitRepo       *string  `json:"git-repo"`
+	Upstream      string   `json:"upstream,omitempty"`    // Canonical repository of a fork cloned as origin
+	PushRemote    string   `json:"push-remote,omitempty"` // Sets remote.pushDefault, origin or upstream
+	Type          string   `json:"type"`
+	URI           string   `json:"uri,omitempty"`            // vscode-remote:// folder of the remote type
+	Lang          string   `json:"lang,omitempty"`           // Selects the generated .gitignore of local-git-repo
+	DefaultBranch string   `json:"default-branch,omitempty"` // Initial branch of local-git-repo, default main
+	Submodules    bool     `json:"submodules,omitempty"`
+	SparsePaths   []string `json:"sparse-paths,omitempty"`
+	LFS           bool     `json:"lfs,omitempty"`
+	PostClone     []string `json:"post-clone,omitempty"`
+	SizeHint      string   `json:"size-hint,omitempty"`
+	// Additional remotes by name, e.g. read-only backups
+	Remotes map[string]string `json:"remotes,omitempty"`
+	// Branches checked out as separate worktrees of one bare clone
+	Worktrees []string `json:"worktrees,omitempty"`
+	// Disabled repositories stay in the config but are not set up
+	Enabled *bool `json:"enabled,omitempty"`
+	// Optional repositories are only set up with --with or when confirmed
+	Optional bool `json:"optional,omitempty"`
+	// Repositories with an order are pinned to the top of the workspace
+	// folder list, lowest first
+	Order *int `json:"order,omitempty"`
+	// Presets adding recommended extensions and default settings, e.g. go
+	Presets []string `json:"presets,omitempty"`
+	// VS Code settings merged into the workspace settings section
+	Settings map[string]json.RawMessage `json:"settings,omitempty"`
+	// VS Code settings and recommended extensions written to .vscode of
+	// the checkout, for tools reading per-folder configs
+	FolderSettings   map[string]json.RawMessage `json:"folder-settings,omitempty"`
+	FolderExtensions []string                   `json:"folder-extensions,omitempty"`
+}
+
+// repoPath returns the checkout location of a repository
+func repoPath(baseDir string, repo Repository) string {
+	if repo.Path != "" {
+		return filepath.Join(baseDir, filepath.FromSlash(repo.Path))
+	}
+	return filepath.Join(baseDir, repo.Name)
+}
+
+// staiTempRepo returns the config entry of stai-temp, a default entry
+// when the config has none
+func staiTempRepo(config *Config) Repository {
+	for _, repo := range config.Repos {
+		if repo.Name == "stai-temp" {
+			return repo
+		}
+	}
+	return Repository{Name: "stai-temp", Type: "local-git-repo"}
+}
+
+// validateRepoPaths checks that repository paths stay inside the base
+// directory, that no repository is checked out inside another one and
+// that subdirs stay inside their repository
+func validateRepoPaths(config *Config) error {
+	const baseDir = "/base"
+	reserved := environmentDirectories(baseDir, &Config{})
+	for i, repo := range config.Repos {
+		if repo.Path == "" {
+			continue
+		}
+		clean := filepath.ToSlash(filepath.Clean(filepath.FromSlash(repo.Path)))
+		if filepath.IsAbs(repo.Path) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+			return configErrorf("invalid path '%s' for %s, must be relative to the base directory", repo.Path, repo.Name)
+		}
+		if repo.Name == "stai-temp" {
+			return configErrorf("path must not be set for stai-temp")
+		}
+		if slices.Contains(reserved, repoPath(baseDir, repo)) {
+			return configErrorf("path '%s' of %s is reserved", repo.Path, repo.Name)
+		}
+		config.Repos[i].Path = clean
+	}
+
+	for i, repo := range config.Repos {
+		if repo.Subdir == "" {
+			continue
+		}
+		clean := filepath.ToSlash(filepath.Clean(filepath.FromSlash(repo.Subdir)))
+		if filepath.IsAbs(repo.Subdir) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+			return configErrorf("invalid subdir '%s' for %s, must be relative to the repository", repo.Subdir, repo.Name)
+		}
+		config.Repos[i].Subdir = clean
+	}
+
+	for i, a := range config.Repos {
+		for _, b := range config.Repos[i+1:] {
+			aDir, bDir := repoPath(baseDir, a), repoPath(baseDir, b)
+			if aDir == bDir {
+				return configErrorf("repositories %s and %s use the same path", a.Name, b.Name)
+			}
+			if isInside(aDir, bDir) || isInside(bDir, aDir) {
+				return configErrorf("repositories %s and %s must not be nested inside each other", a.Name, b.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// isInside reports whether path is below dir
+func isInside(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// configLayer is one raw config source of the effective config
+type configLayer struct {
+	name string
+	data []byte
+}
+
+// loadConfig merges all config layers into the effective config
+func (s *Setup) loadConfig() (*Config, error) {
+	layers, err := s.configLayers()
+	if err != nil {
+		return nil, err
+	}
+
+	merged := map[string]any{}
+	for _, layer := range layers {
+		var raw map[string]any
+		if err := json.Unmarshal(layer.data, &raw); err != nil {
+			return nil, configErrorf("failed to parse %s: %w", layer.name, err)
+		}
+		if inherit, ok := raw["inherit"].(bool); ok && !inherit {
+			merged = map[string]any{}
+		}
+		mergeRawConfig(merged, raw)
+	}
+	delete(merged, "inherit")
+
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return nil, configErrorf("failed to merge config: %w", err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, configErrorf("failed to parse merged config: %w", err)
+	}
+	if err := s.expandConfig(&config); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// loadEffectiveConfig loads and validates the config and applies
+// config dependent settings such as command timeouts
+func (s *Setup) loadEffectiveConfig() (*Config, error) {
+	config, err := s.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.selectRepos(config); err != nil {
+		return nil, err
+	}
+
+	if err := s.validateConfig(config); err != nil {
+		return nil, err
+	}
+
+	if err := s.configureTimeouts(config); err != nil {
+		return nil, err
+	}
+	if err := s.configurePermissions(config); err != nil {
+		return nil, err
+	}
+	s.configureGitAuth(config)
+
+	return config, nil
+}
+
+// configLayers returns config sources from lowest to highest precedence:
+// embedded defaults, system-wide, user, the overlay of the selected named
+// environment and --config. Missing system-wide, user and overlay files
+// are skipped.
+func (s *Setup) configLayers() ([]configLayer, error) {
+	layers := []configLayer{{name: "embedded config", data: s.assets.Config}}
+
+	var optional []string
+	optional = append(optional, systemConfigPath)
+	if path, err := s.userConfigPath(); err == nil {
+		optional = append(optional, path)
+	}
+	env, err := s.environment()
+	if err != nil {
+		return nil, err
+	}
+	if env != nil {
+		path, err := s.environmentConfigPath(env.Name)
+		if err != nil {
+			return nil, err
+		}
+		optional = append(optional, path)
+	}
+
+	for _, path := range optional {
+		data, err := s.FS.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, configErrorf("failed to read config file: %w", err)
+		}
+		layers = append(layers, configLayer{name: "config file " + path, data: data})
+	}
+
+	switch {
+	case isRemoteConfig(s.opts.Config):
+		data, err := fetchRemoteConfig(s.opts.Config, s.opts.ConfigSHA256)
+		if errors.Is(err, errConfigDownload) {
+			data, err = s.cachedRemoteConfig(s.opts.Config, s.opts.ConfigSHA256, err)
+		} else if err == nil {
+			s.cacheRemoteConfig(s.opts.Config, data)
+		}
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, configLayer{name: "config " + s.opts.Config, data: data})
+
+	case s.opts.Config != "":
+		data, err := s.FS.ReadFile(s.opts.Config)
+		if err != nil {
+			return nil, configErrorf("failed to read config file: %w", err)
+		}
+		layers = append(layers, configLayer{name: "config file " + s.opts.Config, data: data})
+	}
+
+	return layers, nil
+}
+
+// userConfigPath returns the per-user config file location
+func (s *Setup) userConfigPath() (string, error) {
+	dir, err := s.Users.ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, paths.App, "config.json"), nil
+}
+
+// mergeRawConfig merges overlay into base. Top-level fields replace base
+// values, repos are merged field by field by name and new repos appended.
+func mergeRawConfig(base, overlay map[string]any) {
+	for key, value := range overlay {
+		if key != "repos" {
+			base[key] = value
+			continue
+		}
+
+		baseRepos, _ := base["repos"].([]any)
+		overlayRepos, _ := value.([]any)
+		for _, item := range overlayRepos {
+			repo, ok := item.(map[string]any)
+			if !ok {
+				baseRepos = append(baseRepos, item)
+				continue
+			}
+			if existing := findRawRepo(baseRepos, repo["name"]); existing != nil {
+				for field, fieldValue := range repo {
+					existing[field] = fieldValue
+				}
+				continue
+			}
+			baseRepos = append(baseRepos, repo)
+		}
+		base["repos"] = baseRepos
+	}
+}
+
+// findRawRepo returns the raw repo entry with the given name
+func findRawRepo(repos []any, name any) map[string]any {
+	for _, item := range repos {
+		if repo, ok := item.(map[string]any); ok && repo["name"] == name {
+			return repo
+		}
+	}
+	return nil
+}
+
+// selectRepos applies --enable and --disable and removes disabled
+// repositories and optional ones neither requested with --with nor
+// confirmed, the rest of the run only sees selected ones
+func (s *Setup) selectRepos(config *Config) error {
+	overrides, err := s.enabledOverrides(config)
+	if err != nil {
+		return err
+	}
+	for _, name := range s.opts.With {
+		if !hasRepo(config, name) {
+			return ValidationErrorf("unknown repository '%s' in --with", name)
+		}
+	}
+
+	var input *bufio.Reader
+	repos := []Repository{}
+	for _, repo := range config.Repos {
+		if !repoEnabled(repo, overrides) {
+			continue
+		}
+
+		if repo.Optional && !slices.Contains(s.opts.With, repo.Name) {
+			if !s.Interactive {
+				continue
+			}
+			if input == nil {
+				input = bufio.NewReader(s.In)
+			}
+			fmt.Fprintf(s.Out, "Set up optional repository %s? [y/N] ", repo.Name)
+			answer, _ := input.ReadString('\n')
+			if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+				continue
+			}
+		}
+
+		repo.Enabled = nil
+		repo.Optional = false
+		if repo.Type == repoTypeRemote {
+			config.RemoteFolders = append(config.RemoteFolders, repo)
+			continue
+		}
+		repos = append(repos, repo)
+	}
+	config.Repos = repos
+
+	return nil
+}
+
+// enabledOverrides returns the --enable and --disable overrides by
+// repository name
+func (s *Setup) enabledOverrides(config *Config) (map[string]bool, error) {
+	overrides := map[string]bool{}
+	for _, name := range s.opts.Enable {
+		overrides[name] = true
+	}
+	for _, name := range s.opts.Disable {
+		if overrides[name] {
+			return nil, ValidationErrorf("repository '%s' cannot be both enabled and disabled", name)
+		}
+		overrides[name] = false
+	}
+
+	for name := range overrides {
+		if !hasRepo(config, name) {
+			return nil, ValidationErrorf("unknown repository '%s' in --enable or --disable", name)
+		}
+	}
+	return overrides, nil
+}
+
+// repoEnabled reports whether repo is enabled in the config or by overrides
+func repoEnabled(repo Repository, overrides map[string]bool) bool {
+	if override, ok := overrides[repo.Name]; ok {
+		return override
+	}
+	return repo.Enabled == nil || *repo.Enabled
+}
+
+// hasRepo reports whether config has a repository named name
+func hasRepo(config *Config, name string) bool {
+	return slices.ContainsFunc(config.Repos, func(repo Repository) bool { return repo.Name == name })
+}
+
+// validateConfig checks config options up front so that invalid values
+// are reported before anything is created on disk
+func (s *Setup) validateConfig(config *Config) error {
+	if _, err := s.folderPathsMode(config); err != nil {
+		return err
+	}
+
+	if _, err := folderSortMode(config); err != nil {
+		return err
+	}
+
+	if _, err := s.resolveEditor(config); err != nil {
+		return err
+	}
+
+	if _, err := s.cloneRetryPolicy(config); err != nil {
+		return err
+	}
+
+	if _, err := s.resolveTimeouts(config); err != nil {
+		return err
+	}
+
+	if _, err := s.minFreeSpace(config); err != nil {
+		return err
+	}
+
+	if _, err := s.backupKeep(config); err != nil {
+		return err
+	}
+
+	if err := validateRepoPaths(config); err != nil {
+		return err
+	}
+
+	if err := validateEditorconfig(config); err != nil {
+		return err
+	}
+	if err := validateSeedFiles(config); err != nil {
+		return err
+	}
+	if err := validateBinaries(config); err != nil {
+		return err
+	}
+	if err := validateServices(config); err != nil {
+		return err
+	}
+	if _, err := s.containerRuntime(config); err != nil {
+		return err
+	}
+
+	if err := validateGitHosts(config); err != nil {
+		return err
+	}
+
+	if err := validateWorkspaceSections(config); err != nil {
+		return err
+	}
+
+	for _, repo := range config.RemoteFolders {
+		if err := validateRemoteFolder(repo); err != nil {
+			return err
+		}
+	}
+	for _, repo := range config.Repos {
+		if repo.URI != "" {
+			return configErrorf("uri is only used for %s type, got it for %s", repoTypeRemote, repo.Name)
+		}
+		if repo.Type == "git-repo" && repo.GitRepo == nil {
+			return configErrorf("git-repo type requires git-repo URL for %s", repo.Name)
+		}
+		if repo.Type == repoTypeDir {
+			if err := validateDirRepo(repo); err != nil {
+				return err
+			}
+		}
+		if err := validateWorktrees(repo); err != nil {
+			return err
+		}
+		if err := validateUpstream(repo); err != nil {
+			return err
+		}
+		if err := s.validateLang(repo); err != nil {
+			return err
+		}
+		if err := s.validatePresets(repo); err != nil {
+			return err
+		}
+		if repo.DefaultBranch != "" {
+			if repo.Type != "local-git-repo" {
+				return configErrorf("default-branch is only used for local-git-repo type, got it for %s", repo.Name)
+			}
+			if strings.HasPrefix(repo.DefaultBranch, "-") || strings.ContainsAny(repo.DefaultBranch, " \t~^:?*[\\") {
+				return configErrorf("invalid default-branch '%s' for %s", repo.DefaultBranch, repo.Name)
+			}
+		}
+		if repo.SizeHint != "" {
+			if _, err := parseSize(repo.SizeHint); err != nil {
+				return configErrorf("invalid size-hint for %s: %w", repo.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// folderPathsMode returns the workspace folder paths style, preferring
+// the --folder-paths flag over the config 'folder-paths' field
+func (s *Setup) folderPathsMode(config *Config) (string, error) {
+	mode := s.opts.FolderPaths
+	if mode == "" {
+		mode = config.FolderPaths
+	}
+
+	switch mode {
+	case "", FolderPathsRelative:
+		return FolderPathsRelative, nil
+	case FolderPathsAbsolute:
+		return FolderPathsAbsolute, nil
+	default:
+		return "", configErrorf("invalid folder-paths value '%s', must be '%s' or '%s'", mode, FolderPathsRelative, FolderPathsAbsolute)
+	}
+}
+
+// folderSortMode returns how unpinned workspace folders are ordered
+func folderSortMode(config *Config) (string, error) {
+	switch config.FolderSort {
+	case "", folderSortConfig:
+		return folderSortConfig, nil
+	case folderSortName:
+		return folderSortName, nil
+	default:
+		return "", configErrorf("invalid folder-sort value '%s', must be '%s' or '%s'", config.FolderSort, folderSortConfig, folderSortName)
+	}
+}
+
+// isRemoteConfig reports whether the config location is a URL
+func isRemoteConfig(location string) bool {
+	return strings.HasPrefix(location, "https://") || strings.HasPrefix(location, "http://")
+}
+
+// fetchRemoteConfig downloads a config over HTTPS and verifies its SHA-256
+// checksum, taken from --config-sha256 or the "<url>.sha256" file
+func fetchRemoteConfig(configURL, expectedSum string) ([]byte, error) {
+	if !strings.HasPrefix(configURL, "https://") {
+		return nil, configErrorf("remote config must use https, got %s", configURL)
+	}
+
+	client := &http.Client{Timeout: remoteConfigTimeout}
+
+	data, err := HTTPGet(client, configURL, remoteConfigMaxSize)
+	if err != nil {
+		return nil, configErrorf("%w: %w", errConfigDownload, err)
+	}
+
+	if expectedSum == "" {
+		sumData, err := HTTPGet(client, configURL+".sha256", remoteConfigMaxSize)
+		if err != nil {
+			return nil, configErrorf("%w: checksum %s.sha256 (use --config-sha256 to provide it): %w", errConfigDownload, configURL, err)
+		}
+		// sha256sum format: "<hex>  <file name>"
+		fields := strings.Fields(string(sumData))
+		if len(fields) == 0 {
+			return nil, configErrorf("empty checksum file %s.sha256", configURL)
+		}
+		expectedSum = fields[0]
+	}
+
+	sum := sha256.Sum256(data)
+	actualSum := hex.EncodeToString(sum[:])
+	if !strings.EqualFold(actualSum, expectedSum) {
+		return nil, configErrorf("checksum mismatch for %s: expected %s, got %s", configURL, expectedSum, actualSum)
+	}
+
+	return data, nil
+}
+
+// HTTPGet returns the body of a successful GET response of at most
+// maxSize bytes
+func HTTPGet(client *http.Client, url string, maxSize int64) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("GET %s: %w", url, err)
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("GET %s: response larger than %d bytes", url, maxSize)
+	}
+
+	return data, nil
+}
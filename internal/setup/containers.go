@@ -0,0 +1,86 @@
+package setup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// containerRuntimes are the supported container runtimes in detection
+// order
+var containerRuntimes = []string{"docker", "podman"}
+
+// containerTimeout bounds each container runtime probe
+const containerTimeout = 15 * time.Second
+
+// containerRuntime returns the container runtime running the services:
+// the container-runtime config field, otherwise the first one of
+// containerRuntimes found in PATH, docker when none is installed
+func (s *Setup) containerRuntime(config *Config) (string, error) {
+	if config.ContainerRuntime != "" {
+		for _, runtime := range containerRuntimes {
+			if config.ContainerRuntime == runtime {
+				return runtime, nil
+			}
+		}
+		return "", configErrorf("invalid container-runtime '%s', must be one of: %s", config.ContainerRuntime, strings.Join(containerRuntimes, ", "))
+	}
+	for _, runtime := range containerRuntimes {
+		if _, err := s.Runner.LookPath(runtime); err == nil {
+			return runtime, nil
+		}
+	}
+	return containerRuntimes[0], nil
+}
+
+// checkContainerRuntime verifies the container runtime of the services is
+// installed, its daemon or socket is reachable and it supports compose.
+// Nothing is checked without services.
+func (s *Setup) checkContainerRuntime(config *Config) error {
+	if len(config.Services) == 0 {
+		return nil
+	}
+	runtime, err := s.containerRuntime(config)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.Runner.LookPath(runtime); err != nil {
+		if skippedBy, ok := s.CanSkipWarning(CheckContainerRuntime); ok {
+			s.Warnf("Container runtime '%s' for the services not found in PATH (continuing due to %s)", runtime, skippedBy)
+			return nil
+		}
+		return ValidationErrorf("container runtime '%s' for the services not found in PATH. Install it or set container-runtime. %s", runtime, skipHint(CheckContainerRuntime))
+	}
+
+	// info talks to the docker daemon or the podman socket
+	if err := s.probeContainerRuntime(runtime, "info"); err != nil {
+		if skippedBy, ok := s.CanSkipWarning(CheckContainerDaemon); ok {
+			s.Warnf("%s is not reachable: %v (continuing due to %s)", runtime, err, skippedBy)
+			return nil
+		}
+		return ValidationErrorf("%s is not reachable: %v. Start the daemon or check the socket permissions. %s", runtime, err, skipHint(CheckContainerDaemon))
+	}
+	if err := s.probeContainerRuntime(runtime, "compose", "version"); err != nil {
+		if skippedBy, ok := s.CanSkipWarning(CheckContainerRuntime); ok {
+			s.Warnf("'%s compose' is not available: %v (continuing due to %s)", runtime, err, skippedBy)
+			return nil
+		}
+		return ValidationErrorf("'%s compose' is not available: %v. Install the compose plugin. %s", runtime, err, skipHint(CheckContainerRuntime))
+	}
+
+	fmt.Fprintf(s.Out, "  Container runtime: %s\n", runtime)
+	return nil
+}
+
+// probeContainerRuntime runs a container runtime command without output
+func (s *Setup) probeContainerRuntime(runtime string, args ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), containerTimeout)
+	defer cancel()
+	err := s.runCommand(ctx, Command{Name: runtime, Args: args})
+	if ctx.Err() != nil {
+		return fmt.Errorf("no answer within %s", containerTimeout)
+	}
+	return err
+}
@@ -0,0 +1,157 @@
+package setup
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/user"
+
+	"github.com/mj41/stai-vscode/internal/paths"
+)
+
+// FileSystem is the filesystem access of a setup run
+type FileSystem interface {
+	Stat(name string) (fs.FileInfo, error)
+	// Lstat is Stat without following a final symlink
+	Lstat(name string) (fs.FileInfo, error)
+	Readlink(name string) (string, error)
+	Symlink(target, name string) error
+	ReadFile(name string) ([]byte, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	// AppendFile appends data to name, creating it with perm when missing
+	AppendFile(name string, data []byte, perm fs.FileMode) error
+	// CreateExclusive writes a new file, failing with fs.ErrExist when
+	// name already exists
+	CreateExclusive(name string, data []byte, perm fs.FileMode) error
+	MkdirAll(path string, perm fs.FileMode) error
+	Chmod(name string, mode fs.FileMode) error
+	Remove(name string) error
+	RemoveAll(path string) error
+	Rename(oldpath, newpath string) error
+	// FreeSpace returns bytes available to unprivileged users on the
+	// filesystem containing path
+	FreeSpace(path string) (int64, error)
+}
+
+// Command is an external command to run
+type Command struct {
+	Name   string
+	Args   []string
+	Dir    string    // Working directory, current directory when empty
+	Env    []string  // Complete environment, the current one when nil
+	Stdin  io.Reader // Empty input when nil
+	Stdout io.Writer // Discarded when nil
+	Stderr io.Writer // Discarded when nil
+}
+
+// Runner runs external commands such as git and post-clone hooks
+type Runner interface {
+	// Run runs the command and waits for it, ctx bounds its runtime
+	Run(ctx context.Context, cmd Command) error
+	// Start starts the command without waiting for it
+	Start(cmd Command) error
+	LookPath(file string) (string, error)
+}
+
+// UserLookup provides the current user and its directories
+type UserLookup interface {
+	Current() (*user.User, error)
+	HomeDir() (string, error)
+	ConfigDir() (string, error) // XDG_CONFIG_HOME
+	StateDir() (string, error)  // XDG_STATE_HOME
+	CacheDir() (string, error)  // XDG_CACHE_HOME
+}
+
+// OSFileSystem is the FileSystem of the operating system
+type OSFileSystem struct{}
+
+func (OSFileSystem) Stat(name string) (fs.FileInfo, error)      { return os.Stat(name) }
+func (OSFileSystem) Lstat(name string) (fs.FileInfo, error)     { return os.Lstat(name) }
+func (OSFileSystem) Readlink(name string) (string, error)       { return os.Readlink(name) }
+func (OSFileSystem) Symlink(target, name string) error          { return os.Symlink(target, name) }
+func (OSFileSystem) ReadFile(name string) ([]byte, error)       { return os.ReadFile(name) }
+func (OSFileSystem) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(name) }
+func (OSFileSystem) Remove(name string) error                   { return os.Remove(name) }
+func (OSFileSystem) RemoveAll(path string) error                { return os.RemoveAll(path) }
+func (OSFileSystem) Rename(oldpath, newpath string) error       { return os.Rename(oldpath, newpath) }
+
+func (OSFileSystem) Chmod(name string, mode fs.FileMode) error { return os.Chmod(name, mode) }
+
+func (OSFileSystem) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return os.WriteFile(name, data, perm)
+}
+
+func (OSFileSystem) AppendFile(name string, data []byte, perm fs.FileMode) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (OSFileSystem) CreateExclusive(name string, data []byte, perm fs.FileMode) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (OSFileSystem) MkdirAll(path string, perm fs.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (OSFileSystem) FreeSpace(path string) (int64, error) {
+	return freeDiskSpace(path)
+}
+
+// ExecRunner is the Runner executing real processes
+type ExecRunner struct{}
+
+func (ExecRunner) command(ctx context.Context, c Command) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, c.Name, c.Args...)
+	cmd.Dir = c.Dir
+	cmd.Env = c.Env
+	cmd.Stdin = c.Stdin
+	cmd.Stdout = c.Stdout
+	cmd.Stderr = c.Stderr
+	cmd.WaitDelay = commandWaitDelay
+	return cmd
+}
+
+func (r ExecRunner) Run(ctx context.Context, c Command) error {
+	return r.command(ctx, c).Run()
+}
+
+func (r ExecRunner) Start(c Command) error {
+	cmd := r.command(context.Background(), c)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	// The caller does not wait, let the process live on its own
+	return cmd.Process.Release()
+}
+
+func (ExecRunner) LookPath(file string) (string, error) {
+	return exec.LookPath(file)
+}
+
+// OSUsers is the UserLookup of the operating system
+type OSUsers struct{}
+
+func (OSUsers) Current() (*user.User, error) { return user.Current() }
+func (OSUsers) HomeDir() (string, error)     { return os.UserHomeDir() }
+func (OSUsers) ConfigDir() (string, error)   { return paths.ConfigHome() }
+func (OSUsers) StateDir() (string, error)    { return paths.StateHome() }
+func (OSUsers) CacheDir() (string, error)    { return paths.CacheHome() }
@@ -0,0 +1,159 @@
+package setup
+
+import (
+	"cmp"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// detectMaxFiles bounds the files counted per checkout by language
+// detection
+const detectMaxFiles = 5000
+
+// detectMaxDepth is how deep checkouts are searched for source files
+const detectMaxDepth = 6
+
+// detectMinShare is the share of counted source files a language needs to
+// be detected without a marker file, in percent
+const detectMinShare = 20
+
+// languageMarkers are files in the checkout root identifying the preset
+// of their language
+var languageMarkers = map[string]string{
+	"go.mod":           "go",
+	"Cargo.toml":       "rust",
+	"package.json":     "node",
+	"pyproject.toml":   "python",
+	"setup.py":         "python",
+	"setup.cfg":        "python",
+	"requirements.txt": "python",
+}
+
+// languageExtensions maps source file extensions to presets
+var languageExtensions = map[string]string{
+	".go":   "go",
+	".rs":   "rust",
+	".js":   "node",
+	".mjs":  "node",
+	".cjs":  "node",
+	".jsx":  "node",
+	".ts":   "node",
+	".tsx":  "node",
+	".py":   "python",
+	".md":   "docs",
+	".rst":  "docs",
+	".adoc": "docs",
+}
+
+// detectSkipDirs are directories of dependencies and build output not
+// counted by language detection
+var detectSkipDirs = []string{"vendor", "node_modules", "testdata", "target", "dist", "build", "__pycache__"}
+
+// withDetectedPresets returns config with the presets of repositories
+// without a presets entry detected from their checkouts, config itself
+// when nothing is detected. The detection is recorded for the summary.
+// An empty presets list disables detection for a repository.
+func (s *Setup) withDetectedPresets(baseDir string, config *Config) *Config {
+	var repos []Repository
+	for i, repo := range config.Repos {
+		if repo.Presets != nil {
+			continue
+		}
+		presets := s.detectPresets(folderDirs(baseDir, repo)[0])
+		if len(presets) == 0 {
+			continue
+		}
+		if repos == nil {
+			repos = slices.Clone(config.Repos)
+		}
+		repos[i].Presets = presets
+		s.recordDetected(repo.Name, presets)
+	}
+	if repos == nil {
+		return config
+	}
+	detected := *config
+	detected.Repos = repos
+	return &detected
+}
+
+// detectPresets returns the presets of the primary languages of the
+// checkout in dir: languages with a marker file in its root or at least
+// detectMinShare percent of its source files, most files first. docs is
+// only detected for checkouts without another language.
+func (s *Setup) detectPresets(dir string) []string {
+	if !s.exists(dir) {
+		return nil
+	}
+	counts := map[string]int{}
+	total := 0
+	s.countLanguageFiles(dir, 0, counts, &total)
+
+	var presets []string
+	for marker, preset := range languageMarkers {
+		if s.exists(filepath.Join(dir, marker)) && !slices.Contains(presets, preset) {
+			presets = append(presets, preset)
+		}
+	}
+	for preset, count := range counts {
+		if preset != "docs" && count*100 >= total*detectMinShare && !slices.Contains(presets, preset) {
+			presets = append(presets, preset)
+		}
+	}
+	if len(presets) == 0 && counts["docs"] > 0 {
+		presets = append(presets, "docs")
+	}
+
+	// Embedded presets only, the assets may leave some out
+	presets = slices.DeleteFunc(presets, func(name string) bool {
+		_, ok := s.assets.Presets[name]
+		return !ok
+	})
+	slices.SortFunc(presets, func(a, b string) int {
+		return cmp.Or(counts[b]-counts[a], strings.Compare(a, b))
+	})
+	return presets
+}
+
+// countLanguageFiles counts the source files of dir by preset, skipping
+// hidden, dependency and build directories, up to detectMaxFiles files
+func (s *Setup) countLanguageFiles(dir string, depth int, counts map[string]int, total *int) {
+	entries, err := s.FS.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if *total >= detectMaxFiles {
+			return
+		}
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		if entry.IsDir() {
+			if depth < detectMaxDepth && !slices.Contains(detectSkipDirs, name) {
+				s.countLanguageFiles(filepath.Join(dir, name), depth+1, counts, total)
+			}
+			continue
+		}
+		if preset, ok := languageExtensions[strings.ToLower(filepath.Ext(name))]; ok {
+			counts[preset]++
+			*total++
+		}
+	}
+}
+
+// recordDetected records the detected presets of a repository for the
+// summary
+func (s *Setup) recordDetected(name string, presets []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, d := range s.summary.Detected {
+		if d.Name == name {
+			s.summary.Detected[i].Presets = presets
+			return
+		}
+	}
+	s.summary.Detected = append(s.summary.Detected, DetectedPresets{Name: name, Presets: presets})
+}
@@ -0,0 +1,47 @@
+package setup
+
+import (
+	"fmt"
+
+	"github.com/mj41/stai-vscode/internal/diff"
+)
+
+// Diff prints a unified diff between the on-disk workspace file and
+// the content ws-config-gen would write
+func (s *Setup) Diff() error {
+	config, err := s.loadEffectiveConfig()
+	if err != nil {
+		return err
+	}
+
+	workDir, err := s.validateWorkingDirectory(s.opts.WorkDir)
+	if err != nil {
+		return err
+	}
+	baseDir, err := s.resolveBaseDirectory(s.opts.BaseDir, workDir)
+	if err != nil {
+		return err
+	}
+
+	plan, err := s.planWorkspace(baseDir, config)
+	if err != nil {
+		return err
+	}
+
+	oldName := plan.Path
+	if plan.Existing == nil {
+		oldName = "/dev/null"
+	}
+
+	out := diff.Unified(oldName, plan.Path, string(plan.Existing), string(plan.Output), diff.DefaultContext)
+	if out == "" {
+		s.PrintDone("Workspace file is up to date")
+		return nil
+	}
+
+	if plan.Merged {
+		fmt.Fprintln(s.Out, "# User edits of the existing workspace file are kept")
+	}
+	fmt.Fprint(s.Out, out)
+	return nil
+}
@@ -0,0 +1,94 @@
+package setup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/mj41/stai-vscode/internal/paths"
+)
+
+// errConfigDownload marks a remote config that could not be downloaded,
+// the cached copy is used instead
+var errConfigDownload = errors.New("failed to download config")
+
+// envStateDir returns the per-user state directory of the environment in
+// baseDir, holding its lock, run state and backups:
+// $XDG_STATE_HOME/stai-vscode/envs/<name>-<hash>
+func (s *Setup) envStateDir(baseDir string) (string, error) {
+	dir, err := s.Users.StateDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get state directory: %w", err)
+	}
+	return filepath.Join(dir, paths.App, "envs", paths.EnvironmentKey(baseDir)), nil
+}
+
+// envStatePath returns the location of a state file of the environment in
+// baseDir, creating its directory
+func (s *Setup) envStatePath(baseDir, name string) (string, error) {
+	dir, err := s.envStateDir(baseDir)
+	if err != nil {
+		return "", err
+	}
+	if err := s.FS.MkdirAll(dir, s.perms.dirs); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// remoteConfigCachePath returns where the last verified download of a
+// remote config is kept: $XDG_CACHE_HOME/stai-vscode/config/<hash>.json
+func (s *Setup) remoteConfigCachePath(configURL string) (string, error) {
+	dir, err := s.Users.CacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(configURL))
+	return filepath.Join(dir, paths.App, "config", hex.EncodeToString(sum[:8])+".json"), nil
+}
+
+// cacheRemoteConfig keeps a verified remote config for offline runs, a
+// failure only loses the fallback
+func (s *Setup) cacheRemoteConfig(configURL string, data []byte) {
+	path, err := s.remoteConfigCachePath(configURL)
+	if err != nil {
+		return
+	}
+	if err := s.FS.MkdirAll(filepath.Dir(path), s.perms.dirs); err != nil {
+		return
+	}
+	_ = s.FS.WriteFile(path, data, s.perms.files)
+}
+
+// cachedRemoteConfig returns the cached copy of a remote config that
+// failed to download with downloadErr. An expected checksum has to match
+// the copy too.
+func (s *Setup) cachedRemoteConfig(configURL, expectedSum string, downloadErr error) ([]byte, error) {
+	path, err := s.remoteConfigCachePath(configURL)
+	if err != nil {
+		return nil, downloadErr
+	}
+	data, err := s.readOptional(path)
+	if err != nil || data == nil {
+		return nil, downloadErr
+	}
+	sum := sha256.Sum256(data)
+	if expectedSum != "" && !strings.EqualFold(hex.EncodeToString(sum[:]), expectedSum) {
+		return nil, downloadErr
+	}
+	s.Warnf("Using the cached config %s: %v", path, downloadErr)
+	return data, nil
+}
+
+// backupDir returns the workspace file backup directory of the
+// environment in baseDir
+func (s *Setup) backupDir(baseDir string) (string, error) {
+	dir, err := s.envStateDir(baseDir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "backups"), nil
+}
@@ -0,0 +1,285 @@
+package setup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Discovery defaults
+const (
+	defaultGitHubAPI  = "https://api.github.com"
+	discoverPageSize  = 100
+	discoverMaxPages  = 100
+	discoverMaxResult = 10 << 20
+	discoverTimeout   = 30 * time.Second // per API request
+)
+
+// DiscoverOptions selects the repositories of a discover run
+type DiscoverOptions struct {
+	GitHubOrg   string
+	GitLabGroup string   // Group path, subgroups are included
+	APIURL      string   // API base URL, default api.github.com or gitlab.com/api/v4
+	Topics      []string // Repositories must have all topics
+	NameFilter  string   // path.Match pattern for repository names
+}
+
+// discoveredRepo is a repository returned by a hosting API
+type discoveredRepo struct {
+	Name     string
+	SSHURL   string
+	Topics   []string
+	Archived bool
+}
+
+// Discover lists the repositories of a GitHub organization or GitLab
+// group matching the filters and writes them as a config to file, "-"
+// for stdout. An existing config file is updated, repositories it
+// already has are kept as they are.
+func (s *Setup) Discover(opts DiscoverOptions, file string) error {
+	if (opts.GitHubOrg == "") == (opts.GitLabGroup == "") {
+		return ValidationErrorf("usage: ws-config-gen discover --github-org ORG|--gitlab-group GROUP [--topic TOPIC] [--name-filter PATTERN] [FILE]")
+	}
+	if _, err := path.Match(opts.NameFilter, ""); err != nil {
+		return ValidationErrorf("invalid --name-filter '%s': %w", opts.NameFilter, err)
+	}
+
+	config, err := s.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	var found []discoveredRepo
+	source := opts.GitHubOrg
+	if opts.GitHubOrg != "" {
+		apiURL, token := discoverAPI(config, HostGitHub, "github.com", opts.APIURL)
+		found, err = githubOrgRepos(apiURL, token, opts.GitHubOrg)
+	} else {
+		source = opts.GitLabGroup
+		apiURL, token := discoverAPI(config, HostGitLab, "gitlab.com", opts.APIURL)
+		found, err = gitlabGroupRepos(apiURL, token, opts.GitLabGroup)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list repositories of %s: %w", source, err)
+	}
+
+	raw := map[string]any{}
+	if file != "-" {
+		data, err := s.readOptional(file)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		if data != nil {
+			if err := json.Unmarshal(data, &raw); err != nil {
+				return configErrorf("failed to parse %s: %w", file, err)
+			}
+		}
+	}
+
+	repos, _ := raw["repos"].([]any)
+	added := 0
+	for _, repo := range found {
+		if !discoverMatch(repo, opts) || findRawRepo(repos, repo.Name) != nil {
+			continue
+		}
+		repos = append(repos, map[string]any{"name": repo.Name, "git-repo": repo.SSHURL, "type": "git-repo"})
+		added++
+	}
+	if repos == nil {
+		repos = []any{}
+	}
+	raw["repos"] = repos
+
+	data, err := json.MarshalIndent(raw, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to marshal discovered config: %w", err)
+	}
+	data = append(data, '\n')
+
+	if file == "-" {
+		_, err = s.Out.Write(data)
+		return err
+	}
+	if err := s.FS.WriteFile(file, data, s.perms.files); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+	fmt.Fprintf(s.Out, "Added %d of %d repositories of %s to %s\n", added, len(found), source, file)
+	return nil
+}
+
+// discoverMatch reports whether a non-archived repository passes the
+// topic and name filters
+func discoverMatch(repo discoveredRepo, opts DiscoverOptions) bool {
+	if repo.Archived {
+		return false
+	}
+	for _, topic := range opts.Topics {
+		if !slices.Contains(repo.Topics, topic) {
+			return false
+		}
+	}
+	if opts.NameFilter != "" {
+		if ok, _ := path.Match(opts.NameFilter, repo.Name); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// discoverAPI returns the API base URL and token of a discover run. A
+// token is only sent to its own host: the one of the public service to
+// its API host, the one of a 'git-hosts' entry of the kind to the server
+// or its API host. Other API URLs get no token.
+func discoverAPI(config *Config, kind, defaultHost, apiURL string) (string, string) {
+	public, _ := gitHost(config, defaultHost)
+	if apiURL == "" {
+		return public.apiURL(defaultHost), public.token()
+	}
+	host := urlHostname(apiURL)
+	switch {
+	case host == "":
+		return apiURL, ""
+	case host == urlHostname(public.apiURL(defaultHost)):
+		return apiURL, public.token()
+	}
+	for _, name := range slices.Sorted(maps.Keys(config.GitHosts)) {
+		h := config.GitHosts[name]
+		if h.Type == kind && (host == name || host == urlHostname(h.apiURL(name))) {
+			return apiURL, h.token()
+		}
+	}
+	return apiURL, ""
+}
+
+// urlHostname returns the host name of rawURL, empty when it has none
+func urlHostname(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// githubOrgRepos returns all repositories of a GitHub organization
+func githubOrgRepos(apiURL, token, org string) ([]discoveredRepo, error) {
+	client := &http.Client{Timeout: discoverTimeout}
+	var repos []discoveredRepo
+	for page := 1; page <= discoverMaxPages; page++ {
+		pageURL := fmt.Sprintf("%s/orgs/%s/repos?per_page=%d&page=%d", strings.TrimSuffix(apiURL, "/"), url.PathEscape(org), discoverPageSize, page)
+		req, err := http.NewRequest(http.MethodGet, pageURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/vnd.github+json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		data, err := apiGet(client, req)
+		if err != nil {
+			return nil, err
+		}
+
+		var items []struct {
+			Name     string   `json:"name"`
+			SSHURL   string   `json:"ssh_url"`
+			Topics   []string `json:"topics"`
+			Archived bool     `json:"archived"`
+		}
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil, fmt.Errorf("GitHub API: %w", err)
+		}
+		for _, item := range items {
+			repos = append(repos, discoveredRepo{Name: item.Name, SSHURL: item.SSHURL, Topics: item.Topics, Archived: item.Archived})
+		}
+		if len(items) < discoverPageSize {
+			break
+		}
+	}
+	return repos, nil
+}
+
+// gitlabGroupRepos returns all projects of a GitLab group and its
+// subgroups
+func gitlabGroupRepos(apiURL, token, group string) ([]discoveredRepo, error) {
+	client := &http.Client{Timeout: discoverTimeout}
+	var repos []discoveredRepo
+	for page := 1; page <= discoverMaxPages; page++ {
+		pageURL := fmt.Sprintf("%s/groups/%s/projects?include_subgroups=true&per_page=%d&page=%d", strings.TrimSuffix(apiURL, "/"), url.PathEscape(group), discoverPageSize, page)
+		req, err := http.NewRequest(http.MethodGet, pageURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		if token != "" {
+			req.Header.Set("PRIVATE-TOKEN", token)
+		}
+
+		data, err := apiGet(client, req)
+		if err != nil {
+			return nil, err
+		}
+
+		var items []struct {
+			Path     string   `json:"path"`
+			FullPath string   `json:"path_with_namespace"`
+			SSHURL   string   `json:"ssh_url_to_repo"`
+			Topics   []string `json:"topics"`
+			TagList  []string `json:"tag_list"` // topics before GitLab 14.5
+			Archived bool     `json:"archived"`
+		}
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil, fmt.Errorf("GitLab API: %w", err)
+		}
+		for _, item := range items {
+			topics := item.Topics
+			if topics == nil {
+				topics = item.TagList
+			}
+			repos = append(repos, discoveredRepo{Name: gitlabRepoName(group, item.Path, item.FullPath), SSHURL: item.SSHURL, Topics: topics, Archived: item.Archived})
+		}
+		if len(items) < discoverPageSize {
+			break
+		}
+	}
+	return repos, nil
+}
+
+// gitlabRepoName names a project by its path below group, '/' of
+// subgroups replaced by '-', so projects of the same name in different
+// subgroups stay apart
+func gitlabRepoName(group, name, fullPath string) string {
+	prefix := strings.Trim(group, "/") + "/"
+	if len(fullPath) > len(prefix) && strings.EqualFold(fullPath[:len(prefix)], prefix) {
+		return strings.ReplaceAll(fullPath[len(prefix):], "/", "-")
+	}
+	return name
+}
+
+// apiGet returns the body of a successful API response
+func apiGet(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", req.URL.Redacted(), resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, discoverMaxResult+1))
+	if err != nil {
+		return nil, fmt.Errorf("GET %s: %w", req.URL.Redacted(), err)
+	}
+	if len(data) > discoverMaxResult {
+		return nil, fmt.Errorf("GET %s: response larger than %d bytes", req.URL.Redacted(), discoverMaxResult)
+	}
+	return data, nil
+}
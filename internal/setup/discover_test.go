@@ -0,0 +1,66 @@
+package setup
+
+import "testing"
+
+func TestDiscoverAPI(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "public-github")
+	t.Setenv("GITLAB_TOKEN", "public-gitlab")
+	t.Setenv("CORP_TOKEN", "corp")
+	t.Setenv("GHE_TOKEN", "ghe")
+	config := &Config{GitHosts: map[string]GitHostConfig{
+		"git.corp.example":    {Type: HostGitLab, TokenEnv: "CORP_TOKEN"},
+		"github.corp.example": {Type: HostGitHub, APIURL: "https://api.github.corp.example/v3", TokenEnv: "GHE_TOKEN"},
+		"plain.example":       {TokenEnv: "CORP_TOKEN"},
+	}}
+
+	tests := []struct {
+		name      string
+		kind      string
+		apiURL    string
+		wantURL   string
+		wantToken string
+	}{
+		{"public github", HostGitHub, "", defaultGitHubAPI, "public-github"},
+		{"public gitlab", HostGitLab, "", defaultGitLabAPI, "public-gitlab"},
+		{"public api host", HostGitHub, "https://api.github.com/", "https://api.github.com/", "public-github"},
+		{"git-hosts server", HostGitLab, "https://git.corp.example/api/v4", "https://git.corp.example/api/v4", "corp"},
+		{"git-hosts api host", HostGitHub, "https://api.github.corp.example/v3", "https://api.github.corp.example/v3", "ghe"},
+		{"git-hosts server of another kind", HostGitHub, "https://git.corp.example/api/v3", "https://git.corp.example/api/v3", ""},
+		{"git-hosts server without kind", HostGitLab, "https://plain.example/api/v4", "https://plain.example/api/v4", ""},
+		{"unknown host", HostGitHub, "https://evil.example/api/v3", "https://evil.example/api/v3", ""},
+		{"public host as user info", HostGitHub, "https://api.github.com@evil.example/", "https://api.github.com@evil.example/", ""},
+		{"no host", HostGitLab, "not a url", "not a url", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defaultHost := "github.com"
+			if tt.kind == HostGitLab {
+				defaultHost = "gitlab.com"
+			}
+			gotURL, gotToken := discoverAPI(config, tt.kind, defaultHost, tt.apiURL)
+			if gotURL != tt.wantURL || gotToken != tt.wantToken {
+				t.Errorf("discoverAPI(%q) = %q, %q, want %q, %q", tt.apiURL, gotURL, gotToken, tt.wantURL, tt.wantToken)
+			}
+		})
+	}
+}
+
+func TestGitlabRepoName(t *testing.T) {
+	tests := []struct {
+		group, name, fullPath string
+		want                  string
+	}{
+		{"team", "app", "team/app", "app"},
+		{"team", "app", "team/backend/app", "backend-app"},
+		{"Team/", "app", "team/backend/app", "backend-app"},
+		{"team/backend", "app", "team/backend/svc/app", "svc-app"},
+		{"team", "app", "", "app"},
+		{"team", "app", "other/app", "app"},
+		{"team", "app", "teamx/app", "app"},
+	}
+	for _, tt := range tests {
+		if got := gitlabRepoName(tt.group, tt.name, tt.fullPath); got != tt.want {
+			t.Errorf("gitlabRepoName(%q, %q, %q) = %q, want %q", tt.group, tt.name, tt.fullPath, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package setup
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default free space kept in reserve on top of the estimated clone sizes
+const defaultMinFreeSpace = 1 << 30
+
+// githubAPITimeout bounds a single GitHub API size lookup
+const githubAPITimeout = 10 * time.Second
+
+// sizeUnits maps size suffixes to their byte multipliers
+var sizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// parseSize parses sizes like "500MB", "2GB" or a plain number of bytes
+func parseSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size '%s', expected e.g. 500MB or 2GB", value)
+	}
+	return int64(number * float64(multiplier)), nil
+}
+
+// formatSize formats bytes for humans
+func formatSize(bytes int64) string {
+	for _, unit := range sizeUnits {
+		if bytes >= unit.multiplier && unit.multiplier > 1 {
+			return fmt.Sprintf("%.1f%s", float64(bytes)/float64(unit.multiplier), unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", bytes)
+}
+
+// minFreeSpace returns the configured free space reserve
+func (s *Setup) minFreeSpace(config *Config) (int64, error) {
+	if config.MinFreeSpace == "" {
+		return defaultMinFreeSpace, nil
+	}
+	size, err := parseSize(config.MinFreeSpace)
+	if err != nil {
+		return 0, configErrorf("invalid min-free-space: %w", err)
+	}
+	return size, nil
+}
+
+// githubRepoPath returns "owner/repo" for github.com URLs
+func githubRepoPath(repoURL string) (string, bool) {
+	if _, host := repoURLHost(repoURL); host != "github.com" {
+		return "", false
+	}
+
+	var path string
+	if u, err := url.Parse(repoURL); err == nil && u.Scheme != "" && strings.Contains(repoURL, "://") {
+		path = u.Path
+	} else if i := strings.Index(repoURL, ":"); i >= 0 {
+		// scp-like git@github.com:owner/repo.git
+		path = repoURL[i+1:]
+	}
+
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	if strings.Count(path, "/") != 1 {
+		return "", false
+	}
+	return path, true
+}
+
+// githubRepoSize asks the GitHub API for the repository size in bytes,
+// GITHUB_TOKEN is used when set
+func githubRepoSize(ownerRepo string) (int64, error) {
+	req, err := http.NewRequest(http.MethodGet, "https://api.github.com/repos/"+ownerRepo, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := &http.Client{Timeout: githubAPITimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("GitHub API: %s", resp.Status)
+	}
+
+	var info struct {
+		Size int64 `json:"size"` // in KB
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return 0, fmt.Errorf("GitHub API: %w", err)
+	}
+	return info.Size << 10, nil
+}
+
+// estimateRepoSize returns the expected on-disk size of a clone. The
+// 'size-hint' wins, otherwise the GitHub repository size is doubled to
+// account for the working tree. Unknown sizes are reported as 0, false.
+func estimateRepoSize(repo Repository) (int64, bool) {
+	if repo.SizeHint != "" {
+		size, err := parseSize(repo.SizeHint)
+		return size, err == nil
+	}
+
+	if repo.GitRepo == nil {
+		return 0, false
+	}
+	ownerRepo, ok := githubRepoPath(*repo.GitRepo)
+	if !ok {
+		return 0, false
+	}
+	size, err := githubRepoSize(ownerRepo)
+	if err != nil {
+		return 0, false
+	}
+	return size * 2, true
+}
+
+// checkDiskSpace verifies the base directory filesystem can hold all
+// repositories still to be cloned plus the configured reserve
+func (s *Setup) checkDiskSpace(config *Config, baseDir string) error {
+	reserve, err := s.minFreeSpace(config)
+	if err != nil {
+		return err
+	}
+
+	required := reserve
+	var unknown []string
+	for _, repo := range config.Repos {
+		if repo.Type != "git-repo" {
+			continue
+		}
+		if _, err := s.FS.Stat(repoPath(baseDir, repo)); err == nil {
+			continue
+		}
+
+		size, ok := estimateRepoSize(repo)
+		if !ok {
+			unknown = append(unknown, repo.Name)
+			continue
+		}
+		required += size
+	}
+
+	free, err := s.FS.FreeSpace(baseDir)
+	if err != nil {
+		s.Warnf("Cannot determine free disk space: %v", err)
+		return nil
+	}
+
+	if len(unknown) > 0 {
+		fmt.Fprintf(s.Out, "  Size unknown for %s (set 'size-hint' to include them)\n", strings.Join(unknown, ", "))
+	}
+
+	if free < required {
+		if skippedBy, ok := s.CanSkipWarning(CheckDiskSpace); ok {
+			s.Warnf("Not enough free disk space in %s: %s free, %s required (continuing due to %s)", baseDir, formatSize(free), formatSize(required), skippedBy)
+			return nil
+		}
+		return ValidationErrorf("not enough free disk space in %s: %s free, %s required. Free some space or use --skip-check=%s to ignore this check", baseDir, formatSize(free), formatSize(required), CheckDiskSpace)
+	}
+
+	fmt.Fprintf(s.Out, "  Disk space: %s free, %s required\n", formatSize(free), formatSize(required))
+	return nil
+}
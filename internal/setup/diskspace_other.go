@@ -0,0 +1,10 @@
+//go:build !linux
+
+package setup
+
+import "errors"
+
+// freeDiskSpace is only implemented on Linux
+func freeDiskSpace(path string) (int64, error) {
+	return 0, errors.New("free disk space check is not supported on this platform")
+}
@@ -0,0 +1,279 @@
+package setup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Editor describes a supported VS Code flavour
+type Editor struct {
+	Name    string // Value of the 'editor' config field and --editor flag
+	Binary  string // CLI binary used for the binary check and --open
+	DataDir string // Directory name under the user config directory
+	Flatpak string // Flatpak application ID, empty when not packaged
+	Snap    string // Snap name, empty when not packaged
+	// Command launches the editor CLI: Binary, the snap binary or flatpak
+	// run, set by resolveEditor
+	Command []string
+}
+
+// snapBinDir holds the binaries of installed snaps, it is not always in
+// PATH
+const snapBinDir = "/snap/bin"
+
+// launch returns the launch command for messages
+func (e Editor) launch() string {
+	return strings.Join(e.Command, " ")
+}
+
+// flatpak reports whether the editor is the Flatpak one, which runs
+// sandboxed
+func (e Editor) flatpak() bool {
+	return len(e.Command) > 0 && e.Command[0] == "flatpak"
+}
+
+// Editor used when neither config nor flag selects one
+const defaultEditor = "code-insiders"
+
+// editors lists all supported editors
+var editors = []Editor{
+	{Name: "code", Binary: "code", DataDir: "Code", Flatpak: "com.visualstudio.code", Snap: "code"},
+	{Name: "code-insiders", Binary: "code-insiders", DataDir: "Code - Insiders", Flatpak: "com.visualstudio.code.insiders", Snap: "code-insiders"},
+	{Name: "codium", Binary: "codium", DataDir: "VSCodium", Flatpak: "com.vscodium.codium", Snap: "codium"},
+	{Name: "cursor", Binary: "cursor", DataDir: "Cursor"},
+}
+
+// EditorNames returns the names of all supported editors
+func EditorNames() []string {
+	var names []string
+	for _, e := range editors {
+		names = append(names, e.Name)
+	}
+	return names
+}
+
+// resolveEditor returns the editor selected by --editor or the config
+// 'editor' field
+func (s *Setup) resolveEditor(config *Config) (Editor, error) {
+	name := s.opts.Editor
+	if name == "" {
+		name = config.Editor
+	}
+	if name == "" {
+		name = defaultEditor
+	}
+
+	for _, e := range editors {
+		if e.Name == name {
+			e.Command = s.editorCommand(e)
+			return e, nil
+		}
+	}
+	return Editor{}, configErrorf("invalid editor '%s', must be one of: %s", name, strings.Join(EditorNames(), ", "))
+}
+
+// editorCommand returns the command launching the editor: its binary in
+// PATH, otherwise the binary of its snap or flatpak run of its Flatpak,
+// the binary when neither is installed. The result is cached per editor.
+func (s *Setup) editorCommand(e Editor) []string {
+	s.mu.Lock()
+	command, ok := s.editorCommands[e.Name]
+	s.mu.Unlock()
+	if ok {
+		return command
+	}
+
+	command = []string{e.Binary}
+	if _, err := s.Runner.LookPath(e.Binary); err != nil {
+		if snap := filepath.Join(snapBinDir, e.Snap); e.Snap != "" && s.exists(snap) {
+			command = []string{snap}
+		} else if e.Flatpak != "" && s.flatpakInstalled(e.Flatpak) {
+			command = []string{"flatpak", "run", e.Flatpak}
+		}
+	}
+
+	s.mu.Lock()
+	if s.editorCommands == nil {
+		s.editorCommands = map[string][]string{}
+	}
+	s.editorCommands[e.Name] = command
+	s.mu.Unlock()
+	return command
+}
+
+// flatpakInstalled reports whether the Flatpak application is installed
+// for the user or system-wide
+func (s *Setup) flatpakInstalled(id string) bool {
+	if _, err := s.Runner.LookPath("flatpak"); err != nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), binaryVersionTimeout)
+	defer cancel()
+	return s.runCommand(ctx, Command{Name: "flatpak", Args: []string{"info", "--show-ref", id}}) == nil
+}
+
+// userSettingsDir returns the editor's user settings directory,
+// empty when the user config directory is unknown. A Flatpak editor keeps
+// it in its own config directory under ~/.var/app.
+func (s *Setup) userSettingsDir(e Editor) string {
+	if e.flatpak() {
+		home, err := s.Users.HomeDir()
+		if err != nil {
+			return ""
+		}
+		return filepath.Join(home, ".var", "app", e.Flatpak, "config", e.DataDir, "User")
+	}
+	dir, err := s.Users.ConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, e.DataDir, "User")
+}
+
+// openWorkspace launches the editor with the workspace file. A failed
+// launch is not an error, the setup itself is already complete.
+func (s *Setup) openWorkspace(editor Editor, workspacePath string) {
+	// The editor CLI hands over to a running instance or detaches itself
+	cmd := Command{Name: editor.Command[0], Args: append(slices.Clone(editor.Command[1:]), workspacePath)}
+	if err := s.Runner.Start(cmd); err != nil {
+		s.Warnf("Failed to open the workspace with '%s': %v", editor.launch(), err)
+		fmt.Fprintf(s.Out, "Open it manually: %s %s\n", editor.launch(), workspacePath)
+	}
+}
+
+// editorTimeout bounds each editor CLI call, --list-extensions starts the
+// editor's extension host
+const editorTimeout = time.Minute
+
+// checkEditor verifies the editor CLI works by asking for its version. A
+// missing binary is reported by the binaries check.
+func (s *Setup) checkEditor(config *Config) error {
+	editor, err := s.resolveEditor(config)
+	if err != nil {
+		return err
+	}
+	if _, err := s.Runner.LookPath(editor.Command[0]); err != nil {
+		return nil
+	}
+
+	out, err := s.editorOutput(editor, "--version")
+	if err != nil {
+		if skippedBy, ok := s.CanSkipWarning(CheckEditor); ok {
+			s.Warnf("'%s --version' failed: %v (continuing due to %s)", editor.launch(), err, skippedBy)
+			return nil
+		}
+		return ValidationErrorf("'%s --version' failed: %v. Reinstall the editor. %s", editor.launch(), err, skipHint(CheckEditor))
+	}
+	version, _, _ := strings.Cut(strings.TrimSpace(out), "\n")
+	fmt.Fprintf(s.Out, "  %s %s\n", editor.launch(), version)
+	return nil
+}
+
+// recommendedExtensions returns the extensions the generated files
+// recommend: the presets of the workspace file, detected ones included,
+// the workspace extensions of the config and the folder-extensions of the
+// repositories
+func (s *Setup) recommendedExtensions(baseDir string, config *Config) ([]string, error) {
+	extensions, err := s.presetExtensions(s.withDetectedPresets(baseDir, config))
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range configExtensions(config) {
+		if !slices.Contains(extensions, id) {
+			extensions = append(extensions, id)
+		}
+	}
+	for _, repo := range config.Repos {
+		for _, id := range repo.FolderExtensions {
+			if !slices.Contains(extensions, id) {
+				extensions = append(extensions, id)
+			}
+		}
+	}
+	return extensions, nil
+}
+
+// missingExtensions returns the recommended extensions the editor does
+// not have installed, extension IDs compare case-insensitively
+func (s *Setup) missingExtensions(editor Editor, recommended []string) ([]string, error) {
+	out, err := s.editorOutput(editor, "--list-extensions")
+	if err != nil {
+		return nil, fmt.Errorf("'%s --list-extensions' failed: %w", editor.launch(), err)
+	}
+	installed := map[string]bool{}
+	for _, line := range strings.Split(out, "\n") {
+		installed[strings.ToLower(strings.TrimSpace(line))] = true
+	}
+
+	var missing []string
+	for _, id := range recommended {
+		if !installed[strings.ToLower(id)] {
+			missing = append(missing, id)
+		}
+	}
+	return missing, nil
+}
+
+// checkExtensions reports the recommended extensions missing in the
+// editor, or installs them with --install-extensions. Problems of the
+// editor CLI are warnings, the environment works without extensions.
+func (s *Setup) checkExtensions(baseDir string, config *Config) error {
+	editor, err := s.resolveEditor(config)
+	if err != nil {
+		return err
+	}
+	if _, err := s.Runner.LookPath(editor.Command[0]); err != nil {
+		fmt.Fprintf(s.Out, "  '%s' not found, extensions not checked\n", editor.Binary)
+		return nil
+	}
+
+	recommended, err := s.recommendedExtensions(baseDir, config)
+	if err != nil {
+		return err
+	}
+	if len(recommended) == 0 {
+		fmt.Fprintln(s.Out, "  No recommended extensions")
+		return nil
+	}
+
+	missing, err := s.missingExtensions(editor, recommended)
+	if err != nil {
+		s.Warnf("Failed to list the editor extensions: %v", err)
+		return nil
+	}
+	if len(missing) == 0 {
+		fmt.Fprintln(s.Out, "  All recommended extensions are installed")
+		return nil
+	}
+	if !s.opts.InstallExtensions {
+		fmt.Fprintf(s.Out, "  Missing recommended extensions: %s. Install them with --install-extensions\n", strings.Join(missing, ", "))
+		return nil
+	}
+
+	for _, id := range missing {
+		if _, err := s.editorOutput(editor, "--install-extension", id); err != nil {
+			s.Warnf("Failed to install extension %s: %v", id, err)
+			continue
+		}
+		fmt.Fprintf(s.Out, "  Installed extension %s\n", id)
+	}
+	return nil
+}
+
+// editorOutput runs the editor CLI and returns its standard output
+func (s *Setup) editorOutput(editor Editor, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), editorTimeout)
+	defer cancel()
+
+	var stdout bytes.Buffer
+	err := s.runCommand(ctx, Command{Name: editor.Command[0], Args: append(slices.Clone(editor.Command[1:]), args...), Stdout: &stdout})
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("no answer within %s", editorTimeout)
+	}
+	return stdout.String(), err
+}
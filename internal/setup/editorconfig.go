@@ -0,0 +1,55 @@
+package setup
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"text/template"
+)
+
+// editorconfigTemplate returns the config editorconfig template or the
+// embedded one
+func (s *Setup) editorconfigTemplate(config *Config) string {
+	if config.Editorconfig != "" {
+		return config.Editorconfig
+	}
+	return s.assets.Editorconfig
+}
+
+// validateEditorconfig checks that the config editorconfig template parses
+func validateEditorconfig(config *Config) error {
+	if _, err := template.New("editorconfig").Parse(config.Editorconfig); err != nil {
+		return configErrorf("failed to parse editorconfig: %w", err)
+	}
+	return nil
+}
+
+// editorconfigContent renders the .editorconfig of created repositories
+func (s *Setup) editorconfigContent(config *Config, data SeedData) ([]byte, error) {
+	tmpl, err := template.New("editorconfig").Parse(s.editorconfigTemplate(config))
+	if err != nil {
+		return nil, templateErrorf("failed to parse editorconfig template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, templateErrorf("failed to render editorconfig template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// writeEditorconfig creates the .editorconfig of a locally initialized
+// repository unless it already has one
+func (s *Setup) writeEditorconfig(baseDir, repoDir string, config *Config, repo Repository) error {
+	path := filepath.Join(repoDir, ".editorconfig")
+	if s.exists(path) {
+		return nil
+	}
+	content, err := s.editorconfigContent(config, SeedData{BaseWorkDir: baseDir, TemplateInfo: s.templateInfo(baseDir, config)})
+	if err != nil {
+		return err
+	}
+	if err := s.FS.WriteFile(path, content, s.perms.files); err != nil {
+		return fmt.Errorf("failed to create .editorconfig for %s: %w", repo.Name, err)
+	}
+	return nil
+}
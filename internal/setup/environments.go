@@ -0,0 +1,261 @@
+package setup
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/mj41/stai-vscode/internal/paths"
+)
+
+// Named environments are registered in $XDG_CONFIG_HOME/stai-vscode, each
+// with a config overlay in the envs directory there
+const (
+	environmentsFileName = "environments.json"
+	environmentsDir      = "envs"
+)
+
+// validEnvironmentName limits names to what is safe as a file name
+var validEnvironmentName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Environment is a named environment with its own base directory and
+// config overlay
+type Environment struct {
+	Name    string `json:"name"`
+	BaseDir string `json:"base-dir"`
+}
+
+// environmentRegistry is the content of the environments file
+type environmentRegistry struct {
+	Current      string                 `json:"current,omitempty"` // Used when no directory is given
+	Environments map[string]Environment `json:"environments"`
+}
+
+// environmentsPath returns the environment registry file
+func (s *Setup) environmentsPath() (string, error) {
+	dir, err := s.Users.ConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get config directory: %w", err)
+	}
+	return filepath.Join(dir, paths.App, environmentsFileName), nil
+}
+
+// environmentConfigPath returns the config overlay of the named environment
+func (s *Setup) environmentConfigPath(name string) (string, error) {
+	dir, err := s.Users.ConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get config directory: %w", err)
+	}
+	return filepath.Join(dir, paths.App, environmentsDir, name+".json"), nil
+}
+
+// readEnvironments returns the environment registry, empty when it does
+// not exist
+func (s *Setup) readEnvironments() (*environmentRegistry, error) {
+	path, err := s.environmentsPath()
+	if err != nil {
+		return nil, err
+	}
+	registry := &environmentRegistry{Environments: map[string]Environment{}}
+	data, err := s.readOptional(path)
+	if err != nil || data == nil {
+		return registry, err
+	}
+	if err := json.Unmarshal(data, registry); err != nil {
+		return nil, configErrorf("failed to parse %s: %w", path, err)
+	}
+	if registry.Environments == nil {
+		registry.Environments = map[string]Environment{}
+	}
+	return registry, nil
+}
+
+// writeEnvironments writes the environment registry
+func (s *Setup) writeEnvironments(registry *environmentRegistry) error {
+	path, err := s.environmentsPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(registry, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal environments: %w", err)
+	}
+	if err := s.FS.MkdirAll(filepath.Dir(path), s.perms.dirs); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	if err := s.FS.WriteFile(path, append(data, '\n'), s.perms.files); err != nil {
+		return fmt.Errorf("failed to write environments: %w", err)
+	}
+	return nil
+}
+
+// environment returns the selected environment: the one named by --env,
+// otherwise the current one of 'env switch' unless --base-dir or
+// --work-dir point elsewhere. Nil selects by directory as without named
+// environments.
+func (s *Setup) environment() (*Environment, error) {
+	if s.opts.Env == "" && (s.opts.BaseDir != "" || s.opts.WorkDir != "") {
+		return nil, nil
+	}
+	registry, err := s.readEnvironments()
+	if err != nil {
+		return nil, err
+	}
+	name := s.opts.Env
+	if name == "" {
+		name = registry.Current
+	}
+	if name == "" {
+		return nil, nil
+	}
+	env, ok := registry.Environments[name]
+	if !ok {
+		if s.opts.Env == "" {
+			return nil, ValidationErrorf("current environment '%s' is not registered, use 'ws-config-gen env switch' to select another", name)
+		}
+		return nil, ValidationErrorf("unknown environment '%s', see 'ws-config-gen env list'", name)
+	}
+	if s.opts.BaseDir != "" {
+		if abs, err := filepath.Abs(s.opts.BaseDir); err != nil || abs != env.BaseDir {
+			return nil, ValidationErrorf("--base-dir %s conflicts with the base directory %s of environment %s", s.opts.BaseDir, env.BaseDir, name)
+		}
+	}
+	return &env, nil
+}
+
+// environmentWorkDir returns the stai-vscode checkout of an environment,
+// its base directory when it has none
+func (s *Setup) environmentWorkDir(env *Environment) string {
+	if dir := filepath.Join(env.BaseDir, "stai-vscode"); s.exists(dir) {
+		return dir
+	}
+	return env.BaseDir
+}
+
+// CreateEnvironment registers a named environment with baseDir, by
+// default ~/stai-<name>, creating the directory and an empty config
+// overlay for it
+func (s *Setup) CreateEnvironment(name, baseDir string) error {
+	if !validEnvironmentName.MatchString(name) {
+		return ValidationErrorf("invalid environment name '%s', use letters, digits, '.', '_' and '-'", name)
+	}
+	registry, err := s.readEnvironments()
+	if err != nil {
+		return err
+	}
+	if _, ok := registry.Environments[name]; ok {
+		return ValidationErrorf("environment '%s' already exists", name)
+	}
+
+	if baseDir == "" {
+		home, err := s.Users.HomeDir()
+		if err != nil {
+			return ValidationErrorf("failed to get home directory: %w", err)
+		}
+		baseDir = filepath.Join(home, "stai-"+name)
+	}
+	baseDir, err = filepath.Abs(baseDir)
+	if err != nil {
+		return ValidationErrorf("failed to get absolute path for %s: %w", baseDir, err)
+	}
+	for _, env := range registry.Environments {
+		if env.BaseDir == baseDir {
+			return ValidationErrorf("base directory %s is already used by environment %s", baseDir, env.Name)
+		}
+	}
+
+	if err := s.FS.MkdirAll(baseDir, s.perms.dirs); err != nil {
+		return fmt.Errorf("failed to create base directory: %w", err)
+	}
+	overlay, err := s.environmentConfigPath(name)
+	if err != nil {
+		return err
+	}
+	if !s.exists(overlay) {
+		if err := s.FS.MkdirAll(filepath.Dir(overlay), s.perms.dirs); err != nil {
+			return fmt.Errorf("failed to create %s: %w", filepath.Dir(overlay), err)
+		}
+		if err := s.FS.WriteFile(overlay, []byte("{}\n"), s.perms.files); err != nil {
+			return fmt.Errorf("failed to write config overlay: %w", err)
+		}
+	}
+
+	registry.Environments[name] = Environment{Name: name, BaseDir: baseDir}
+	if err := s.writeEnvironments(registry); err != nil {
+		return err
+	}
+	fmt.Fprintf(s.Out, "Created environment %s in %s with config overlay %s\n", name, baseDir, overlay)
+	fmt.Fprintf(s.Out, "Set it up with 'ws-config-gen --env %s'\n", name)
+	return nil
+}
+
+// SwitchEnvironment makes the named environment the current one, used by
+// runs without --env, --base-dir and --work-dir
+func (s *Setup) SwitchEnvironment(name string) error {
+	registry, err := s.readEnvironments()
+	if err != nil {
+		return err
+	}
+	env, ok := registry.Environments[name]
+	if !ok {
+		return ValidationErrorf("unknown environment '%s', see 'ws-config-gen env list'", name)
+	}
+	registry.Current = name
+	if err := s.writeEnvironments(registry); err != nil {
+		return err
+	}
+	fmt.Fprintf(s.Out, "Switched to environment %s in %s\n", name, env.BaseDir)
+	return nil
+}
+
+// environmentInfo is an environment as listed by ListEnvironments
+type environmentInfo struct {
+	Environment
+	Current bool   `json:"current"`
+	Config  string `json:"config"` // The config overlay file
+	Exists  bool   `json:"exists"` // Whether the base directory exists
+}
+
+// ListEnvironments prints the named environments sorted by name
+func (s *Setup) ListEnvironments(format string) error {
+	if format != ListText && format != ListJSON {
+		return ValidationErrorf("invalid env list output format '%s', must be %s or %s", format, ListText, ListJSON)
+	}
+	registry, err := s.readEnvironments()
+	if err != nil {
+		return err
+	}
+	envs := []environmentInfo{}
+	for _, name := range slices.Sorted(maps.Keys(registry.Environments)) {
+		env := registry.Environments[name]
+		overlay, err := s.environmentConfigPath(name)
+		if err != nil {
+			return err
+		}
+		envs = append(envs, environmentInfo{Environment: env, Current: name == registry.Current, Config: overlay, Exists: s.exists(env.BaseDir)})
+	}
+
+	if format == ListJSON {
+		data, err := json.MarshalIndent(envs, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal environments: %w", err)
+		}
+		_, err = fmt.Fprintln(s.Out, string(data))
+		return err
+	}
+
+	w := tabwriter.NewWriter(s.Out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CURRENT\tNAME\tBASE DIR\tEXISTS\tCONFIG")
+	for _, env := range envs {
+		current := ""
+		if env.Current {
+			current = "*"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", current, env.Name, env.BaseDir, yesNo(env.Exists), env.Config)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,106 @@
+package setup
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Exit codes returned by ws-config-gen
+const (
+	exitOK         = 0
+	exitError      = 1 // unclassified error
+	exitValidation = 2 // wrong user, directory, missing binary or bad usage
+	exitGit        = 3 // git command failed
+	exitConfig     = 4 // config could not be loaded or is invalid
+	exitTemplate   = 5 // template could not be parsed or rendered
+	exitHook       = 6 // post-clone hook failed
+)
+
+// ValidationError reports a failed environment or usage check
+type ValidationError struct {
+	Err error
+}
+
+func (e *ValidationError) Error() string { return e.Err.Error() }
+func (e *ValidationError) Unwrap() error { return e.Err }
+
+// GitError reports a failed git operation
+type GitError struct {
+	Err error
+}
+
+func (e *GitError) Error() string { return e.Err.Error() }
+func (e *GitError) Unwrap() error { return e.Err }
+
+// ConfigError reports an invalid or unreadable config
+type ConfigError struct {
+	Err error
+}
+
+func (e *ConfigError) Error() string { return e.Err.Error() }
+func (e *ConfigError) Unwrap() error { return e.Err }
+
+// TemplateError reports a template parsing or rendering failure
+type TemplateError struct {
+	Err error
+}
+
+func (e *TemplateError) Error() string { return e.Err.Error() }
+func (e *TemplateError) Unwrap() error { return e.Err }
+
+// HookError reports a failed post-clone hook
+type HookError struct {
+	Err error
+}
+
+func (e *HookError) Error() string { return e.Err.Error() }
+func (e *HookError) Unwrap() error { return e.Err }
+
+// ValidationErrorf returns a ValidationError with a formatted message
+func ValidationErrorf(format string, args ...any) error {
+	return &ValidationError{Err: fmt.Errorf(format, args...)}
+}
+
+func gitErrorf(format string, args ...any) error {
+	return &GitError{Err: fmt.Errorf(format, args...)}
+}
+
+func configErrorf(format string, args ...any) error {
+	return &ConfigError{Err: fmt.Errorf(format, args...)}
+}
+
+func templateErrorf(format string, args ...any) error {
+	return &TemplateError{Err: fmt.Errorf(format, args...)}
+}
+
+func hookErrorf(format string, args ...any) error {
+	return &HookError{Err: fmt.Errorf(format, args...)}
+}
+
+// ExitCode maps an error to the process exit code
+func ExitCode(err error) int {
+	var (
+		validationErr *ValidationError
+		gitErr        *GitError
+		configErr     *ConfigError
+		templateErr   *TemplateError
+		hookErr       *HookError
+	)
+
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &validationErr):
+		return exitValidation
+	case errors.As(err, &gitErr):
+		return exitGit
+	case errors.As(err, &configErr):
+		return exitConfig
+	case errors.As(err, &templateErr):
+		return exitTemplate
+	case errors.As(err, &hookErr):
+		return exitHook
+	default:
+		return exitError
+	}
+}
@@ -0,0 +1,120 @@
+package setup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// envReference matches ${NAME} and ${NAME:-default} in config values
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandEnv replaces ${NAME} references with environment variables, a
+// variable that is unset or empty takes the :- default. Unset variables
+// without a default are an error naming field.
+func expandEnv(value, field string) (string, error) {
+	var err error
+	expanded := envReference.ReplaceAllStringFunc(value, func(ref string) string {
+		match := envReference.FindStringSubmatch(ref)
+		if v := os.Getenv(match[1]); v != "" {
+			return v
+		}
+		if match[2] != "" {
+			return match[3]
+		}
+		if err == nil {
+			err = configErrorf("environment variable %s used in %s is not set, set it or use ${%s:-default}", match[1], field, match[1])
+		}
+		return ref
+	})
+	return expanded, err
+}
+
+// expandPath expands environment references and a leading ~ to the home
+// directory of the current user
+func (s *Setup) expandPath(value, field string) (string, error) {
+	value, err := expandEnv(value, field)
+	if err != nil {
+		return "", err
+	}
+	if value != "~" && !strings.HasPrefix(value, "~/") {
+		return value, nil
+	}
+	homeDir, err := s.Users.HomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, strings.TrimPrefix(value, "~")), nil
+}
+
+// expandConfig expands environment references in config values naming
+// locations, so one config can serve all users. URLs and the editor also
+// expand a leading ~, paths inside the base directory do not.
+func (s *Setup) expandConfig(config *Config) error {
+	expand := func(value *string, field string, home bool) error {
+		if *value == "" {
+			return nil
+		}
+		var err error
+		if home {
+			*value, err = s.expandPath(*value, field)
+		} else {
+			*value, err = expandEnv(*value, field)
+		}
+		return err
+	}
+
+	if err := expand(&config.Editor, "editor", true); err != nil {
+		return err
+	}
+	if err := expand(&config.Proxy, "proxy", false); err != nil {
+		return err
+	}
+	for host, h := range config.GitHosts {
+		if err := expand(&h.APIURL, "git-hosts api-url of "+host, false); err != nil {
+			return err
+		}
+		config.GitHosts[host] = h
+	}
+	for i := range config.SeedFiles {
+		if err := expand(&config.SeedFiles[i].Path, "seed file path", false); err != nil {
+			return err
+		}
+	}
+
+	for i := range config.Repos {
+		repo := &config.Repos[i]
+		if repo.GitRepo != nil {
+			url := *repo.GitRepo
+			if err := expand(&url, "git-repo of "+repo.Name, true); err != nil {
+				return err
+			}
+			repo.GitRepo = &url
+		}
+		if err := expand(&repo.Upstream, "upstream of "+repo.Name, true); err != nil {
+			return err
+		}
+		for name, url := range repo.Remotes {
+			if err := expand(&url, "remote "+name+" of "+repo.Name, true); err != nil {
+				return err
+			}
+			repo.Remotes[name] = url
+		}
+		for _, field := range []struct {
+			value *string
+			name  string
+		}{
+			{&repo.Path, "path"},
+			{&repo.Subdir, "subdir"},
+			{&repo.DisplayName, "display-name"},
+			{&repo.URI, "uri"},
+		} {
+			if err := expand(field.value, field.name+" of "+repo.Name, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,425 @@
+package setup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"maps"
+	"os/exec"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// memFS is an in-memory FileSystem, parent directories of written files
+// are implied. Symlinks are followed like the operating system does.
+type memFS struct {
+	mu    sync.Mutex
+	files fstest.MapFS // Keyed by absolute paths without the leading "/"
+}
+
+func newMemFS() *memFS {
+	return &memFS{files: fstest.MapFS{}}
+}
+
+// key returns the MapFS key of an absolute path
+func (m *memFS) key(name string) string {
+	key := strings.TrimPrefix(filepath.ToSlash(filepath.Clean(name)), "/")
+	if key == "" {
+		return "."
+	}
+	return key
+}
+
+// resolve returns the key of name with the symlinks in it followed, the
+// final one only when followLast is set
+func (m *memFS) resolve(name string, followLast bool) (string, error) {
+	parts := strings.Split(m.key(name), "/")
+	for hops := 0; hops < 40; hops++ {
+		resolved, restarted := ".", false
+		for i, part := range parts {
+			key := path.Join(resolved, part)
+			file := m.files[key]
+			if file == nil || file.Mode.Type() != fs.ModeSymlink || (i == len(parts)-1 && !followLast) {
+				resolved = key
+				continue
+			}
+			target := string(file.Data)
+			if !path.IsAbs(target) {
+				target = path.Join("/"+resolved, target)
+			}
+			parts = append(strings.Split(m.key(target), "/"), parts[i+1:]...)
+			restarted = true
+			break
+		}
+		if !restarted {
+			return resolved, nil
+		}
+	}
+	return "", &fs.PathError{Op: "resolve", Path: name, Err: errors.New("too many levels of symbolic links")}
+}
+
+func (m *memFS) pathError(op, name string, err error) error {
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+func (m *memFS) Stat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, err := m.resolve(name, true)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Stat(m.files, key)
+}
+
+func (m *memFS) Lstat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, err := m.resolve(name, false)
+	if err != nil {
+		return nil, err
+	}
+	if file := m.files[key]; file != nil && file.Mode.Type() == fs.ModeSymlink {
+		return symlinkInfo{name: path.Base(key), file: file}, nil
+	}
+	return fs.Stat(m.files, key)
+}
+
+func (m *memFS) Readlink(name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, err := m.resolve(name, false)
+	if err != nil {
+		return "", err
+	}
+	file := m.files[key]
+	if file == nil || file.Mode.Type() != fs.ModeSymlink {
+		return "", m.pathError("readlink", name, fs.ErrInvalid)
+	}
+	return string(file.Data), nil
+}
+
+func (m *memFS) Symlink(target, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, err := m.resolve(name, false)
+	if err != nil {
+		return err
+	}
+	if m.files[key] != nil {
+		return m.pathError("symlink", name, fs.ErrExist)
+	}
+	m.files[key] = &fstest.MapFile{Data: []byte(target), Mode: fs.ModeSymlink | 0777, ModTime: time.Now()}
+	return nil
+}
+
+// symlinkInfo is the FileInfo of a symlink itself
+type symlinkInfo struct {
+	name string
+	file *fstest.MapFile
+}
+
+func (i symlinkInfo) Name() string       { return i.name }
+func (i symlinkInfo) Size() int64        { return int64(len(i.file.Data)) }
+func (i symlinkInfo) Mode() fs.FileMode  { return i.file.Mode }
+func (i symlinkInfo) ModTime() time.Time { return i.file.ModTime }
+func (i symlinkInfo) IsDir() bool        { return false }
+func (i symlinkInfo) Sys() any           { return nil }
+
+func (m *memFS) ReadFile(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, err := m.resolve(name, true)
+	if err != nil {
+		return nil, err
+	}
+	file, ok := m.files[key]
+	if !ok || file.Mode.IsDir() {
+		return nil, m.pathError("open", name, fs.ErrNotExist)
+	}
+	return append([]byte(nil), file.Data...), nil
+}
+
+func (m *memFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, err := m.resolve(name, true)
+	if err != nil {
+		return nil, err
+	}
+	return fs.ReadDir(m.files, key)
+}
+
+func (m *memFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.write(name, data, perm, false)
+}
+
+func (m *memFS) AppendFile(name string, data []byte, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.write(name, data, perm, true)
+}
+
+// write stores data in name, appended to its content when add is set
+func (m *memFS) write(name string, data []byte, perm fs.FileMode, add bool) error {
+	key, err := m.resolve(name, true)
+	if err != nil {
+		return err
+	}
+	if file := m.files[key]; file != nil && file.Mode.IsDir() {
+		return m.pathError("open", name, errors.New("is a directory"))
+	} else if file != nil && add {
+		data = append(append([]byte(nil), file.Data...), data...)
+		perm = file.Mode
+	}
+	m.files[key] = &fstest.MapFile{Data: append([]byte(nil), data...), Mode: perm, ModTime: time.Now()}
+	return nil
+}
+
+func (m *memFS) CreateExclusive(name string, data []byte, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// Like O_EXCL a symlink fails even when it dangles
+	if key, err := m.resolve(name, false); err != nil || m.files[key] != nil {
+		return m.pathError("open", name, fs.ErrExist)
+	}
+	return m.write(name, data, perm, false)
+}
+
+func (m *memFS) MkdirAll(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, err := m.resolve(name, true)
+	if err != nil {
+		return err
+	}
+	for ; key != "."; key = path.Dir(key) {
+		if file, ok := m.files[key]; ok && !file.Mode.IsDir() {
+			return m.pathError("mkdir", name, errors.New("not a directory"))
+		} else if ok {
+			continue
+		}
+		m.files[key] = &fstest.MapFile{Mode: fs.ModeDir | perm, ModTime: time.Now()}
+	}
+	return nil
+}
+
+func (m *memFS) Chmod(name string, mode fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, err := m.resolve(name, true)
+	if err != nil {
+		return err
+	}
+	file, ok := m.files[key]
+	if !ok {
+		return m.pathError("chmod", name, fs.ErrNotExist)
+	}
+	file.Mode = file.Mode&fs.ModeType | mode.Perm()
+	return nil
+}
+
+func (m *memFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, err := m.resolve(name, false)
+	if err != nil {
+		return err
+	}
+	if _, ok := m.files[key]; !ok {
+		return m.pathError("remove", name, fs.ErrNotExist)
+	}
+	delete(m.files, key)
+	return nil
+}
+
+func (m *memFS) RemoveAll(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, err := m.resolve(name, false)
+	if err != nil {
+		return err
+	}
+	for file := range m.files {
+		if file == key || strings.HasPrefix(file, key+"/") {
+			delete(m.files, file)
+		}
+	}
+	return nil
+}
+
+func (m *memFS) Rename(oldpath, newpath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	oldKey, err := m.resolve(oldpath, false)
+	if err != nil {
+		return err
+	}
+	newKey, err := m.resolve(newpath, false)
+	if err != nil {
+		return err
+	}
+	if _, ok := m.files[oldKey]; !ok {
+		return m.pathError("rename", oldpath, fs.ErrNotExist)
+	}
+	moved := fstest.MapFS{}
+	for file, data := range m.files {
+		if file == oldKey || strings.HasPrefix(file, oldKey+"/") {
+			moved[newKey+strings.TrimPrefix(file, oldKey)] = data
+			delete(m.files, file)
+		}
+	}
+	maps.Copy(m.files, moved)
+	return nil
+}
+
+// regularFiles returns the content of all files and symlink targets by
+// key, directories are left out
+func (m *memFS) regularFiles() map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	files := map[string]string{}
+	for key, file := range m.files {
+		if !file.Mode.IsDir() {
+			files[key] = string(file.Data)
+		}
+	}
+	return files
+}
+
+func (m *memFS) FreeSpace(string) (int64, error) { return 1 << 40, nil }
+
+// fakeRunner records commands and answers them with the result of the
+// first matching rule, commands without a rule succeed
+type fakeRunner struct {
+	mu       sync.Mutex
+	rules    []runRule
+	commands []string // Name and arguments joined by spaces
+	binaries []string // Found by LookPath
+}
+
+// runRule answers commands whose name and arguments start with prefix
+type runRule struct {
+	prefix string
+	stdout string
+	stderr string
+	err    error
+}
+
+func (r *fakeRunner) Run(ctx context.Context, cmd Command) error {
+	line := strings.Join(append([]string{cmd.Name}, cmd.Args...), " ")
+	r.mu.Lock()
+	r.commands = append(r.commands, line)
+	r.mu.Unlock()
+
+	for _, rule := range r.rules {
+		if !strings.HasPrefix(line, rule.prefix) {
+			continue
+		}
+		writeOutput(cmd.Stdout, rule.stdout)
+		writeOutput(cmd.Stderr, rule.stderr)
+		return rule.err
+	}
+	return nil
+}
+
+func writeOutput(w io.Writer, s string) {
+	if w != nil && s != "" {
+		io.WriteString(w, s)
+	}
+}
+
+func (r *fakeRunner) Start(cmd Command) error {
+	return r.Run(context.Background(), cmd)
+}
+
+func (r *fakeRunner) LookPath(file string) (string, error) {
+	for _, binary := range r.binaries {
+		if binary == file {
+			return "/usr/bin/" + file, nil
+		}
+	}
+	return "", &exec.Error{Name: file, Err: exec.ErrNotFound}
+}
+
+// fakeUsers is a UserLookup with all directories below home
+type fakeUsers struct {
+	name string
+	home string
+}
+
+func (u fakeUsers) Current() (*user.User, error) {
+	return &user.User{Username: u.name, HomeDir: u.home, Uid: "1000", Gid: "1000"}, nil
+}
+func (u fakeUsers) HomeDir() (string, error)   { return u.home, nil }
+func (u fakeUsers) ConfigDir() (string, error) { return filepath.Join(u.home, ".config"), nil }
+func (u fakeUsers) StateDir() (string, error)  { return filepath.Join(u.home, ".local", "state"), nil }
+func (u fakeUsers) CacheDir() (string, error)  { return filepath.Join(u.home, ".cache"), nil }
+
+// testSetup is a Setup on fakes, its output is logged with the test
+type testSetup struct {
+	*Setup
+	fs     *memFS
+	runner *fakeRunner
+}
+
+func newTestSetup(t *testing.T, opts Options, assets Assets) *testSetup {
+	t.Helper()
+	memFS := newMemFS()
+	runner := &fakeRunner{binaries: []string{"git"}}
+	out := testWriter{t}
+	s := &Setup{
+		FS:       memFS,
+		Runner:   runner,
+		Users:    fakeUsers{name: defaultExpectedUser, home: "/home/" + defaultExpectedUser},
+		Out:      out,
+		Err:      out,
+		Report:   out,
+		In:       strings.NewReader(""),
+		opts:     opts,
+		assets:   assets,
+		timeouts: defaultTimeouts,
+		perms:    defaultPermissions,
+		summary:  newRunSummary(),
+		started:  time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	// Like New, changes are audited and tracked for rollback
+	s.FS = auditFS{FileSystem: memFS, s: s}
+	return &testSetup{Setup: s, fs: memFS, runner: runner}
+}
+
+// testWriter writes to the test log
+type testWriter struct{ t *testing.T }
+
+func (w testWriter) Write(p []byte) (int, error) {
+	w.t.Helper()
+	w.t.Log(strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+// writeFile stores a file in the fake filesystem
+func (ts *testSetup) writeFile(t *testing.T, name, content string) {
+	t.Helper()
+	if err := ts.fs.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readFile returns a file of the fake filesystem
+func (ts *testSetup) readFile(t *testing.T, name string) string {
+	t.Helper()
+	data, err := ts.fs.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,205 @@
+package setup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	"github.com/mj41/stai-vscode/internal/jsonc"
+)
+
+// folderFile is a .vscode file of a checkout with the generated content
+// remembered to detect user edits next time
+type folderFile struct {
+	renderedFile
+	basePath  string // Last generated content, empty when not tracked
+	generated []byte
+}
+
+// folderSettingsBase returns where the last generated content of a
+// checkout file is kept, outside the checkout
+func folderSettingsBase(baseDir, path string) string {
+	rel, _ := filepath.Rel(baseDir, path)
+	return filepath.Join(baseDir, "vscode", "folder-settings", rel+".generated")
+}
+
+// folderDirs returns the workspace folder directories of a repository
+func folderDirs(baseDir string, repo Repository) []string {
+	repoDir := repoPath(baseDir, repo)
+	dirs := []string{repoDir}
+	if len(repo.Worktrees) > 0 {
+		dirs = worktreeDirs(repoDir, repo)
+	}
+	for i := range dirs {
+		dirs[i] = filepath.Join(dirs[i], filepath.FromSlash(repo.Subdir))
+	}
+	return dirs
+}
+
+// planFolderSettings returns the .vscode/settings.json and
+// extensions.json files of checkouts with folder-settings or
+// folder-extensions whose content changes. Existing files are merged:
+// settings the user added or changed since the last run are kept, the
+// recommended extensions are added to the existing ones.
+func (s *Setup) planFolderSettings(baseDir string, config *Config) ([]folderFile, error) {
+	var files []folderFile
+	for _, repo := range config.Repos {
+		if len(repo.FolderSettings) == 0 && len(repo.FolderExtensions) == 0 {
+			continue
+		}
+		for _, dir := range folderDirs(baseDir, repo) {
+			if !s.exists(dir) {
+				continue
+			}
+			if len(repo.FolderSettings) > 0 {
+				file, err := s.planFolderFileSettings(baseDir, filepath.Join(dir, ".vscode", "settings.json"), repo)
+				if err != nil {
+					return nil, err
+				}
+				if file != nil {
+					files = append(files, *file)
+				}
+			}
+			if len(repo.FolderExtensions) > 0 {
+				file, err := s.planFolderExtensions(filepath.Join(dir, ".vscode", "extensions.json"), repo)
+				if err != nil {
+					return nil, err
+				}
+				if file != nil {
+					files = append(files, *file)
+				}
+			}
+		}
+	}
+	return files, nil
+}
+
+// planFolderFileSettings merges the folder-settings of repo into the
+// settings file at path, nil when it is up to date
+func (s *Setup) planFolderFileSettings(baseDir, path string, repo Repository) (*folderFile, error) {
+	raw, err := json.Marshal(repo.FolderSettings)
+	if err != nil {
+		return nil, configErrorf("invalid folder-settings of %s: %w", repo.Name, err)
+	}
+	var generated bytes.Buffer
+	if err := json.Indent(&generated, raw, "", "\t"); err != nil {
+		return nil, configErrorf("invalid folder-settings of %s: %w", repo.Name, err)
+	}
+	generated.WriteByte('\n')
+
+	file := &folderFile{
+		renderedFile: renderedFile{path: path, content: generated.Bytes()},
+		basePath:     folderSettingsBase(baseDir, path),
+		generated:    generated.Bytes(),
+	}
+
+	existing, err := s.readOptional(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	base, err := s.readOptional(file.basePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file.basePath, err)
+	}
+	if existing != nil && !bytes.Equal(existing, generated.Bytes()) {
+		current, err := parseWorkspaceObject(existing)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		result, err := parseWorkspaceObject(generated.Bytes())
+		if err != nil {
+			return nil, templateErrorf("failed to parse generated settings of %s: %w", repo.Name, err)
+		}
+		var previous *jsonc.Value
+		if base != nil {
+			previous, _ = parseWorkspaceObject(base)
+		}
+		merged, err := mergeSettings(current, previous, result)
+		if err != nil {
+			return nil, fmt.Errorf("failed to merge %s: %w", path, err)
+		}
+		file.content = append(merged.Marshal(), '\n')
+	}
+
+	if bytes.Equal(existing, file.content) && bytes.Equal(base, file.generated) {
+		return nil, nil
+	}
+	return file, nil
+}
+
+// planFolderExtensions adds the folder-extensions of repo to the
+// recommendations of the extensions file at path, nil when it has all
+func (s *Setup) planFolderExtensions(path string, repo Repository) (*folderFile, error) {
+	existing, err := s.readOptional(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	doc := &jsonc.Value{Kind: jsonc.Object}
+	if existing != nil {
+		if doc, err = parseWorkspaceObject(existing); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	}
+
+	recommendations := memberValue(doc, "recommendations")
+	if recommendations == nil {
+		recommendations = &jsonc.Value{Kind: jsonc.Array}
+		doc.Set(&jsonc.Member{Key: "recommendations", Value: recommendations})
+	}
+	if recommendations.Kind != jsonc.Array {
+		return nil, fmt.Errorf("failed to parse %s: recommendations is not an array", path)
+	}
+
+	var present []string
+	for _, element := range recommendations.Members {
+		var id string
+		if json.Unmarshal(element.Value.JSON(), &id) == nil {
+			present = append(present, id)
+		}
+	}
+	added := false
+	for _, id := range repo.FolderExtensions {
+		if slices.Contains(present, id) {
+			continue
+		}
+		raw, err := json.Marshal(id)
+		if err != nil {
+			return nil, configErrorf("invalid folder-extensions of %s: %w", repo.Name, err)
+		}
+		recommendations.Members = append(recommendations.Members, &jsonc.Member{Value: &jsonc.Value{Kind: jsonc.Scalar, Raw: string(raw)}})
+		present = append(present, id)
+		added = true
+	}
+	if !added {
+		return nil, nil
+	}
+	return &folderFile{renderedFile: renderedFile{path: path, content: append(doc.Marshal(), '\n')}}, nil
+}
+
+// writeFolderSettings writes the planned .vscode files of checkouts
+func (s *Setup) writeFolderSettings(baseDir string, config *Config) error {
+	files, err := s.planFolderSettings(baseDir, config)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := s.FS.MkdirAll(filepath.Dir(file.path), s.perms.dirs); err != nil {
+			return fmt.Errorf("failed to create %s: %w", filepath.Dir(file.path), err)
+		}
+		if err := s.FS.WriteFile(file.path, file.content, s.perms.files); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file.path, err)
+		}
+		if file.basePath != "" {
+			if err := s.FS.MkdirAll(filepath.Dir(file.basePath), s.perms.dirs); err != nil {
+				return fmt.Errorf("failed to create %s: %w", filepath.Dir(file.basePath), err)
+			}
+			if err := s.FS.WriteFile(file.basePath, file.generated, s.perms.files); err != nil {
+				return fmt.Errorf("failed to write %s: %w", file.basePath, err)
+			}
+		}
+		fmt.Fprintf(s.Out, "  Wrote %s\n", file.path)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package setup
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Remote names of the fork workflow, reserved in 'remotes'
+const (
+	remoteOrigin   = "origin"
+	remoteUpstream = "upstream"
+)
+
+// gitRepoDir returns the directory holding the git config of a
+// repository, the bare repository of worktrees repositories
+func gitRepoDir(repoDir string, repo Repository) string {
+	if len(repo.Worktrees) > 0 {
+		return bareRepoDir(repoDir)
+	}
+	return repoDir
+}
+
+// setRemote adds remote name or changes its URL, changed is false when
+// it already pointed to url
+func (s *Setup) setRemote(gitDir, name, url string) (bool, error) {
+	current, err := s.gitOutput(opInit, gitDir, "remote", "get-url", name)
+	switch {
+	case err == nil && current == url:
+		return false, nil
+	case err == nil:
+		return true, s.runGit(opInit, gitDir, "remote", "set-url", name, url)
+	}
+	return true, s.runGit(opInit, gitDir, "remote", "add", name, url)
+}
+
+// validateUpstream checks the fork and remotes options of a repository
+func validateUpstream(repo Repository) error {
+	if repo.Upstream != "" && repo.Type != "git-repo" {
+		return configErrorf("upstream requires git-repo type for %s", repo.Name)
+	}
+	for name := range repo.Remotes {
+		if name == remoteOrigin || name == remoteUpstream {
+			return configErrorf("remote name '%s' of %s is reserved, use git-repo or upstream", name, repo.Name)
+		}
+		if name == "" || strings.HasPrefix(name, "-") || strings.ContainsAny(name, " \t~^:?*[\\/") {
+			return configErrorf("invalid remote name '%s' for %s", name, repo.Name)
+		}
+		if repo.Type != "git-repo" {
+			return configErrorf("remotes require git-repo type for %s", repo.Name)
+		}
+	}
+	if repo.PushRemote == "" {
+		return nil
+	}
+	if repo.Upstream == "" {
+		return configErrorf("push-remote requires upstream for %s", repo.Name)
+	}
+	if repo.PushRemote != remoteOrigin && repo.PushRemote != remoteUpstream {
+		return configErrorf("invalid push-remote '%s' for %s, must be %s or %s", repo.PushRemote, repo.Name, remoteOrigin, remoteUpstream)
+	}
+	return nil
+}
+
+// configureUpstream adds the canonical repository of a fork as the
+// upstream remote and fetches it. With push-remote origin the checked
+// out branch tracks its upstream counterpart, so pulls come from the
+// canonical repository and pushes go to the fork. Existing checkouts
+// whose upstream remote is already set are left alone.
+func (s *Setup) configureUpstream(repoDir, proxy string, repo Repository) error {
+	if repo.Upstream == "" {
+		return nil
+	}
+
+	gitDir := gitRepoDir(repoDir, repo)
+	changed, err := s.setRemote(gitDir, remoteUpstream, repo.Upstream)
+	if err != nil {
+		return gitErrorf("failed to set upstream remote of %s: %w", repo.Name, err)
+	}
+	if !changed {
+		return nil
+	}
+
+	fmt.Fprintf(s.Out, "Fetching upstream of %s\n", repo.Name)
+	args := append(proxyArgs(proxy, repo.Upstream), "fetch", remoteUpstream)
+	if err := s.runGit(opClone, gitDir, args...); err != nil {
+		return gitErrorf("failed to fetch upstream of %s: %w", repo.Name, err)
+	}
+
+	if repo.PushRemote == "" {
+		return nil
+	}
+	if err := s.runGit(opInit, gitDir, "config", "remote.pushDefault", repo.PushRemote); err != nil {
+		return gitErrorf("failed to set push remote of %s: %w", repo.Name, err)
+	}
+	if repo.PushRemote != remoteOrigin || len(repo.Worktrees) > 0 {
+		return nil
+	}
+
+	branch, err := s.gitOutput(opInit, repoDir, "symbolic-ref", "--short", "HEAD")
+	if err != nil {
+		return nil
+	}
+	tracking := remoteUpstream + "/" + branch
+	if s.runGit(opInit, repoDir, "rev-parse", "--verify", "--quiet", "refs/remotes/"+tracking) != nil {
+		s.Warnf("Branch %s of %s has no upstream counterpart, it keeps tracking origin", branch, repo.Name)
+		return nil
+	}
+	if err := s.runGit(opInit, repoDir, "branch", "--set-upstream-to", tracking); err != nil {
+		return gitErrorf("failed to track %s in %s: %w", tracking, repo.Name, err)
+	}
+	return nil
+}
+
+// remoteNames returns the names of the additional remotes sorted
+func remoteNames(repo Repository) []string {
+	names := make([]string, 0, len(repo.Remotes))
+	for name := range repo.Remotes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// configureRemotes adds or corrects the additional 'remotes' of a
+// repository in name order. They are not fetched.
+func (s *Setup) configureRemotes(repoDir string, repo Repository) error {
+	gitDir := gitRepoDir(repoDir, repo)
+	for _, name := range remoteNames(repo) {
+		changed, err := s.setRemote(gitDir, name, repo.Remotes[name])
+		if err != nil {
+			return gitErrorf("failed to set remote %s of %s: %w", name, repo.Name, err)
+		}
+		if changed {
+			fmt.Fprintf(s.Out, "Set remote %s of %s to %s\n", name, repo.Name, repo.Remotes[name])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,427 @@
+package setup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// proxyEnvVars are proxy variables git (libcurl) only honors in lower case
+var proxyEnvVars = []string{"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY"}
+
+// Git operation kinds with separate timeouts
+const (
+	opClone  = "clone"  // network operations: clone, fetch, ls-remote, submodules, LFS
+	opInit   = "init"   // local repository setup: init, remote and config changes
+	opCommit = "commit" // add and commit
+	opHook   = "hook"   // post-clone hook commands
+)
+
+// Default timeouts per operation kind
+var defaultTimeouts = map[string]time.Duration{
+	opClone:  30 * time.Minute,
+	opInit:   time.Minute,
+	opCommit: time.Minute,
+	opHook:   30 * time.Minute,
+}
+
+// commandWaitDelay bounds waiting for I/O of a killed command
+const commandWaitDelay = 5 * time.Second
+
+// TimeoutsConfig configures per-operation timeouts of external commands
+type TimeoutsConfig struct {
+	Clone  string `json:"clone,omitempty"`
+	Init   string `json:"init,omitempty"`
+	Commit string `json:"commit,omitempty"`
+	Hook   string `json:"hook,omitempty"`
+}
+
+// resolveTimeouts returns effective timeouts, --timeout overrides all
+// operations, otherwise config 'timeouts' overrides the defaults
+func (s *Setup) resolveTimeouts(config *Config) (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration, len(defaultTimeouts))
+	for op, timeout := range defaultTimeouts {
+		timeouts[op] = timeout
+	}
+
+	if config.Timeouts != nil {
+		for op, value := range map[string]string{
+			opClone:  config.Timeouts.Clone,
+			opInit:   config.Timeouts.Init,
+			opCommit: config.Timeouts.Commit,
+			opHook:   config.Timeouts.Hook,
+		} {
+			if value == "" {
+				continue
+			}
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return nil, configErrorf("invalid %s timeout '%s', must be a positive duration like 10m", op, value)
+			}
+			timeouts[op] = timeout
+		}
+	}
+
+	if s.opts.Timeout < 0 {
+		return nil, configErrorf("--timeout must not be negative, got %s", s.opts.Timeout)
+	}
+	if s.opts.Timeout > 0 {
+		for op := range timeouts {
+			timeouts[op] = s.opts.Timeout
+		}
+	}
+
+	return timeouts, nil
+}
+
+// configureTimeouts applies the effective timeouts for external commands
+func (s *Setup) configureTimeouts(config *Config) error {
+	timeouts, err := s.resolveTimeouts(config)
+	if err != nil {
+		return err
+	}
+	s.timeouts = timeouts
+	return nil
+}
+
+// gitContext returns a context bounded by the timeout of operation op
+func (s *Setup) gitContext(op string) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), s.timeouts[op])
+}
+
+// gitCommand returns a git command running in dir (current directory
+// when empty) with the environment adjusted for the current mode
+func (s *Setup) gitCommand(dir string, args ...string) Command {
+	cmd := Command{Name: "git", Args: args, Dir: dir, Env: append(os.Environ(), s.gitEnv...)}
+
+	if s.throttleProxy != "" {
+		// Every HTTP(S) request goes through the --max-rate proxy, which
+		// applies the configured proxy and NO_PROXY itself
+		cmd.Args = append([]string{"-c", "http.proxy=" + s.throttleProxy}, args...)
+		cmd.Env = slices.DeleteFunc(cmd.Env, func(v string) bool {
+			name, _, _ := strings.Cut(v, "=")
+			return strings.EqualFold(name, "NO_PROXY")
+		})
+		return cmd
+	}
+
+	// libcurl ignores upper case HTTP_PROXY, pass the lower case variants too
+	for _, name := range proxyEnvVars {
+		lower := strings.ToLower(name)
+		if value := os.Getenv(name); value != "" && os.Getenv(lower) == "" {
+			cmd.Env = append(cmd.Env, lower+"="+value)
+		}
+	}
+
+	if s.opts.CI {
+		// Fail instead of waiting for credentials or host key confirmation
+		cmd.Env = append(cmd.Env, "GIT_TERMINAL_PROMPT=0")
+		if os.Getenv("GIT_SSH_COMMAND") == "" {
+			cmd.Env = append(cmd.Env, "GIT_SSH_COMMAND=ssh -o BatchMode=yes")
+		}
+	}
+
+	return cmd
+}
+
+// runGit runs a git command for operation op within its timeout
+func (s *Setup) runGit(op, dir string, args ...string) error {
+	ctx, cancel := s.gitContext(op)
+	defer cancel()
+	return s.timeoutError(ctx, op, s.runCommand(ctx, s.gitCommand(dir, args...)))
+}
+
+// gitOutput runs a git command for operation op and returns its trimmed
+// standard output
+func (s *Setup) gitOutput(op, dir string, args ...string) (string, error) {
+	ctx, cancel := s.gitContext(op)
+	defer cancel()
+
+	var stdout bytes.Buffer
+	cmd := s.gitCommand(dir, args...)
+	cmd.Stdout = &stdout
+	err := s.timeoutError(ctx, op, s.runCommand(ctx, cmd))
+	return strings.TrimSpace(stdout.String()), err
+}
+
+// errGitTimeout marks git commands killed by their operation timeout
+var errGitTimeout = errors.New("timed out")
+
+// timeoutError wraps err of a git command with a clear message when ctx
+// timed out, keeping the stderr tail of the killed command
+func (s *Setup) timeoutError(ctx context.Context, op string, err error) error {
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("git %s operation %w after %s (see --timeout): %w", op, errGitTimeout, s.timeouts[op], err)
+	}
+	return err
+}
+
+// repoURLHost returns the scheme and host of a git URL, scp-like
+// URLs (git@github.com:org/repo.git) are reported as "ssh"
+func repoURLHost(repoURL string) (scheme, host string) {
+	if !strings.Contains(repoURL, "://") {
+		if at := strings.Index(repoURL, "@"); at >= 0 {
+			repoURL = repoURL[at+1:]
+		}
+		if colon := strings.Index(repoURL, ":"); colon >= 0 {
+			return "ssh", repoURL[:colon]
+		}
+		return "file", ""
+	}
+
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return "", ""
+	}
+	return u.Scheme, u.Hostname()
+}
+
+// noProxyMatch reports whether host is excluded from proxying by a
+// NO_PROXY style list ("*", "example.com", ".example.com", "*.example.com")
+func noProxyMatch(host, noProxy string) bool {
+	host = strings.ToLower(host)
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		entry = strings.TrimPrefix(entry, "*")
+		if strings.HasPrefix(entry, ".") {
+			if strings.HasSuffix(host, entry) || host == entry[1:] {
+				return true
+			}
+			continue
+		}
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyArgs returns git options applying the config proxy to repoURL,
+// SSH URLs and hosts matched by NO_PROXY are not proxied
+func proxyArgs(proxy, repoURL string) []string {
+	if proxy == "" {
+		return nil
+	}
+
+	scheme, host := repoURLHost(repoURL)
+	if scheme != "http" && scheme != "https" {
+		return nil
+	}
+
+	noProxy := os.Getenv("NO_PROXY")
+	if noProxy == "" {
+		noProxy = os.Getenv("no_proxy")
+	}
+	if noProxyMatch(host, noProxy) {
+		return nil
+	}
+
+	return []string{"-c", "http.proxy=" + proxy}
+}
+
+// defaultInitBranch is the initial branch of locally initialized repositories
+const defaultInitBranch = "main"
+
+// initRepo initializes a git repository with the repository's default
+// branch. HEAD is pointed to the branch instead of 'git init -b', which
+// git before 2.28 does not support.
+func (s *Setup) initRepo(repoDir string, repo Repository) error {
+	branch := repo.DefaultBranch
+	if branch == "" {
+		branch = defaultInitBranch
+	}
+
+	err := s.runGit(opInit, repoDir, "init")
+	s.audit(auditInit, repoDir, branch, err)
+	if err != nil {
+		return err
+	}
+	return s.runGit(opInit, repoDir, "symbolic-ref", "HEAD", "refs/heads/"+branch)
+}
+
+// GitUserConfig is the git identity set in locally initialized repositories
+type GitUserConfig struct {
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+}
+
+// configureIdentity sets the config 'git-user' identity in a locally
+// initialized repository
+func (s *Setup) configureIdentity(repoDir string, config *Config) error {
+	if config.GitUser == nil {
+		return nil
+	}
+	for _, setting := range [][2]string{{"user.name", config.GitUser.Name}, {"user.email", config.GitUser.Email}} {
+		if setting[1] == "" {
+			continue
+		}
+		if err := s.runGit(opInit, repoDir, "config", "--local", setting[0], setting[1]); err != nil {
+			return gitErrorf("failed to set %s in %s: %w", setting[0], repoDir, err)
+		}
+	}
+	return nil
+}
+
+// checkIdentity fails with a hint when git cannot determine the
+// committer identity, which otherwise only shows as a failed commit
+func (s *Setup) checkIdentity(repoDir string) error {
+	if err := s.runGit(opInit, repoDir, "var", "GIT_COMMITTER_IDENT"); err != nil {
+		return gitErrorf("git user identity is not configured. Set 'git-user' in the config or run 'git config --global user.name NAME' and 'git config --global user.email EMAIL'")
+	}
+	return nil
+}
+
+// checkSigning fails with a hint when config 'sign-commits' is set but
+// git has no signing key, before anything is committed
+func (s *Setup) checkSigning(repoDir string, config *Config) error {
+	if !config.SignCommits {
+		return nil
+	}
+	if err := s.runGit(opInit, repoDir, "config", "--get", "user.signingkey"); err != nil {
+		return gitErrorf("sign-commits is set but git has no signing key. Run 'git config --global user.signingkey KEY', for SSH keys also 'git config --global gpg.format ssh'")
+	}
+	return nil
+}
+
+// commit commits the staged changes, signed when config 'sign-commits'
+// is set
+func (s *Setup) commit(repoDir string, config *Config, msg string) error {
+	args := []string{"commit", "-m", msg}
+	if config.SignCommits {
+		args = append(args, "-S")
+	}
+	err := s.runGit(opCommit, repoDir, args...)
+	s.audit(auditCommit, repoDir, msg, err)
+	if err != nil {
+		if config.SignCommits {
+			return gitErrorf("failed to sign and commit in %s, check that the signing key in user.signingkey is usable: %w", repoDir, err)
+		}
+		return gitErrorf("failed to commit initial files: %w", err)
+	}
+	return nil
+}
+
+// Default clone retry policy
+const (
+	defaultCloneAttempts = 3
+	defaultCloneBackoff  = 2 * time.Second
+	maxCloneBackoff      = 30 * time.Second
+)
+
+// RetryConfig configures retries of clone operations
+type RetryConfig struct {
+	Attempts int    `json:"attempts,omitempty"`
+	Backoff  string `json:"backoff,omitempty"`
+}
+
+// retryPolicy is the effective retry configuration
+type retryPolicy struct {
+	attempts int
+	backoff  time.Duration
+}
+
+// cloneRetryPolicy returns the clone retry policy, flags take precedence
+// over the config 'clone-retry' section
+func (s *Setup) cloneRetryPolicy(config *Config) (retryPolicy, error) {
+	policy := retryPolicy{attempts: defaultCloneAttempts, backoff: defaultCloneBackoff}
+
+	if config.CloneRetry != nil {
+		if config.CloneRetry.Attempts != 0 {
+			policy.attempts = config.CloneRetry.Attempts
+		}
+		if config.CloneRetry.Backoff != "" {
+			backoff, err := time.ParseDuration(config.CloneRetry.Backoff)
+			if err != nil {
+				return policy, configErrorf("invalid clone-retry backoff '%s': %w", config.CloneRetry.Backoff, err)
+			}
+			policy.backoff = backoff
+		}
+	}
+	if s.opts.CloneAttempts != 0 {
+		policy.attempts = s.opts.CloneAttempts
+	}
+	if s.opts.CloneBackoff != 0 {
+		policy.backoff = s.opts.CloneBackoff
+	}
+
+	if policy.attempts < 1 {
+		return policy, configErrorf("clone attempts must be at least 1, got %d", policy.attempts)
+	}
+	if policy.backoff < 0 {
+		return policy, configErrorf("clone backoff must not be negative, got %s", policy.backoff)
+	}
+
+	return policy, nil
+}
+
+// withRetry runs fn until it succeeds or attempts are exhausted, doubling
+// the wait between attempts up to maxCloneBackoff. Only transient failures
+// are retried, others such as a rejected authentication are returned at
+// once.
+func (s *Setup) withRetry(policy retryPolicy, what string, fn func() error) error {
+	wait := policy.backoff
+	var err error
+	for attempt := 1; attempt <= policy.attempts; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if attempt == policy.attempts || !transientError(err) {
+			break
+		}
+
+		s.Warnf("%s failed (attempt %d of %d), retrying in %s: %v", what, attempt, policy.attempts, wait, err)
+		time.Sleep(wait)
+		wait = min(wait*2, maxCloneBackoff)
+	}
+	return err
+}
+
+// transientGitErrors are lowercase git stderr fragments of failures that
+// may pass on another attempt: network trouble and HTTP 429 or 5xx answers
+var transientGitErrors = []string{
+	"could not resolve host",
+	"connection timed out",
+	"connection reset",
+	"connection refused",
+	"operation timed out",
+	"network is unreachable",
+	"early eof",
+	"unexpected disconnect",
+	"the remote end hung up unexpectedly",
+	"rpc failed; curl",
+	"rpc failed; http 429",
+	"rpc failed; http 5",
+	"gnutls_handshake",
+	"returned error: 429",
+	"returned error: 5",
+}
+
+// transientError reports whether a failed git command is worth retrying
+func transientError(err error) bool {
+	if errors.Is(err, errGitTimeout) || errors.Is(err, context.DeadlineExceeded) || isTimeout(err) {
+		return true
+	}
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) {
+		return false
+	}
+	stderr := strings.ToLower(cmdErr.Stderr)
+	for _, fragment := range transientGitErrors {
+		if strings.Contains(stderr, fragment) {
+			return true
+		}
+	}
+	return false
+}
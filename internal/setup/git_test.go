@@ -0,0 +1,76 @@
+package setup
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		stderr   string
+		attempts int // Clone attempts made
+	}{
+		{"authentication", "fatal: Authentication failed for 'https://example.com/app.git/'", 1},
+		{"missing repository", "remote: Repository not found.\nfatal: repository 'https://example.com/app.git/' not found", 1},
+		{"http 403", "fatal: unable to access 'https://example.com/app.git/': The requested URL returned error: 403", 1},
+		{"dns", "fatal: unable to access 'https://example.com/app.git/': Could not resolve host: example.com", 3},
+		{"http 503", "fatal: unable to access 'https://example.com/app.git/': The requested URL returned error: 503", 3},
+		{"http 429", "fatal: unable to access 'https://example.com/app.git/': The requested URL returned error: 429", 3},
+		{"rpc 502", "error: RPC failed; HTTP 502 curl 22 The requested URL returned error: 502", 3},
+		{"rpc 401", "error: RPC failed; HTTP 401 curl 22 The requested URL returned error: 401", 1},
+		{"hung up", "fetch-pack: unexpected disconnect while reading sideband packet\nfatal: early EOF", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestSetup(t, Options{}, Assets{})
+			ts.runner.rules = []runRule{{prefix: "git clone", stderr: tt.stderr, err: errors.New("exit status 128")}}
+
+			err := ts.withRetry(retryPolicy{attempts: 3}, "clone of app", func() error {
+				return ts.runGit(opClone, "", "clone", "https://example.com/app.git", "/home/stai/work-stai/app")
+			})
+			if err == nil {
+				t.Fatal("withRetry succeeded, want an error")
+			}
+			if len(ts.runner.commands) != tt.attempts {
+				t.Errorf("%d attempts, want %d", len(ts.runner.commands), tt.attempts)
+			}
+			if last := tt.stderr[strings.LastIndex(tt.stderr, "\n")+1:]; !strings.Contains(err.Error(), last) {
+				t.Errorf("error %q lacks the stderr tail %q", err, last)
+			}
+		})
+	}
+}
+
+func TestWithRetrySucceeds(t *testing.T) {
+	ts := newTestSetup(t, Options{}, Assets{})
+	calls := 0
+	err := ts.withRetry(retryPolicy{attempts: 3}, "clone of app", func() error {
+		if calls++; calls < 3 {
+			return &CommandError{Err: errors.New("exit status 128"), Stderr: "fatal: the remote end hung up unexpectedly"}
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("withRetry = %v after %d calls, want success after 3", err, calls)
+	}
+}
+
+func TestTimeoutErrorKeepsStderr(t *testing.T) {
+	ts := newTestSetup(t, Options{}, Assets{})
+	ts.timeouts = map[string]time.Duration{opClone: 0}
+	ts.runner.rules = []runRule{{prefix: "git clone", stderr: "Receiving objects:  42%", err: errors.New("signal: killed")}}
+
+	err := ts.runGit(opClone, "", "clone", "https://example.com/app.git", "/home/stai/work-stai/app")
+	if !errors.Is(err, errGitTimeout) {
+		t.Fatalf("runGit error = %v, want a timeout", err)
+	}
+	if !strings.Contains(err.Error(), "Receiving objects:  42%") {
+		t.Errorf("timeout error %q lacks the stderr tail", err)
+	}
+	if !transientError(err) {
+		t.Errorf("timeout error %q is not retried", err)
+	}
+}
@@ -0,0 +1,127 @@
+package setup
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Git hosting kinds
+const (
+	HostGitHub = "github"
+	HostGitLab = "gitlab"
+)
+
+// Default API base URLs of the public hosting services
+const defaultGitLabAPI = "https://gitlab.com/api/v4"
+
+// GitHostConfig describes a git server, e.g. a self-hosted GitLab
+type GitHostConfig struct {
+	Type     string `json:"type,omitempty"`      // github or gitlab, empty for other SSH servers
+	APIURL   string `json:"api-url,omitempty"`   // API base URL, default https://HOST/api/v4 for gitlab, https://HOST/api/v3 for github
+	TokenEnv string `json:"token-env,omitempty"` // Environment variable with an access token
+	// SHA256 host key fingerprints added to known_hosts without asking
+	SSHFingerprints []string `json:"ssh-fingerprints,omitempty"`
+}
+
+// gitHost returns the config of a git server, github.com and gitlab.com
+// are known without config
+func gitHost(config *Config, host string) (GitHostConfig, bool) {
+	if h, ok := config.GitHosts[host]; ok {
+		return h, true
+	}
+	switch host {
+	case "github.com":
+		return GitHostConfig{Type: HostGitHub, APIURL: defaultGitHubAPI, TokenEnv: "GITHUB_TOKEN"}, true
+	case "gitlab.com":
+		return GitHostConfig{Type: HostGitLab, APIURL: defaultGitLabAPI, TokenEnv: "GITLAB_TOKEN"}, true
+	}
+	return GitHostConfig{}, false
+}
+
+// apiURL returns the API base URL of a git server
+func (h GitHostConfig) apiURL(host string) string {
+	switch {
+	case h.APIURL != "":
+		return h.APIURL
+	case h.Type == HostGitLab:
+		return "https://" + host + "/api/v4"
+	}
+	return "https://" + host + "/api/v3"
+}
+
+// token returns the access token of a git server, empty when unset
+func (h GitHostConfig) token() string {
+	if h.TokenEnv == "" {
+		return ""
+	}
+	return os.Getenv(h.TokenEnv)
+}
+
+// validateGitHosts checks the config 'git-hosts' section
+func validateGitHosts(config *Config) error {
+	for host, h := range config.GitHosts {
+		if h.Type != "" && h.Type != HostGitHub && h.Type != HostGitLab {
+			return configErrorf("invalid git-hosts type '%s' for %s, must be %s or %s", h.Type, host, HostGitHub, HostGitLab)
+		}
+		for _, fingerprint := range h.SSHFingerprints {
+			if _, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(fingerprint, "SHA256:")); err != nil || !strings.HasPrefix(fingerprint, "SHA256:") {
+				return configErrorf("invalid git-hosts ssh-fingerprints entry '%s' for %s, expected SHA256:... as printed by ssh-keygen -l", fingerprint, host)
+			}
+		}
+		if h.APIURL != "" {
+			if u, err := url.Parse(h.APIURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				return configErrorf("invalid git-hosts api-url '%s' for %s", h.APIURL, host)
+			}
+		}
+	}
+	return nil
+}
+
+// httpAuthUser is the user name sent with a token for git over HTTPS
+var httpAuthUser = map[string]string{
+	HostGitHub: "x-access-token",
+	HostGitLab: "oauth2",
+}
+
+// configureGitAuth passes the access tokens of HTTPS repository hosts to
+// git. The header is set through GIT_CONFIG_* variables scoped to the
+// host URL, so tokens neither show in the process list nor reach other
+// hosts.
+func (s *Setup) configureGitAuth(config *Config) {
+	s.gitEnv = nil
+
+	var hosts []string
+	for _, repo := range config.Repos {
+		if repo.GitRepo == nil {
+			continue
+		}
+		scheme, host := repoURLHost(*repo.GitRepo)
+		if scheme == "https" && !slices.Contains(hosts, host) {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+
+	count, _ := strconv.Atoi(os.Getenv("GIT_CONFIG_COUNT"))
+	for _, host := range hosts {
+		h, ok := gitHost(config, host)
+		token := h.token()
+		if !ok || token == "" || h.Type == "" {
+			continue
+		}
+		credentials := base64.StdEncoding.EncodeToString([]byte(httpAuthUser[h.Type] + ":" + token))
+		s.gitEnv = append(s.gitEnv,
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=http.https://%s/.extraHeader", count, host),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=Authorization: Basic %s", count, credentials))
+		count++
+	}
+	if len(s.gitEnv) > 0 {
+		s.gitEnv = append(s.gitEnv, fmt.Sprintf("GIT_CONFIG_COUNT=%d", count))
+	}
+}
@@ -0,0 +1,60 @@
+package setup
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// commonGitignore is the Assets.Gitignores entry used for every language
+const commonGitignore = "common"
+
+// gitignoreLangs returns the languages with an embedded .gitignore template
+func (s *Setup) gitignoreLangs() []string {
+	var langs []string
+	for lang := range s.assets.Gitignores {
+		if lang != commonGitignore {
+			langs = append(langs, lang)
+		}
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// validateLang checks the lang option of a repository
+func (s *Setup) validateLang(repo Repository) error {
+	if repo.Lang == "" {
+		return nil
+	}
+	if repo.Type != "local-git-repo" {
+		return configErrorf("lang is only used for local-git-repo type, got it for %s", repo.Name)
+	}
+	if _, ok := s.assets.Gitignores[repo.Lang]; !ok || repo.Lang == commonGitignore {
+		return configErrorf("invalid lang '%s' for %s, must be one of: %s", repo.Lang, repo.Name, strings.Join(s.gitignoreLangs(), ", "))
+	}
+	return nil
+}
+
+// gitignoreContent returns the generated .gitignore of a locally
+// initialized repository: common entries and those of its lang
+func (s *Setup) gitignoreContent(repo Repository) []byte {
+	content := s.assets.Gitignores[commonGitignore]
+	if repo.Lang != "" {
+		content += "\n" + s.assets.Gitignores[repo.Lang]
+	}
+	return []byte(content)
+}
+
+// writeGitignore creates the .gitignore of a locally initialized
+// repository unless it already has one
+func (s *Setup) writeGitignore(repoDir string, repo Repository) error {
+	path := filepath.Join(repoDir, ".gitignore")
+	if s.exists(path) {
+		return nil
+	}
+	if err := s.FS.WriteFile(path, s.gitignoreContent(repo), s.perms.files); err != nil {
+		return fmt.Errorf("failed to create .gitignore for %s: %w", repo.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package setup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// goWorkMarker is the first line of a generated go.work, files without
+// it are maintained by hand and left alone
+const goWorkMarker = "// Generated by ws-config-gen, remove this line to maintain go.work by hand"
+
+// goModMaxDepth is how deep go.mod files are searched for in a checkout
+const goModMaxDepth = 3
+
+// goModule is a Go module found in a checkout
+type goModule struct {
+	dir       string
+	path      string // module path
+	goVersion string // go directive, e.g. 1.22
+}
+
+// goWorkPath returns the location of the generated go.work
+func goWorkPath(baseDir string) string {
+	return filepath.Join(baseDir, "go.work")
+}
+
+// goWorkEnabled reports whether go.work is generated, config go-work
+// disables it
+func goWorkEnabled(config *Config) bool {
+	return config.GoWork == nil || *config.GoWork
+}
+
+// goModules returns the Go modules of the checkouts in config order. The
+// first worktree stands for worktrees repositories, a module path found
+// twice is only used once.
+func (s *Setup) goModules(baseDir string, config *Config) []goModule {
+	var modules []goModule
+	seen := map[string]bool{}
+	for _, repo := range config.Repos {
+		dir := repoPath(baseDir, repo)
+		if len(repo.Worktrees) > 0 {
+			dir = worktreeDirs(dir, repo)[0]
+		}
+		if !s.exists(dir) {
+			continue
+		}
+		for _, module := range s.findGoModules(dir, 0) {
+			if seen[module.path] {
+				continue
+			}
+			seen[module.path] = true
+			modules = append(modules, module)
+		}
+	}
+	return modules
+}
+
+// findGoModules returns the modules in dir and its subdirectories up to
+// goModMaxDepth, skipping hidden, vendor and testdata directories
+func (s *Setup) findGoModules(dir string, depth int) []goModule {
+	var modules []goModule
+	if data, err := s.FS.ReadFile(filepath.Join(dir, "go.mod")); err == nil {
+		if module, ok := parseGoMod(dir, data); ok {
+			modules = append(modules, module)
+		}
+	}
+	if depth >= goModMaxDepth {
+		return modules
+	}
+
+	entries, err := s.FS.ReadDir(dir)
+	if err != nil {
+		return modules
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || strings.HasPrefix(name, ".") || slices.Contains([]string{"vendor", "testdata", "node_modules"}, name) {
+			continue
+		}
+		modules = append(modules, s.findGoModules(filepath.Join(dir, name), depth+1)...)
+	}
+	return modules
+}
+
+// parseGoMod reads the module path and go directive of a go.mod file
+func parseGoMod(dir string, data []byte) (goModule, bool) {
+	module := goModule{dir: dir}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "module":
+			module.path = strings.Trim(fields[1], `"`)
+		case "go":
+			module.goVersion = fields[1]
+		}
+	}
+	return module, module.path != ""
+}
+
+// goWorkContent renders a go.work using modules with the highest go
+// directive among them
+func goWorkContent(baseDir string, modules []goModule) []byte {
+	version := ""
+	for _, module := range modules {
+		if version == "" || compareVersions(module.goVersion, version) > 0 {
+			version = module.goVersion
+		}
+	}
+
+	var b bytes.Buffer
+	b.WriteString(goWorkMarker + "\n")
+	if version != "" {
+		fmt.Fprintf(&b, "go %s\n", version)
+	}
+	b.WriteString("\nuse (\n")
+	for _, module := range modules {
+		rel, err := filepath.Rel(baseDir, module.dir)
+		if err != nil {
+			rel = module.dir
+		}
+		fmt.Fprintf(&b, "\t./%s\n", filepath.ToSlash(rel))
+	}
+	b.WriteString(")\n")
+	return b.Bytes()
+}
+
+// planGoWork returns the go.work of the base directory when checkouts
+// contain at least two Go modules and the content changes, nil otherwise.
+// A go.work not generated by ws-config-gen is kept.
+func (s *Setup) planGoWork(baseDir string, config *Config) (*renderedFile, error) {
+	if !goWorkEnabled(config) {
+		return nil, nil
+	}
+	modules := s.goModules(baseDir, config)
+	if len(modules) < 2 {
+		return nil, nil
+	}
+
+	path := goWorkPath(baseDir)
+	existing, err := s.readOptional(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if existing != nil && !bytes.HasPrefix(existing, []byte(goWorkMarker)) {
+		return nil, nil
+	}
+	content := goWorkContent(baseDir, modules)
+	if bytes.Equal(existing, content) {
+		return nil, nil
+	}
+	return &renderedFile{path: path, content: content}, nil
+}
+
+// writeGoWork writes the planned go.work of the base directory
+func (s *Setup) writeGoWork(baseDir string, config *Config) error {
+	file, err := s.planGoWork(baseDir, config)
+	if err != nil || file == nil {
+		return err
+	}
+	if err := s.FS.WriteFile(file.path, file.content, s.perms.files); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file.path, err)
+	}
+	fmt.Fprintf(s.Out, "  Wrote %s\n", file.path)
+	return nil
+}
+
+// goWorkSettings returns the workspace settings pointing the Go tools at
+// the go.work of the base directory, nil when none is used
+func (s *Setup) goWorkSettings(baseDir string, config *Config) (map[string]json.RawMessage, error) {
+	if !goWorkEnabled(config) {
+		return nil, nil
+	}
+	if !s.exists(goWorkPath(baseDir)) && len(s.goModules(baseDir, config)) < 2 {
+		return nil, nil
+	}
+	env, err := json.Marshal(map[string]string{"GOWORK": goWorkPath(baseDir)})
+	if err != nil {
+		return nil, templateErrorf("failed to marshal go.work settings: %w", err)
+	}
+	return map[string]json.RawMessage{"go.toolsEnvVars": env}, nil
+}
@@ -0,0 +1,60 @@
+package setup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// hookOutputTail is the number of output lines shown for a failed hook
+const hookOutputTail = 20
+
+// runPostCloneHooks runs the repository's post-clone commands with sh in
+// repoDir, stopping at the first failing command
+func (s *Setup) runPostCloneHooks(baseDir, repoDir string, repo Repository) error {
+	for _, hook := range repo.PostClone {
+		fmt.Fprintf(s.Out, "Running post-clone hook for %s: %s\n", repo.Name, hook)
+
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeouts[opHook])
+		var output bytes.Buffer
+		cmd := Command{
+			Name: "sh",
+			Args: []string{"-c", hook},
+			Dir:  repoDir,
+			Env: append(os.Environ(),
+				"STAI_BASE_DIR="+baseDir,
+				"STAI_REPO_NAME="+repo.Name,
+				"STAI_REPO_DIR="+repoDir,
+			),
+			Stdout: &output,
+			Stderr: &output,
+		}
+
+		err := s.runCommand(ctx, cmd)
+		timedOut := errors.Is(ctx.Err(), context.DeadlineExceeded)
+		cancel()
+		if err == nil {
+			continue
+		}
+
+		fmt.Fprintf(s.Out, "Post-clone hook output (last %d lines):\n%s\n", hookOutputTail, tailLines(output.String(), hookOutputTail))
+		if timedOut {
+			err = fmt.Errorf("timed out after %s", s.timeouts[opHook])
+		}
+		return hookErrorf("post-clone hook '%s' failed for %s: %w", hook, repo.Name, err)
+	}
+
+	return nil
+}
+
+// tailLines returns the last n lines of s
+func tailLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
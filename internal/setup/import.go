@@ -0,0 +1,186 @@
+package setup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// importMaxDepth bounds how deep Import looks for repositories below
+// the base directory
+const importMaxDepth = 3
+
+// Import scans the base directory for git repositories and writes a
+// config describing them to path, "-" for stdout. Repositories with an
+// origin remote become git-repo entries, others local-git-repo.
+func (s *Setup) Import(path string) error {
+	workDir, err := s.validateWorkingDirectory(s.opts.WorkDir)
+	if err != nil {
+		return err
+	}
+	baseDir, err := s.resolveBaseDirectory(s.opts.BaseDir, workDir)
+	if err != nil {
+		return err
+	}
+
+	// Progress would mix with the config written to stdout
+	progress := s.Out
+	if path == "-" {
+		progress = io.Discard
+	}
+
+	repos := []Repository{}
+	skip := []string{workDir, filepath.Join(baseDir, "vscode")}
+	if err := s.scanRepos(progress, baseDir, baseDir, skip, 1, &repos); err != nil {
+		return err
+	}
+
+	// Names must be unique, nested repositories sharing a name are named
+	// by their path
+	for i := range repos {
+		for j := range repos {
+			if i != j && repos[i].Name == repos[j].Name && repos[i].Path != "" {
+				repos[i].Name = strings.ReplaceAll(repos[i].Path, "/", "-")
+			}
+		}
+	}
+
+	imported := struct {
+		Inherit bool         `json:"inherit"`
+		Repos   []Repository `json:"repos"`
+	}{Repos: repos}
+	data, err := json.MarshalIndent(imported, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to marshal imported config: %w", err)
+	}
+	data = append(data, '\n')
+
+	if path == "-" {
+		_, err = s.Out.Write(data)
+		return err
+	}
+	if err := s.FS.WriteFile(path, data, s.perms.files); err != nil {
+		return fmt.Errorf("failed to write imported config: %w", err)
+	}
+	fmt.Fprintf(s.Out, "Wrote config with %d repositories to %s\n", len(repos), path)
+	return nil
+}
+
+// scanRepos appends the repositories found in dir to repos, descending
+// into directories that are not repositories up to importMaxDepth
+func (s *Setup) scanRepos(progress io.Writer, baseDir, dir string, skip []string, depth int, repos *[]Repository) error {
+	entries, err := s.FS.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || slices.Contains(skip, path) {
+			continue
+		}
+
+		repo, ok := s.importRepo(path)
+		if !ok {
+			if depth < importMaxDepth {
+				if err := s.scanRepos(progress, baseDir, path, skip, depth+1, repos); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		repo.Name = entry.Name()
+		if rel, err := filepath.Rel(baseDir, path); err == nil && rel != repo.Name {
+			repo.Path = filepath.ToSlash(rel)
+		}
+		fmt.Fprintf(progress, "Found %s repository %s\n", repo.Type, path)
+		*repos = append(*repos, repo)
+	}
+	return nil
+}
+
+// importRepo describes the repository in dir, ok is false when dir is no
+// git checkout or worktrees repository
+func (s *Setup) importRepo(dir string) (Repository, bool) {
+	var repo Repository
+	gitDir := dir
+	switch {
+	case s.exists(filepath.Join(dir, ".git")):
+	case s.exists(filepath.Join(bareRepoDir(dir), "HEAD")):
+		gitDir = bareRepoDir(dir)
+		repo.Worktrees = s.importWorktrees(gitDir)
+	default:
+		return repo, false
+	}
+
+	url, err := s.gitOutput(opInit, gitDir, "remote", "get-url", "origin")
+	if err != nil || url == "" {
+		repo.Type = "local-git-repo"
+		if branch, err := s.gitOutput(opInit, dir, "symbolic-ref", "--short", "HEAD"); err == nil && branch != defaultInitBranch {
+			repo.DefaultBranch = branch
+		}
+		return repo, true
+	}
+
+	repo.Type = "git-repo"
+	repo.GitRepo = &url
+	if upstream, err := s.gitOutput(opInit, gitDir, "remote", "get-url", remoteUpstream); err == nil {
+		repo.Upstream = upstream
+		if push, err := s.gitOutput(opInit, gitDir, "config", "--get", "remote.pushDefault"); err == nil && (push == remoteOrigin || push == remoteUpstream) {
+			repo.PushRemote = push
+		}
+	}
+	repo.Remotes = s.importRemotes(gitDir)
+	if len(repo.Worktrees) > 0 {
+		return repo, true
+	}
+	repo.Submodules = s.exists(filepath.Join(dir, ".gitmodules"))
+	if sparse, err := s.gitOutput(opInit, dir, "config", "--bool", "core.sparseCheckout"); err == nil && sparse == "true" {
+		if paths, err := s.gitOutput(opInit, dir, "sparse-checkout", "list"); err == nil && paths != "" {
+			repo.SparsePaths = strings.Split(paths, "\n")
+		}
+	}
+	return repo, true
+}
+
+// importRemotes returns the remotes of a repository besides origin and
+// upstream, nil without any
+func (s *Setup) importRemotes(gitDir string) map[string]string {
+	out, err := s.gitOutput(opInit, gitDir, "remote")
+	if err != nil || out == "" {
+		return nil
+	}
+	var remotes map[string]string
+	for _, name := range strings.Split(out, "\n") {
+		if name == remoteOrigin || name == remoteUpstream {
+			continue
+		}
+		if url, err := s.gitOutput(opInit, gitDir, "remote", "get-url", name); err == nil {
+			if remotes == nil {
+				remotes = map[string]string{}
+			}
+			remotes[name] = url
+		}
+	}
+	return remotes
+}
+
+// importWorktrees returns the branches checked out as worktrees of a
+// bare repository
+func (s *Setup) importWorktrees(bareDir string) []string {
+	out, err := s.gitOutput(opInit, bareDir, "worktree", "list", "--porcelain")
+	if err != nil {
+		return nil
+	}
+	var branches []string
+	for _, line := range strings.Split(out, "\n") {
+		if branch, ok := strings.CutPrefix(line, "branch refs/heads/"); ok {
+			branches = append(branches, branch)
+		}
+	}
+	return branches
+}
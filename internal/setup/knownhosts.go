@@ -0,0 +1,228 @@
+package setup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// globalKnownHosts is the system-wide known_hosts file ssh reads
+const globalKnownHosts = "/etc/ssh/ssh_known_hosts"
+
+// builtinSSHFingerprints are the published SHA256 host key fingerprints
+// of the public hosting services
+var builtinSSHFingerprints = map[string][]string{
+	"github.com": {
+		"SHA256:+DiY3wvvV6TuJJhbpZisF/zLDA0zPMSvHdkr4UvCOqU", // ed25519
+		"SHA256:p2QAMXNIC1TJYWeIOttrVc98/R1BUFWu3/LiyKgUfQM", // ecdsa
+		"SHA256:uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s", // rsa
+	},
+	"gitlab.com": {
+		"SHA256:eUXGGm1YGsMAS7vkcx6JOJdOGHPem5gQp4taiCfCLB8", // ed25519
+		"SHA256:HbW3g8zUjNSksFbqTiUWPWg2Bq1x8xdGUrliXFzSnUw", // ecdsa
+		"SHA256:ROQFvPThGrW4RuWLoL9tq9I9zJ42fK4XywyRtbOz/EQ", // rsa
+	},
+}
+
+// hostKey is a scanned SSH host key
+type hostKey struct {
+	line        string // known_hosts line
+	fingerprint string // SHA256:... as printed by ssh-keygen -l
+}
+
+// knownHostsName returns the host name as it is stored in known_hosts
+func (t sshTarget) knownHostsName() string {
+	if t.port != "" && t.port != "22" {
+		return "[" + t.host + "]:" + t.port
+	}
+	return t.host
+}
+
+// hostFingerprints returns the expected host key fingerprints of host,
+// the git-hosts ssh-fingerprints or the built-in ones
+func hostFingerprints(config *Config, host string) []string {
+	if h, ok := config.GitHosts[host]; ok && len(h.SSHFingerprints) > 0 {
+		return h.SSHFingerprints
+	}
+	return builtinSSHFingerprints[host]
+}
+
+// sshFingerprint returns the SHA256 fingerprint of a base64 encoded key
+func sshFingerprint(key string) (string, error) {
+	blob, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(blob)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:]), nil
+}
+
+// seedKnownHosts adds missing host keys of the SSH targets to
+// ~/.ssh/known_hosts, so a first clone in a fresh container does not stop
+// at the host key prompt. Keys are added when they match the expected
+// fingerprints, keys of other hosts only after confirmation. Without
+// write nothing is changed, the returned known_hosts names are the hosts
+// whose keys would be added.
+func (s *Setup) seedKnownHosts(config *Config, targets []sshTarget, write bool) (map[string]bool, error) {
+	for _, binary := range []string{"ssh-keygen", "ssh-keyscan"} {
+		if _, err := s.Runner.LookPath(binary); err != nil {
+			fmt.Fprintf(s.Out, "  %s not found in PATH, known_hosts not checked\n", binary)
+			return nil, nil
+		}
+	}
+
+	homeDir, err := s.Users.HomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get home directory: %w", err)
+	}
+	knownHosts := filepath.Join(homeDir, ".ssh", "known_hosts")
+
+	pending := map[string]bool{}
+	seen := map[string]bool{}
+	for _, target := range targets {
+		name := target.knownHostsName()
+		if seen[name] || s.knownHost(name, knownHosts) {
+			seen[name] = true
+			continue
+		}
+		seen[name] = true
+
+		keys, err := s.scanHostKeys(target)
+		if err != nil {
+			fmt.Fprintf(s.Out, "  Host key of %s: %v\n", name, err)
+			continue
+		}
+
+		expected := hostFingerprints(config, target.host)
+		var verified []hostKey
+		for _, key := range keys {
+			if slices.Contains(expected, key.fingerprint) {
+				verified = append(verified, key)
+			}
+		}
+		switch {
+		case len(expected) > 0 && len(verified) == 0:
+			return nil, gitErrorf("host key of %s (%s) does not match its known fingerprints, the connection may be intercepted. Check the host or its git-hosts ssh-fingerprints", name, keyFingerprints(keys))
+		case len(expected) == 0 && write && s.confirmHostKeys(name, keys):
+			verified = keys
+		case len(expected) == 0:
+			if err := s.sshProblem(ValidationErrorf, "host key of %s (%s) is not in %s and cannot be verified, add it or set ssh-fingerprints in git-hosts", name, keyFingerprints(keys), knownHosts); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if !write {
+			fmt.Fprintf(s.Out, "  Host key of %s: missing from %s, setup adds the verified key\n", name, knownHosts)
+			pending[name] = true
+			continue
+		}
+		if err := s.appendKnownHosts(knownHosts, verified); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(s.Out, "  Host key of %s: added to %s (%s)\n", name, knownHosts, keyFingerprints(verified))
+	}
+	return pending, nil
+}
+
+// knownHost reports whether name has a key in the user or global
+// known_hosts file
+func (s *Setup) knownHost(name, knownHosts string) bool {
+	for _, file := range []string{knownHosts, globalKnownHosts} {
+		if !s.exists(file) {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), sshTimeout)
+		err := s.runCommand(ctx, Command{Name: "ssh-keygen", Args: []string{"-F", name, "-f", file}})
+		cancel()
+		if err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// scanHostKeys fetches the host keys of target with ssh-keyscan
+func (s *Setup) scanHostKeys(target sshTarget) ([]hostKey, error) {
+	args := []string{"-T", "10"}
+	if target.port != "" {
+		args = append(args, "-p", target.port)
+	}
+	args = append(args, target.host)
+
+	ctx, cancel := context.WithTimeout(context.Background(), sshTimeout)
+	defer cancel()
+
+	var stdout bytes.Buffer
+	if err := s.runCommand(ctx, Command{Name: "ssh-keyscan", Args: args, Stdout: &stdout}); err != nil {
+		return nil, fmt.Errorf("ssh-keyscan failed: %w", err)
+	}
+
+	var keys []hostKey
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		fingerprint, err := sshFingerprint(fields[2])
+		if err != nil {
+			continue
+		}
+		keys = append(keys, hostKey{line: strings.TrimSpace(line), fingerprint: fingerprint})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("ssh-keyscan returned no keys")
+	}
+	return keys, nil
+}
+
+// confirmHostKeys asks whether to trust the unverified keys of name
+func (s *Setup) confirmHostKeys(name string, keys []hostKey) bool {
+	if !s.Interactive {
+		return false
+	}
+	fmt.Fprintf(s.Out, "  Host key fingerprints of %s:\n", name)
+	for _, key := range keys {
+		fmt.Fprintf(s.Out, "    %s\n", key.fingerprint)
+	}
+	fmt.Fprintf(s.Out, "  Add the host keys of %s to known_hosts? [y/N] ", name)
+	answer, _ := bufio.NewReader(s.In).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// appendKnownHosts appends host keys to the known_hosts file
+func (s *Setup) appendKnownHosts(knownHosts string, keys []hostKey) error {
+	if err := s.FS.MkdirAll(filepath.Dir(knownHosts), 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(knownHosts), err)
+	}
+	data, err := s.readOptional(knownHosts)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", knownHosts, err)
+	}
+	if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+		data = append(data, '\n')
+	}
+	for _, key := range keys {
+		data = append(data, key.line+"\n"...)
+	}
+	if err := s.FS.WriteFile(knownHosts, data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", knownHosts, err)
+	}
+	return nil
+}
+
+// keyFingerprints returns the fingerprints of keys, comma separated
+func keyFingerprints(keys []hostKey) string {
+	fingerprints := make([]string, len(keys))
+	for i, key := range keys {
+		fingerprints[i] = key.fingerprint
+	}
+	return strings.Join(fingerprints, ", ")
+}
@@ -0,0 +1,167 @@
+package setup
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Repository list formats
+const (
+	ListText = "text"
+	ListJSON = "json"
+)
+
+// ListedRepo is a repository of the effective config as printed by List
+type ListedRepo struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	URL  string `json:"url,omitempty"`
+	Ref  string `json:"ref,omitempty"`
+	// Remotes besides origin, upstream included
+	Remotes  map[string]string `json:"remotes,omitempty"`
+	Enabled  bool              `json:"enabled"`
+	Optional bool              `json:"optional"`
+	Present  bool              `json:"present"`
+	Path     string            `json:"path"`
+}
+
+// List prints the repositories of the effective config, including
+// disabled and optional ones, and whether they are checked out
+func (s *Setup) List(format string) error {
+	if format != ListText && format != ListJSON {
+		return ValidationErrorf("invalid list output format '%s', must be %s or %s", format, ListText, ListJSON)
+	}
+
+	config, err := s.loadConfig()
+	if err != nil {
+		return err
+	}
+	overrides, err := s.enabledOverrides(config)
+	if err != nil {
+		return err
+	}
+
+	workDir, err := s.validateWorkingDirectory(s.opts.WorkDir)
+	if err != nil {
+		return err
+	}
+	baseDir, err := s.resolveBaseDirectory(s.opts.BaseDir, workDir)
+	if err != nil {
+		return err
+	}
+
+	repos := []ListedRepo{}
+	for _, repo := range config.Repos {
+		listed := ListedRepo{
+			Name:     repo.Name,
+			Type:     repo.Type,
+			Ref:      repoRef(repo),
+			Enabled:  repoEnabled(repo, overrides),
+			Optional: repo.Optional,
+			Path:     repoPath(baseDir, repo),
+		}
+		if repo.GitRepo != nil {
+			listed.URL = *repo.GitRepo
+		}
+		listed.Remotes = listedRemotes(repo)
+		if repo.Type == repoTypeRemote {
+			// Nothing is set up locally for a remote folder
+			listed.URL, listed.Path = repo.URI, repo.URI
+		} else {
+			listed.Present = s.exists(listed.Path)
+		}
+		repos = append(repos, listed)
+	}
+
+	if format == ListJSON {
+		data, err := json.MarshalIndent(repos, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal repository list: %w", err)
+		}
+		_, err = fmt.Fprintln(s.Out, string(data))
+		return err
+	}
+
+	w := tabwriter.NewWriter(s.Out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTYPE\tURL\tREF\tREMOTES\tENABLED\tPRESENT")
+	for _, repo := range repos {
+		enabled := yesNo(repo.Enabled)
+		if repo.Enabled && repo.Optional {
+			enabled = "optional"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", repo.Name, repo.Type, orDash(repo.URL), orDash(repo.Ref), orDash(remotesColumn(repo.Remotes)), enabled, yesNo(repo.Present))
+	}
+	return w.Flush()
+}
+
+// RepoNames returns the names of the repositories of the merged config,
+// disabled and optional ones included, e.g. for shell completion
+func (s *Setup) RepoNames() ([]string, error) {
+	config, err := s.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(config.Repos))
+	for _, repo := range config.Repos {
+		names = append(names, repo.Name)
+	}
+	return names, nil
+}
+
+// repoRef returns the configured ref of a repository: worktree branches,
+// the default branch of local repositories, empty for the default
+// branch of origin
+func repoRef(repo Repository) string {
+	switch {
+	case len(repo.Worktrees) > 0:
+		return strings.Join(repo.Worktrees, ",")
+	case repo.Type == "local-git-repo" && repo.DefaultBranch != "":
+		return repo.DefaultBranch
+	case repo.Type == "local-git-repo":
+		return defaultInitBranch
+	}
+	return ""
+}
+
+// listedRemotes returns the upstream and additional remotes of a
+// repository, nil without any
+func listedRemotes(repo Repository) map[string]string {
+	if repo.Upstream == "" && len(repo.Remotes) == 0 {
+		return nil
+	}
+	remotes := map[string]string{}
+	for name, url := range repo.Remotes {
+		remotes[name] = url
+	}
+	if repo.Upstream != "" {
+		remotes[remoteUpstream] = repo.Upstream
+	}
+	return remotes
+}
+
+// remotesColumn returns the sorted remote names, comma separated
+func remotesColumn(remotes map[string]string) string {
+	names := make([]string, 0, len(remotes))
+	for name := range remotes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
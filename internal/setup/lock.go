@@ -0,0 +1,77 @@
+package setup
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// lockFileName is the file in the environment state directory held by a
+// running setup, older versions kept it in the base directory
+const lockFileName = "setup.lock"
+
+// legacyLockFileName is the lock file of older versions in the base
+// directory
+const legacyLockFileName = ".stai-setup.lock"
+
+// acquireLock creates the lock file of the base directory with the PID and
+// host of this process. A lock left by a process that no longer runs on
+// this host is replaced. The returned function releases the lock.
+func (s *Setup) acquireLock(baseDir string) (func(), error) {
+	path, err := s.envStatePath(baseDir, lockFileName)
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	content := fmt.Sprintf("%d %s\n", os.Getpid(), host)
+
+	for attempt := 0; attempt < 2; attempt++ {
+		err := s.FS.CreateExclusive(path, []byte(content), s.perms.files)
+		if err == nil {
+			return func() { _ = s.FS.Remove(path) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, fmt.Errorf("failed to create lock file %s: %w", path, err)
+		}
+
+		data, err := s.FS.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // released meanwhile
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read lock file %s: %w", path, err)
+		}
+
+		pid, lockHost, ok := parseLock(data)
+		if ok && (lockHost != host || (pid != os.Getpid() && processRunning(pid))) {
+			return nil, ValidationErrorf("another ws-config-gen run (PID %d on %s) is using %s. Wait for it to finish or remove %s if it is not running", pid, lockHost, baseDir, path)
+		}
+
+		fmt.Fprintf(s.Out, "Removing stale lock file %s\n", path)
+		if err := s.FS.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("failed to remove stale lock file %s: %w", path, err)
+		}
+	}
+
+	return nil, ValidationErrorf("failed to acquire lock file %s, another run is starting", path)
+}
+
+// parseLock returns the PID and host recorded in a lock file
+func parseLock(data []byte) (int, string, bool) {
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, "", false
+	}
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil || pid <= 0 {
+		return 0, "", false
+	}
+	host := ""
+	if len(fields) > 1 {
+		host = fields[1]
+	}
+	return pid, host, true
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package setup
+
+import "os"
+
+// processRunning reports whether a process with pid exists
+func processRunning(pid int) bool {
+	_, err := os.FindProcess(pid)
+	return err == nil
+}
@@ -0,0 +1,15 @@
+//go:build unix
+
+package setup
+
+import (
+	"errors"
+	"syscall"
+)
+
+// processRunning reports whether a process with pid exists, a process
+// of another user counts as running
+func processRunning(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,176 @@
+package setup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mj41/stai-vscode/internal/paths"
+)
+
+// logFileName is the default log file in $XDG_STATE_HOME/stai-vscode
+const logFileName = "ws-config-gen.log"
+
+// logFileOff disables the log file as value of --log-file
+const logFileOff = "off"
+
+// Size based rotation of the log file: once it would grow past
+// logMaxSize it is renamed to <name>.1, older ones shift up to
+// <name>.<logKeep>
+const (
+	logMaxSize = 10 << 20
+	logKeep    = 3
+)
+
+// ansiPattern matches the color codes of terminal output
+var ansiPattern = regexp.MustCompile("\033\\[[0-9;]*m")
+
+// logFile is an appending, rotating log writer on the FileSystem of a
+// Setup. The file is opened on the first write, so runs printing nothing
+// leave no log. Each line starts with the time, colors are removed.
+type logFile struct {
+	s       *Setup
+	path    string      // The default log of the state directory when empty
+	onError func(error) // Called once when the log cannot be written
+	mu      sync.Mutex
+	opened  bool
+	size    int64
+	midLine bool // The last write did not end a line
+	failed  bool
+}
+
+// defaultLogPath returns the log file used without --log-file
+func (s *Setup) defaultLogPath() (string, error) {
+	dir, err := s.Users.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, paths.App, logFileName), nil
+}
+
+// newLogFile returns the log of --log-file for s, nil when it is off
+func newLogFile(s *Setup, path string, onError func(error)) *logFile {
+	if path == logFileOff {
+		return nil
+	}
+	return &logFile{s: s, path: path, onError: onError}
+}
+
+// Write logs p with a timestamp in front of every line. It never fails,
+// a log that cannot be written is reported once and dropped.
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.failed {
+		return len(p), nil
+	}
+	if l.path == "" {
+		path, err := l.s.defaultLogPath()
+		if err != nil {
+			// No state directory, no default log
+			l.failed = true
+			return len(p), nil
+		}
+		l.path = path
+	}
+
+	text := ansiPattern.ReplaceAllString(string(p), "")
+	var b strings.Builder
+	stamp := time.Now().Format("2006-01-02 15:04:05.000 ")
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if line == "" {
+			continue
+		}
+		if !l.midLine {
+			b.WriteString(stamp)
+		}
+		b.WriteString(line)
+		l.midLine = !strings.HasSuffix(line, "\n")
+	}
+
+	if err := l.write(b.String()); err != nil {
+		l.failed = true
+		if l.onError != nil {
+			l.onError(err)
+		}
+	}
+	return len(p), nil
+}
+
+// fs returns the FileSystem of the log, the log is neither audited nor
+// rolled back
+func (l *logFile) fs() FileSystem {
+	if a, ok := l.s.FS.(auditFS); ok {
+		return a.FileSystem
+	}
+	return l.s.FS
+}
+
+// write appends text, opening and rotating the file as needed
+func (l *logFile) write(text string) error {
+	if !l.opened {
+		if err := l.open(); err != nil {
+			return err
+		}
+		header := fmt.Sprintf("%s=== %s (pid %d)\n", time.Now().Format("2006-01-02 15:04:05.000 "), strings.Join(os.Args, " "), os.Getpid())
+		text = header + text
+	}
+	if l.size > 0 && l.size+int64(len(text)) > logMaxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+		l.size = 0
+	}
+	if err := l.fs().AppendFile(l.path, []byte(text), 0600); err != nil {
+		return err
+	}
+	l.size += int64(len(text))
+	return nil
+}
+
+// open creates the directory of the log and takes the size of an
+// existing one
+func (l *logFile) open() error {
+	if err := l.fs().MkdirAll(filepath.Dir(l.path), defaultDirPerms); err != nil {
+		return err
+	}
+	info, err := l.fs().Stat(l.path)
+	switch {
+	case err == nil:
+		l.size = info.Size()
+	case !errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+	l.opened = true
+	return nil
+}
+
+// rotate shifts the log to <path>.1 and the older logs up, dropping the
+// one past logKeep
+func (l *logFile) rotate() error {
+	for i := logKeep; i > 0; i-- {
+		from := l.path
+		if i > 1 {
+			from = fmt.Sprintf("%s.%d", l.path, i-1)
+		}
+		if err := l.fs().Rename(from, fmt.Sprintf("%s.%d", l.path, i)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// logWriter returns w also writing to the log, w alone without one
+func logWriter(w io.Writer, log *logFile) io.Writer {
+	if log == nil {
+		return w
+	}
+	return io.MultiWriter(w, log)
+}
@@ -0,0 +1,58 @@
+package setup
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestLogFile(t *testing.T) {
+	ts := newTestSetup(t, Options{}, Assets{})
+	log := newLogFile(ts.Setup, "", nil)
+	fmt.Fprint(log, "\033[32m✓ Cloned\033[0m app\nHalf a ")
+	fmt.Fprint(log, "line\n")
+
+	data := ts.readFile(t, "/home/stai/.local/state/stai-vscode/"+logFileName)
+	lines := strings.Split(strings.TrimSuffix(data, "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d log lines, want 3:\n%s", len(lines), data)
+	}
+	stamp := `^\d{4}-\d\d-\d\d \d\d:\d\d:\d\d\.\d{3} `
+	for i, want := range []string{`=== .* \(pid \d+\)$`, `✓ Cloned app$`, `Half a line$`} {
+		if !regexp.MustCompile(stamp + want).MatchString(lines[i]) {
+			t.Errorf("log line %d = %q, want %s%s", i, lines[i], stamp, want)
+		}
+	}
+}
+
+func TestLogFileRotation(t *testing.T) {
+	ts := newTestSetup(t, Options{}, Assets{})
+	const path = "/tmp/logs/run.log"
+	ts.writeFile(t, path, strings.Repeat("x", logMaxSize-10))
+	for i := 1; i <= logKeep; i++ {
+		ts.writeFile(t, fmt.Sprintf("%s.%d", path, i), fmt.Sprint(i))
+	}
+
+	log := newLogFile(ts.Setup, path, nil)
+	fmt.Fprintln(log, "after rotation")
+
+	if got := ts.readFile(t, path); !strings.HasSuffix(got, "after rotation\n") || len(got) > 200 {
+		t.Errorf("new log = %q, want the rotated run only", got)
+	}
+	if got := ts.readFile(t, path+".1"); len(got) != logMaxSize-10 {
+		t.Errorf("%s.1 has %d bytes, want the previous log", path, len(got))
+	}
+	for i := 2; i <= logKeep; i++ {
+		if got := ts.readFile(t, fmt.Sprintf("%s.%d", path, i)); got != fmt.Sprint(i-1) {
+			t.Errorf("%s.%d = %q, want %d", path, i, got, i-1)
+		}
+	}
+}
+
+func TestLogFileOff(t *testing.T) {
+	ts := newTestSetup(t, Options{}, Assets{})
+	if log := newLogFile(ts.Setup, logFileOff, nil); log != nil {
+		t.Errorf("newLogFile(%s) = %v, want nil", logFileOff, log)
+	}
+}
@@ -0,0 +1,86 @@
+package setup
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// mirrorPath returns the bare mirror location of a repository
+func mirrorPath(mirrorDir string, repo Repository) string {
+	return filepath.Join(mirrorDir, repo.Name+".git")
+}
+
+// hasMirror reports whether a bare mirror exists for the repository
+func (s *Setup) hasMirror(mirrorDir string, repo Repository) bool {
+	if mirrorDir == "" {
+		return false
+	}
+	info, err := s.FS.Stat(mirrorPath(mirrorDir, repo))
+	return err == nil && info.IsDir()
+}
+
+// syncMirrors creates missing bare mirrors and refreshes existing ones
+// from upstream for all git-repo type repositories
+func (s *Setup) syncMirrors(mirrorDir string, config *Config) error {
+	policy, err := s.cloneRetryPolicy(config)
+	if err != nil {
+		return err
+	}
+
+	if err := s.FS.MkdirAll(mirrorDir, s.perms.dirs); err != nil {
+		return fmt.Errorf("failed to create mirror directory %s: %w", mirrorDir, err)
+	}
+
+	for _, repo := range config.Repos {
+		if repo.Type != "git-repo" {
+			continue
+		}
+
+		path := mirrorPath(mirrorDir, repo)
+		if s.hasMirror(mirrorDir, repo) {
+			fmt.Fprintf(s.Out, "Updating mirror %s\n", repo.Name)
+			args := append(proxyArgs(config.Proxy, *repo.GitRepo), "remote", "update", "--prune")
+			err := s.withRetry(policy, "mirror update of "+repo.Name, func() error {
+				return s.runGit(opClone, path, args...)
+			})
+			if err != nil {
+				return gitErrorf("failed to update mirror %s: %w", repo.Name, err)
+			}
+			continue
+		}
+
+		fmt.Fprintf(s.Out, "Creating mirror %s\n", repo.Name)
+		args := append(proxyArgs(config.Proxy, *repo.GitRepo), "clone", "--mirror", *repo.GitRepo, path)
+		err := s.withRetry(policy, "mirror clone of "+repo.Name, func() error {
+			if err := s.FS.RemoveAll(path); err != nil {
+				return err
+			}
+			return s.runGit(opClone, "", args...)
+		})
+		s.audit(auditClone, path, *repo.GitRepo, err)
+		if err != nil {
+			return gitErrorf("failed to create mirror %s: %w", repo.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// SyncMirrors creates or refreshes the bare mirrors in the mirror directory
+func (s *Setup) SyncMirrors() error {
+	if s.opts.MirrorDir == "" {
+		return ValidationErrorf("--mirror-dir is required for mirror sync")
+	}
+
+	config, err := s.loadEffectiveConfig()
+	if err != nil {
+		return err
+	}
+
+	mirrorDir, err := filepath.Abs(s.opts.MirrorDir)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path for mirror directory: %w", err)
+	}
+
+	return s.syncMirrors(mirrorDir, config)
+}
@@ -0,0 +1,215 @@
+package setup
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// networkTimeout bounds the DNS, TCP and HTTPS checks of a single host
+const networkTimeout = 10 * time.Second
+
+// Default ports of git transports checked with a TCP connection
+var gitTransportPorts = map[string]string{
+	"ssh":     "22",
+	"git+ssh": "22",
+	"git":     "9418",
+}
+
+// networkHost is a repository host checked before cloning
+type networkHost struct {
+	scheme string
+	host   string
+	port   string // empty for the default port of scheme
+}
+
+// String returns the host as it is shown to the user
+func (h networkHost) String() string {
+	if h.port != "" {
+		return h.scheme + "://" + net.JoinHostPort(h.host, h.port)
+	}
+	return h.scheme + "://" + h.host
+}
+
+// networkHostOf returns the host of a git URL, ok is false for local
+// paths and file URLs
+func networkHostOf(repoURL string) (networkHost, bool) {
+	scheme, host := repoURLHost(repoURL)
+	if host == "" || scheme == "file" {
+		return networkHost{}, false
+	}
+	h := networkHost{scheme: scheme, host: host}
+	if target, ok := sshTargetOf(repoURL); ok {
+		h.port = target.port
+	} else if u, err := url.Parse(repoURL); err == nil {
+		h.port = u.Port()
+	}
+	return h, true
+}
+
+// networkHosts returns the distinct hosts of all git-repo, upstream and
+// remotes URLs in config order. Repos cloned from a mirror are skipped.
+func (s *Setup) networkHosts(config *Config) []networkHost {
+	var hosts []networkHost
+	seen := map[networkHost]bool{}
+	for _, repo := range config.Repos {
+		if repo.Type != "git-repo" || s.hasMirror(s.opts.MirrorDir, repo) {
+			continue
+		}
+		urls := []string{*repo.GitRepo, repo.Upstream}
+		for _, name := range remoteNames(repo) {
+			urls = append(urls, repo.Remotes[name])
+		}
+		for _, repoURL := range urls {
+			if h, ok := networkHostOf(repoURL); ok && !seen[h] {
+				seen[h] = true
+				hosts = append(hosts, h)
+			}
+		}
+	}
+	return hosts
+}
+
+// checkNetwork checks DNS resolution and reachability of all repository
+// hosts at once and reports every failing host with the reason, DNS, TLS
+// or proxy, instead of clones failing one by one
+func (s *Setup) checkNetwork(config *Config) error {
+	hosts := s.networkHosts(config)
+	if len(hosts) == 0 {
+		return nil
+	}
+
+	results := make([]error, len(hosts))
+	var wg sync.WaitGroup
+	for i, h := range hosts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = probeNetworkHost(config.Proxy, h)
+		}()
+	}
+	wg.Wait()
+
+	var failed []string
+	for i, h := range hosts {
+		if results[i] != nil {
+			fmt.Fprintf(s.Out, "  %s: %v\n", h, results[i])
+			failed = append(failed, h.host)
+			continue
+		}
+		fmt.Fprintf(s.Out, "  %s: reachable\n", h)
+	}
+
+	if len(failed) > 0 {
+		return gitErrorf("unreachable hosts: %s. Check DNS, proxy and firewall settings or use --skip-check=%s", strings.Join(failed, ", "), CheckPreflight)
+	}
+	return nil
+}
+
+// probeNetworkHost checks one host, HTTP(S) hosts with a request through
+// the proxy git would use, other hosts with DNS and a TCP connection
+func probeNetworkHost(proxy string, h networkHost) error {
+	ctx, cancel := context.WithTimeout(context.Background(), networkTimeout)
+	defer cancel()
+
+	if h.scheme == "http" || h.scheme == "https" {
+		return classifyNetworkError(probeHTTP(ctx, proxy, h))
+	}
+
+	if _, err := net.DefaultResolver.LookupHost(ctx, h.host); err != nil {
+		return classifyNetworkError(err)
+	}
+	port := h.port
+	if port == "" {
+		port = gitTransportPorts[h.scheme]
+	}
+	if port == "" {
+		return nil
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(h.host, port))
+	if err != nil {
+		return classifyNetworkError(err)
+	}
+	return conn.Close()
+}
+
+// probeHTTP sends a HEAD request to the host root, any HTTP response
+// proves DNS, proxy and TLS work
+func probeHTTP(ctx context.Context, proxy string, h networkHost) error {
+	target := &url.URL{Scheme: h.scheme, Host: h.host}
+	if h.port != "" {
+		target.Host = net.JoinHostPort(h.host, h.port)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		if proxyArgs(proxy, req.URL.String()) == nil {
+			return http.ProxyFromEnvironment(req)
+		}
+		if !strings.Contains(proxy, "://") {
+			return url.Parse("http://" + proxy)
+		}
+		return url.Parse(proxy)
+	}
+	client := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, target.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// classifyNetworkError names the failing layer of a network error
+func classifyNetworkError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	var unknownAuthority x509.UnknownAuthorityError
+	var invalidCert x509.CertificateInvalidError
+	var hostnameErr x509.HostnameError
+	var verifyErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	switch {
+	case errors.As(err, &opErr) && opErr.Op == "proxyconnect":
+		return fmt.Errorf("proxy: %w", opErr.Err)
+	case errors.As(err, &dnsErr):
+		return fmt.Errorf("DNS: cannot resolve %s: %s", dnsErr.Name, dnsErr.Err)
+	case errors.As(err, &unknownAuthority), errors.As(err, &invalidCert), errors.As(err, &hostnameErr), errors.As(err, &verifyErr):
+		return fmt.Errorf("TLS: certificate not trusted: %w. A TLS intercepting proxy needs its CA certificate installed", err)
+	case errors.As(err, &recordErr):
+		return fmt.Errorf("TLS: server does not speak TLS, check the URL and proxy")
+	case errors.Is(err, context.DeadlineExceeded), isTimeout(err):
+		return fmt.Errorf("timeout: no response within %s", networkTimeout)
+	case errors.As(err, &opErr):
+		return fmt.Errorf("connection: %w", opErr.Err)
+	}
+	return err
+}
+
+// isTimeout reports whether err is a network timeout
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,103 @@
+package setup
+
+import (
+	"fmt"
+	"io/fs"
+	"slices"
+	"strconv"
+)
+
+// Default permissions of created directories and written files, the
+// umask applies to both
+const (
+	defaultDirPerms  fs.FileMode = 0750
+	defaultFilePerms fs.FileMode = 0644
+)
+
+// PermissionsConfig overrides the permissions of created directories and
+// written files, octal like "0770"
+type PermissionsConfig struct {
+	Dirs  string `json:"dirs,omitempty"`
+	Files string `json:"files,omitempty"`
+}
+
+// permissions are the effective modes of created directories and files
+type permissions struct {
+	dirs  fs.FileMode
+	files fs.FileMode
+}
+
+var defaultPermissions = permissions{dirs: defaultDirPerms, files: defaultFilePerms}
+
+// resolvePermissions returns the defaults overridden by the config
+// 'permissions' section
+func resolvePermissions(config *Config) (permissions, error) {
+	perms := defaultPermissions
+	if config.Permissions == nil {
+		return perms, nil
+	}
+	for _, p := range []struct {
+		field string
+		value string
+		mode  *fs.FileMode
+	}{
+		{"dirs", config.Permissions.Dirs, &perms.dirs},
+		{"files", config.Permissions.Files, &perms.files},
+	} {
+		if p.value == "" {
+			continue
+		}
+		mode, err := strconv.ParseUint(p.value, 8, 32)
+		if err != nil || mode > 0777 {
+			return perms, configErrorf("invalid permissions %s '%s', use an octal mode like 0750", p.field, p.value)
+		}
+		*p.mode = fs.FileMode(mode)
+	}
+	if perms.dirs&0700 != 0700 {
+		return perms, configErrorf("invalid permissions dirs '%04o', the owner needs full access", perms.dirs)
+	}
+	if perms.files&0600 != 0600 {
+		return perms, configErrorf("invalid permissions files '%04o', the owner needs read and write access", perms.files)
+	}
+	return perms, nil
+}
+
+// configurePermissions applies the effective permissions
+func (s *Setup) configurePermissions(config *Config) error {
+	perms, err := resolvePermissions(config)
+	if err != nil {
+		return err
+	}
+	s.perms = perms
+	return nil
+}
+
+// fixDirPermissions sets the configured mode on dirs that have another
+// one, the umask does not apply. Only the directories themselves change,
+// not the checkouts inside. Each change is printed, a mode that could not
+// be set is reported.
+func (s *Setup) fixDirPermissions(dirs []string, report func(string, ...any)) {
+	for _, dir := range dirs {
+		info, err := s.FS.Stat(dir)
+		if err != nil || !info.IsDir() || info.Mode().Perm() == s.perms.dirs {
+			continue
+		}
+		if err := s.FS.Chmod(dir, s.perms.dirs); err != nil {
+			report("failed to set permissions of %s to %04o: %v", dir, s.perms.dirs, err)
+			continue
+		}
+		fmt.Fprintf(s.Out, "  Permissions of %s changed from %04o to %04o\n", dir, info.Mode().Perm(), s.perms.dirs)
+	}
+}
+
+// permissionDirs returns the directories --strict-perms keeps at the
+// configured mode: the environment directories and the repository roots
+func permissionDirs(baseDir string, config *Config) []string {
+	dirs := environmentDirectories(baseDir, config)
+	for _, repo := range config.Repos {
+		if dir := repoPath(baseDir, repo); !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
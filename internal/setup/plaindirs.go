@@ -0,0 +1,20 @@
+package setup
+
+// repoTypeDir is a plain directory, e.g. for datasets or scratch files,
+// created when missing and added to the workspace but never made a git
+// repository
+const repoTypeDir = "dir"
+
+// validateDirRepo checks a dir type entry, which takes none of the git
+// options
+func validateDirRepo(repo Repository) error {
+	switch {
+	case repo.GitRepo != nil:
+		return configErrorf("git-repo is not used for %s type, got it for %s", repoTypeDir, repo.Name)
+	case repo.Submodules || len(repo.SparsePaths) > 0 || repo.LFS:
+		return configErrorf("submodules, sparse-paths and lfs require git-repo type, got them for %s", repo.Name)
+	case len(repo.PostClone) > 0:
+		return configErrorf("post-clone is only run for git-repo type, got it for %s", repo.Name)
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package setup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// Plan repository actions
+const (
+	planClone  = "clone"
+	planInit   = "init"
+	planCreate = "create" // Plain directory of the dir type
+	planSkip   = "skip"   // Directory already exists
+)
+
+// Plan lists everything a run would do, written by --plan-out and
+// executed by --apply after review
+type Plan struct {
+	BaseDir     string     `json:"base-dir"`
+	Directories []string   `json:"directories"`
+	Repos       []PlanRepo `json:"repos"`
+	Files       []PlanFile `json:"files"`
+	// Generation time of the planned files, --apply writes them with it
+	Generated time.Time `json:"generated"`
+	// Effective config the plan was built from, --apply uses it instead
+	// of loading the config layers again
+	Config *Config `json:"config"`
+}
+
+// PlanRepo is the action for one repository
+type PlanRepo struct {
+	Name        string   `json:"name"`
+	Action      string   `json:"action"`
+	Dir         string   `json:"dir"`
+	URL         string   `json:"url,omitempty"`
+	Mirror      string   `json:"mirror,omitempty"`
+	Submodules  bool     `json:"submodules,omitempty"`
+	SparsePaths []string `json:"sparse-paths,omitempty"`
+	LFS         bool     `json:"lfs,omitempty"`
+	Worktrees   []string `json:"worktrees,omitempty"`
+	PostClone   []string `json:"post-clone,omitempty"`
+}
+
+// PlanFile is a file the run writes, identified by content checksum
+type PlanFile struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+}
+
+func newPlanFile(path string, content []byte) PlanFile {
+	sum := sha256.Sum256(content)
+	return PlanFile{Path: path, SHA256: hex.EncodeToString(sum[:])}
+}
+
+// buildPlan computes the actions of a run without changing anything
+func (s *Setup) buildPlan(baseDir string, config *Config) (*Plan, error) {
+	plan := &Plan{
+		BaseDir:     baseDir,
+		Directories: []string{},
+		Repos:       []PlanRepo{},
+		Files:       []PlanFile{},
+		Generated:   s.started,
+		Config:      config,
+	}
+
+	for _, dir := range environmentDirectories(baseDir, config) {
+		if _, err := s.FS.Stat(dir); err != nil {
+			plan.Directories = append(plan.Directories, dir)
+		}
+	}
+
+	staiTempDir := filepath.Join(baseDir, "stai-temp")
+	if _, err := s.FS.Stat(filepath.Join(staiTempDir, ".git")); err != nil {
+		files, err := s.seedFiles(baseDir, config)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			plan.Files = append(plan.Files, newPlanFile(file.path, file.content))
+		}
+	}
+
+	for _, repo := range config.Repos {
+		entry := PlanRepo{Name: repo.Name, Dir: repoPath(baseDir, repo)}
+		switch {
+		case s.exists(entry.Dir):
+			entry.Action = planSkip
+		case repo.Type == "git-repo":
+			entry.Action = planClone
+			entry.URL = *repo.GitRepo
+			if s.hasMirror(s.opts.MirrorDir, repo) {
+				entry.Mirror = mirrorPath(s.opts.MirrorDir, repo)
+			}
+			entry.Submodules = repo.Submodules
+			entry.SparsePaths = repo.SparsePaths
+			entry.LFS = repo.LFS
+			entry.Worktrees = repo.Worktrees
+			entry.PostClone = repo.PostClone
+		case repo.Name == "stai-temp":
+			// Initialized together with its readme.md above
+			continue
+		case repo.Type == repoTypeDir:
+			entry.Action = planCreate
+		default:
+			entry.Action = planInit
+		}
+		plan.Repos = append(plan.Repos, entry)
+	}
+
+	workspace, err := s.planWorkspace(baseDir, config)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(workspace.Existing, workspace.Output) {
+		plan.Files = append(plan.Files, newPlanFile(workspace.Path, workspace.Output))
+	}
+
+	folderFiles, err := s.planFolderSettings(baseDir, config)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range folderFiles {
+		plan.Files = append(plan.Files, newPlanFile(file.path, file.content))
+	}
+	goWork, err := s.planGoWork(baseDir, config)
+	if err != nil {
+		return nil, err
+	}
+	if goWork != nil {
+		plan.Files = append(plan.Files, newPlanFile(goWork.path, goWork.content))
+	}
+	services, err := s.planServices(baseDir, config)
+	if err != nil {
+		return nil, err
+	}
+	if services != nil {
+		plan.Files = append(plan.Files, newPlanFile(services.path, services.content))
+	}
+
+	return plan, nil
+}
+
+// exists reports whether path exists
+func (s *Setup) exists(path string) bool {
+	_, err := s.FS.Stat(path)
+	return err == nil
+}
+
+// writePlan writes the plan as indented JSON
+func (s *Setup) writePlan(path string, plan *Plan) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal plan: %w", err)
+	}
+	if err := s.FS.WriteFile(path, append(data, '\n'), s.perms.files); err != nil {
+		return fmt.Errorf("failed to write plan: %w", err)
+	}
+	return nil
+}
+
+// readPlan reads a plan written by --plan-out and validates its config
+func (s *Setup) readPlan(path string) (*Plan, error) {
+	data, err := s.FS.ReadFile(path)
+	if err != nil {
+		return nil, ValidationErrorf("failed to read plan: %w", err)
+	}
+
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, ValidationErrorf("failed to parse plan %s: %w", path, err)
+	}
+	if plan.Config == nil || plan.BaseDir == "" {
+		return nil, ValidationErrorf("plan %s has no config or base-dir", path)
+	}
+
+	if err := s.validateConfig(plan.Config); err != nil {
+		return nil, err
+	}
+	if err := s.configureTimeouts(plan.Config); err != nil {
+		return nil, err
+	}
+
+	return &plan, nil
+}
+
+// checkPlanCurrent verifies the reviewed plan still describes what the
+// run would do
+func (s *Setup) checkPlanCurrent(reviewed *Plan, baseDir string) error {
+	current, err := s.buildPlan(baseDir, reviewed.Config)
+	if err != nil {
+		return err
+	}
+
+	want, err := json.Marshal(reviewed)
+	if err != nil {
+		return fmt.Errorf("failed to marshal plan: %w", err)
+	}
+	got, err := json.Marshal(current)
+	if err != nil {
+		return fmt.Errorf("failed to marshal plan: %w", err)
+	}
+	if !bytes.Equal(want, got) {
+		return ValidationErrorf("the environment changed since the plan was created, create and review a new plan with --plan-out")
+	}
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package setup
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// runPreflight runs checks that need no changes on disk, so problems are
+// reported before a partial setup is created
+func (s *Setup) runPreflight(config *Config, baseDir string) error {
+	if s.opts.SkipChecks[CheckPreflight] {
+		fmt.Fprintf(s.Out, "Skipping preflight checks (--skip-check=%s)\n", CheckPreflight)
+		return nil
+	}
+
+	s.printSection("Running preflight checks...")
+
+	if err := s.checkNetwork(config); err != nil {
+		return err
+	}
+
+	if err := s.checkSSH(config, true); err != nil {
+		return err
+	}
+
+	if err := s.checkRemotes(config); err != nil {
+		return err
+	}
+
+	if err := s.checkDiskSpace(config, baseDir); err != nil {
+		return err
+	}
+
+	if err := s.checkEditor(config); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// checkRemotes probes every git-repo URL with 'git ls-remote' and reports
+// all unreachable ones together. Repos cloned from a mirror are skipped.
+func (s *Setup) checkRemotes(config *Config) error {
+	var unreachable []string
+	for _, repo := range config.Repos {
+		if repo.Type != "git-repo" || s.hasMirror(s.opts.MirrorDir, repo) {
+			continue
+		}
+
+		for _, remote := range []string{*repo.GitRepo, repo.Upstream} {
+			if remote == "" {
+				continue
+			}
+			if err := s.probeRemote(config.Proxy, remote); err != nil {
+				fmt.Fprintf(s.Out, "  %s: %s unreachable: %v\n", repo.Name, remote, err)
+				unreachable = append(unreachable, repo.Name)
+				break
+			}
+		}
+	}
+
+	if len(unreachable) > 0 {
+		return gitErrorf("unreachable repositories: %s. Check network and credentials or use --skip-check=%s", strings.Join(unreachable, ", "), CheckPreflight)
+	}
+
+	return nil
+}
+
+// probeRemote checks that a remote can be listed without prompting
+func (s *Setup) probeRemote(proxy, repoURL string) error {
+	args := append(proxyArgs(proxy, repoURL), "ls-remote", repoURL, "HEAD")
+	ctx, cancel := s.gitContext(opClone)
+	defer cancel()
+
+	cmd := s.gitCommand("", args...)
+	cmd.Env = append(cmd.Env, "GIT_TERMINAL_PROMPT=0", "GIT_SSH_COMMAND=ssh -o BatchMode=yes")
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := s.runCommand(ctx, cmd); err != nil {
+		if ctx.Err() != nil {
+			return s.timeoutError(ctx, opClone, err)
+		}
+		if msg := firstLine(stderr.String()); msg != "" {
+			return fmt.Errorf("%s", msg)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// firstLine returns the first non-empty line of s, for git and ssh
+// errors it names the actual problem
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(line)
+}
+
+// Doctor runs the environment and preflight checks without changing anything
+func (s *Setup) Doctor() error {
+	config, err := s.loadEffectiveConfig()
+	if err != nil {
+		return err
+	}
+
+	s.printSection("Checking user and environment...")
+
+	if err := s.checkUser(s.expectedUser(config)); err != nil {
+		return err
+	}
+	if err := s.checkBinaries(config); err != nil {
+		return err
+	}
+
+	s.printSection("Checking network...")
+
+	if err := s.checkNetwork(config); err != nil {
+		return err
+	}
+
+	s.printSection("Checking SSH access...")
+
+	if err := s.checkSSH(config, false); err != nil {
+		return err
+	}
+
+	s.printSection("Checking remotes...")
+
+	if err := s.checkRemotes(config); err != nil {
+		return err
+	}
+
+	s.printSection("Checking disk space...")
+
+	workDir, err := s.validateWorkingDirectory(s.opts.WorkDir)
+	if err != nil {
+		return err
+	}
+	baseDir, err := s.resolveBaseDirectory(s.opts.BaseDir, workDir)
+	if err != nil {
+		return err
+	}
+	if err := s.checkDiskSpace(config, baseDir); err != nil {
+		return err
+	}
+
+	s.printSection("Checking editor...")
+
+	if err := s.checkEditor(config); err != nil {
+		return err
+	}
+	if err := s.checkExtensions(baseDir, config); err != nil {
+		return err
+	}
+
+	if len(config.Services) > 0 && selinuxEnforcing() {
+		s.printSection("Checking SELinux labels of service volumes...")
+		s.checkSELinux(baseDir, config)
+	}
+
+	s.PrintDone("No problems found")
+	return nil
+}
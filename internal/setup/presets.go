@@ -0,0 +1,68 @@
+package setup
+
+import (
+	"encoding/json"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Preset is a bundled set of recommended extensions and default settings
+// for a kind of repository
+type Preset struct {
+	Extensions []string                   `json:"extensions"`
+	Settings   map[string]json.RawMessage `json:"settings"`
+}
+
+// presetNames returns the names of the embedded presets
+func (s *Setup) presetNames() []string {
+	var names []string
+	for name := range s.assets.Presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// preset returns the embedded preset name
+func (s *Setup) preset(name string) (Preset, error) {
+	var preset Preset
+	data, ok := s.assets.Presets[name]
+	if !ok {
+		return preset, configErrorf("unknown preset '%s', must be one of: %s", name, strings.Join(s.presetNames(), ", "))
+	}
+	if err := json.Unmarshal([]byte(data), &preset); err != nil {
+		return preset, templateErrorf("failed to parse preset %s: %w", name, err)
+	}
+	return preset, nil
+}
+
+// validatePresets checks the presets of a repository exist
+func (s *Setup) validatePresets(repo Repository) error {
+	for _, name := range repo.Presets {
+		if _, err := s.preset(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// presetExtensions returns the recommended extensions of all presets used
+// by the repositories, in config order without duplicates
+func (s *Setup) presetExtensions(config *Config) ([]string, error) {
+	var extensions []string
+	for _, repo := range config.Repos {
+		for _, name := range repo.Presets {
+			preset, err := s.preset(name)
+			if err != nil {
+				return nil, err
+			}
+			for _, extension := range preset.Extensions {
+				if !slices.Contains(extensions, extension) {
+					extensions = append(extensions, extension)
+				}
+			}
+		}
+	}
+	return extensions, nil
+}
@@ -0,0 +1,72 @@
+package setup
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mj41/stai-vscode/internal/version"
+)
+
+// provenanceMarker starts the comment header of generated workspace files
+const provenanceMarker = "// Generated by ws-config-gen"
+
+// provenance is the comment header of a generated workspace file
+type provenance struct {
+	Version      string
+	ConfigSHA256 string
+	Generated    string // RFC 3339 time
+}
+
+// header returns the comment lines of p
+func (p provenance) header() []byte {
+	return fmt.Appendf(nil, "%s %s\n// config-sha256: %s\n// generated: %s\n", provenanceMarker, p.Version, p.ConfigSHA256, p.Generated)
+}
+
+// splitProvenance returns the provenance header of a workspace file and
+// the content after it, ok is false for files without one
+func splitProvenance(data []byte) (p provenance, body []byte, ok bool) {
+	lines := bytes.SplitN(data, []byte("\n"), 4)
+	if len(lines) < 4 {
+		return provenance{}, data, false
+	}
+	var found [3]bool
+	p.Version, found[0] = strings.CutPrefix(string(lines[0]), provenanceMarker+" ")
+	p.ConfigSHA256, found[1] = strings.CutPrefix(string(lines[1]), "// config-sha256: ")
+	p.Generated, found[2] = strings.CutPrefix(string(lines[2]), "// generated: ")
+	if !found[0] || !found[1] || !found[2] {
+		return provenance{}, data, false
+	}
+	return p, lines[3], true
+}
+
+// workspaceProvenance returns the header of the workspace file generated
+// from config in this run
+func (s *Setup) workspaceProvenance(config *Config) (provenance, error) {
+	sum, err := configSHA256(config)
+	if err != nil {
+		return provenance{}, err
+	}
+	return provenance{
+		Version:      version.Version,
+		ConfigSHA256: sum,
+		Generated:    s.started.UTC().Format(time.RFC3339),
+	}, nil
+}
+
+// withProvenance prepends the header p to body. The header of existing is
+// kept when only its timestamp differs and the content is unchanged, so
+// regenerating an up to date file does not touch it.
+func withProvenance(p provenance, body, existing []byte) []byte {
+	if old, oldBody, ok := splitProvenance(existing); ok && bytes.Equal(oldBody, body) &&
+		old.Version == p.Version && old.ConfigSHA256 == p.ConfigSHA256 {
+		return existing
+	}
+	return append(p.header(), body...)
+}
+
+// shortSHA abbreviates a checksum for messages
+func shortSHA(sum string) string {
+	return sum[:min(len(sum), 12)]
+}
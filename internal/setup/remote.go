@@ -0,0 +1,258 @@
+package setup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// remoteDir keeps the binary and config of remote-setup in the home of
+// the remote user
+const remoteDir = ".cache/stai-vscode/remote"
+
+// remoteBaseDir is the default base directory of remote-setup in the home
+// of the remote user
+const remoteBaseDir = "work-stai"
+
+// remoteTimeout bounds each ssh and scp call of remote-setup except the
+// setup run itself
+const remoteTimeout = 10 * time.Minute
+
+// remoteModule is built on remote machines of another platform
+const remoteModule = "github.com/mj41/stai-vscode/cmd/ws-config-gen@latest"
+
+// remotePlatforms maps uname output to Go platform names
+var remotePlatforms = map[string]string{
+	"Linux":   "linux",
+	"Darwin":  "darwin",
+	"x86_64":  "amd64",
+	"amd64":   "amd64",
+	"aarch64": "arm64",
+	"arm64":   "arm64",
+}
+
+// RemoteSetup sets up the environment on host ([user@]host) over SSH:
+// it copies this binary, or builds it with go install on another platform,
+// copies a local --config and runs setup there in CI mode with the output
+// streamed. The base directory is --base-dir on the remote machine,
+// ~/work-stai by default. When workspacePath is set, a local workspace
+// file opening the remote folders with VS Code Remote-SSH is written.
+func (s *Setup) RemoteSetup(host, workspacePath string) error {
+	if host == "" || strings.HasPrefix(host, "-") {
+		return ValidationErrorf("invalid remote host '%s', must be [user@]host", host)
+	}
+	for _, binary := range []string{"ssh", "scp"} {
+		if _, err := s.Runner.LookPath(binary); err != nil {
+			return ValidationErrorf("%s not found in PATH, it is needed for remote-setup", binary)
+		}
+	}
+
+	s.printSection("Preparing " + host + "...")
+	probe, err := s.remoteOutput(host, "uname -sm && echo $HOME")
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(probe)
+	if len(fields) != 3 {
+		return fmt.Errorf("unexpected reply of %s to uname: %q", host, probe)
+	}
+	goos, goarch, home := remotePlatforms[fields[0]], remotePlatforms[fields[1]], fields[2]
+	dir := path.Join(home, remoteDir)
+	if _, err := s.remoteOutput(host, "mkdir -p "+shellQuote(dir)); err != nil {
+		return err
+	}
+
+	binary := path.Join(dir, "ws-config-gen")
+	if goos == runtime.GOOS && goarch == runtime.GOARCH {
+		exe, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("failed to locate the ws-config-gen binary: %w", err)
+		}
+		if err := s.copyToRemote(host, exe, binary); err != nil {
+			return err
+		}
+		fmt.Fprintf(s.Out, "  Copied %s to %s:%s\n", exe, host, binary)
+	} else {
+		fmt.Fprintf(s.Out, "  %s is %s %s, building ws-config-gen there with go install\n", host, fields[0], fields[1])
+		if _, err := s.remoteOutput(host, "GOBIN="+shellQuote(dir)+" go install "+remoteModule); err != nil {
+			return fmt.Errorf("failed to build ws-config-gen on %s, install Go there or copy a binary for its platform: %w", host, err)
+		}
+	}
+
+	args := []string{binary, "--ci"}
+	switch config := s.opts.Config; {
+	case isRemoteConfig(config):
+		args = append(args, "--config", config)
+	case config != "":
+		remoteConfig := path.Join(dir, "config.json")
+		if err := s.copyToRemote(host, config, remoteConfig); err != nil {
+			return err
+		}
+		args = append(args, "--config", remoteConfig)
+	}
+
+	baseDir := s.opts.BaseDir
+	if baseDir == "" {
+		baseDir = remoteBaseDir
+	}
+	if !path.IsAbs(baseDir) {
+		baseDir = path.Join(home, baseDir)
+	}
+	workDir := path.Join(baseDir, "stai-vscode")
+	if _, err := s.remoteOutput(host, "mkdir -p "+shellQuote(workDir)); err != nil {
+		return err
+	}
+	args = append(args, "--work-dir", workDir, "--base-dir", baseDir)
+	args = append(args, s.remoteArgs()...)
+
+	s.printSection("Running setup on " + host + "...")
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	err = s.runCommand(context.Background(), Command{
+		Name:   "ssh",
+		Args:   []string{"--", host, strings.Join(quoted, " ")},
+		Stdout: s.Out,
+		Stderr: s.Err,
+	})
+	if err != nil {
+		return fmt.Errorf("setup on %s failed: %w", host, err)
+	}
+
+	if workspacePath != "" {
+		return s.writeRemoteWorkspace(host, baseDir, workspacePath)
+	}
+	return nil
+}
+
+// remoteArgs returns the flags of this invocation passed on to the remote
+// setup run
+func (s *Setup) remoteArgs() []string {
+	var args []string
+	if s.opts.ConfigSHA256 != "" {
+		args = append(args, "--config-sha256", s.opts.ConfigSHA256)
+	}
+	if s.opts.ExpectedUser != "" {
+		args = append(args, "--expected-user", s.opts.ExpectedUser)
+	}
+	if s.opts.ForceLevel != 0 {
+		args = append(args, "--force="+strconv.Itoa(s.opts.ForceLevel))
+	}
+	if len(s.opts.SkipChecks) > 0 {
+		var checks []string
+		for _, check := range CheckNames {
+			if s.opts.SkipChecks[check] {
+				checks = append(checks, check)
+			}
+		}
+		args = append(args, "--skip-check", strings.Join(checks, ","))
+	}
+	if s.opts.Jobs > 1 {
+		args = append(args, "--jobs", strconv.Itoa(s.opts.Jobs))
+	}
+	if s.opts.Timeout > 0 {
+		args = append(args, "--timeout", s.opts.Timeout.String())
+	}
+	for _, list := range []struct {
+		flag  string
+		names []string
+	}{{"--enable", s.opts.Enable}, {"--disable", s.opts.Disable}, {"--with", s.opts.With}} {
+		if len(list.names) > 0 {
+			args = append(args, list.flag, strings.Join(list.names, ","))
+		}
+	}
+	return args
+}
+
+// remoteOutput runs a shell command on host and returns its trimmed output
+func (s *Setup) remoteOutput(host, command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteTimeout)
+	defer cancel()
+	var stdout bytes.Buffer
+	err := s.runCommand(ctx, Command{Name: "ssh", Args: []string{"--", host, command}, Stdout: &stdout})
+	if err != nil {
+		return "", fmt.Errorf("ssh %s '%s' failed: %w", host, command, err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// copyToRemote copies a local file to remote on host, scp takes remote
+// literally in its SFTP mode, so it is not quoted
+func (s *Setup) copyToRemote(host, local, remote string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteTimeout)
+	defer cancel()
+	err := s.runCommand(ctx, Command{Name: "scp", Args: []string{"-q", "--", local, host + ":" + remote}})
+	if err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", local, host, err)
+	}
+	return nil
+}
+
+// writeRemoteWorkspace writes a local copy of the workspace file of the
+// remote environment in baseDir, with each folder opened on host through
+// a vscode-remote://ssh-remote URI
+func (s *Setup) writeRemoteWorkspace(host, baseDir, workspacePath string) error {
+	remotePath := path.Join(baseDir, "vscode", "stai-all.code-workspace")
+	data, err := s.remoteOutput(host, "cat "+shellQuote(remotePath))
+	if err != nil {
+		return err
+	}
+	workspace, err := parseWorkspaceObject([]byte(data))
+	if err != nil {
+		return fmt.Errorf("invalid workspace file %s on %s: %w", remotePath, host, err)
+	}
+	var content map[string]any
+	if err := json.Unmarshal(workspace.JSON(), &content); err != nil {
+		return fmt.Errorf("invalid workspace file %s on %s: %w", remotePath, host, err)
+	}
+
+	folders, _ := content["folders"].([]any)
+	for _, item := range folders {
+		folder, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		folderPath, ok := folder["path"].(string)
+		if !ok {
+			continue
+		}
+		if !path.IsAbs(folderPath) {
+			folderPath = path.Join(path.Dir(remotePath), folderPath)
+		}
+		delete(folder, "path")
+		folder["uri"] = remoteFolderURI(host, folderPath)
+	}
+	content["remoteAuthority"] = "ssh-remote+" + host
+
+	out, err := json.MarshalIndent(content, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to marshal remote workspace: %w", err)
+	}
+	if err := s.FS.WriteFile(workspacePath, append(out, '\n'), s.perms.files); err != nil {
+		return fmt.Errorf("failed to write remote workspace: %w", err)
+	}
+	fmt.Fprintf(s.Out, "Wrote workspace with %d folders on %s to %s\n", len(folders), host, workspacePath)
+	return nil
+}
+
+// remoteFolderURI returns the VS Code Remote-SSH URI of dir on host
+func remoteFolderURI(host, dir string) string {
+	return "vscode-remote://ssh-remote+" + host + (&url.URL{Path: dir}).EscapedPath()
+}
+
+// shellQuote quotes arg for a POSIX shell
+func shellQuote(arg string) string {
+	if arg != "" && strings.Trim(arg, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789@%+=:,./_-") == "" {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
@@ -0,0 +1,44 @@
+package setup
+
+import "strings"
+
+// repoTypeRemote is a workspace folder on another machine, opened through
+// a VS Code remote URI instead of a local checkout
+const repoTypeRemote = "remote"
+
+// remoteURIPrefix starts the URIs of VS Code remote folders, e.g.
+// vscode-remote://ssh-remote+build-box/home/dev/app or
+// vscode-remote://tunnel+build-box/home/dev/app
+const remoteURIPrefix = "vscode-remote://"
+
+// validateRemoteFolder checks a remote type entry: it needs a remote URI
+// and has nothing to clone or initialize
+func validateRemoteFolder(repo Repository) error {
+	rest, ok := strings.CutPrefix(repo.URI, remoteURIPrefix)
+	if !ok {
+		return configErrorf("remote type requires a %s uri for %s, e.g. %sssh-remote+HOST/path", remoteURIPrefix, repo.Name, remoteURIPrefix)
+	}
+	authority, path, _ := strings.Cut(rest, "/")
+	if authority == "" || path == "" {
+		return configErrorf("invalid uri '%s' for %s, must be %sAUTHORITY/path", repo.URI, repo.Name, remoteURIPrefix)
+	}
+	switch {
+	case repo.GitRepo != nil:
+		return configErrorf("git-repo is not used for remote type, got it for %s", repo.Name)
+	case repo.Path != "" || repo.Subdir != "":
+		return configErrorf("path and subdir are not used for remote type, the uri is the folder of %s", repo.Name)
+	case len(repo.Worktrees) > 0 || len(repo.PostClone) > 0 || len(repo.FolderSettings) > 0 || len(repo.FolderExtensions) > 0:
+		return configErrorf("worktrees, post-clone, folder-settings and folder-extensions need a local checkout, got them for remote %s", repo.Name)
+	}
+	return nil
+}
+
+// remoteFolder returns the workspace folder of a remote type entry, named
+// after the entry as VS Code shows remote folders by their URI otherwise
+func remoteFolder(repo Repository) FolderEntry {
+	name := repo.DisplayName
+	if name == "" {
+		name = repo.Name
+	}
+	return FolderEntry{Name: name, URI: repo.URI, Repo: repo.Name}
+}
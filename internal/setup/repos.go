@@ -0,0 +1,294 @@
+package setup
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// readUserConfig returns the raw user config, empty when it does not exist
+func (s *Setup) readUserConfig(path string) (map[string]any, []byte, error) {
+	data, err := s.readOptional(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read user config: %w", err)
+	}
+	raw := map[string]any{}
+	if data != nil {
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, nil, configErrorf("failed to parse user config %s: %w", path, err)
+		}
+	}
+	return raw, data, nil
+}
+
+// writeUserConfig writes the raw user config
+func (s *Setup) writeUserConfig(path string, raw map[string]any) error {
+	data, err := json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal user config: %w", err)
+	}
+	if err := s.FS.MkdirAll(filepath.Dir(path), s.perms.dirs); err != nil {
+		return fmt.Errorf("failed to create user config directory: %w", err)
+	}
+	if err := s.FS.WriteFile(path, append(data, '\n'), s.perms.files); err != nil {
+		return fmt.Errorf("failed to write user config: %w", err)
+	}
+	return nil
+}
+
+// AddRepo appends a repository to the user config, clones, initializes
+// or creates it and regenerates the workspace file. The user config is left
+// unchanged when the resulting config is invalid.
+func (s *Setup) AddRepo(name, repoURL, repoType string) error {
+	if repoType == "" {
+		repoType = "git-repo"
+	}
+	switch {
+	case name == "":
+		return ValidationErrorf("usage: ws-config-gen add-repo --name NAME --url URL [--type git-repo|local-git-repo|dir]")
+	case repoType != "git-repo" && repoType != "local-git-repo" && repoType != repoTypeDir:
+		return ValidationErrorf("invalid repository type '%s', must be git-repo, local-git-repo or %s", repoType, repoTypeDir)
+	case repoType == "git-repo" && repoURL == "":
+		return ValidationErrorf("--url is required for git-repo repositories")
+	case repoType != "git-repo" && repoURL != "":
+		return ValidationErrorf("--url is not used for %s repositories", repoType)
+	}
+
+	config, err := s.loadConfig()
+	if err != nil {
+		return err
+	}
+	if hasRepo(config, name) {
+		return ValidationErrorf("repository '%s' is already configured", name)
+	}
+
+	path, err := s.userConfigPath()
+	if err != nil {
+		return fmt.Errorf("failed to determine user config path: %w", err)
+	}
+	raw, previous, err := s.readUserConfig(path)
+	if err != nil {
+		return err
+	}
+	entry := map[string]any{"name": name, "type": repoType}
+	if repoURL != "" {
+		entry["git-repo"] = repoURL
+	}
+	repos, _ := raw["repos"].([]any)
+	raw["repos"] = append(repos, entry)
+	if err := s.writeUserConfig(path, raw); err != nil {
+		return err
+	}
+	fmt.Fprintf(s.Out, "Added %s to %s\n", name, path)
+
+	config, err = s.loadEffectiveConfig()
+	if err == nil && !hasRepo(config, name) {
+		err = configErrorf("repository '%s' is not part of the effective config, check 'inherit' of --config", name)
+	}
+	if err != nil {
+		s.restoreUserConfig(path, previous)
+		return err
+	}
+
+	workDir, err := s.validateWorkingDirectory(s.opts.WorkDir)
+	if err != nil {
+		return err
+	}
+	baseDir, err := s.resolveBaseDirectory(s.opts.BaseDir, workDir)
+	if err != nil {
+		return err
+	}
+
+	release, err := s.acquireLock(baseDir)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if err := s.createDirectories(baseDir, config); err != nil {
+		return err
+	}
+
+	added := *config
+	for _, repo := range config.Repos {
+		if repo.Name == name {
+			added.Repos = []Repository{repo}
+		}
+	}
+	s.printSection("Cloning repositories...")
+	if err := s.cloneRepositories(baseDir, &added); err != nil {
+		return err
+	}
+
+	s.printSection("Generating workspace file...")
+	if err := s.generateWorkspace(baseDir, config); err != nil {
+		return err
+	}
+
+	s.PrintDone(fmt.Sprintf("Repository %s added", name))
+	return nil
+}
+
+// archiveDir returns where remove-repo moves checkouts to
+func archiveDir(baseDir string) string {
+	return filepath.Join(baseDir, "vscode", "archive")
+}
+
+// RemoveRepo removes a repository from the user config, or disables it
+// there when a lower config layer defines it, and regenerates the
+// workspace file. The checkout is moved to vscode/archive, with
+// deleteCheckout it is deleted instead when it has no uncommitted changes.
+func (s *Setup) RemoveRepo(name string, deleteCheckout bool) error {
+	if name == "stai-temp" {
+		return ValidationErrorf("stai-temp cannot be removed")
+	}
+
+	config, err := s.loadConfig()
+	if err != nil {
+		return err
+	}
+	var repo Repository
+	for _, configured := range config.Repos {
+		if configured.Name == name {
+			repo = configured
+		}
+	}
+	if repo.Name == "" {
+		return ValidationErrorf("unknown repository '%s'", name)
+	}
+
+	workDir, err := s.validateWorkingDirectory(s.opts.WorkDir)
+	if err != nil {
+		return err
+	}
+	baseDir, err := s.resolveBaseDirectory(s.opts.BaseDir, workDir)
+	if err != nil {
+		return err
+	}
+
+	repoDir := repoPath(baseDir, repo)
+	checkedOut := s.exists(repoDir)
+	if checkedOut && deleteCheckout {
+		if err := s.checkClean(repoDir, repo); err != nil {
+			return err
+		}
+	}
+
+	release, err := s.acquireLock(baseDir)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if config, err = s.removeFromUserConfig(name); err != nil {
+		return err
+	}
+
+	switch {
+	case !checkedOut:
+	case deleteCheckout:
+		if err := s.FS.RemoveAll(repoDir); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", repoDir, err)
+		}
+		fmt.Fprintf(s.Out, "Deleted %s\n", repoDir)
+	default:
+		archived := filepath.Join(archiveDir(baseDir), name+"."+time.Now().Format(backupTimeFormat))
+		if err := s.FS.MkdirAll(archiveDir(baseDir), s.perms.dirs); err != nil {
+			return fmt.Errorf("failed to create archive directory: %w", err)
+		}
+		if err := s.FS.Rename(repoDir, archived); err != nil {
+			return fmt.Errorf("failed to archive %s: %w", repoDir, err)
+		}
+		fmt.Fprintf(s.Out, "Moved %s to %s\n", repoDir, archived)
+	}
+
+	s.printSection("Generating workspace file...")
+	if err := s.generateWorkspace(baseDir, config); err != nil {
+		return err
+	}
+
+	s.PrintDone(fmt.Sprintf("Repository %s removed", name))
+	return nil
+}
+
+// removeFromUserConfig drops the repository entry from the user config
+// and disables the repository there when another config layer still
+// defines it. It returns the effective config without the repository,
+// the user config is restored when the repository stays enabled.
+func (s *Setup) removeFromUserConfig(name string) (*Config, error) {
+	path, err := s.userConfigPath()
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine user config path: %w", err)
+	}
+	raw, previous, err := s.readUserConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	repos, _ := raw["repos"].([]any)
+	kept := []any{}
+	for _, item := range repos {
+		if entry, ok := item.(map[string]any); !ok || entry["name"] != name {
+			kept = append(kept, item)
+		}
+	}
+	raw["repos"] = kept
+	if err := s.writeUserConfig(path, raw); err != nil {
+		return nil, err
+	}
+
+	action := "Removed %s from %s\n"
+	config, err := s.loadConfig()
+	if err == nil && hasRepo(config, name) {
+		raw["repos"] = append(kept, map[string]any{"name": name, "enabled": false})
+		err = s.writeUserConfig(path, raw)
+		action = "Disabled %s in %s\n"
+	}
+	if err == nil {
+		config, err = s.loadEffectiveConfig()
+	}
+	if err == nil && hasRepo(config, name) {
+		err = configErrorf("repository '%s' stays enabled by --config, remove it there", name)
+	}
+	if err != nil {
+		s.restoreUserConfig(path, previous)
+		return nil, err
+	}
+
+	fmt.Fprintf(s.Out, action, name, path)
+	return config, nil
+}
+
+// restoreUserConfig writes back the previous user config, removing it
+// when there was none
+func (s *Setup) restoreUserConfig(path string, previous []byte) {
+	if previous == nil {
+		_ = s.FS.Remove(path)
+	} else {
+		_ = s.FS.WriteFile(path, previous, s.perms.files)
+	}
+	fmt.Fprintf(s.Out, "Reverted %s\n", path)
+}
+
+// checkClean fails when a checkout, or any worktree of it, has
+// uncommitted or untracked changes
+func (s *Setup) checkClean(repoDir string, repo Repository) error {
+	dirs := []string{repoDir}
+	if len(repo.Worktrees) > 0 {
+		dirs = worktreeDirs(repoDir, repo)
+	}
+	for _, dir := range dirs {
+		if !s.exists(dir) {
+			continue
+		}
+		status, err := s.gitOutput(opInit, dir, "status", "--porcelain")
+		if err != nil {
+			return gitErrorf("failed to check changes in %s: %w", dir, err)
+		}
+		if status != "" {
+			return ValidationErrorf("%s has uncommitted changes, not deleting it. Run without --delete to archive it", dir)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package setup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// envLockName is the file name of the revision lock in exported archives
+const envLockName = "stai-env.lock.json"
+
+// RepoRevision is the checked out revision of a repository
+type RepoRevision struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"` // Checkout relative to the base directory, slash separated
+	URL    string `json:"url,omitempty"`
+	Branch string `json:"branch,omitempty"` // Empty for a detached HEAD
+	SHA    string `json:"sha,omitempty"`    // Empty without commits
+	// Uncommitted and untracked files, recorded by snapshots only
+	Dirty []string `json:"dirty,omitempty"`
+}
+
+// EnvironmentLock records the revisions of all checked out repositories
+// of an environment, a snapshot also their uncommitted files
+type EnvironmentLock struct {
+	Generated time.Time      `json:"generated"`
+	Host      string         `json:"host,omitempty"`
+	BaseDir   string         `json:"base-dir"`
+	Repos     []RepoRevision `json:"repos"`
+	// Folder paths and settings of the workspace file
+	Folders  []string                   `json:"folders,omitempty"`
+	Settings map[string]json.RawMessage `json:"settings,omitempty"`
+}
+
+// repoRevisions returns the revisions of the repositories of config that
+// are checked out in baseDir, stai-temp included and plain directories
+// left out
+func (s *Setup) repoRevisions(baseDir string, config *Config) []RepoRevision {
+	repos := config.Repos
+	if !hasRepo(config, "stai-temp") {
+		repos = append([]Repository{staiTempRepo(config)}, repos...)
+	}
+
+	revisions := []RepoRevision{}
+	for _, repo := range repos {
+		if repo.Type == repoTypeDir {
+			continue
+		}
+		// The first worktree stands for a worktrees repository
+		dir := repoPath(baseDir, repo)
+		if len(repo.Worktrees) > 0 {
+			dir = worktreeDirs(dir, repo)[0]
+		}
+		if !s.exists(dir) {
+			continue
+		}
+		rel, err := filepath.Rel(baseDir, dir)
+		if err != nil {
+			continue
+		}
+		revision := RepoRevision{Name: repo.Name, Path: filepath.ToSlash(rel)}
+		if repo.GitRepo != nil {
+			revision.URL = *repo.GitRepo
+		}
+		if branch, err := s.gitOutput(opInit, dir, "symbolic-ref", "--short", "-q", "HEAD"); err == nil {
+			revision.Branch = branch
+		}
+		if sha, err := s.gitOutput(opInit, dir, "rev-parse", "--verify", "--quiet", "HEAD"); err == nil {
+			revision.SHA = sha
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions
+}
+
+// environmentLock returns the revision lock of the environment in baseDir
+func (s *Setup) environmentLock(baseDir string, config *Config) *EnvironmentLock {
+	host, _ := os.Hostname()
+	lock := &EnvironmentLock{
+		Generated: time.Now().UTC(),
+		Host:      host,
+		BaseDir:   baseDir,
+		Repos:     s.repoRevisions(baseDir, config),
+	}
+	if data, err := s.readOptional(workspaceFilePath(baseDir)); err == nil && data != nil {
+		if workspace, err := parseWorkspaceObject(data); err == nil {
+			lock.Folders = workspaceFolderPaths(workspace)
+			lock.Settings = workspaceSettings(workspace)
+		}
+	}
+	return lock
+}
+
+// marshalLock returns lock as indented JSON
+func marshalLock(lock any) ([]byte, error) {
+	data, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal revisions: %w", err)
+	}
+	return append(data, '\n'), nil
+}
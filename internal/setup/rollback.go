@@ -0,0 +1,171 @@
+package setup
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// change is an artifact of the running setup that a rollback undoes
+type change struct {
+	path     string
+	created  bool        // path did not exist before, it is removed
+	previous []byte      // content of an overwritten file
+	mode     fs.FileMode // mode of an overwritten file or before a chmod
+	chmod    bool
+}
+
+// journal collects the changes of a run for rollback, nil when changes
+// are not tracked
+type journal struct {
+	changes []change
+}
+
+// trackChanges starts recording the changes made through FS and by clones
+func (s *Setup) trackChanges() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.journal = &journal{}
+}
+
+// stopTracking ends recording once all steps succeeded
+func (s *Setup) stopTracking() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.journal = nil
+}
+
+// track records a change when changes are tracked
+func (s *Setup) track(c change) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.journal != nil {
+		s.journal.changes = append(s.journal.changes, c)
+	}
+}
+
+// trackCreated records that path is created by the run
+func (s *Setup) trackCreated(path string) {
+	s.track(change{path: path, created: true})
+}
+
+// tracking reports whether changes are recorded
+func (s *Setup) tracking() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.journal != nil
+}
+
+// missingAncestor returns the outermost directory of path that does not
+// exist yet, the one MkdirAll creates first
+func missingAncestor(fsys FileSystem, path string) string {
+	missing := path
+	for dir := filepath.Dir(missing); dir != missing; dir = filepath.Dir(missing) {
+		if _, err := fsys.Stat(dir); err == nil {
+			break
+		}
+		missing = dir
+	}
+	return missing
+}
+
+// rollback undoes the tracked changes of a failed run, newest first:
+// created files, directories and clones are removed and overwritten files
+// get their previous content back. The run state is removed too, so the
+// next run starts over. Without --rollback-on-failure an interactive run
+// asks first, others keep the changes and only print how many there are.
+func (s *Setup) rollback(baseDir string, runErr error) {
+	s.mu.Lock()
+	j := s.journal
+	s.journal = nil
+	s.mu.Unlock()
+	if j == nil || len(j.changes) == 0 {
+		return
+	}
+
+	if !s.opts.RollbackOnFailure && !s.confirmRollback(j, runErr) {
+		if !s.Interactive {
+			fmt.Fprintf(s.Out, "The failed run made %d changes, use --rollback-on-failure to undo them\n", len(j.changes))
+		}
+		return
+	}
+
+	s.printSection("Rolling back changes of the failed run...")
+	created := map[string]bool{}
+	for _, c := range j.changes {
+		if c.created {
+			created[c.path] = true
+		}
+	}
+	for i := len(j.changes) - 1; i >= 0; i-- {
+		c := j.changes[i]
+		// Changes inside created directories go with them
+		if insideCreated(filepath.Dir(c.path), created) {
+			continue
+		}
+		switch {
+		case c.created:
+			if !s.exists(c.path) {
+				continue
+			}
+			if err := s.FS.RemoveAll(c.path); err != nil {
+				s.Warnf("Failed to remove %s: %v", c.path, err)
+				continue
+			}
+			fmt.Fprintf(s.Out, "  Removed %s\n", c.path)
+		case c.chmod:
+			if err := s.FS.Chmod(c.path, c.mode); err != nil {
+				s.Warnf("Failed to restore permissions of %s: %v", c.path, err)
+				continue
+			}
+			fmt.Fprintf(s.Out, "  Restored permissions of %s\n", c.path)
+		default:
+			if err := s.FS.WriteFile(c.path, c.previous, c.mode); err != nil {
+				s.Warnf("Failed to restore %s: %v", c.path, err)
+				continue
+			}
+			fmt.Fprintf(s.Out, "  Restored %s\n", c.path)
+		}
+	}
+
+	if path, err := s.envStatePath(baseDir, stateFileName); err == nil {
+		if err := s.FS.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			s.Warnf("Failed to remove %s: %v", path, err)
+		}
+	}
+}
+
+// insideCreated reports whether dir or one of its parents was created by
+// the run
+func insideCreated(dir string, created map[string]bool) bool {
+	for ; ; dir = filepath.Dir(dir) {
+		if created[dir] {
+			return true
+		}
+		if filepath.Dir(dir) == dir {
+			return false
+		}
+	}
+}
+
+// confirmRollback asks whether the changes of the failed run should be
+// undone
+func (s *Setup) confirmRollback(j *journal, runErr error) bool {
+	if !s.Interactive {
+		return false
+	}
+	created := 0
+	for _, c := range j.changes {
+		if c.created {
+			created++
+		}
+	}
+	fmt.Fprintf(s.Out, "Setup failed: %v\n", runErr)
+	fmt.Fprintf(s.Out, "Remove the %d paths created and restore the %d files changed by this run? [y/N] ", created, len(j.changes)-created)
+	answer, _ := bufio.NewReader(s.In).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,94 @@
+package setup
+
+import (
+	"bytes"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// SeedFile is a file committed to stai-temp in its initial commit
+type SeedFile struct {
+	Path    string `json:"path"`    // Relative to stai-temp
+	Content string `json:"content"` // Template, see SeedData
+}
+
+// SeedData contains data for the readme and seed file templates
+type SeedData struct {
+	BaseWorkDir string
+	TemplateInfo
+}
+
+// renderedFile is a file path with its rendered content
+type renderedFile struct {
+	path    string
+	content []byte
+}
+
+// validateSeedFiles checks that seed file paths stay inside stai-temp
+// and their templates parse
+func validateSeedFiles(config *Config) error {
+	for _, file := range config.SeedFiles {
+		clean := filepath.ToSlash(filepath.Clean(filepath.FromSlash(file.Path)))
+		if file.Path == "" || filepath.IsAbs(file.Path) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+			return configErrorf("invalid seed file path '%s', must be relative to stai-temp", file.Path)
+		}
+		if clean == ".git" || strings.HasPrefix(clean, ".git/") {
+			return configErrorf("invalid seed file path '%s', must not be inside .git", file.Path)
+		}
+		if _, err := template.New(file.Path).Parse(file.Content); err != nil {
+			return configErrorf("failed to parse seed file %s: %w", file.Path, err)
+		}
+	}
+	return nil
+}
+
+// seedFiles returns the files of the initial stai-temp commit: readme.md
+// rendered from the embedded template, the generated .gitignore and
+// .editorconfig and the config seed files, which can replace them
+func (s *Setup) seedFiles(baseDir string, config *Config) ([]renderedFile, error) {
+	data := SeedData{BaseWorkDir: baseDir, TemplateInfo: s.templateInfo(baseDir, config)}
+
+	tmpl, err := template.New("readme").Parse(s.assets.ReadmeTemplate)
+	if err != nil {
+		return nil, templateErrorf("failed to parse readme template: %w", err)
+	}
+	var readme bytes.Buffer
+	if err := tmpl.Execute(&readme, data); err != nil {
+		return nil, templateErrorf("failed to render readme template: %w", err)
+	}
+
+	editorconfig, err := s.editorconfigContent(config, data)
+	if err != nil {
+		return nil, err
+	}
+
+	staiTemp := staiTempRepo(config)
+	staiTempDir := filepath.Join(baseDir, "stai-temp")
+	files := []renderedFile{
+		{path: filepath.Join(staiTempDir, "readme.md"), content: readme.Bytes()},
+		{path: filepath.Join(staiTempDir, ".gitignore"), content: s.gitignoreContent(staiTemp)},
+		{path: filepath.Join(staiTempDir, ".editorconfig"), content: editorconfig},
+	}
+
+	for _, file := range config.SeedFiles {
+		tmpl, err := template.New(file.Path).Parse(file.Content)
+		if err != nil {
+			return nil, templateErrorf("failed to parse seed file %s: %w", file.Path, err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return nil, templateErrorf("failed to render seed file %s: %w", file.Path, err)
+		}
+
+		rendered := renderedFile{path: filepath.Join(staiTempDir, filepath.FromSlash(file.Path)), content: buf.Bytes()}
+		if i := slices.IndexFunc(files, func(f renderedFile) bool { return f.path == rendered.path }); i >= 0 {
+			files[i] = rendered
+			continue
+		}
+		files = append(files, rendered)
+	}
+
+	return files, nil
+}
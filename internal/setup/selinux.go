@@ -0,0 +1,110 @@
+package setup
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// containerFileType is the SELinux type containers may read and write
+const containerFileType = "container_file_t"
+
+// relabelTimeout bounds a chcon run of the SELinux check
+const relabelTimeout = 5 * time.Minute
+
+// bindMount is a host directory mounted into a service container
+type bindMount struct {
+	service string
+	source  string // absolute host path
+	options []string
+}
+
+// serviceBindMounts returns the host paths of the service volumes, relative
+// ones resolved from the compose file directory as docker compose does.
+// Sources using variables are left out.
+func (s *Setup) serviceBindMounts(baseDir string, config *Config) []bindMount {
+	var mounts []bindMount
+	for _, service := range config.Services {
+		for _, volume := range resolveService(service).Volumes {
+			parts := strings.Split(volume, ":")
+			if len(parts) < 2 || !isBindSource(parts[0]) || strings.Contains(parts[0], "$") {
+				continue
+			}
+			source := parts[0]
+			if rest, ok := strings.CutPrefix(source, "~"); ok {
+				home, err := s.Users.HomeDir()
+				if err != nil {
+					continue
+				}
+				source = home + rest
+			}
+			if !filepath.IsAbs(source) {
+				source = filepath.Join(filepath.Dir(composeFilePath(baseDir)), source)
+			}
+			mount := bindMount{service: service.Name, source: source}
+			if len(parts) > 2 {
+				mount.options = strings.Split(parts[2], ",")
+			}
+			mounts = append(mounts, mount)
+		}
+	}
+	return mounts
+}
+
+// isBindSource reports whether a volume source is a host path rather than
+// a named volume
+func isBindSource(source string) bool {
+	return source != "" && strings.ContainsAny(source[:1], "/.~$")
+}
+
+// checkSELinux warns about service bind mounts a container cannot access
+// on an SELinux enforcing system: sources without the container_file_t
+// type and without the z or Z volume option that relabels them. Interactive
+// runs offer to relabel them with chcon.
+func (s *Setup) checkSELinux(baseDir string, config *Config) {
+	if !selinuxEnforcing() {
+		return
+	}
+	for _, mount := range s.serviceBindMounts(baseDir, config) {
+		if slices.Contains(mount.options, "z") || slices.Contains(mount.options, "Z") || !s.exists(mount.source) {
+			continue
+		}
+		label, err := selinuxLabel(mount.source)
+		if err != nil {
+			s.Warnf("Failed to read the SELinux label of %s: %v", mount.source, err)
+			continue
+		}
+		if fields := strings.Split(label, ":"); len(fields) >= 3 && fields[2] == containerFileType {
+			continue
+		}
+
+		relabel := []string{"chcon", "-R", "-t", containerFileType, mount.source}
+		if s.confirmRelabel(mount, label) {
+			ctx, cancel := context.WithTimeout(context.Background(), relabelTimeout)
+			err := s.runCommand(ctx, Command{Name: relabel[0], Args: relabel[1:]})
+			cancel()
+			if err == nil {
+				fmt.Fprintf(s.Out, "  Relabeled %s\n", mount.source)
+				continue
+			}
+			s.Warnf("Failed to relabel %s: %v", mount.source, err)
+		}
+		s.Warnf("SELinux denies service %s access to %s (%s). Run '%s' or add ':z' to the volume", mount.service, mount.source, label, strings.Join(relabel, " "))
+	}
+}
+
+// confirmRelabel asks whether a bind mount source should be relabeled
+func (s *Setup) confirmRelabel(mount bindMount, label string) bool {
+	if !s.Interactive {
+		return false
+	}
+	fmt.Fprintf(s.Out, "  %s mounted by service %s has SELinux label %s\n", mount.source, mount.service, label)
+	fmt.Fprintf(s.Out, "  Relabel it as %s for containers? [y/N] ", containerFileType)
+	answer, _ := bufio.NewReader(s.In).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,24 @@
+package setup
+
+import (
+	"os"
+	"strings"
+	"syscall"
+)
+
+// selinuxEnforcing reports whether SELinux is enabled and enforcing
+func selinuxEnforcing() bool {
+	data, err := os.ReadFile("/sys/fs/selinux/enforce")
+	return err == nil && strings.TrimSpace(string(data)) == "1"
+}
+
+// selinuxLabel returns the SELinux context of path, e.g.
+// unconfined_u:object_r:user_home_t:s0
+func selinuxLabel(path string) (string, error) {
+	buf := make([]byte, 256)
+	n, err := syscall.Getxattr(path, "security.selinux", buf)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(buf[:n]), "\x00"), nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package setup
+
+import "errors"
+
+// selinuxEnforcing is only implemented on Linux
+func selinuxEnforcing() bool {
+	return false
+}
+
+// selinuxLabel is only implemented on Linux
+func selinuxLabel(path string) (string, error) {
+	return "", errors.New("SELinux is not supported on this platform")
+}
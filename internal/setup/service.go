@@ -0,0 +1,195 @@
+package setup
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+)
+
+// serviceName is the name of the systemd user service and timer
+const serviceName = "ws-config-gen"
+
+// defaultSchedule is the systemd OnCalendar expression of the timer
+const defaultSchedule = "daily"
+
+// systemctlTimeout bounds each systemctl call of install-service
+const systemctlTimeout = time.Minute
+
+// InstallService writes a systemd user service running setup in CI mode
+// with the current config and directories, and a timer starting it on
+// schedule, then enables the timer when systemctl is available
+func (s *Setup) InstallService(schedule string) error {
+	if runtime.GOOS != "linux" {
+		return ValidationErrorf("install-service needs systemd and is only supported on Linux")
+	}
+	if schedule == "" {
+		schedule = defaultSchedule
+	}
+	if strings.ContainsAny(schedule, "\n\r") {
+		return ValidationErrorf("invalid --schedule '%s'", schedule)
+	}
+
+	if _, err := s.loadEffectiveConfig(); err != nil {
+		return err
+	}
+	workDir, err := s.validateWorkingDirectory(s.opts.WorkDir)
+	if err != nil {
+		return err
+	}
+	baseDir, err := s.resolveBaseDirectory(s.opts.BaseDir, workDir)
+	if err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate the ws-config-gen binary: %w", err)
+	}
+	if strings.Contains(exe, "go-build") {
+		fmt.Fprintf(s.Out, "  %s is a temporary 'go run' binary, install ws-config-gen and run install-service with it\n", exe)
+	}
+
+	args, err := s.serviceArgs(workDir, baseDir)
+	if err != nil {
+		return err
+	}
+
+	configDir, err := s.Users.ConfigDir()
+	if err != nil {
+		return fmt.Errorf("failed to get config directory: %w", err)
+	}
+	unitDir := filepath.Join(configDir, "systemd", "user")
+	if err := s.FS.MkdirAll(unitDir, s.perms.dirs); err != nil {
+		return fmt.Errorf("failed to create %s: %w", unitDir, err)
+	}
+
+	units := []renderedFile{
+		{path: filepath.Join(unitDir, serviceName+".service"), content: serviceUnit(workDir, append([]string{exe}, args...))},
+		{path: filepath.Join(unitDir, serviceName+".timer"), content: timerUnit(schedule)},
+	}
+	for _, unit := range units {
+		if err := s.FS.WriteFile(unit.path, unit.content, s.perms.files); err != nil {
+			return fmt.Errorf("failed to write %s: %w", unit.path, err)
+		}
+		fmt.Fprintf(s.Out, "Wrote %s\n", unit.path)
+	}
+
+	enable := []string{"systemctl", "--user", "enable", "--now", serviceName + ".timer"}
+	if err := s.enableTimer(enable); err != nil {
+		fmt.Fprintf(s.Out, "  Timer not enabled: %v\n  Enable it with: %s\n", err, strings.Join(enable, " "))
+		return nil
+	}
+
+	s.PrintDone(fmt.Sprintf("Installed %s.timer (%s), logs: journalctl --user -u %s", serviceName, schedule, serviceName))
+	return nil
+}
+
+// serviceArgs returns the flags of the scheduled run: CI mode, output
+// only on changes and the config, directory and check flags of this
+// invocation
+func (s *Setup) serviceArgs(workDir, baseDir string) ([]string, error) {
+	args := []string{"--ci", "--quiet", "--changes-only", "--work-dir", workDir, "--base-dir", baseDir}
+
+	env, err := s.environment()
+	if err != nil {
+		return nil, err
+	}
+	if env != nil {
+		args = append(args, "--env", env.Name)
+	}
+
+	if config := s.opts.Config; config != "" {
+		if !isRemoteConfig(config) {
+			abs, err := filepath.Abs(config)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve --config: %w", err)
+			}
+			config = abs
+		}
+		args = append(args, "--config", config)
+	}
+	if s.opts.ConfigSHA256 != "" {
+		args = append(args, "--config-sha256", s.opts.ConfigSHA256)
+	}
+	if s.opts.MirrorDir != "" {
+		abs, err := filepath.Abs(s.opts.MirrorDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve --mirror-dir: %w", err)
+		}
+		args = append(args, "--mirror-dir", abs)
+	}
+	if s.opts.ExpectedUser != "" {
+		args = append(args, "--expected-user", s.opts.ExpectedUser)
+	}
+	if len(s.opts.SkipChecks) > 0 {
+		checks := slices.Sorted(maps.Keys(s.opts.SkipChecks))
+		args = append(args, "--skip-check", strings.Join(checks, ","))
+	}
+	return args, nil
+}
+
+// serviceUnit returns the systemd service running command in workDir
+func serviceUnit(workDir string, command []string) []byte {
+	quoted := make([]string, len(command))
+	for i, arg := range command {
+		quoted[i] = systemdQuote(arg)
+	}
+
+	var b strings.Builder
+	b.WriteString("[Unit]\n")
+	b.WriteString("Description=Sync the stai-vscode environment with its config\n")
+	b.WriteString("Documentation=https://github.com/mj41/stai-vscode\n\n")
+	b.WriteString("[Service]\n")
+	b.WriteString("Type=oneshot\n")
+	fmt.Fprintf(&b, "WorkingDirectory=%s\n", strings.ReplaceAll(workDir, "%", "%%"))
+	fmt.Fprintf(&b, "ExecStart=%s\n", strings.Join(quoted, " "))
+	return []byte(b.String())
+}
+
+// timerUnit returns the systemd timer starting the service on schedule,
+// runs missed while the machine was off are caught up
+func timerUnit(schedule string) []byte {
+	var b strings.Builder
+	b.WriteString("[Unit]\n")
+	b.WriteString("Description=Periodic sync of the stai-vscode environment\n\n")
+	b.WriteString("[Timer]\n")
+	fmt.Fprintf(&b, "OnCalendar=%s\n", schedule)
+	b.WriteString("Persistent=true\n")
+	b.WriteString("RandomizedDelaySec=10m\n\n")
+	b.WriteString("[Install]\n")
+	b.WriteString("WantedBy=timers.target\n")
+	return []byte(b.String())
+}
+
+// systemdQuote quotes arg for a unit file command line, % specifiers are
+// escaped everywhere
+func systemdQuote(arg string) string {
+	arg = strings.ReplaceAll(arg, "%", "%%")
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\;$") {
+		return arg
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `$$`)
+	return `"` + r.Replace(arg) + `"`
+}
+
+// enableTimer reloads the systemd user manager and runs enable
+func (s *Setup) enableTimer(enable []string) error {
+	if _, err := s.Runner.LookPath("systemctl"); err != nil {
+		return fmt.Errorf("systemctl not found in PATH")
+	}
+	for _, command := range [][]string{{"systemctl", "--user", "daemon-reload"}, enable} {
+		ctx, cancel := context.WithTimeout(context.Background(), systemctlTimeout)
+		err := s.runCommand(ctx, Command{Name: command[0], Args: command[1:]})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("%s failed: %w", strings.Join(command, " "), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package setup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// composeProject is the docker compose project name of the services
+const composeProject = "stai"
+
+// composeHeader is the first line of the generated docker-compose.yml
+const composeHeader = "# Generated by ws-config-gen from the services of the config, edits are overwritten"
+
+// serviceNamePattern matches names valid as compose service names
+var serviceNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Service is a local development service run with docker compose
+type Service struct {
+	Name        string            `json:"name"`
+	Type        string            `json:"type,omitempty"` // postgres, redis or ollama for defaults, empty for a custom image
+	Image       string            `json:"image,omitempty"`
+	Ports       []string          `json:"ports,omitempty"` // host:container
+	Environment map[string]string `json:"environment,omitempty"`
+	Volumes     []string          `json:"volumes,omitempty"` // named volume or host path, then :container path
+	Command     []string          `json:"command,omitempty"`
+}
+
+// serviceDefaults are the built-in service types, config fields override
+// them and environment variables are added to theirs
+var serviceDefaults = map[string]Service{
+	"postgres": {
+		Image:       "postgres:16",
+		Ports:       []string{"5432:5432"},
+		Environment: map[string]string{"POSTGRES_USER": "stai", "POSTGRES_PASSWORD": "stai", "POSTGRES_DB": "stai"},
+		Volumes:     []string{"postgres-data:/var/lib/postgresql/data"},
+	},
+	"redis": {
+		Image:   "redis:7",
+		Ports:   []string{"6379:6379"},
+		Volumes: []string{"redis-data:/data"},
+	},
+	"ollama": {
+		Image:   "ollama/ollama",
+		Ports:   []string{"11434:11434"},
+		Volumes: []string{"ollama-data:/root/.ollama"},
+	},
+}
+
+// composeFilePath returns the location of the generated compose file
+func composeFilePath(baseDir string) string {
+	return filepath.Join(baseDir, "vscode", "docker-compose.yml")
+}
+
+// validateServices checks service names, types and images
+func validateServices(config *Config) error {
+	seen := map[string]bool{}
+	for _, service := range config.Services {
+		if !serviceNamePattern.MatchString(service.Name) {
+			return configErrorf("invalid service name '%s', use lowercase letters, digits, '-' and '_'", service.Name)
+		}
+		if seen[service.Name] {
+			return configErrorf("duplicate service '%s'", service.Name)
+		}
+		seen[service.Name] = true
+		if service.Type != "" {
+			if _, ok := serviceDefaults[service.Type]; !ok {
+				return configErrorf("unknown type '%s' of service %s, must be one of: %s", service.Type, service.Name, strings.Join(slices.Sorted(maps.Keys(serviceDefaults)), ", "))
+			}
+		} else if service.Image == "" {
+			return configErrorf("service %s needs a type or an image", service.Name)
+		}
+	}
+	return nil
+}
+
+// resolveService applies the defaults of the service type
+func resolveService(service Service) Service {
+	defaults, ok := serviceDefaults[service.Type]
+	if !ok {
+		return service
+	}
+	if service.Image == "" {
+		service.Image = defaults.Image
+	}
+	if service.Ports == nil {
+		service.Ports = defaults.Ports
+	}
+	if service.Volumes == nil {
+		service.Volumes = defaults.Volumes
+	}
+	if service.Command == nil {
+		service.Command = defaults.Command
+	}
+	env := maps.Clone(defaults.Environment)
+	if env == nil {
+		env = map[string]string{}
+	}
+	maps.Copy(env, service.Environment)
+	service.Environment = env
+	return service
+}
+
+// composeContent renders the docker-compose.yml of the services. Scalars
+// are written as JSON strings, which YAML reads as they are.
+func composeContent(services []Service) []byte {
+	quote := func(value string) string {
+		data, _ := json.Marshal(value)
+		return string(data)
+	}
+
+	var b bytes.Buffer
+	b.WriteString(composeHeader + "\n")
+	fmt.Fprintf(&b, "name: %s\n", composeProject)
+	b.WriteString("services:\n")
+	var volumes []string
+	for _, service := range services {
+		service = resolveService(service)
+		fmt.Fprintf(&b, "  %s:\n", service.Name)
+		fmt.Fprintf(&b, "    image: %s\n", quote(service.Image))
+		b.WriteString("    restart: unless-stopped\n")
+		if len(service.Command) > 0 {
+			b.WriteString("    command:\n")
+			for _, arg := range service.Command {
+				fmt.Fprintf(&b, "      - %s\n", quote(arg))
+			}
+		}
+		if len(service.Ports) > 0 {
+			b.WriteString("    ports:\n")
+			for _, port := range service.Ports {
+				fmt.Fprintf(&b, "      - %s\n", quote(port))
+			}
+		}
+		if len(service.Environment) > 0 {
+			b.WriteString("    environment:\n")
+			for _, key := range slices.Sorted(maps.Keys(service.Environment)) {
+				fmt.Fprintf(&b, "      %s: %s\n", quote(key), quote(service.Environment[key]))
+			}
+		}
+		if len(service.Volumes) > 0 {
+			b.WriteString("    volumes:\n")
+			for _, volume := range service.Volumes {
+				fmt.Fprintf(&b, "      - %s\n", quote(volume))
+				source, _, found := strings.Cut(volume, ":")
+				if found && !isBindSource(source) && !slices.Contains(volumes, source) {
+					volumes = append(volumes, source)
+				}
+			}
+		}
+	}
+	if len(volumes) > 0 {
+		b.WriteString("volumes:\n")
+		for _, volume := range volumes {
+			fmt.Fprintf(&b, "  %s: {}\n", volume)
+		}
+	}
+	return b.Bytes()
+}
+
+// workspaceTasks is the tasks section of the workspace file
+type workspaceTasks struct {
+	Version string          `json:"version"`
+	Tasks   []workspaceTask `json:"tasks"`
+}
+
+// workspaceTask is a VS Code task running a process
+type workspaceTask struct {
+	Label          string   `json:"label"`
+	Type           string   `json:"type"`
+	Command        string   `json:"command"`
+	Args           []string `json:"args"`
+	ProblemMatcher []string `json:"problemMatcher"`
+}
+
+// servicesTasks returns the workspace tasks starting, stopping and
+// following the logs of the services with the container runtime, nil
+// without services
+func (s *Setup) servicesTasks(baseDir string, config *Config) (*workspaceTasks, error) {
+	if len(config.Services) == 0 {
+		return nil, nil
+	}
+	runtime, err := s.containerRuntime(config)
+	if err != nil {
+		return nil, err
+	}
+	editor, err := s.resolveEditor(config)
+	if err != nil {
+		return nil, err
+	}
+	task := func(label string, args ...string) workspaceTask {
+		t := workspaceTask{
+			Label:          label,
+			Type:           "process",
+			Command:        runtime,
+			Args:           append([]string{"compose", "-f", composeFilePath(baseDir)}, args...),
+			ProblemMatcher: []string{},
+		}
+		// Tasks of a Flatpak editor run in its sandbox without the runtime
+		if editor.flatpak() {
+			t.Args = append([]string{"--host", t.Command}, t.Args...)
+			t.Command = "flatpak-spawn"
+		}
+		return t
+	}
+	return &workspaceTasks{
+		Version: "2.0.0",
+		Tasks: []workspaceTask{
+			task("Start services", "up", "-d"),
+			task("Stop services", "down"),
+			task("Services logs", "logs", "-f"),
+		},
+	}, nil
+}
+
+// planServices returns the docker-compose.yml of the services when its
+// content changes, nil otherwise or without services
+func (s *Setup) planServices(baseDir string, config *Config) (*renderedFile, error) {
+	if len(config.Services) == 0 {
+		return nil, nil
+	}
+	path := composeFilePath(baseDir)
+	existing, err := s.readOptional(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	content := composeContent(config.Services)
+	if bytes.Equal(existing, content) {
+		return nil, nil
+	}
+	return &renderedFile{path: path, content: content}, nil
+}
+
+// writeServices writes the planned docker-compose.yml of the services
+func (s *Setup) writeServices(baseDir string, config *Config) error {
+	file, err := s.planServices(baseDir, config)
+	if err != nil || file == nil {
+		return err
+	}
+	if err := s.FS.WriteFile(file.path, file.content, s.perms.files); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file.path, err)
+	}
+	fmt.Fprintf(s.Out, "  Wrote %s\n", file.path)
+	return nil
+}
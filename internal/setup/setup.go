@@ -0,0 +1,912 @@
+// Package setup creates the Tate AI development environment: it checks
+// the machine, clones the configured repositories and generates the VS Code
+// workspace file. Filesystem, external commands and user lookup are
+// injected so the logic can run against fakes.
+package setup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options are the command line settings of a run, zero values select
+// the config or built-in defaults
+type Options struct {
+	ForceLevel    int             // Number of warnings to ignore, -1 for unlimited
+	SkipChecks    map[string]bool // Checks reported as warnings only, see CheckNames
+	ExpectedUser  string
+	BaseDir       string
+	WorkDir       string
+	Env           string // Named environment providing the directories and a config overlay
+	Container     string // toolbox or distrobox container the environment is set up in
+	ContainerTool string // toolbox or distrobox, detected when empty
+	Config        string // Path or https:// URL of the top config layer
+	ConfigSHA256  string
+	CI            bool
+	NoColor       bool // Plain output even on a terminal, also set by NO_COLOR
+	Quiet         bool // No progress output, only the summary and errors
+	MirrorDir     string
+	CloneAttempts int
+	CloneBackoff  time.Duration
+	Jobs          int           // Repositories cloned in parallel, at least 1
+	MaxRate       string        // Bandwidth limit of HTTP(S) clones, e.g. 2MB
+	Timeout       time.Duration // Overrides all command timeouts when set
+	StrictPerms   bool          // Set the configured permissions on existing directories
+	// Undo the changes of a failed run without asking
+	RollbackOnFailure bool
+	// Log of all output and external commands, the default file in the
+	// state directory when empty, "off" for none
+	LogFile string
+
+	FolderPaths        string
+	OverwriteWorkspace bool
+	NoBackup           bool
+	BackupKeep         int // Negative when unset
+	Open               bool
+	InstallExtensions  bool // Install missing recommended extensions with the editor CLI
+	Editor             string
+
+	PlanOut string // Plan file written by PlanOut
+	Apply   string // Reviewed plan file executed by Run
+
+	FromStep string // Run this and all later steps, see StepNames
+	OnlyStep string // Run only this step
+
+	Enable  []string // Repositories enabled regardless of config 'enabled'
+	Disable []string // Repositories disabled regardless of config 'enabled'
+	With    []string // Optional repositories to set up without asking
+}
+
+// Assets are the files embedded into the binary
+type Assets struct {
+	Config            []byte // Lowest config layer
+	WorkspaceTemplate string
+	ReadmeTemplate    string // stai-temp readme.md
+	Editorconfig      string // .editorconfig template of created repositories
+	// .gitignore templates of local repositories keyed by lang, "common"
+	// is used for all of them
+	Gitignores map[string]string
+	// Extension and settings presets keyed by name, JSON, see Preset
+	Presets map[string]string
+}
+
+// Setup runs setup steps with the given options. The exported fields
+// default to the real operating system and can be replaced before use.
+type Setup struct {
+	FS     FileSystem
+	Runner Runner
+	Users  UserLookup
+	Out    io.Writer
+	Err    io.Writer // Error messages
+	// Report receives the run summary, stdout also with --quiet
+	Report io.Writer
+	In     io.Reader
+	// Transcript receives every external command with its complete
+	// output for diagnosis, commands are not recorded when nil
+	Transcript io.Writer
+	// Interactive enables prompts, by default when stdin is a terminal
+	// outside CI mode
+	Interactive bool
+
+	opts     Options
+	assets   Assets
+	timeouts map[string]time.Duration
+	perms    permissions
+	gitEnv   []string // Added to the environment of git commands
+	// Proxy URL of --max-rate passed to git, empty when not limited
+	throttleProxy string
+	warningCount  int
+	summary       RunSummary
+	mu            sync.Mutex // Guards warningCount and summary of parallel clones
+	auditOnce     sync.Once  // Warns once about an audit trail that cannot be written
+	journal       *journal   // Changes of the run for rollback, guarded by mu
+	imported      []string   // Base directory entries restored by ImportArchive
+	// Launch commands detected by editorCommand keyed by editor name,
+	// guarded by mu
+	editorCommands map[string][]string
+	color          bool      // Out is colored
+	errColor       bool      // Err is colored
+	started        time.Time // Start of the run, {{.Generated}} of templates
+}
+
+// New returns a Setup using the operating system
+func New(opts Options, assets Assets) *Setup {
+	var out io.Writer = os.Stdout
+	if opts.Quiet {
+		out = io.Discard
+	}
+	s := &Setup{
+		FS:          OSFileSystem{},
+		Runner:      ExecRunner{},
+		Users:       OSUsers{},
+		In:          os.Stdin,
+		Interactive: !opts.CI && !opts.Quiet && isTerminal(os.Stdin),
+		opts:        opts,
+		assets:      assets,
+		timeouts:    defaultTimeouts,
+		perms:       defaultPermissions,
+		summary:     newRunSummary(),
+		color:       colorEnabled(os.Stdout, opts),
+		errColor:    colorEnabled(os.Stderr, opts),
+		started:     time.Now(),
+	}
+	// The log is written through FS and Users, as replaced before use
+	log := newLogFile(s, opts.LogFile, func(err error) {
+		fmt.Fprintf(os.Stderr, "Warning: log file disabled: %v\n", err)
+	})
+	s.Out = logWriter(out, log)
+	s.Err = logWriter(os.Stderr, log)
+	s.Report = logWriter(os.Stdout, log)
+	if log != nil {
+		s.Transcript = log
+	}
+	s.FS = auditFS{FileSystem: s.FS, s: s}
+	return s
+}
+
+// isTerminal reports whether f is a character device such as a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Default username the tool expects to run as
+const defaultExpectedUser = "stai"
+
+// PrintDone prints a final success message, without status symbol in CI mode
+func (s *Setup) PrintDone(msg string) {
+	fmt.Fprintln(s.Out, s.marked(s.color, markDone, styleGreen, msg))
+}
+
+// prepareRun loads the config, or takes it from the --apply plan, and
+// resolves the directories. It returns the config, the working and base
+// directories and the plan being applied, if any.
+func (s *Setup) prepareRun() (*Config, string, string, *Plan, error) {
+	// Load repository configuration
+	var config *Config
+	var reviewed *Plan
+	var err error
+	if s.opts.Apply != "" {
+		reviewed, err = s.readPlan(s.opts.Apply)
+		if err != nil {
+			return nil, "", "", nil, err
+		}
+		config = reviewed.Config
+		// The planned files carry the generation time of the plan
+		if !reviewed.Generated.IsZero() {
+			s.started = reviewed.Generated
+		}
+	} else {
+		config, err = s.loadEffectiveConfig()
+		if err != nil {
+			return nil, "", "", nil, err
+		}
+	}
+
+	// Validate current directory
+	workDir, err := s.validateWorkingDirectory(s.opts.WorkDir)
+	if err != nil {
+		return nil, "", "", nil, err
+	}
+
+	// Determine base directory, a plan is applied where it was created
+	var baseDir string
+	if reviewed != nil {
+		baseDir = reviewed.BaseDir
+	} else {
+		baseDir, err = s.resolveBaseDirectory(s.opts.BaseDir, workDir)
+		if err != nil {
+			return nil, "", "", nil, err
+		}
+	}
+
+	return config, workDir, baseDir, reviewed, nil
+}
+
+// runChecks runs the user, environment and preflight checks
+func (s *Setup) runChecks(config *Config, workDir, baseDir string) error {
+	s.printSection("Checking user and environment...")
+
+	// Check current user
+	if err := s.checkUser(s.expectedUser(config)); err != nil {
+		return err
+	}
+
+	// Check required binaries
+	if err := s.checkBinaries(config); err != nil {
+		return err
+	}
+
+	// Validate base directory
+	if err := s.validateBaseDirectory(baseDir, workDir); err != nil {
+		return err
+	}
+
+	// Preflight checks before anything is created
+	return s.runPreflight(config, baseDir)
+}
+
+// PlanOut writes the plan of a run to the PlanOut file without changing
+// anything
+func (s *Setup) PlanOut() error {
+	if s.opts.Apply != "" {
+		return ValidationErrorf("--plan-out and --apply cannot be combined")
+	}
+
+	config, workDir, baseDir, _, err := s.prepareRun()
+	if err != nil {
+		return err
+	}
+
+	if err := s.runChecks(config, workDir, baseDir); err != nil {
+		return err
+	}
+
+	plan, err := s.buildPlan(baseDir, config)
+	if err != nil {
+		return err
+	}
+
+	return s.writePlan(s.opts.PlanOut, plan)
+}
+
+// Run checks the machine and creates or updates the environment. Steps
+// completed by an interrupted run are skipped, see FromStep and OnlyStep.
+func (s *Setup) Run() error {
+	config, workDir, baseDir, reviewed, err := s.prepareRun()
+	if err != nil {
+		return err
+	}
+
+	// Keep concurrent runs from racing on the same directories and state,
+	// the checks then see what this run will change
+	release, err := s.acquireLock(baseDir)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	state, err := s.startState(baseDir, config)
+	if err != nil {
+		return err
+	}
+
+	if !state.done(StepChecks) {
+		if err := s.runChecks(config, workDir, baseDir); err != nil {
+			return err
+		}
+	}
+	s.trackChanges()
+
+	if reviewed != nil && !state.done(StepChecks) {
+		if err := s.checkPlanCurrent(reviewed, baseDir); err != nil {
+			return err
+		}
+	}
+
+	steps := []struct {
+		name string
+		msg  string
+		run  func() error
+	}{
+		{StepChecks, "", func() error { return nil }}, // Ran above
+		{StepDirectories, "Creating directories...", func() error { return s.createDirectories(baseDir, config) }},
+		{StepStaiTemp, "", func() error { return s.initStaiTempRepo(baseDir, config) }},
+		{StepClone, "Cloning repositories...", func() error { return s.cloneRepositories(baseDir, config) }},
+		{StepWorkspace, "Generating workspace file...", func() error { return s.generateWorkspace(baseDir, config) }},
+	}
+	for _, step := range steps {
+		if state.done(step.name) {
+			continue
+		}
+		if step.msg != "" {
+			s.printSection(step.msg)
+		}
+		if err := step.run(); err != nil {
+			s.rollback(baseDir, err)
+			return err
+		}
+		if err := s.completeStep(baseDir, state, step.name); err != nil {
+			return err
+		}
+	}
+	s.stopTracking()
+
+	if len(config.Services) > 0 && selinuxEnforcing() {
+		s.printSection("Checking SELinux labels of service volumes...")
+		s.checkSELinux(baseDir, config)
+	}
+
+	if s.opts.InstallExtensions {
+		s.printSection("Installing recommended extensions...")
+		if err := s.checkExtensions(baseDir, config); err != nil {
+			return err
+		}
+	}
+
+	if s.opts.Open {
+		editor, err := s.resolveEditor(config)
+		if err != nil {
+			return err
+		}
+		s.printSection("Opening workspace...")
+		s.openWorkspace(editor, workspaceFilePath(baseDir))
+	}
+
+	return nil
+}
+
+// expectedUser returns the username to check against, preferring the
+// --expected-user flag over the config 'user' field
+func (s *Setup) expectedUser(config *Config) string {
+	if s.opts.ExpectedUser != "" {
+		return s.opts.ExpectedUser
+	}
+	if config.User != "" {
+		return config.User
+	}
+	return defaultExpectedUser
+}
+
+func (s *Setup) checkUser(expected string) error {
+	currentUser, err := s.Users.Current()
+	if err != nil {
+		return fmt.Errorf("failed to get current user: %w", err)
+	}
+
+	if currentUser.Username != expected {
+		if skippedBy, ok := s.CanSkipWarning(CheckUser); ok {
+			s.Warnf("Current user is '%s', expected '%s' (continuing due to %s)", currentUser.Username, expected, skippedBy)
+		} else {
+			return ValidationErrorf("current user is '%s', expected '%s'. Use --expected-user, --skip-check=%s or --force to ignore this check", currentUser.Username, expected, CheckUser)
+		}
+	}
+
+	return nil
+}
+
+func (s *Setup) checkBinaries(config *Config) error {
+	binaries, err := s.requiredBinaries(config)
+	if err != nil {
+		return err
+	}
+
+	for _, binary := range binaries {
+		problem := ""
+		if _, err := s.Runner.LookPath(binary.Name); err != nil {
+			problem = fmt.Sprintf("'%s' not found in PATH", binary.Name)
+		} else if problem, err = s.checkBinaryVersion(binary); err != nil {
+			return err
+		}
+		if problem == "" {
+			continue
+		}
+		if binary.Hint != "" {
+			problem += " (" + binary.Hint + ")"
+		}
+		if skippedBy, ok := s.CanSkipWarning(CheckBinaries); ok {
+			s.Warnf("Binary %s (continuing due to %s)", problem, skippedBy)
+		} else {
+			return ValidationErrorf("required binary %s. %s", problem, skipHint(CheckBinaries))
+		}
+	}
+
+	return s.checkContainerRuntime(config)
+}
+
+// validateWorkingDirectory returns the absolute stai-vscode checkout path.
+// An explicitly provided path only has to be an existing directory, a
+// selected named environment provides its checkout, otherwise the current
+// directory must be named 'stai-vscode'.
+func (s *Setup) validateWorkingDirectory(dir string) (string, error) {
+	if dir == "" {
+		env, err := s.environment()
+		if err != nil {
+			return "", err
+		}
+		if env != nil {
+			dir = s.environmentWorkDir(env)
+		}
+	}
+	if dir != "" {
+		absDir, err := s.existingDirectory(dir)
+		if err != nil {
+			return "", ValidationErrorf("invalid working directory: %w", err)
+		}
+		return absDir, nil
+	}
+
+	workDir, err := os.Getwd()
+	if err != nil {
+		return "", ValidationErrorf("failed to get current directory: %w", err)
+	}
+
+	if filepath.Base(workDir) != "stai-vscode" {
+		return "", ValidationErrorf("current directory must be named 'stai-vscode', got '%s'. Use --work-dir to point to the checkout", filepath.Base(workDir))
+	}
+
+	return workDir, nil
+}
+
+// resolveBaseDirectory returns the absolute base directory, defaulting
+// to the one of the selected named environment or the parent of the
+// working directory
+func (s *Setup) resolveBaseDirectory(dir, workDir string) (string, error) {
+	if dir == "" {
+		env, err := s.environment()
+		if err != nil {
+			return "", err
+		}
+		if env == nil {
+			return filepath.Dir(workDir), nil
+		}
+		dir = env.BaseDir
+	}
+
+	absDir, err := s.existingDirectory(dir)
+	if err != nil {
+		return "", ValidationErrorf("invalid base directory: %w", err)
+	}
+	return absDir, nil
+}
+
+// existingDirectory returns the absolute form of dir after checking
+// that it exists and is a directory
+func (s *Setup) existingDirectory(dir string) (string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path for %s: %w", dir, err)
+	}
+
+	info, err := s.FS.Stat(absDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to access %s: %w", absDir, err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", absDir)
+	}
+
+	return absDir, nil
+}
+
+func (s *Setup) validateBaseDirectory(baseDir, workDir string) error {
+	// Check that base directory is not $HOME
+	homeDir, err := s.Users.HomeDir()
+	if err != nil {
+		return ValidationErrorf("failed to get home directory: %w", err)
+	}
+
+	if baseDir == homeDir {
+		return ValidationErrorf("base directory cannot be the home directory (%s)", homeDir)
+	}
+
+	// Check that base directory is under home directory
+	absBaseDir, err := filepath.Abs(baseDir)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path for base directory: %w", err)
+	}
+
+	absHomeDir, err := filepath.Abs(homeDir)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path for home directory: %w", err)
+	}
+
+	relPath, err := filepath.Rel(absHomeDir, absBaseDir)
+	if err != nil || strings.HasPrefix(relPath, "..") {
+		return ValidationErrorf("base directory must be under home directory (%s), got %s", homeDir, baseDir)
+	}
+
+	// Check that base directory is empty except for the working directory
+	workDirName := ""
+	if filepath.Dir(workDir) == absBaseDir {
+		workDirName = filepath.Base(workDir)
+	}
+
+	entries, err := s.FS.ReadDir(baseDir)
+	if err != nil {
+		return ValidationErrorf("failed to read base directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.Name() != workDirName && entry.Name() != legacyLockFileName && entry.Name() != legacyStateFileName && !slices.Contains(s.imported, entry.Name()) {
+			if skippedBy, ok := s.CanSkipWarning(CheckBaseDirEmpty); ok {
+				s.Warnf("Base directory contains additional files/directories (continuing due to %s)", skippedBy)
+				break
+			} else {
+				if workDirName == "" {
+					return ValidationErrorf("base directory must be empty. Found: %s. %s", entry.Name(), skipHint(CheckBaseDirEmpty))
+				}
+				return ValidationErrorf("base directory must be empty except for '%s' directory. Found: %s. %s", workDirName, entry.Name(), skipHint(CheckBaseDirEmpty))
+			}
+		}
+	}
+
+	return nil
+}
+
+// environmentDirectories returns the directories every environment has
+// and the parent directories of nested repository paths
+func environmentDirectories(baseDir string, config *Config) []string {
+	dirs := []string{
+		filepath.Join(baseDir, "vscode"),
+		filepath.Join(baseDir, "stai-temp"),
+		filepath.Join(baseDir, "stai-temp", "aitsk"),
+	}
+
+	for _, repo := range config.Repos {
+		var parents []string
+		for dir := filepath.Dir(repoPath(baseDir, repo)); isInside(dir, baseDir); dir = filepath.Dir(dir) {
+			parents = append([]string{dir}, parents...)
+		}
+		for _, dir := range parents {
+			if !slices.Contains(dirs, dir) {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+
+	return dirs
+}
+
+func (s *Setup) createDirectories(baseDir string, config *Config) error {
+	dirs := environmentDirectories(baseDir, config)
+	for _, dir := range dirs {
+		if err := s.FS.MkdirAll(dir, s.perms.dirs); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", dir, err)
+		}
+	}
+
+	if s.opts.StrictPerms {
+		var failed error
+		s.fixDirPermissions(permissionDirs(baseDir, config), func(format string, args ...any) {
+			if failed == nil {
+				failed = fmt.Errorf(format, args...)
+			}
+		})
+		return failed
+	}
+
+	return nil
+}
+
+func (s *Setup) initStaiTempRepo(baseDir string, config *Config) error {
+	staiTempDir := filepath.Join(baseDir, "stai-temp")
+
+	// Check if already a git repository, one left without the initial
+	// commit by a failed run is initialized again
+	if _, err := s.FS.Stat(filepath.Join(staiTempDir, ".git")); err == nil && s.runGit(opInit, staiTempDir, "rev-parse", "--verify", "--quiet", "HEAD") == nil {
+		fmt.Fprintf(s.Out, "stai-temp is already a git repository, skipping initialization\n")
+		s.summary.Skipped = append(s.summary.Skipped, "stai-temp")
+		return nil
+	}
+
+	// Initialize git repository
+	if err := s.initRepo(staiTempDir, staiTempRepo(config)); err != nil {
+		return gitErrorf("failed to initialize git repository in stai-temp: %w", err)
+	}
+
+	if err := s.configureIdentity(staiTempDir, config); err != nil {
+		return err
+	}
+	if err := s.checkIdentity(staiTempDir); err != nil {
+		return err
+	}
+	if err := s.checkSigning(staiTempDir, config); err != nil {
+		return err
+	}
+
+	// Create readme.md and seed files from templates
+	files, err := s.seedFiles(baseDir, config)
+	if err != nil {
+		return err
+	}
+	addArgs := []string{"add", "--"}
+	for _, file := range files {
+		if err := s.FS.MkdirAll(filepath.Dir(file.path), s.perms.dirs); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", file.path, err)
+		}
+		if err := s.FS.WriteFile(file.path, file.content, s.perms.files); err != nil {
+			return fmt.Errorf("failed to create %s: %w", file.path, err)
+		}
+		rel, _ := filepath.Rel(staiTempDir, file.path)
+		addArgs = append(addArgs, rel)
+	}
+
+	// Add and commit
+	if err := s.runGit(opCommit, staiTempDir, addArgs...); err != nil {
+		return gitErrorf("failed to add initial files to git: %w", err)
+	}
+
+	if err := s.commit(staiTempDir, config, "Initial commit - stai-temp workspace"); err != nil {
+		return err
+	}
+
+	s.summary.Initialized = append(s.summary.Initialized, "stai-temp")
+	return nil
+}
+
+// cloneRepositories sets up all repositories, up to --jobs of them in
+// parallel. All of them are waited for, the first error in config order
+// is returned.
+func (s *Setup) cloneRepositories(baseDir string, config *Config) error {
+	if s.opts.Jobs < 1 {
+		return ValidationErrorf("invalid --jobs %d, must be at least 1", s.opts.Jobs)
+	}
+	config, stop, err := s.startThrottle(config)
+	defer stop()
+	if err != nil {
+		return err
+	}
+
+	jobs := s.opts.Jobs
+	errs := make([]error, len(config.Repos))
+	sem := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	for i, repo := range config.Repos {
+		if jobs == 1 {
+			if errs[i] = s.timeRepo(repo.Name, func() error { return s.setupRepo(baseDir, config, repo) }); errs[i] != nil {
+				return errs[i]
+			}
+			continue
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = s.timeRepo(repo.Name, func() error { return s.setupRepo(baseDir, config, repo) })
+			<-sem
+		}()
+	}
+	wg.Wait()
+	s.sortSummary(config)
+
+	return firstError(errs)
+}
+
+// firstError returns the first non-nil error
+func firstError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setupRepo clones, initializes or creates a repository, an existing
+// checkout gets its submodules, LFS files, worktrees and remotes updated
+func (s *Setup) setupRepo(baseDir string, config *Config, repo Repository) error {
+	repoDir := repoPath(baseDir, repo)
+
+	// Skip if directory already exists
+	if _, err := s.FS.Stat(repoDir); err == nil {
+		fmt.Fprintf(s.Out, "Repository %s already exists, skipping\n", repo.Name)
+		s.recordRepo(&s.summary.Skipped, repo.Name)
+		if repo.Type == "git-repo" && repo.Submodules {
+			if err := s.updateSubmodules(repoDir, config.Proxy, repo); err != nil {
+				return err
+			}
+		}
+		if repo.Type == "git-repo" && repo.LFS {
+			if err := s.pullLFS(repoDir, config.Proxy, repo); err != nil {
+				return err
+			}
+		}
+		if len(repo.Worktrees) > 0 {
+			if err := s.addWorktrees(repoDir, config.Proxy, repo, true); err != nil {
+				return err
+			}
+		}
+		if err := s.configureUpstream(repoDir, config.Proxy, repo); err != nil {
+			return err
+		}
+		if err := s.configureRemotes(repoDir, repo); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	switch repo.Type {
+	case "git-repo":
+		if repo.GitRepo == nil {
+			return configErrorf("git-repo type requires git-repo URL for %s", repo.Name)
+		}
+
+		if len(repo.Worktrees) > 0 {
+			if err := s.cloneWorktreeRepo(repoDir, config, repo); err != nil {
+				return err
+			}
+			if err := s.configureUpstream(repoDir, config.Proxy, repo); err != nil {
+				return err
+			}
+			if err := s.configureRemotes(repoDir, repo); err != nil {
+				return err
+			}
+			for _, dir := range worktreeDirs(repoDir, repo) {
+				if err := s.runPostCloneHooks(baseDir, dir, repo); err != nil {
+					return err
+				}
+			}
+			s.recordRepo(&s.summary.Cloned, repo.Name)
+			return nil
+		}
+
+		if err := s.cloneGitRepo(repoDir, config, repo); err != nil {
+			return err
+		}
+
+		if err := s.configureUpstream(repoDir, config.Proxy, repo); err != nil {
+			return err
+		}
+		if err := s.configureRemotes(repoDir, repo); err != nil {
+			return err
+		}
+
+		if repo.LFS || s.usesLFS(repoDir) {
+			if err := s.pullLFS(repoDir, config.Proxy, repo); err != nil {
+				return err
+			}
+		}
+
+		if err := s.runPostCloneHooks(baseDir, repoDir, repo); err != nil {
+			return err
+		}
+
+		s.recordRepo(&s.summary.Cloned, repo.Name)
+
+	case "local-git-repo":
+		// For local-git-repo, we already handled stai-temp above
+		if repo.Name == "stai-temp" {
+			return nil
+		}
+
+		if err := s.FS.MkdirAll(repoDir, s.perms.dirs); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", repo.Name, err)
+		}
+
+		if err := s.initRepo(repoDir, repo); err != nil {
+			return gitErrorf("failed to initialize git repository for %s: %w", repo.Name, err)
+		}
+
+		if err := s.configureIdentity(repoDir, config); err != nil {
+			return err
+		}
+
+		if err := s.writeGitignore(repoDir, repo); err != nil {
+			return err
+		}
+		if err := s.writeEditorconfig(baseDir, repoDir, config, repo); err != nil {
+			return err
+		}
+
+		s.recordRepo(&s.summary.Initialized, repo.Name)
+
+	case repoTypeDir:
+		fmt.Fprintf(s.Out, "Creating directory %s for %s\n", repoDir, repo.Name)
+		if err := s.FS.MkdirAll(repoDir, s.perms.dirs); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", repo.Name, err)
+		}
+		s.recordRepo(&s.summary.Created, repo.Name)
+
+	default:
+		return configErrorf("unknown repository type %s for %s", repo.Type, repo.Name)
+	}
+
+	return nil
+}
+
+// cloneGitRepo clones a git-repo type repository into repoDir, from
+// the local mirror when one exists in --mirror-dir
+func (s *Setup) cloneGitRepo(repoDir string, config *Config, repo Repository) error {
+	proxy := config.Proxy
+	policy, err := s.cloneRetryPolicy(config)
+	if err != nil {
+		return err
+	}
+
+	source := *repo.GitRepo
+	fromMirror := s.hasMirror(s.opts.MirrorDir, repo)
+	if fromMirror {
+		source = mirrorPath(s.opts.MirrorDir, repo)
+		fmt.Fprintf(s.Out, "Cloning %s from mirror %s\n", repo.Name, source)
+	}
+
+	args := append(proxyArgs(proxy, *repo.GitRepo), "clone")
+	if repo.Submodules {
+		args = append(args, "--recurse-submodules")
+	}
+	if len(repo.SparsePaths) > 0 {
+		args = append(args, "--sparse")
+		if !fromMirror {
+			// Skip blobs outside the sparse paths, only root files are checked out
+			args = append(args, "--filter=blob:none")
+		}
+	}
+	args = append(args, source, repoDir)
+
+	s.trackCreated(repoDir)
+	err = s.withRetry(policy, "clone of "+repo.Name, func() error {
+		// Remove leftovers of a failed attempt, repoDir did not exist before
+		if err := s.FS.RemoveAll(repoDir); err != nil {
+			return err
+		}
+		return s.runGit(opClone, "", args...)
+	})
+	s.audit(auditClone, repoDir, source, err)
+	if err != nil {
+		return gitErrorf("failed to clone repository %s: %w%s", repo.Name, err, sshHint(source))
+	}
+
+	if fromMirror {
+		// Point origin back to upstream so fetch and push work as usual
+		if err := s.runGit(opInit, repoDir, "remote", "set-url", "origin", *repo.GitRepo); err != nil {
+			return gitErrorf("failed to set origin URL for %s: %w", repo.Name, err)
+		}
+	}
+
+	if len(repo.SparsePaths) > 0 {
+		args := append(proxyArgs(proxy, *repo.GitRepo), "sparse-checkout", "set", "--cone")
+		args = append(args, repo.SparsePaths...)
+		if err := s.runGit(opInit, repoDir, args...); err != nil {
+			return gitErrorf("failed to set sparse checkout paths for %s: %w", repo.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// updateSubmodules initializes and updates submodules of an existing
+// checkout, covering repos cloned before 'submodules' was enabled
+func (s *Setup) updateSubmodules(repoDir, proxy string, repo Repository) error {
+	fmt.Fprintf(s.Out, "Updating submodules of %s\n", repo.Name)
+
+	args := append(proxyArgs(proxy, *repo.GitRepo), "submodule", "update", "--init", "--recursive")
+	if err := s.runGit(opClone, repoDir, args...); err != nil {
+		return gitErrorf("failed to update submodules of %s: %w", repo.Name, err)
+	}
+
+	return nil
+}
+
+// usesLFS reports whether the checkout's .gitattributes routes any
+// paths through the Git LFS filter
+func (s *Setup) usesLFS(repoDir string) bool {
+	data, err := s.FS.ReadFile(filepath.Join(repoDir, ".gitattributes"))
+	if err != nil {
+		return false
+	}
+	return strings.Contains(string(data), "filter=lfs")
+}
+
+// pullLFS downloads LFS objects replacing pointer files in the checkout.
+// A missing git-lfs fails repos declaring 'lfs', repos only detected by
+// their .gitattributes get a warning.
+func (s *Setup) pullLFS(repoDir, proxy string, repo Repository) error {
+	if _, err := s.Runner.LookPath("git-lfs"); err != nil {
+		if repo.LFS {
+			return ValidationErrorf("repository %s sets \"lfs\": true but git-lfs is not installed. Install git-lfs", repo.Name)
+		}
+		s.Warnf("Repository %s uses Git LFS in .gitattributes but git-lfs is not installed, files are LFS pointers. Install git-lfs and set \"lfs\": true", repo.Name)
+		return nil
+	}
+
+	fmt.Fprintf(s.Out, "Pulling Git LFS objects for %s\n", repo.Name)
+
+	if err := s.runGit(opInit, repoDir, "lfs", "install", "--local"); err != nil {
+		return gitErrorf("failed to install Git LFS hooks for %s: %w", repo.Name, err)
+	}
+
+	args := append(proxyArgs(proxy, *repo.GitRepo), "lfs", "pull")
+	if err := s.runGit(opClone, repoDir, args...); err != nil {
+		return gitErrorf("failed to pull Git LFS objects for %s: %w", repo.Name, err)
+	}
+
+	return nil
+}
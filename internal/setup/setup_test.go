@@ -0,0 +1,509 @@
+package setup
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const (
+	runBaseDir = "/home/stai/work-stai"
+	runWorkDir = runBaseDir + "/stai-vscode"
+)
+
+// runConfig is a config with the git-repo repositories names cloned from
+// example.com
+func runConfig(names ...string) string {
+	var repos []string
+	for _, name := range names {
+		repos = append(repos, fmt.Sprintf(`{"name": %q, "type": "git-repo", "git-repo": "https://example.com/%s.git"}`, name, name))
+	}
+	return `{"repos": [` + strings.Join(repos, ", ") + `]}`
+}
+
+// newRunSetup returns a test Setup for Run with a checkout in runWorkDir
+// and config as the embedded config
+func newRunSetup(t *testing.T, opts Options, config string) *testSetup {
+	t.Helper()
+	opts.WorkDir, opts.BaseDir = runWorkDir, runBaseDir
+	skip := map[string]bool{CheckPreflight: true}
+	maps.Copy(skip, opts.SkipChecks)
+	opts.SkipChecks = skip
+	opts.BackupKeep = -1
+	if opts.Jobs == 0 {
+		opts.Jobs = 1
+	}
+	ts := newTestSetup(t, opts, Assets{
+		Config:            []byte(config),
+		WorkspaceTemplate: "{\n\t\"folders\": []\n}",
+		ReadmeTemplate:    "# stai-temp\n",
+	})
+	ts.runner.binaries = append(ts.runner.binaries, "code-insiders")
+	ts.runner.rules = []runRule{{prefix: "git --version", stdout: "git version 2.45.0\n"}}
+	if err := ts.fs.MkdirAll(runWorkDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return ts
+}
+
+// envFile returns the path of a file in the environment state directory
+func (ts *testSetup) envFile(t *testing.T, name string) string {
+	t.Helper()
+	path, err := ts.envStatePath(runBaseDir, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// exists reports whether path exists in the fake filesystem
+func (ts *testSetup) exists(path string) bool {
+	_, err := ts.fs.Lstat(path)
+	return err == nil
+}
+
+// clones returns the repositories cloned by git clone commands
+func (ts *testSetup) clones() []string {
+	var repos []string
+	for _, command := range ts.runner.commands {
+		if strings.HasPrefix(command, "git clone ") {
+			repos = append(repos, command[strings.LastIndex(command, "/")+1:])
+		}
+	}
+	return repos
+}
+
+func TestRun(t *testing.T) {
+	host, _ := os.Hostname()
+	tests := []struct {
+		name    string
+		opts    Options
+		config  string
+		prepare func(t *testing.T, ts *testSetup)
+		rules   []runRule // Answered before the default rules
+		code    int
+		wantErr string // Part of the error, empty when the run succeeds
+		check   func(t *testing.T, ts *testSetup)
+	}{
+		{
+			name:   "creates the environment",
+			config: runConfig("app", "lib"),
+			check: func(t *testing.T, ts *testSetup) {
+				if got := ts.clones(); !slices.Equal(got, []string{"app", "lib"}) {
+					t.Errorf("cloned %v, want app and lib", got)
+				}
+				workspace := ts.readFile(t, workspaceFilePath(runBaseDir))
+				for _, want := range []string{`"path": "../app"`, `"path": "../lib"`} {
+					if !strings.Contains(workspace, want) {
+						t.Errorf("workspace lacks %s:\n%s", want, workspace)
+					}
+				}
+				if ts.summary.Workspace != workspaceCreated {
+					t.Errorf("workspace %s, want %s", ts.summary.Workspace, workspaceCreated)
+				}
+				if ts.exists(ts.envFile(t, lockFileName)) {
+					t.Error("lock file left after the run")
+				}
+			},
+		},
+		{
+			name:   "clones in parallel with --jobs",
+			opts:   Options{Jobs: 3},
+			config: runConfig("app", "lib", "docs", "tools"),
+			check: func(t *testing.T, ts *testSetup) {
+				got := ts.clones()
+				slices.Sort(got)
+				if !slices.Equal(got, []string{"app", "docs", "lib", "tools"}) {
+					t.Errorf("cloned %v, want all four", got)
+				}
+				// The summary keeps the config order of parallel clones
+				if !slices.Equal(ts.summary.Cloned, []string{"app", "lib", "docs", "tools"}) {
+					t.Errorf("summary cloned %v, want config order", ts.summary.Cloned)
+				}
+			},
+		},
+		{
+			name:    "invalid --jobs",
+			opts:    Options{Jobs: -1},
+			config:  runConfig("app"),
+			code:    exitValidation,
+			wantErr: "invalid --jobs -1",
+		},
+		{
+			name:    "unexpected user",
+			opts:    Options{ExpectedUser: "tate"},
+			config:  runConfig("app"),
+			code:    exitValidation,
+			wantErr: "current user is 'stai', expected 'tate'",
+			check: func(t *testing.T, ts *testSetup) {
+				if got := ts.clones(); len(got) != 0 {
+					t.Errorf("cloned %v after a failed check", got)
+				}
+			},
+		},
+		{
+			name:   "unexpected user with the check skipped",
+			opts:   Options{ExpectedUser: "tate", SkipChecks: map[string]bool{CheckUser: true}},
+			config: runConfig("app"),
+		},
+		{
+			name:    "missing binary",
+			config:  runConfig("app"),
+			prepare: func(t *testing.T, ts *testSetup) { ts.runner.binaries = []string{"git"} },
+			code:    exitValidation,
+			wantErr: "required binary 'code-insiders' not found",
+		},
+		{
+			name:   "base directory not empty",
+			config: runConfig("app"),
+			prepare: func(t *testing.T, ts *testSetup) {
+				ts.writeFile(t, runBaseDir+"/notes.txt", "mine")
+			},
+			code:    exitValidation,
+			wantErr: "Found: notes.txt",
+		},
+		{
+			name:    "invalid config",
+			config:  `{"repos": [{"name": "app", "type": "svn"}]}`,
+			code:    exitConfig,
+			wantErr: "svn",
+		},
+		{
+			name:    "clone failure rolls back",
+			opts:    Options{RollbackOnFailure: true},
+			config:  runConfig("app", "lib"),
+			rules:   []runRule{{prefix: "git clone https://example.com/lib.git", stderr: "fatal: repository not found", err: errors.New("exit status 128")}},
+			code:    exitGit,
+			wantErr: "failed to clone repository lib",
+			check: func(t *testing.T, ts *testSetup) {
+				entries, err := ts.fs.ReadDir(runBaseDir)
+				if err != nil || len(entries) != 1 || entries[0].Name() != "stai-vscode" {
+					t.Errorf("base directory has %v (%v) after the rollback, want only stai-vscode", entries, err)
+				}
+				if ts.exists(ts.envFile(t, stateFileName)) {
+					t.Error("run state left after the rollback")
+				}
+			},
+		},
+		{
+			name:    "clone failure without rollback",
+			opts:    Options{CI: true},
+			config:  runConfig("app", "lib"),
+			rules:   []runRule{{prefix: "git clone https://example.com/lib.git", err: errors.New("exit status 128")}},
+			code:    exitGit,
+			wantErr: "failed to clone repository lib",
+			check: func(t *testing.T, ts *testSetup) {
+				if !ts.exists(runBaseDir+"/stai-temp") || ts.exists(workspaceFilePath(runBaseDir)) {
+					t.Error("want stai-temp kept and no workspace file")
+				}
+			},
+		},
+		{
+			name:   "locked by a run on another host",
+			config: runConfig("app"),
+			prepare: func(t *testing.T, ts *testSetup) {
+				ts.writeFile(t, ts.envFile(t, lockFileName), "4242 other-host\n")
+			},
+			code:    exitValidation,
+			wantErr: "another ws-config-gen run (PID 4242 on other-host)",
+			check: func(t *testing.T, ts *testSetup) {
+				// The lock is checked before the state is read and the checks run
+				if len(ts.runner.commands) != 0 {
+					t.Errorf("ran %q while locked", ts.runner.commands)
+				}
+				if ts.exists(ts.envFile(t, stateFileName)) {
+					t.Error("run state written while locked")
+				}
+				if got := ts.readFile(t, ts.envFile(t, lockFileName)); got != "4242 other-host\n" {
+					t.Errorf("lock file = %q, want the other run's", got)
+				}
+			},
+		},
+		{
+			name:   "stale lock",
+			config: runConfig("app"),
+			prepare: func(t *testing.T, ts *testSetup) {
+				// PIDs are below 2^22 on Linux
+				ts.writeFile(t, ts.envFile(t, lockFileName), fmt.Sprintf("%d %s\n", 1<<30, host))
+			},
+			check: func(t *testing.T, ts *testSetup) {
+				if ts.exists(ts.envFile(t, lockFileName)) {
+					t.Error("lock file left after the run")
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newRunSetup(t, tt.opts, tt.config)
+			ts.runner.rules = append(tt.rules, ts.runner.rules...)
+			if tt.prepare != nil {
+				tt.prepare(t, ts)
+			}
+
+			err := ts.Run()
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("Run error = %v, want %q", err, tt.wantErr)
+			}
+			if code := ExitCode(err); code != tt.code {
+				t.Errorf("exit code %d, want %d", code, tt.code)
+			}
+			if tt.check != nil {
+				tt.check(t, ts)
+			}
+		})
+	}
+}
+
+// TestRunRollbackKeepsExisting checks a failed step does not remove what existed
+// before the run
+func TestRunRollbackKeepsExisting(t *testing.T) {
+	ts := newRunSetup(t, Options{RollbackOnFailure: true, SkipChecks: map[string]bool{CheckBaseDirEmpty: true}}, runConfig("app"))
+	ts.writeFile(t, runBaseDir+"/vscode/notes.txt", "mine")
+	ts.runner.rules = append([]runRule{{prefix: "git clone", err: errors.New("exit status 128")}}, ts.runner.rules...)
+
+	if err := ts.Run(); ExitCode(err) != exitGit {
+		t.Fatalf("Run = %v, want a git error", err)
+	}
+	if got := ts.readFile(t, runBaseDir+"/vscode/notes.txt"); got != "mine" {
+		t.Errorf("notes.txt = %q after the rollback", got)
+	}
+	if _, err := ts.fs.Stat(runBaseDir + "/stai-temp"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("stai-temp left after the rollback: %v", err)
+	}
+}
+
+func TestCheckUser(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		config   Config
+		wantErr  bool
+		warnings int
+	}{
+		{name: "default user"},
+		{name: "config user", config: Config{User: "tate"}, wantErr: true},
+		{name: "expected user over config", opts: Options{ExpectedUser: "stai"}, config: Config{User: "tate"}},
+		{name: "skipped check", opts: Options{ExpectedUser: "tate", SkipChecks: map[string]bool{CheckUser: true}}, warnings: 1},
+		{name: "force", opts: Options{ExpectedUser: "tate", ForceLevel: 1}, warnings: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestSetup(t, tt.opts, Assets{})
+			err := ts.checkUser(ts.expectedUser(&tt.config))
+			if !tt.wantErr && err != nil {
+				t.Fatalf("checkUser: %v", err)
+			}
+			if tt.wantErr {
+				if ExitCode(err) != exitValidation {
+					t.Fatalf("checkUser = %v, want a validation error", err)
+				}
+				// Every way around the check is named
+				for _, hint := range []string{"--expected-user", "--skip-check=user", "--force"} {
+					if !strings.Contains(err.Error(), hint) {
+						t.Errorf("error %q lacks %s", err, hint)
+					}
+				}
+			}
+			if got := len(ts.summary.Warnings); got != tt.warnings {
+				t.Errorf("%d warnings, want %d", got, tt.warnings)
+			}
+		})
+	}
+}
+
+func TestCanSkipWarning(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  Options
+		check []string // Failed checks in order
+		want  []string // Flag skipping each check, empty when it fails
+	}{
+		{"no flags", Options{}, []string{CheckUser, CheckBinaries}, []string{"", ""}},
+		{"named check", Options{SkipChecks: map[string]bool{CheckBinaries: true}}, []string{CheckUser, CheckBinaries}, []string{"", "--skip-check=binaries"}},
+		{"force budget", Options{ForceLevel: 1}, []string{CheckUser, CheckBinaries}, []string{"--force", ""}},
+		{"named checks leave the budget", Options{ForceLevel: 1, SkipChecks: map[string]bool{CheckUser: true}}, []string{CheckUser, CheckBinaries, CheckSSH}, []string{"--skip-check=user", "--force", ""}},
+		{"unlimited force", Options{ForceLevel: -1}, []string{CheckUser, CheckBinaries, CheckSSH}, []string{"--force", "--force", "--force"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestSetup(t, tt.opts, Assets{})
+			for i, check := range tt.check {
+				skippedBy, ok := ts.CanSkipWarning(check)
+				if skippedBy != tt.want[i] || ok != (tt.want[i] != "") {
+					t.Errorf("CanSkipWarning(%s) = %q, %t, want %q", check, skippedBy, ok, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// ran reports whether a command starting with prefix was run
+func (ts *testSetup) ran(prefix string) bool {
+	return slices.ContainsFunc(ts.runner.commands, func(command string) bool {
+		return strings.HasPrefix(command, prefix)
+	})
+}
+
+func TestRunResume(t *testing.T) {
+	ts := newRunSetup(t, Options{CI: true}, runConfig("app", "lib"))
+	defaults := ts.runner.rules
+	ts.runner.rules = append([]runRule{{prefix: "git clone https://example.com/lib.git", err: errors.New("exit status 128")}}, defaults...)
+	if err := ts.Run(); ExitCode(err) != exitGit {
+		t.Fatalf("first Run = %v, want a git error", err)
+	}
+	if got := ts.readFile(t, ts.envFile(t, stateFileName)); !strings.Contains(got, `"stai-temp"`) || strings.Contains(got, `"clone"`) {
+		t.Fatalf("run state after the failed clone:\n%s", got)
+	}
+
+	// The re-run starts at the failed clone step
+	ts.runner.rules, ts.runner.commands = defaults, nil
+	if err := ts.Run(); err != nil {
+		t.Fatalf("resumed Run: %v", err)
+	}
+	if ts.ran("git --version") || ts.ran("git init") {
+		t.Errorf("resumed run repeated completed steps: %q", ts.runner.commands)
+	}
+	if got := ts.clones(); !slices.Contains(got, "lib") {
+		t.Errorf("resumed run cloned %v, want lib", got)
+	}
+	if !ts.exists(workspaceFilePath(runBaseDir)) {
+		t.Error("resumed run wrote no workspace file")
+	}
+	if ts.exists(ts.envFile(t, stateFileName)) {
+		t.Error("run state left after all steps completed")
+	}
+}
+
+func TestRunResumeChangedConfig(t *testing.T) {
+	ts := newRunSetup(t, Options{}, runConfig("app"))
+	ts.writeFile(t, ts.envFile(t, stateFileName), `{"config-sha256": "old", "completed": ["checks", "directories", "stai-temp"]}`)
+	if err := ts.Run(); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if !ts.ran("git --version") || !ts.ran("git init") {
+		t.Errorf("run with a changed config skipped steps: %q", ts.runner.commands)
+	}
+}
+
+func TestRunSteps(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      Options
+		wantRan   []string // Command prefixes of the run steps
+		wantSkip  []string // Command prefixes of skipped steps
+		workspace bool
+		wantErr   string
+	}{
+		{name: "from clone", opts: Options{FromStep: StepClone}, wantRan: []string{"git clone"}, wantSkip: []string{"git --version", "git init"}, workspace: true},
+		{name: "from workspace", opts: Options{FromStep: StepWorkspace}, wantSkip: []string{"git"}, workspace: true},
+		{name: "only stai-temp", opts: Options{OnlyStep: StepStaiTemp}, wantRan: []string{"git init"}, wantSkip: []string{"git --version", "git clone"}},
+		{name: "invalid step", opts: Options{FromStep: "deploy"}, wantErr: "invalid --from-step 'deploy'"},
+		{name: "both", opts: Options{FromStep: StepClone, OnlyStep: StepClone}, wantErr: "cannot be combined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newRunSetup(t, tt.opts, runConfig("app"))
+			// An unfinished run is ignored
+			ts.writeFile(t, ts.envFile(t, stateFileName), `{"config-sha256": "", "completed": ["checks"]}`)
+			err := ts.Run()
+			if tt.wantErr != "" {
+				if ExitCode(err) != exitValidation || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Run = %v, want a validation error %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			for _, prefix := range tt.wantRan {
+				if !ts.ran(prefix) {
+					t.Errorf("%s not run: %q", prefix, ts.runner.commands)
+				}
+			}
+			for _, prefix := range tt.wantSkip {
+				if ts.ran(prefix) {
+					t.Errorf("%s run: %q", prefix, ts.runner.commands)
+				}
+			}
+			if ts.exists(workspaceFilePath(runBaseDir)) != tt.workspace {
+				t.Errorf("workspace file written %t, want %t", !tt.workspace, tt.workspace)
+			}
+		})
+	}
+}
+
+func TestRollback(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        Options
+		interactive bool
+		answer      string
+		undone      bool
+	}{
+		{name: "--rollback-on-failure", opts: Options{RollbackOnFailure: true}, undone: true},
+		{name: "confirmed", interactive: true, answer: "y\n", undone: true},
+		{name: "declined", interactive: true, answer: "\n"},
+		{name: "not interactive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestSetup(t, tt.opts, Assets{})
+			ts.Interactive, ts.In = tt.interactive, strings.NewReader(tt.answer)
+			ts.writeFile(t, runBaseDir+"/notes.txt", "mine")
+			ts.writeFile(t, runBaseDir+"/run.sh", "#!/bin/sh")
+			state := ts.envFile(t, stateFileName)
+
+			// The changes of a failed run
+			ts.trackChanges()
+			steps := []func() error{
+				func() error { return ts.FS.MkdirAll(runBaseDir+"/app/src", 0755) },
+				func() error { return ts.FS.WriteFile(runBaseDir+"/app/src/main.go", []byte("package main"), 0644) },
+				func() error { return ts.FS.WriteFile(runBaseDir+"/notes.txt", []byte("changed"), 0600) },
+				func() error { return ts.FS.Chmod(runBaseDir+"/run.sh", 0755) },
+				func() error { return ts.FS.Symlink("notes.txt", runBaseDir+"/latest") },
+				func() error { return ts.FS.WriteFile(state, []byte("{}"), 0644) },
+			}
+			for _, step := range steps {
+				if err := step(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			ts.rollback(runBaseDir, errors.New("clone failed"))
+
+			if ts.tracking() {
+				t.Error("changes still tracked after the rollback")
+			}
+			info, err := ts.fs.Stat(runBaseDir + "/run.sh")
+			if err != nil {
+				t.Fatal(err)
+			}
+			notes := ts.readFile(t, runBaseDir+"/notes.txt")
+			if !tt.undone {
+				if notes != "changed" || !ts.exists(runBaseDir+"/app/src/main.go") || info.Mode().Perm() != 0755 {
+					t.Error("declined rollback changed files")
+				}
+				return
+			}
+			for _, created := range []string{runBaseDir + "/app", runBaseDir + "/latest", state} {
+				if ts.exists(created) {
+					t.Errorf("%s left after the rollback", created)
+				}
+			}
+			if notes != "mine" {
+				t.Errorf("notes.txt = %q after the rollback, want the previous content", notes)
+			}
+			if info.Mode().Perm() != 0644 {
+				t.Errorf("run.sh mode %04o after the rollback, want 0644", info.Mode().Perm())
+			}
+		})
+	}
+}
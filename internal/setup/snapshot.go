@@ -0,0 +1,236 @@
+package setup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// snapshotDir is the directory of named snapshots in the environment
+// state directory
+const snapshotDir = "snapshots"
+
+// dirtyFiles returns the uncommitted and untracked files of the checkout
+// in dir
+func (s *Setup) dirtyFiles(dir string) ([]string, error) {
+	ctx, cancel := s.gitContext(opInit)
+	defer cancel()
+	var stdout bytes.Buffer
+	cmd := s.gitCommand(dir, "status", "--porcelain", "-z", "--untracked-files=all")
+	cmd.Stdout = &stdout
+	if err := s.timeoutError(ctx, opInit, s.runCommand(ctx, cmd)); err != nil {
+		return nil, gitErrorf("failed to check changes in %s: %w", dir, err)
+	}
+
+	// Entries are "XY path", renames and copies are followed by the old path
+	var files []string
+	entries := strings.Split(stdout.String(), "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) < 4 {
+			continue
+		}
+		files = append(files, entry[3:])
+		if entry[0] == 'R' || entry[0] == 'C' {
+			i++
+		}
+	}
+	return files, nil
+}
+
+// snapshotDirPath returns the directory of named snapshots of the
+// environment in baseDir
+func (s *Setup) snapshotDirPath(baseDir string) (string, error) {
+	dir, err := s.envStateDir(baseDir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, snapshotDir), nil
+}
+
+// Snapshot records the branch, commit and uncommitted files of every
+// checked out repository to path, a new file in the snapshot directory of
+// the environment when empty, e.g. to capture where a bug reproduced.
+// Uncommitted changes themselves are not saved, only which files had
+// them.
+func (s *Setup) Snapshot(path string) error {
+	config, err := s.loadEffectiveConfig()
+	if err != nil {
+		return err
+	}
+	if err := s.configureTimeouts(config); err != nil {
+		return err
+	}
+	workDir, err := s.validateWorkingDirectory(s.opts.WorkDir)
+	if err != nil {
+		return err
+	}
+	baseDir, err := s.resolveBaseDirectory(s.opts.BaseDir, workDir)
+	if err != nil {
+		return err
+	}
+
+	snapshot := s.environmentLock(baseDir, config)
+	for i, repo := range snapshot.Repos {
+		dirty, err := s.dirtyFiles(filepath.Join(baseDir, filepath.FromSlash(repo.Path)))
+		if err != nil {
+			return err
+		}
+		snapshot.Repos[i].Dirty = dirty
+	}
+	data, err := marshalLock(snapshot)
+	if err != nil {
+		return err
+	}
+
+	if path == "" {
+		dir, err := s.snapshotDirPath(baseDir)
+		if err != nil {
+			return err
+		}
+		if err := s.FS.MkdirAll(dir, s.perms.dirs); err != nil {
+			return fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+		path = filepath.Join(dir, snapshot.Generated.Local().Format("20060102-150405")+".json")
+	}
+	if err := s.FS.WriteFile(path, data, s.perms.files); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	fmt.Fprintf(s.Out, "Wrote snapshot of %d repositories to %s\n", len(snapshot.Repos), path)
+	return nil
+}
+
+// readLock reads a revision lock or snapshot. A name that is not a file
+// is looked up in the snapshot directory of the environment, with or
+// without .json.
+func (s *Setup) readLock(baseDir, name string) (*EnvironmentLock, string, error) {
+	path := name
+	if !s.exists(path) && !strings.ContainsAny(name, `/\`) {
+		dir, err := s.snapshotDirPath(baseDir)
+		if err != nil {
+			return nil, "", err
+		}
+		path = filepath.Join(dir, strings.TrimSuffix(name, ".json")+".json")
+	}
+	data, err := s.FS.ReadFile(path)
+	if err != nil {
+		return nil, "", ValidationErrorf("failed to read snapshot %s: %w", name, err)
+	}
+	var lock EnvironmentLock
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, "", ValidationErrorf("invalid snapshot %s: %w", path, err)
+	}
+	return &lock, path, nil
+}
+
+// Restore checks out the revisions recorded by a snapshot or revision
+// lock. A branch still at the recorded commit is checked out, otherwise
+// the commit is checked out detached, fetched from origin when missing.
+// Checkouts with uncommitted changes are left alone, nothing is restored
+// before all are clean.
+func (s *Setup) Restore(name string) error {
+	config, err := s.loadEffectiveConfig()
+	if err != nil {
+		return err
+	}
+	if err := s.configureTimeouts(config); err != nil {
+		return err
+	}
+	workDir, err := s.validateWorkingDirectory(s.opts.WorkDir)
+	if err != nil {
+		return err
+	}
+	baseDir, err := s.resolveBaseDirectory(s.opts.BaseDir, workDir)
+	if err != nil {
+		return err
+	}
+	lock, path, err := s.readLock(baseDir, name)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(s.Out, "Restoring %s taken %s\n", path, lock.Generated.Local().Format(time.DateTime))
+
+	var dirty []string
+	for _, repo := range lock.Repos {
+		dir := filepath.Join(baseDir, filepath.FromSlash(repo.Path))
+		if !s.exists(dir) || repo.SHA == "" {
+			continue
+		}
+		files, err := s.dirtyFiles(dir)
+		if err != nil {
+			return err
+		}
+		if len(files) > 0 {
+			dirty = append(dirty, repo.Name)
+		}
+	}
+	if len(dirty) > 0 {
+		return ValidationErrorf("uncommitted changes in %s, commit or stash them before restoring", strings.Join(dirty, ", "))
+	}
+
+	failed := 0
+	for _, repo := range lock.Repos {
+		dir := filepath.Join(baseDir, filepath.FromSlash(repo.Path))
+		switch {
+		case repo.SHA == "":
+			continue
+		case !s.exists(dir):
+			s.Warnf("%s is not checked out in %s, run setup first", repo.Name, dir)
+			failed++
+			continue
+		}
+		if err := s.restoreRevision(dir, repo); err != nil {
+			s.Warnf("Failed to restore %s: %v", repo.Name, err)
+			failed++
+			continue
+		}
+		if len(repo.Dirty) > 0 {
+			fmt.Fprintf(s.Out, "  %s had uncommitted changes when the snapshot was taken: %s\n", repo.Name, strings.Join(repo.Dirty, ", "))
+		}
+	}
+	if failed > 0 {
+		return gitErrorf("%d of %d repositories were not restored", failed, len(lock.Repos))
+	}
+	return nil
+}
+
+// restoreRevision checks out the recorded revision in dir
+func (s *Setup) restoreRevision(dir string, repo RepoRevision) error {
+	if head, err := s.gitOutput(opInit, dir, "rev-parse", "HEAD"); err == nil && head == repo.SHA {
+		if branch, _ := s.gitOutput(opInit, dir, "symbolic-ref", "--short", "-q", "HEAD"); branch == repo.Branch {
+			fmt.Fprintf(s.Out, "  %s is at %s\n", repo.Name, shortSHA(repo.SHA))
+			return nil
+		}
+	}
+
+	if s.runGit(opInit, dir, "cat-file", "-e", repo.SHA+"^{commit}") != nil {
+		if err := s.runGit(opClone, dir, "fetch", "--quiet", "origin"); err != nil {
+			return fmt.Errorf("commit %s is missing and fetching origin failed: %w", shortSHA(repo.SHA), err)
+		}
+		if s.runGit(opInit, dir, "cat-file", "-e", repo.SHA+"^{commit}") != nil {
+			return fmt.Errorf("commit %s is not in the repository or on origin", shortSHA(repo.SHA))
+		}
+	}
+
+	if repo.Branch != "" {
+		if tip, err := s.gitOutput(opInit, dir, "rev-parse", "--verify", "--quiet", "refs/heads/"+repo.Branch); err == nil && tip == repo.SHA {
+			if err := s.runGit(opInit, dir, "checkout", "--quiet", repo.Branch); err != nil {
+				return err
+			}
+			fmt.Fprintf(s.Out, "  %s: checked out %s at %s\n", repo.Name, repo.Branch, shortSHA(repo.SHA))
+			return nil
+		}
+	}
+	if err := s.runGit(opInit, dir, "checkout", "--quiet", "--detach", repo.SHA); err != nil {
+		return err
+	}
+	if repo.Branch != "" {
+		fmt.Fprintf(s.Out, "  %s: checked out %s detached, branch %s has moved since\n", repo.Name, shortSHA(repo.SHA), repo.Branch)
+	} else {
+		fmt.Fprintf(s.Out, "  %s: checked out %s detached\n", repo.Name, shortSHA(repo.SHA))
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package setup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sshTimeout bounds a single ssh-add or ssh -T call of the SSH check
+const sshTimeout = 20 * time.Second
+
+// sshExitError is the exit status of ssh itself failing, any other status
+// comes from the server side after a successful authentication
+const sshExitError = 255
+
+// defaultSSHKeys are the identity files ssh tries without configuration
+var defaultSSHKeys = []string{"id_ed25519", "id_ecdsa", "id_rsa", "id_ed25519_sk", "id_ecdsa_sk"}
+
+// sshTarget is a host git connects to over SSH
+type sshTarget struct {
+	user string // empty for the ssh default
+	host string
+	port string // empty for the default port
+}
+
+// sshTargetOf returns the SSH destination of a git URL, ok is false for
+// URLs not using SSH
+func sshTargetOf(repoURL string) (sshTarget, bool) {
+	if scheme, _ := repoURLHost(repoURL); scheme != "ssh" && scheme != "git+ssh" {
+		return sshTarget{}, false
+	}
+	if !strings.Contains(repoURL, "://") {
+		dest, _, _ := strings.Cut(repoURL, ":")
+		user, host, ok := strings.Cut(dest, "@")
+		if !ok {
+			return sshTarget{host: dest}, true
+		}
+		return sshTarget{user: user, host: host}, true
+	}
+
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return sshTarget{}, false
+	}
+	target := sshTarget{host: u.Hostname(), port: u.Port()}
+	if u.User != nil {
+		target.user = u.User.Username()
+	}
+	return target, true
+}
+
+// dest returns the [user@]host destination passed to ssh
+func (t sshTarget) dest() string {
+	if t.user != "" {
+		return t.user + "@" + t.host
+	}
+	return t.host
+}
+
+// String returns the destination as it is shown to the user
+func (t sshTarget) String() string {
+	if t.port != "" {
+		return t.dest() + ":" + t.port
+	}
+	return t.dest()
+}
+
+// sshTargets returns the distinct SSH destinations of all git-repo
+// origin and upstream URLs in config order. Repos cloned from a mirror
+// are skipped like in checkRemotes.
+func (s *Setup) sshTargets(config *Config) []sshTarget {
+	var targets []sshTarget
+	seen := map[sshTarget]bool{}
+	for _, repo := range config.Repos {
+		if repo.Type != "git-repo" || s.hasMirror(s.opts.MirrorDir, repo) {
+			continue
+		}
+		for _, remote := range []string{*repo.GitRepo, repo.Upstream} {
+			if target, ok := sshTargetOf(remote); ok && !seen[target] {
+				seen[target] = true
+				targets = append(targets, target)
+			}
+		}
+	}
+	return targets
+}
+
+// sshHint returns the error message suffix pointing to the SSH check for
+// failed git operations on SSH URLs
+func sshHint(repoURL string) string {
+	if _, ok := sshTargetOf(repoURL); !ok {
+		return ""
+	}
+	return ". Check SSH access with 'ws-config-gen doctor'"
+}
+
+// sshCommand returns the ssh command git uses, GIT_SSH_COMMAND when set
+func sshCommand() (name string, args []string, custom bool) {
+	if fields := strings.Fields(os.Getenv("GIT_SSH_COMMAND")); len(fields) > 0 {
+		return fields[0], fields[1:], true
+	}
+	return "ssh", nil, false
+}
+
+// checkSSH verifies that an SSH key is usable and that every SSH host of
+// the config accepts it, before any clone fails with a bare exit status.
+// Missing host keys are added to known_hosts first when seed is set.
+func (s *Setup) checkSSH(config *Config, seed bool) error {
+	targets := s.sshTargets(config)
+	if len(targets) == 0 {
+		return nil
+	}
+
+	name, baseArgs, custom := sshCommand()
+	if _, err := s.Runner.LookPath(name); err != nil {
+		return s.sshProblem(ValidationErrorf, "SSH client '%s' not found in PATH, install OpenSSH or use HTTPS URLs", name)
+	}
+
+	// A custom GIT_SSH_COMMAND usually names its key, leave it to ssh -T
+	if !custom {
+		found, err := s.checkSSHKeys()
+		if err != nil {
+			return err
+		}
+		if !found {
+			return s.sshProblem(ValidationErrorf, "no SSH key found: start ssh-agent and load a key with 'ssh-add', or create one with 'ssh-keygen -t ed25519' and add the public key to your git host account")
+		}
+	}
+
+	pending, err := s.seedKnownHosts(config, targets, seed)
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	for _, target := range targets {
+		if pending[target.knownHostsName()] {
+			fmt.Fprintf(s.Out, "  SSH %s: not checked, host key not added yet\n", target)
+			continue
+		}
+		if err := s.probeSSH(name, baseArgs, target); err != nil {
+			fmt.Fprintf(s.Out, "  SSH %s: %v\n", target, err)
+			failed = append(failed, target.String())
+			continue
+		}
+		fmt.Fprintf(s.Out, "  SSH %s: authenticated\n", target)
+	}
+
+	if len(failed) > 0 {
+		return s.sshProblem(gitErrorf, "SSH authentication failed for %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// sshProblem turns a failed SSH check into a warning when it may be
+// skipped, otherwise into an error created by errorf
+func (s *Setup) sshProblem(errorf func(string, ...any) error, format string, args ...any) error {
+	msg := fmt.Sprintf(format, args...)
+	if skippedBy, ok := s.CanSkipWarning(CheckSSH); ok {
+		s.Warnf("%s%s (continuing due to %s)", strings.ToUpper(msg[:1]), msg[1:], skippedBy)
+		return nil
+	}
+	return errorf("%s. %s", msg, skipHint(CheckSSH))
+}
+
+// checkSSHKeys reports whether the SSH agent holds a key or a key ssh
+// would use exists. An ~/.ssh/config may name any identity file, it
+// counts as usable and is verified by ssh -T.
+func (s *Setup) checkSSHKeys() (bool, error) {
+	if os.Getenv("SSH_AUTH_SOCK") != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), sshTimeout)
+		defer cancel()
+
+		var stdout bytes.Buffer
+		err := s.runCommand(ctx, Command{Name: "ssh-add", Args: []string{"-l"}, Stdout: &stdout})
+		if err == nil {
+			keys := strings.Count(strings.TrimSpace(stdout.String()), "\n") + 1
+			fmt.Fprintf(s.Out, "  SSH agent: %d keys loaded\n", keys)
+			return true, nil
+		}
+		fmt.Fprintln(s.Out, "  SSH agent: no keys loaded or not running")
+	}
+
+	homeDir, err := s.Users.HomeDir()
+	if err != nil {
+		return false, fmt.Errorf("failed to get home directory: %w", err)
+	}
+	sshDir := filepath.Join(homeDir, ".ssh")
+	for _, key := range defaultSSHKeys {
+		if path := filepath.Join(sshDir, key); s.exists(path) {
+			fmt.Fprintf(s.Out, "  SSH key: %s\n", path)
+			return true, nil
+		}
+	}
+	if path := filepath.Join(sshDir, "config"); s.exists(path) {
+		fmt.Fprintf(s.Out, "  SSH config: %s\n", path)
+		return true, nil
+	}
+	return false, nil
+}
+
+// probeSSH authenticates to target with ssh -T without prompting. Git
+// hosts reject the shell after a successful login, so only the ssh exit
+// status 255 is a failure.
+func (s *Setup) probeSSH(name string, baseArgs []string, target sshTarget) error {
+	args := append(append([]string{}, baseArgs...), "-T", "-o", "BatchMode=yes", "-o", "ConnectTimeout=10")
+	if target.port != "" {
+		args = append(args, "-p", target.port)
+	}
+	args = append(args, target.dest())
+
+	ctx, cancel := context.WithTimeout(context.Background(), sshTimeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	err := s.runCommand(ctx, Command{Name: name, Args: args, Stderr: &stderr})
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return nil
+	case ctx.Err() != nil:
+		return fmt.Errorf("timed out after %s, check the network and the host name", sshTimeout)
+	case errors.As(err, &exitErr) && exitErr.ExitCode() != sshExitError:
+		return nil
+	}
+
+	msg := strings.TrimSuffix(firstLine(stderr.String()), ".")
+	if msg == "" {
+		msg = err.Error()
+	}
+	return fmt.Errorf("%s%s", msg, sshFailureHint(msg, target))
+}
+
+// sshFailureHint returns what to do about an ssh error message
+func sshFailureHint(msg string, target sshTarget) string {
+	switch {
+	case strings.Contains(msg, "Permission denied"):
+		return ". Add your public key to your account on " + target.String() + " and load it with 'ssh-add'"
+	case strings.Contains(msg, "Host key verification failed"):
+		return ". Add the host key to ~/.ssh/known_hosts, e.g. by connecting once with 'ssh -T " + target.dest() + "'"
+	case strings.Contains(msg, "Could not resolve hostname"), strings.Contains(msg, "Connection refused"), strings.Contains(msg, "timed out"):
+		return ". Check the network and the host name"
+	}
+	return ""
+}
@@ -0,0 +1,158 @@
+package setup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Setup steps in run order, see --from-step and --only-step
+const (
+	StepChecks      = "checks"      // user, binaries, base directory and preflight
+	StepDirectories = "directories" // environment directories
+	StepStaiTemp    = "stai-temp"   // stai-temp repository with readme.md
+	StepClone       = "clone"       // repository clones and local repositories
+	StepWorkspace   = "workspace"   // workspace file
+)
+
+// StepNames lists all steps in run order
+var StepNames = []string{StepChecks, StepDirectories, StepStaiTemp, StepClone, StepWorkspace}
+
+// stateFileName is the file in the environment state directory recording
+// the steps an unfinished run completed
+const stateFileName = "setup.state"
+
+// legacyStateFileName is the state file of older versions in the base
+// directory, an unfinished run recorded there is resumed
+const legacyStateFileName = ".stai-setup.state"
+
+// runState records completed steps, a re-run with the same config skips
+// them. The file is removed once all steps are done.
+type runState struct {
+	ConfigSHA256 string   `json:"config-sha256"`
+	Completed    []string `json:"completed"`
+}
+
+func (r *runState) done(step string) bool {
+	return slices.Contains(r.Completed, step)
+}
+
+// startState returns the steps to treat as completed: those before
+// --from-step, all but --only-step, or those recorded by an unfinished
+// run of the same config
+func (s *Setup) startState(baseDir string, config *Config) (*runState, error) {
+	sum, err := configSHA256(config)
+	if err != nil {
+		return nil, err
+	}
+	state := &runState{ConfigSHA256: sum, Completed: []string{}}
+
+	switch {
+	case s.opts.FromStep != "" && s.opts.OnlyStep != "":
+		return nil, ValidationErrorf("--from-step and --only-step cannot be combined")
+	case s.opts.FromStep != "":
+		index, err := stepIndex(s.opts.FromStep, "--from-step")
+		if err != nil {
+			return nil, err
+		}
+		state.Completed = append(state.Completed, StepNames[:index]...)
+		return state, nil
+	case s.opts.OnlyStep != "":
+		if _, err := stepIndex(s.opts.OnlyStep, "--only-step"); err != nil {
+			return nil, err
+		}
+		for _, step := range StepNames {
+			if step != s.opts.OnlyStep {
+				state.Completed = append(state.Completed, step)
+			}
+		}
+		return state, nil
+	}
+
+	path, err := s.envStatePath(baseDir, stateFileName)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.FS.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		path = filepath.Join(baseDir, legacyStateFileName)
+		data, err = s.FS.ReadFile(path)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var saved runState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		s.Warnf("Ignoring unreadable %s: %v", path, err)
+		return state, nil
+	}
+	if saved.ConfigSHA256 != sum {
+		fmt.Fprintln(s.Out, "Config changed since the unfinished run, running all steps")
+		return state, nil
+	}
+
+	if len(saved.Completed) > 0 {
+		fmt.Fprintf(s.Out, "Resuming unfinished run, skipping completed steps: %s (use --from-step=%s to run all)\n", strings.Join(saved.Completed, ", "), StepChecks)
+		state.Completed = saved.Completed
+	}
+	return state, nil
+}
+
+// completeStep records step as completed, removing the state file once
+// all steps are done
+func (s *Setup) completeStep(baseDir string, state *runState, step string) error {
+	if !state.done(step) {
+		state.Completed = append(state.Completed, step)
+	}
+
+	path, err := s.envStatePath(baseDir, stateFileName)
+	if err != nil {
+		return err
+	}
+	if len(state.Completed) == len(StepNames) {
+		for _, done := range []string{path, filepath.Join(baseDir, legacyStateFileName)} {
+			if err := s.FS.Remove(done); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("failed to remove %s: %w", done, err)
+			}
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal run state: %w", err)
+	}
+	if err := s.FS.WriteFile(path, append(data, '\n'), s.perms.files); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// stepIndex returns the position of step in StepNames
+func stepIndex(step, flagName string) (int, error) {
+	index := slices.Index(StepNames, step)
+	if index < 0 {
+		return 0, ValidationErrorf("invalid %s '%s', must be one of: %s", flagName, step, strings.Join(StepNames, ", "))
+	}
+	return index, nil
+}
+
+// configSHA256 returns the checksum of the effective config
+func configSHA256(config *Config) (string, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal config: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
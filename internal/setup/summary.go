@@ -0,0 +1,179 @@
+package setup
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Workspace file states reported in the run summary
+const (
+	workspaceCreated   = "created"
+	workspaceUpdated   = "updated"
+	workspaceUnchanged = "unchanged"
+)
+
+// RunSummary collects actions and warnings of a run for the summary
+// printed at the end
+type RunSummary struct {
+	Cloned      []string `json:"cloned"`
+	Initialized []string `json:"initialized"`
+	Created     []string `json:"created"` // Plain directories of the dir type
+	Skipped     []string `json:"skipped"`
+	Workspace   string   `json:"workspace,omitempty"`
+	Warnings    []string `json:"warnings"`
+	Error       string   `json:"error,omitempty"`
+	// Time spent per repository in the clone step, in config order
+	Timings []RepoTiming `json:"timings"`
+	// Presets detected for repositories without presets in the config
+	Detected []DetectedPresets `json:"detected"`
+}
+
+// DetectedPresets are the presets detected from the files of a checkout
+type DetectedPresets struct {
+	Name    string   `json:"name"`
+	Presets []string `json:"presets"`
+}
+
+// RepoTiming is the time spent setting up one repository
+type RepoTiming struct {
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+}
+
+func newRunSummary() RunSummary {
+	return RunSummary{
+		Cloned:      []string{},
+		Initialized: []string{},
+		Created:     []string{},
+		Skipped:     []string{},
+		Warnings:    []string{},
+		Timings:     []RepoTiming{},
+		Detected:    []DetectedPresets{},
+	}
+}
+
+// recordRepo adds a repository name to a summary list, repositories may
+// be set up in parallel
+func (s *Setup) recordRepo(list *[]string, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	*list = append(*list, name)
+}
+
+// timeRepo runs fn and records its duration for repository name
+func (s *Setup) timeRepo(name string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	seconds := time.Since(start).Round(time.Millisecond).Seconds()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.summary.Timings = append(s.summary.Timings, RepoTiming{Name: name, Seconds: seconds})
+	return err
+}
+
+// sortSummary restores config order of the repository lists after a
+// parallel clone step
+func (s *Setup) sortSummary(config *Config) {
+	order := map[string]int{}
+	for i, repo := range config.Repos {
+		order[repo.Name] = i
+	}
+	for _, list := range [][]string{s.summary.Cloned, s.summary.Initialized, s.summary.Created, s.summary.Skipped} {
+		sort.SliceStable(list, func(i, j int) bool { return order[list[i]] < order[list[j]] })
+	}
+	sort.SliceStable(s.summary.Timings, func(i, j int) bool {
+		return order[s.summary.Timings[i].Name] < order[s.summary.Timings[j].Name]
+	})
+}
+
+// Warnf prints a warning and records it for the summary
+func (s *Setup) Warnf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintln(s.Out, s.marked(s.color, markWarning, styleYellow, "Warning: "+msg))
+	s.summary.Warnings = append(s.summary.Warnings, msg)
+}
+
+// Changed reports whether the run cloned, initialized or created
+// repositories or wrote the workspace file, otherwise --changes-only
+// prints no summary. Warnings alone are no change, scheduled runs repeat
+// skipped checks.
+func (s *Setup) Changed() bool {
+	return len(s.summary.Cloned) > 0 || len(s.summary.Initialized) > 0 || len(s.summary.Created) > 0 ||
+		s.summary.Workspace == workspaceCreated || s.summary.Workspace == workspaceUpdated
+}
+
+// PrintSummary prints the collected actions and warnings to Report. The
+// summary of --quiet leaves out skipped repositories, timings, an
+// unchanged workspace file and a zero warning count, nothing is printed
+// when that leaves it empty.
+func (s *Setup) PrintSummary() {
+	quiet := s.opts.Quiet
+	if quiet && !s.Changed() && len(s.summary.Warnings) == 0 {
+		return
+	}
+	fmt.Fprintln(s.Report, paint(s.color, "Summary:", styleBold, styleCyan))
+	s.printSummaryRepos("cloned", s.summary.Cloned)
+	s.printSummaryRepos("initialized", s.summary.Initialized)
+	s.printSummaryRepos("created", s.summary.Created)
+	if !quiet {
+		s.printSummaryRepos("skipped (already exist)", s.summary.Skipped)
+	}
+	if len(s.summary.Timings) > 0 && !quiet {
+		timings := make([]string, len(s.summary.Timings))
+		for i, t := range s.summary.Timings {
+			timings[i] = fmt.Sprintf("%s %.1fs", t.Name, t.Seconds)
+		}
+		fmt.Fprintf(s.Report, "  Repository timings: %s\n", strings.Join(timings, ", "))
+	}
+	if len(s.summary.Detected) > 0 && !quiet {
+		detected := make([]string, len(s.summary.Detected))
+		for i, d := range s.summary.Detected {
+			detected[i] = fmt.Sprintf("%s %s", d.Name, strings.Join(d.Presets, "+"))
+		}
+		fmt.Fprintf(s.Report, "  Detected presets: %s\n", strings.Join(detected, ", "))
+	}
+	if s.summary.Workspace != "" && !(quiet && s.summary.Workspace == workspaceUnchanged) {
+		fmt.Fprintf(s.Report, "  Workspace file: %s\n", s.summary.Workspace)
+	}
+	if len(s.summary.Warnings) > 0 || !quiet {
+		fmt.Fprintf(s.Report, "  Warnings: %d\n", len(s.summary.Warnings))
+	}
+	for _, w := range s.summary.Warnings {
+		fmt.Fprintf(s.Report, "    - %s\n", w)
+	}
+}
+
+func (s *Setup) printSummaryRepos(what string, names []string) {
+	if len(names) == 0 {
+		return
+	}
+	fmt.Fprintf(s.Report, "  Repositories %s: %d (%s)\n", what, len(names), strings.Join(names, ", "))
+}
+
+// WriteSummaryJSON writes the summary to path, "-" for Report
+func (s *Setup) WriteSummaryJSON(path string, runErr error) error {
+	if runErr != nil {
+		s.summary.Error = runErr.Error()
+	}
+
+	data, err := json.MarshalIndent(s.summary, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal summary: %w", err)
+	}
+	data = append(data, '\n')
+
+	if path == "-" {
+		_, err = s.Report.Write(data)
+		return err
+	}
+	if err := s.FS.WriteFile(path, data, s.perms.files); err != nil {
+		return fmt.Errorf("failed to write summary: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package setup
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// throttleDialTimeout bounds connecting to a git server or proxy from
+// the throttling proxy
+const throttleDialTimeout = 30 * time.Second
+
+// parseRate parses --max-rate values like "2MB", "500KB/s" or a plain
+// number of bytes per second
+func parseRate(value string) (int64, error) {
+	rate, err := parseSize(strings.TrimSuffix(strings.TrimSpace(value), "/s"))
+	if err != nil {
+		return 0, ValidationErrorf("invalid --max-rate: %w", err)
+	}
+	if rate <= 0 {
+		return 0, ValidationErrorf("invalid --max-rate '%s', must be above zero", value)
+	}
+	return rate, nil
+}
+
+// rateLimiter is a token bucket shared by all connections of one
+// direction, so parallel clones together stay below the rate
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate int64) *rateLimiter {
+	return &rateLimiter{rate: float64(rate), last: time.Now()}
+}
+
+// wait blocks until n bytes may be transferred
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	// Allow bursts of at most a second of traffic
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// throttledReader limits reads of r with limiter
+type throttledReader struct {
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (t throttledReader) Read(p []byte) (int, error) {
+	// Small reads keep the traffic smooth
+	if limit := int(t.limiter.rate / 10); limit > 0 && len(p) > limit {
+		p = p[:limit]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		t.limiter.wait(n)
+	}
+	return n, err
+}
+
+// throttleProxy is a local HTTP proxy limiting the bandwidth of git over
+// HTTP(S). HTTPS is tunneled with CONNECT, so TLS stays end to end.
+// Connections go through the upstream proxy git would use otherwise.
+type throttleProxy struct {
+	upstream  string // config proxy, environment proxies apply when empty
+	down, up  *rateLimiter
+	transport *http.Transport
+	server    *http.Server
+	listener  net.Listener
+}
+
+// startThrottleProxy starts a throttling proxy on a local port
+func startThrottleProxy(rate int64, upstream string) (*throttleProxy, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to start --max-rate proxy: %w", err)
+	}
+
+	p := &throttleProxy{
+		upstream: upstream,
+		down:     newRateLimiter(rate),
+		up:       newRateLimiter(rate),
+		listener: listener,
+	}
+	p.transport = http.DefaultTransport.(*http.Transport).Clone()
+	p.transport.Proxy = p.proxyURL
+	p.server = &http.Server{Handler: p}
+	go p.server.Serve(listener)
+	return p, nil
+}
+
+// URL returns the proxy URL passed to git
+func (p *throttleProxy) URL() string {
+	return "http://" + p.listener.Addr().String()
+}
+
+// Close stops the proxy and its tunnels
+func (p *throttleProxy) Close() error {
+	p.transport.CloseIdleConnections()
+	return p.server.Close()
+}
+
+// proxyURL returns the upstream proxy of req like git would choose it,
+// the config proxy unless NO_PROXY matches, then the environment
+func (p *throttleProxy) proxyURL(req *http.Request) (*url.URL, error) {
+	if proxyArgs(p.upstream, req.URL.String()) == nil {
+		return http.ProxyFromEnvironment(req)
+	}
+	if !strings.Contains(p.upstream, "://") {
+		return url.Parse("http://" + p.upstream)
+	}
+	return url.Parse(p.upstream)
+}
+
+func (p *throttleProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r)
+		return
+	}
+
+	// Plain HTTP request to an absolute URL
+	req := r.Clone(r.Context())
+	req.RequestURI = ""
+	req.Body = io.NopCloser(throttledReader{r.Body, p.up})
+	resp, err := p.transport.RoundTrip(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for key, values := range resp.Header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, throttledReader{resp.Body, p.down})
+}
+
+// tunnel connects to the CONNECT target, through the upstream proxy when
+// one applies, and copies data throttled in both directions
+func (p *throttleProxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	server, err := p.dial(r.Context(), r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer server.Close()
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "tunneling not supported", http.StatusInternalServerError)
+		return
+	}
+	client, buffered, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	defer client.Close()
+
+	if _, err := io.WriteString(client, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		return
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(server, throttledReader{buffered, p.up})
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(client, throttledReader{server, p.down})
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// dial opens a connection to host:port, with a CONNECT tunnel of the
+// upstream proxy when one applies
+func (p *throttleProxy) dial(ctx context.Context, hostPort string) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: throttleDialTimeout}
+	target := &url.URL{Scheme: "https", Host: hostPort}
+	proxy, err := p.proxyURL(&http.Request{URL: target, Header: http.Header{}})
+	if err != nil {
+		return nil, err
+	}
+	if proxy == nil {
+		return dialer.DialContext(ctx, "tcp", hostPort)
+	}
+
+	proxyHost := proxy.Host
+	if proxy.Port() == "" {
+		proxyHost = net.JoinHostPort(proxy.Hostname(), "80")
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", proxyHost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to proxy %s: %w", proxy.Redacted(), err)
+	}
+
+	connect := &http.Request{Method: http.MethodConnect, URL: &url.URL{Opaque: hostPort}, Host: hostPort, Header: http.Header{}}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		connect.SetBasicAuth(proxy.User.Username(), password)
+		connect.Header.Set("Proxy-Authorization", connect.Header.Get("Authorization"))
+		connect.Header.Del("Authorization")
+	}
+	if err := connect.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, connect)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused tunnel to %s: %s", proxy.Redacted(), hostPort, resp.Status)
+	}
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: reader}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a connection with data already read into r
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+// startThrottle starts the --max-rate proxy for the clone step, git
+// commands send all HTTP(S) traffic through it until stop is called. It
+// returns the config to clone with, its proxy is applied by the
+// throttling proxy instead of git. The returned stop function is never
+// nil.
+func (s *Setup) startThrottle(config *Config) (*Config, func(), error) {
+	if s.opts.MaxRate == "" {
+		return config, func() {}, nil
+	}
+	rate, err := parseRate(s.opts.MaxRate)
+	if err != nil {
+		return nil, func() {}, err
+	}
+
+	proxy, err := startThrottleProxy(rate, config.Proxy)
+	if err != nil {
+		return nil, func() {}, err
+	}
+	fmt.Fprintf(s.Out, "Limiting HTTP(S) clones to %s/s\n", formatSize(rate))
+
+	for _, repo := range config.Repos {
+		if repo.GitRepo == nil || s.hasMirror(s.opts.MirrorDir, repo) {
+			continue
+		}
+		if _, ok := sshTargetOf(*repo.GitRepo); ok {
+			fmt.Fprintf(s.Out, "  %s uses SSH and is not limited by --max-rate\n", repo.Name)
+		}
+	}
+
+	s.throttleProxy = proxy.URL()
+	throttled := *config
+	throttled.Proxy = ""
+	return &throttled, func() {
+		s.throttleProxy = ""
+		proxy.Close()
+	}, nil
+}
@@ -0,0 +1,205 @@
+package setup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Supported values of --container-tool
+const (
+	ContainerToolbox   = "toolbox"
+	ContainerDistrobox = "distrobox"
+)
+
+// toolboxTimeout bounds creating the container and installing git and
+// the editor CLI in it
+const toolboxTimeout = 15 * time.Minute
+
+// validContainerName is what toolbox and distrobox accept as names
+var validContainerName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// installGitScript installs git with the package manager of the container
+const installGitScript = `command -v git >/dev/null && exit 0
+if command -v dnf >/dev/null; then sudo dnf install -y git
+elif command -v apt-get >/dev/null; then sudo apt-get update && sudo apt-get install -y git
+elif command -v zypper >/dev/null; then sudo zypper --non-interactive install git
+elif command -v pacman >/dev/null; then sudo pacman -S --noconfirm git
+elif command -v apk >/dev/null; then sudo apk add git
+else echo "no supported package manager to install git" >&2; exit 1
+fi`
+
+// InContainer reports whether this process runs inside a container, where
+// --container is already satisfied
+func InContainer() bool {
+	for _, marker := range []string{"/run/.containerenv", "/.dockerenv"} {
+		if _, err := os.Stat(marker); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// containerToolInside returns tool or, when empty, the tool that created
+// the container this process runs in
+func containerToolInside(tool string) string {
+	if tool != "" {
+		return tool
+	}
+	if _, err := os.Stat("/run/.toolboxenv"); err == nil {
+		return ContainerToolbox
+	}
+	return ContainerDistrobox
+}
+
+// containerTool returns --container-tool or the first of toolbox and
+// distrobox found in PATH
+func (s *Setup) containerTool() (string, error) {
+	switch tool := s.opts.ContainerTool; tool {
+	case ContainerToolbox, ContainerDistrobox:
+		if _, err := s.Runner.LookPath(tool); err != nil {
+			return "", ValidationErrorf("%s not found in PATH", tool)
+		}
+		return tool, nil
+	case "":
+	default:
+		return "", ValidationErrorf("invalid container tool '%s', must be %s or %s", tool, ContainerToolbox, ContainerDistrobox)
+	}
+	for _, tool := range []string{ContainerToolbox, ContainerDistrobox} {
+		if _, err := s.Runner.LookPath(tool); err == nil {
+			return tool, nil
+		}
+	}
+	return "", ValidationErrorf("--container needs %s or %s in PATH", ContainerToolbox, ContainerDistrobox)
+}
+
+// containerCommand returns the command running args in the container
+func containerCommand(tool, name string, args ...string) Command {
+	if tool == ContainerToolbox {
+		return Command{Name: tool, Args: append([]string{"run", "--container", name}, args...)}
+	}
+	return Command{Name: tool, Args: append([]string{"enter", name, "--"}, args...)}
+}
+
+// containerExists reports whether the container is listed by tool
+func (s *Setup) containerExists(tool, name string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), editorTimeout)
+	defer cancel()
+	var out strings.Builder
+	cmd := Command{Name: tool, Args: []string{"list", "--containers"}, Stdout: &out}
+	if tool == ContainerDistrobox {
+		cmd.Args = []string{"list", "--no-color"}
+	}
+	if err := s.runCommand(ctx, cmd); err != nil {
+		return false, fmt.Errorf("%s list failed: %w", tool, err)
+	}
+
+	// toolbox separates the columns by spaces, distrobox by '|', the name
+	// follows the ID in both
+	for _, line := range strings.Split(out.String(), "\n")[1:] {
+		fields := strings.Fields(strings.ReplaceAll(line, "|", " "))
+		if len(fields) > 1 && fields[1] == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// editorWrapper returns a script running the editor CLI of the host, so
+// the workspace opens in the editor installed there
+func editorWrapper(tool, binary string) string {
+	hostExec := "distrobox-host-exec"
+	if tool == ContainerToolbox {
+		hostExec = "flatpak-spawn --host"
+	}
+	return "#!/bin/sh\nexec " + hostExec + " " + binary + " \"$@\"\n"
+}
+
+// RunInContainer provisions the environment inside the --container
+// toolbox or distrobox container so the host stays clean: it creates the
+// container when missing, installs git and an editor CLI forwarding to
+// the editor of the host in it, then runs ws-config-gen with args there.
+// The home directory is shared with the container, so the base directory
+// has the same path inside and outside. It returns the exit code of the
+// run in the container.
+func (s *Setup) RunInContainer(args []string) (int, error) {
+	name := s.opts.Container
+	if !validContainerName.MatchString(name) {
+		return 0, ValidationErrorf("invalid container name '%s'", name)
+	}
+	tool, err := s.containerTool()
+	if err != nil {
+		return 0, err
+	}
+	config, err := s.loadEffectiveConfig()
+	if err != nil {
+		return 0, err
+	}
+	editor, err := s.resolveEditor(config)
+	if err != nil {
+		return 0, err
+	}
+
+	s.printSection("Preparing " + tool + " container " + name + "...")
+	exists, err := s.containerExists(tool, name)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		create := Command{Name: tool, Args: []string{"--assumeyes", "create", name}}
+		if tool == ContainerDistrobox {
+			create.Args = []string{"create", "--yes", "--name", name}
+		}
+		if err := s.runContainerStep(create); err != nil {
+			return 0, fmt.Errorf("failed to create container %s: %w", name, err)
+		}
+		fmt.Fprintf(s.Out, "  Created container %s\n", name)
+	}
+
+	if err := s.runContainerStep(containerCommand(tool, name, "sh", "-c", installGitScript)); err != nil {
+		return 0, fmt.Errorf("failed to install git in container %s: %w", name, err)
+	}
+	wrapper := "/usr/local/bin/" + editor.Binary
+	install := fmt.Sprintf("command -v %s >/dev/null || { printf '%%s' %s | sudo tee %s >/dev/null && sudo chmod 755 %s; }",
+		editor.Binary, shellQuote(editorWrapper(tool, editor.Binary)), wrapper, wrapper)
+	if err := s.runContainerStep(containerCommand(tool, name, "sh", "-c", install)); err != nil {
+		return 0, fmt.Errorf("failed to install the %s CLI in container %s: %w", editor.Binary, name, err)
+	}
+	fmt.Fprintf(s.Out, "  git and the %s CLI are installed\n", editor.Binary)
+
+	// The home directory is shared, the rest of the host is below /run/host
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("failed to locate the ws-config-gen binary: %w", err)
+	}
+	if home, err := s.Users.HomeDir(); err != nil || !isInside(exe, home) {
+		exe = filepath.Join("/run/host", exe)
+	}
+
+	s.printSection("Running setup in container " + name + "...")
+	run := containerCommand(tool, name, append([]string{exe, "--container-tool", tool}, args...)...)
+	run.Stdin, run.Stdout, run.Stderr = s.In, s.Out, s.Err
+	err = s.runCommand(context.Background(), run)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// The run in the container reported its error itself
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to run setup in container %s: %w", name, err)
+	}
+	return exitOK, nil
+}
+
+// runContainerStep runs a preparation command of RunInContainer
+func (s *Setup) runContainerStep(cmd Command) error {
+	ctx, cancel := context.WithTimeout(context.Background(), toolboxTimeout)
+	defer cancel()
+	return s.runCommand(ctx, cmd)
+}
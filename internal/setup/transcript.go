@@ -0,0 +1,97 @@
+package setup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// commandErrorTail is the number of stderr lines added to the error of a
+// failed command
+const commandErrorTail = 3
+
+// CommandError is a failed external command with the tail of its stderr
+type CommandError struct {
+	Err    error
+	Stderr string // Complete stderr output
+}
+
+func (e *CommandError) Error() string {
+	if tail := stderrTail(e.Stderr, commandErrorTail); tail != "" {
+		return e.Err.Error() + ": " + tail
+	}
+	return e.Err.Error()
+}
+
+func (e *CommandError) Unwrap() error { return e.Err }
+
+// stderrTail returns the last n non-empty lines of stderr joined with
+// "; ", git progress lines ending with carriage returns are split too
+func stderrTail(stderr string, n int) string {
+	var lines []string
+	for _, line := range strings.FieldsFunc(stderr, func(r rune) bool { return r == '\n' || r == '\r' }) {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "; ")
+}
+
+// runCommand runs cmd with the Runner, writes the command and its
+// complete output to the transcript and returns a CommandError with the
+// stderr tail on failure. Output is still passed to cmd.Stdout and
+// cmd.Stderr when they are set.
+func (s *Setup) runCommand(ctx context.Context, cmd Command) error {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = teeWriter(&stdout, cmd.Stdout)
+	cmd.Stderr = teeWriter(&stderr, cmd.Stderr)
+
+	start := time.Now()
+	err := s.Runner.Run(ctx, cmd)
+	s.writeTranscript(cmd, stdout.String(), stderr.String(), time.Since(start), err)
+
+	if err != nil {
+		return &CommandError{Err: err, Stderr: stderr.String()}
+	}
+	return nil
+}
+
+// teeWriter returns a writer copying to buf and w, buf alone when w is nil
+func teeWriter(buf *bytes.Buffer, w io.Writer) io.Writer {
+	if w == nil {
+		return buf
+	}
+	return io.MultiWriter(buf, w)
+}
+
+// writeTranscript records a finished command in the transcript, the
+// environment is left out as it may hold access tokens
+func (s *Setup) writeTranscript(cmd Command, stdout, stderr string, elapsed time.Duration, err error) {
+	if s.Transcript == nil {
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "$ %s %s\n", cmd.Name, strings.Join(cmd.Args, " "))
+	if cmd.Dir != "" {
+		fmt.Fprintf(&b, "  dir: %s\n", cmd.Dir)
+	}
+	for _, output := range []struct{ name, text string }{{"stdout", stdout}, {"stderr", stderr}} {
+		if text := strings.TrimRight(output.text, "\n"); text != "" {
+			fmt.Fprintf(&b, "  %s:\n    %s\n", output.name, strings.ReplaceAll(text, "\n", "\n    "))
+		}
+	}
+	result := "ok"
+	if err != nil {
+		result = err.Error()
+	}
+	fmt.Fprintf(&b, "  result: %s in %s\n", result, elapsed.Round(time.Millisecond))
+
+	io.WriteString(s.Transcript, b.String())
+}
@@ -0,0 +1,219 @@
+package setup
+
+import (
+	"cmp"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Verify checks that the environment on disk matches the config: all
+// directories exist, repositories have the configured remote URL and
+// checked out ref, and the workspace file lists exactly the configured
+// folders. All mismatches are reported before failing.
+func (s *Setup) Verify() error {
+	config, err := s.loadEffectiveConfig()
+	if err != nil {
+		return err
+	}
+	if err := s.configureTimeouts(config); err != nil {
+		return err
+	}
+
+	workDir, err := s.validateWorkingDirectory(s.opts.WorkDir)
+	if err != nil {
+		return err
+	}
+	baseDir, err := s.resolveBaseDirectory(s.opts.BaseDir, workDir)
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	report := func(format string, args ...any) {
+		msg := fmt.Sprintf(format, args...)
+		fmt.Fprintf(s.Out, "  %s\n", msg)
+		problems = append(problems, msg)
+	}
+
+	s.printSection("Checking directories...")
+	dirs := environmentDirectories(baseDir, config)
+	for _, repo := range config.Repos {
+		dirs = append(dirs, repoPath(baseDir, repo))
+	}
+	for _, dir := range dirs {
+		if info, err := s.FS.Stat(dir); err != nil || !info.IsDir() {
+			report("missing directory %s", dir)
+		}
+	}
+
+	if s.opts.StrictPerms {
+		s.printSection("Checking permissions...")
+		s.fixDirPermissions(permissionDirs(baseDir, config), report)
+	}
+
+	s.printSection("Checking repositories...")
+	repos := config.Repos
+	if !hasRepo(config, "stai-temp") {
+		repos = append(slices.Clone(repos), staiTempRepo(config))
+	}
+	for _, repo := range repos {
+		if s.exists(repoPath(baseDir, repo)) {
+			s.verifyRepo(repoPath(baseDir, repo), repo, report)
+		}
+	}
+
+	s.printSection("Checking workspace file...")
+	if err := s.verifyWorkspace(baseDir, config, report); err != nil {
+		return err
+	}
+
+	if len(problems) > 0 {
+		return ValidationErrorf("environment does not match the config: %d problems found", len(problems))
+	}
+
+	s.PrintDone("Environment matches the config")
+	return nil
+}
+
+// verifyRepo reports a repository without the configured remote URLs or
+// with another ref checked out. Cloned repositories are expected on the
+// default branch of origin, worktrees on their branch and locally
+// initialized repositories on their default branch.
+func (s *Setup) verifyRepo(repoDir string, repo Repository, report func(string, ...any)) {
+	switch repo.Type {
+	case "git-repo":
+		gitDir := gitRepoDir(repoDir, repo)
+		s.verifyRemote(gitDir, repo.Name, remoteOrigin, *repo.GitRepo, report)
+		if repo.Upstream != "" {
+			s.verifyRemote(gitDir, repo.Name, remoteUpstream, repo.Upstream, report)
+		}
+		for _, remote := range remoteNames(repo) {
+			s.verifyRemote(gitDir, repo.Name, remote, repo.Remotes[remote], report)
+		}
+
+		if len(repo.Worktrees) > 0 {
+			for i, dir := range worktreeDirs(repoDir, repo) {
+				s.verifyBranch(dir, repo.Name+" worktree "+repo.Worktrees[i], repo.Worktrees[i], report)
+			}
+			return
+		}
+
+		remoteHead, err := s.gitOutput(opInit, repoDir, "symbolic-ref", "--short", "refs/remotes/origin/HEAD")
+		if err != nil {
+			report("%s: default branch of origin is unknown, run 'git remote set-head origin --auto'", repo.Name)
+			return
+		}
+		s.verifyBranch(repoDir, repo.Name, strings.TrimPrefix(remoteHead, "origin/"), report)
+	case "local-git-repo":
+		branch := repo.DefaultBranch
+		if branch == "" {
+			branch = defaultInitBranch
+		}
+		s.verifyBranch(repoDir, repo.Name, branch, report)
+	}
+}
+
+// verifyRemote reports a missing remote or one with another URL
+func (s *Setup) verifyRemote(gitDir, name, remote, expected string, report func(string, ...any)) {
+	if url, err := s.gitOutput(opInit, gitDir, "remote", "get-url", remote); err != nil {
+		report("%s: no %s remote", name, remote)
+	} else if url != expected {
+		report("%s: %s is %s, expected %s", name, remote, url, expected)
+	}
+}
+
+// verifyBranch reports a checkout in dir not on branch
+func (s *Setup) verifyBranch(dir, what, branch string, report func(string, ...any)) {
+	if !s.exists(dir) {
+		report("%s: missing checkout %s", what, dir)
+		return
+	}
+
+	head, err := s.gitOutput(opInit, dir, "symbolic-ref", "--short", "HEAD")
+	switch {
+	case err != nil:
+		report("%s: HEAD is detached, expected branch %s", what, branch)
+	case head != branch:
+		report("%s: branch %s is checked out, expected %s", what, head, branch)
+	}
+}
+
+// verifyProvenance prints which tool version generated the workspace file
+// and when, and reports a file generated from another config
+func (s *Setup) verifyProvenance(data []byte, config *Config, report func(string, ...any)) error {
+	header, _, ok := splitProvenance(data)
+	if !ok {
+		fmt.Fprintln(s.Out, "  Workspace file has no provenance header, it was generated by an older ws-config-gen")
+		return nil
+	}
+	fmt.Fprintf(s.Out, "  Workspace file generated by ws-config-gen %s at %s\n", header.Version, header.Generated)
+
+	current, err := s.workspaceProvenance(config)
+	if err != nil {
+		return err
+	}
+	if header.ConfigSHA256 != current.ConfigSHA256 {
+		report("workspace file was generated from another config (sha256 %s), run setup to regenerate it", shortSHA(header.ConfigSHA256))
+	}
+	return nil
+}
+
+// verifyWorkspace reports configured folders missing from the workspace
+// file, folders not in the config and folders out of order
+func (s *Setup) verifyWorkspace(baseDir string, config *Config, report func(string, ...any)) error {
+	pathsMode, err := s.folderPathsMode(config)
+	if err != nil {
+		return err
+	}
+	sortMode, err := folderSortMode(config)
+	if err != nil {
+		return err
+	}
+
+	var expected []string
+	for _, repo := range folderOrder(workspaceRepos(config), sortMode) {
+		for _, folder := range repoFolders(baseDir, repo, pathsMode) {
+			expected = append(expected, cmp.Or(folder.Path, folder.URI))
+		}
+	}
+
+	workspacePath := workspaceFilePath(baseDir)
+	data, err := s.readOptional(workspacePath)
+	if err != nil {
+		return fmt.Errorf("failed to read workspace file: %w", err)
+	}
+	if data == nil {
+		report("missing workspace file %s", workspacePath)
+		return nil
+	}
+	if err := s.verifyProvenance(data, config, report); err != nil {
+		return err
+	}
+	workspace, err := parseWorkspaceObject(data)
+	if err != nil {
+		report("workspace file %s is not valid: %v", filepath.Base(workspacePath), err)
+		return nil
+	}
+
+	actual := workspaceFolderPaths(workspace)
+
+	differs := false
+	for _, path := range expected {
+		if !slices.Contains(actual, path) {
+			report("workspace folder %s is missing", path)
+			differs = true
+		}
+	}
+	for _, path := range actual {
+		if !slices.Contains(expected, path) {
+			report("workspace folder %s is not in the config", path)
+			differs = true
+		}
+	}
+	if !differs && !slices.Equal(actual, expected) {
+		report("workspace folders are not in the configured order")
+	}
+	return nil
+}
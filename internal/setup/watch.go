@@ -0,0 +1,135 @@
+package setup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mj41/stai-vscode/internal/diff"
+)
+
+// watchInterval is how often --watch checks the config files for changes
+const watchInterval = time.Second
+
+// Watch regenerates the workspace file whenever a config file changes,
+// printing the diff of each update, until interrupted. Repositories are
+// not cloned, run setup for new ones.
+func (s *Setup) Watch() error {
+	config, err := s.loadEffectiveConfig()
+	if err != nil {
+		return err
+	}
+	workDir, err := s.validateWorkingDirectory(s.opts.WorkDir)
+	if err != nil {
+		return err
+	}
+	baseDir, err := s.resolveBaseDirectory(s.opts.BaseDir, workDir)
+	if err != nil {
+		return err
+	}
+
+	files := s.watchedFiles()
+	if isRemoteConfig(s.opts.Config) {
+		fmt.Fprintf(s.Out, "Remote config %s is not watched\n", s.opts.Config)
+	}
+	fmt.Fprintf(s.Out, "Watching %s, press Ctrl+C to stop\n", strings.Join(files, ", "))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	stamps := s.fileStamps(files)
+	if err := s.regenerateWorkspace(baseDir, config); err != nil {
+		s.PrintError(err)
+	}
+
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Fprintln(s.Out, "Stopped watching")
+			return nil
+		case <-ticker.C:
+		}
+
+		current := s.fileStamps(files)
+		if current == stamps {
+			continue
+		}
+		stamps = current
+
+		s.printSection("Config changed, regenerating workspace file...")
+		config, err := s.loadEffectiveConfig()
+		if err == nil {
+			err = s.regenerateWorkspace(baseDir, config)
+		}
+		if err != nil {
+			// Keep watching, the next save may fix the config
+			s.PrintError(err)
+		}
+	}
+}
+
+// watchedFiles returns the local config files of all layers, missing
+// ones too as creating them changes the config
+func (s *Setup) watchedFiles() []string {
+	files := []string{systemConfigPath}
+	if path, err := s.userConfigPath(); err == nil {
+		files = append(files, path)
+	}
+	if s.opts.Config != "" && !isRemoteConfig(s.opts.Config) {
+		files = append(files, s.opts.Config)
+	}
+	return files
+}
+
+// fileStamps returns the modification times and sizes of files as one
+// comparable key, missing files have no stamp
+func (s *Setup) fileStamps(files []string) string {
+	var b strings.Builder
+	for _, path := range files {
+		b.WriteString(path)
+		if info, err := s.FS.Stat(path); err == nil {
+			fmt.Fprintf(&b, " %d %d", info.ModTime().UnixNano(), info.Size())
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// regenerateWorkspace prints the diff of the workspace file for config
+// and writes it
+func (s *Setup) regenerateWorkspace(baseDir string, config *Config) error {
+	s.started = time.Now()
+	plan, err := s.planWorkspace(baseDir, config)
+	if err != nil {
+		return err
+	}
+
+	oldName := plan.Path
+	if plan.Existing == nil {
+		oldName = "/dev/null"
+	}
+	out := diff.Unified(oldName, plan.Path, string(plan.Existing), string(plan.Output), diff.DefaultContext)
+	if out == "" {
+		fmt.Fprintln(s.Out, "  Workspace file is up to date")
+		return nil
+	}
+	fmt.Fprint(s.Out, out)
+
+	release, err := s.acquireLock(baseDir)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if err := s.generateWorkspace(baseDir, config); err != nil {
+		return err
+	}
+	s.PrintDone("Workspace file updated")
+	return nil
+}
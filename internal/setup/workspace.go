@@ -0,0 +1,500 @@
+package setup
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/mj41/stai-vscode/internal/jsonc"
+	"github.com/mj41/stai-vscode/internal/version"
+)
+
+// TemplateData contains data for template processing
+type TemplateData struct {
+	FolderList  []FolderEntry // workspace folders in order
+	BaseWorkDir string
+	Editor      string // editor binary, e.g. code-insiders
+	// Command launching the editor, e.g. flatpak run com.visualstudio.code
+	EditorCommand string
+	EditorUser    string // editor user settings directory
+	// --container the environment is set up in and its tool, empty
+	// outside container mode
+	Container     string
+	ContainerTool string
+	TemplateInfo
+}
+
+// TemplateInfo is the data shared by the workspace, readme and seed file
+// templates
+type TemplateInfo struct {
+	Repos     []TemplateRepo // repositories set up, in config order
+	User      string         // expected user
+	Hostname  string
+	Version   string    // ws-config-gen version
+	Generated time.Time // start of the run, the output changes on every run when used
+}
+
+// TemplateRepo describes a repository to templates
+type TemplateRepo struct {
+	Name        string
+	Type        string
+	DisplayName string
+	GitRepo     string // clone URL, empty for local repositories
+	Upstream    string
+	Dir         string // absolute repository directory
+}
+
+// templateInfo returns the shared template data of config
+func (s *Setup) templateInfo(baseDir string, config *Config) TemplateInfo {
+	hostname, _ := os.Hostname()
+	info := TemplateInfo{
+		User:      s.expectedUser(config),
+		Hostname:  hostname,
+		Version:   version.Version,
+		Generated: s.started,
+	}
+	for _, repo := range config.Repos {
+		r := TemplateRepo{
+			Name:        repo.Name,
+			Type:        repo.Type,
+			DisplayName: repo.DisplayName,
+			Upstream:    repo.Upstream,
+			Dir:         repoPath(baseDir, repo),
+		}
+		if repo.GitRepo != nil {
+			r.GitRepo = *repo.GitRepo
+		}
+		info.Repos = append(info.Repos, r)
+	}
+	return info
+}
+
+// FolderEntry represents a folder in the VS Code workspace with the
+// fields of its folders schema
+type FolderEntry struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+	URI  string `json:"uri,omitempty"` // Remote folders have a URI instead of a path
+	// The entry of the folder and its folder-settings, for templates only:
+	// VS Code reads folder settings from .vscode/settings.json of the
+	// folder, not from the workspace file
+	Repo     string                     `json:"-"`
+	Settings map[string]json.RawMessage `json:"-"`
+}
+
+// workspaceFilePath returns the generated workspace file location
+func workspaceFilePath(baseDir string) string {
+	return filepath.Join(baseDir, "vscode", "stai-all.code-workspace")
+}
+
+// renderWorkspace renders the workspace file content from the template
+func (s *Setup) renderWorkspace(baseDir string, config *Config) ([]byte, error) {
+	// Use embedded workspace template
+	tmpl, err := template.New("workspace").Parse(s.assets.WorkspaceTemplate)
+	if err != nil {
+		return nil, templateErrorf("failed to parse workspace template: %w", err)
+	}
+
+	pathsMode, err := s.folderPathsMode(config)
+	if err != nil {
+		return nil, err
+	}
+
+	sortMode, err := folderSortMode(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Generate folders
+	folders := []FolderEntry{}
+	for _, repo := range folderOrder(workspaceRepos(config), sortMode) {
+		folders = append(folders, repoFolders(baseDir, repo, pathsMode)...)
+	}
+
+	goWork, err := s.goWorkSettings(baseDir, config)
+	if err != nil {
+		return nil, err
+	}
+	presetConfig := s.withDetectedPresets(baseDir, config)
+	repoSettings, err := s.repoSettings(presetConfig, goWork)
+	if err != nil {
+		return nil, err
+	}
+
+	editor, err := s.resolveEditor(config)
+	if err != nil {
+		return nil, err
+	}
+
+	extensions, err := s.presetExtensions(presetConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	tasks, err := s.servicesTasks(baseDir, config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Prepare template data
+	data := TemplateData{
+		FolderList:    folders,
+		BaseWorkDir:   baseDir,
+		Editor:        editor.Binary,
+		EditorCommand: editor.launch(),
+		EditorUser:    s.userSettingsDir(editor),
+		TemplateInfo:  s.templateInfo(baseDir, config),
+	}
+	if s.opts.Container != "" {
+		data.Container = s.opts.Container
+		data.ContainerTool = containerToolInside(s.opts.ContainerTool)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, templateErrorf("failed to execute workspace template: %w", err)
+	}
+
+	workspace, err := parseRenderedWorkspace(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	// The template provides the defaults, the generated sections and
+	// those of the config are merged over them
+	sections := []workspaceSection{{"folders", folders, true}}
+	if len(extensions) > 0 {
+		sections = append(sections, workspaceSection{"extensions", map[string][]string{"recommendations": extensions}, false})
+	}
+	if tasks != nil {
+		sections = append(sections, workspaceSection{"tasks", tasks, false})
+	}
+	sections = append(sections, config.Workspace.entries()...)
+	if err := buildWorkspace(workspace, repoSettings, sections); err != nil {
+		return nil, err
+	}
+
+	return append(workspace.Marshal(), '\n'), nil
+}
+
+// parseRenderedWorkspace parses the rendered workspace file as a JSONC
+// object, naming the line of a syntax error
+func parseRenderedWorkspace(content []byte) (*jsonc.Value, error) {
+	workspace, err := parseWorkspaceObject(content)
+	if err == nil {
+		return workspace, nil
+	}
+
+	var syntaxErr *jsonc.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		lines := strings.Split(string(content), "\n")
+		text := ""
+		if syntaxErr.Line >= 1 && syntaxErr.Line <= len(lines) {
+			text = strings.TrimSpace(lines[syntaxErr.Line-1])
+		}
+		return nil, templateErrorf("generated workspace file is not valid JSON at line %d (%s): %s. Check the workspace template and repository settings", syntaxErr.Line, text, syntaxErr.Msg)
+	}
+	return nil, templateErrorf("generated workspace file is not a valid workspace: %w", err)
+}
+
+// repoFolders returns the workspace folders of a repository, one per
+// worktree for worktrees repositories, pointing to its subdir when set
+func repoFolders(baseDir string, repo Repository, pathsMode string) []FolderEntry {
+	if repo.Type == repoTypeRemote {
+		return []FolderEntry{remoteFolder(repo)}
+	}
+	folderPath := func(dir string) string {
+		dir = filepath.Join(dir, filepath.FromSlash(repo.Subdir))
+		if pathsMode == FolderPathsAbsolute {
+			return dir
+		}
+		rel, _ := filepath.Rel(filepath.Join(baseDir, "vscode"), dir)
+		return filepath.ToSlash(rel)
+	}
+
+	repoDir := repoPath(baseDir, repo)
+	if len(repo.Worktrees) == 0 {
+		return []FolderEntry{{Name: repo.DisplayName, Path: folderPath(repoDir), Repo: repo.Name, Settings: repo.FolderSettings}}
+	}
+
+	name := repo.DisplayName
+	if name == "" {
+		name = repo.Name
+	}
+	var folders []FolderEntry
+	for i, dir := range worktreeDirs(repoDir, repo) {
+		folders = append(folders, FolderEntry{
+			Name:     fmt.Sprintf("%s (%s)", name, repo.Worktrees[i]),
+			Path:     folderPath(dir),
+			Repo:     repo.Name,
+			Settings: repo.FolderSettings,
+		})
+	}
+	return folders
+}
+
+// workspaceRepos returns the entries with workspace folders, checkouts
+// and remote folders
+func workspaceRepos(config *Config) []Repository {
+	return append(slices.Clone(config.Repos), config.RemoteFolders...)
+}
+
+// folderOrder returns repos in workspace folder order: pinned repos by
+// 'order', then the others in config order or sorted by name
+func folderOrder(repos []Repository, sortMode string) []Repository {
+	sorted := slices.Clone(repos)
+	slices.SortStableFunc(sorted, func(a, b Repository) int {
+		switch {
+		case a.Order != nil && b.Order != nil:
+			return cmp.Compare(*a.Order, *b.Order)
+		case a.Order != nil:
+			return -1
+		case b.Order != nil:
+			return 1
+		case sortMode == folderSortName:
+			return strings.Compare(a.Name, b.Name)
+		default:
+			return 0
+		}
+	})
+	return sorted
+}
+
+// repoSettings merges generated defaults such as the go.work settings,
+// preset and per-repo settings, later repos win on conflicts and repo
+// settings override defaults and presets. They replace the settings of
+// the template with the same key.
+func (s *Setup) repoSettings(config *Config, defaults map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+	merged := map[string]json.RawMessage{}
+	owner := map[string]string{}
+	preset := map[string]bool{} // settings set by a default or preset only
+	for key, value := range defaults {
+		merged[key] = value
+		owner[key] = "generated defaults"
+		preset[key] = true
+	}
+
+	// Presets provide defaults, applied once each before repo settings
+	applied := map[string]bool{}
+	for _, repo := range config.Repos {
+		for _, name := range repo.Presets {
+			if applied[name] {
+				continue
+			}
+			applied[name] = true
+			p, err := s.preset(name)
+			if err != nil {
+				return nil, err
+			}
+			for key, value := range p.Settings {
+				if prev, ok := merged[key]; ok && !bytes.Equal(prev, value) {
+					s.Warnf("Setting '%s' of preset %s overrides the value from %s", key, name, owner[key])
+				}
+				merged[key] = value
+				owner[key] = "preset " + name
+				preset[key] = true
+			}
+		}
+	}
+
+	for _, repo := range config.Repos {
+		for key, value := range repo.Settings {
+			if prev, ok := merged[key]; ok && !preset[key] && !bytes.Equal(prev, value) {
+				s.Warnf("Setting '%s' of %s overrides the value from %s", key, repo.Name, owner[key])
+			}
+			merged[key] = value
+			owner[key] = repo.Name
+			preset[key] = false
+		}
+	}
+
+	for key, value := range merged {
+		if !json.Valid(value) {
+			return nil, configErrorf("invalid value of setting '%s' from %s", key, owner[key])
+		}
+	}
+	return merged, nil
+}
+
+func (s *Setup) generateWorkspace(baseDir string, config *Config) error {
+	plan, err := s.planWorkspace(baseDir, config)
+	if err != nil {
+		return err
+	}
+	if plan.Merged {
+		fmt.Fprintln(s.Out, "Merging user edits of the existing workspace file")
+	}
+
+	switch {
+	case plan.Existing == nil:
+		s.summary.Workspace = workspaceCreated
+	case bytes.Equal(plan.Existing, plan.Output):
+		s.summary.Workspace = workspaceUnchanged
+	default:
+		s.summary.Workspace = workspaceUpdated
+	}
+
+	// An unchanged file is neither backed up nor written, so it gets no
+	// audit entry either
+	if s.summary.Workspace != workspaceUnchanged {
+		if plan.Existing != nil && !s.opts.NoBackup {
+			keep, err := s.backupKeep(config)
+			if err != nil {
+				return err
+			}
+			if err := s.backupWorkspace(baseDir, plan.Path, plan.Existing, keep); err != nil {
+				return err
+			}
+		}
+
+		// Generate workspace file
+		if err := s.FS.WriteFile(plan.Path, plan.Output, s.perms.files); err != nil {
+			return fmt.Errorf("failed to write workspace file: %w", err)
+		}
+	}
+
+	// Remember the generated content to detect user edits next time
+	if !bytes.Equal(plan.Base, plan.Generated) {
+		basePath := generatedBasePath(plan.Path)
+		if err := s.FS.WriteFile(basePath, plan.Generated, s.perms.files); err != nil {
+			return fmt.Errorf("failed to write %s: %w", basePath, err)
+		}
+	}
+
+	if err := s.writeFolderSettings(baseDir, config); err != nil {
+		return err
+	}
+	if err := s.writeGoWork(baseDir, config); err != nil {
+		return err
+	}
+	return s.writeServices(baseDir, config)
+}
+
+// workspacePlan describes what generateWorkspace would write
+type workspacePlan struct {
+	Path      string
+	Existing  []byte // Current file content, nil when missing
+	Base      []byte // Generated content of the last run, nil when missing
+	Generated []byte // Freshly rendered content without provenance header
+	Output    []byte // Content to write, Generated merged with user edits
+	Merged    bool
+}
+
+// planWorkspace renders the workspace file and merges user edits of the
+// existing file without writing anything
+func (s *Setup) planWorkspace(baseDir string, config *Config) (*workspacePlan, error) {
+	content, err := s.renderWorkspace(baseDir, config)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := s.workspaceProvenance(config)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &workspacePlan{
+		Path:      workspaceFilePath(baseDir),
+		Generated: content,
+	}
+
+	plan.Existing, err = s.readOptional(plan.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read existing workspace file: %w", err)
+	}
+	basePath := generatedBasePath(plan.Path)
+	plan.Base, err = s.readOptional(basePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", basePath, err)
+	}
+
+	// Keep user edits of an existing workspace file, the provenance
+	// headers are not part of the compared content
+	output := content
+	if !s.opts.OverwriteWorkspace {
+		_, existing, _ := splitProvenance(plan.Existing)
+		_, base, _ := splitProvenance(plan.Base)
+
+		if plan.Existing != nil && !bytes.Equal(existing, base) && !bytes.Equal(existing, content) {
+			output, err = mergeWorkspace(existing, base, content)
+			if err != nil {
+				return nil, fmt.Errorf("%w. Use --overwrite-workspace to replace the file", err)
+			}
+			plan.Merged = true
+		}
+	}
+	plan.Output = withProvenance(header, output, plan.Existing)
+
+	return plan, nil
+}
+
+// Default number of kept workspace file backups
+const defaultBackupKeep = 10
+
+// backupTimeFormat sorts lexicographically in chronological order, the
+// nanoseconds keep backups of runs within the same second apart
+const backupTimeFormat = "20060102-150405.000000000"
+
+// backupWorkspace copies the existing workspace file to backups/<name>.<timestamp>
+// in the environment state directory and prunes old backups
+func (s *Setup) backupWorkspace(baseDir, workspacePath string, existing []byte, keep int) error {
+	backupDir, err := s.backupDir(baseDir)
+	if err != nil {
+		return err
+	}
+	if err := s.FS.MkdirAll(backupDir, s.perms.dirs); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	name := filepath.Base(workspacePath)
+	backupPath := filepath.Join(backupDir, name+"."+time.Now().Format(backupTimeFormat))
+	if err := s.FS.WriteFile(backupPath, existing, s.perms.files); err != nil {
+		return fmt.Errorf("failed to write workspace backup: %w", err)
+	}
+	fmt.Fprintf(s.Out, "Saved workspace file backup %s\n", backupPath)
+
+	entries, err := s.FS.ReadDir(backupDir)
+	if err != nil {
+		return fmt.Errorf("failed to list workspace backups: %w", err)
+	}
+	var backups []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), name+".") {
+			backups = append(backups, filepath.Join(backupDir, entry.Name()))
+		}
+	}
+	sort.Strings(backups)
+	for len(backups) > keep {
+		if err := s.FS.Remove(backups[0]); err != nil {
+			return fmt.Errorf("failed to remove old workspace backup: %w", err)
+		}
+		backups = backups[1:]
+	}
+
+	return nil
+}
+
+// backupKeep returns how many backups to keep, the --backup-keep flag
+// takes precedence over the config 'backup-keep' field
+func (s *Setup) backupKeep(config *Config) (int, error) {
+	keep := defaultBackupKeep
+	if config.BackupKeep != nil {
+		keep = *config.BackupKeep
+	}
+	if s.opts.BackupKeep >= 0 {
+		keep = s.opts.BackupKeep
+	}
+	if keep < 1 {
+		return 0, configErrorf("backup-keep must be at least 1, got %d. Use --no-backup to disable backups", keep)
+	}
+	return keep, nil
+}
@@ -0,0 +1,138 @@
+package setup
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+
+	"github.com/mj41/stai-vscode/internal/jsonc"
+)
+
+// WorkspaceSections are .code-workspace sections given in the config,
+// merged into the generated workspace file
+type WorkspaceSections struct {
+	// Recommended extensions, e.g. {"recommendations": ["golang.go"]}
+	Extensions json.RawMessage `json:"extensions,omitempty"`
+	// Launch configurations, e.g. {"version": "0.2.0", "configurations": []}
+	Launch json.RawMessage `json:"launch,omitempty"`
+	// Tasks, e.g. {"version": "2.0.0", "tasks": []}
+	Tasks json.RawMessage `json:"tasks,omitempty"`
+}
+
+// workspaceSection is a top level section of the workspace document
+type workspaceSection struct {
+	key   string
+	value any  // Marshaled to JSON
+	reset bool // Replaces the section of the template instead of merging
+}
+
+// entries returns the sections given in the config
+func (w *WorkspaceSections) entries() []workspaceSection {
+	if w == nil {
+		return nil
+	}
+	var sections []workspaceSection
+	for _, section := range []struct {
+		key string
+		raw json.RawMessage
+	}{{"extensions", w.Extensions}, {"launch", w.Launch}, {"tasks", w.Tasks}} {
+		if section.raw != nil {
+			sections = append(sections, workspaceSection{key: section.key, value: section.raw})
+		}
+	}
+	return sections
+}
+
+// validateWorkspaceSections checks that the workspace sections of the
+// config are JSON objects
+func validateWorkspaceSections(config *Config) error {
+	for _, section := range config.Workspace.entries() {
+		if value, err := toJSONC(section.value); err != nil || value.Kind != jsonc.Object {
+			return configErrorf("workspace %s must be a JSON object as in a .code-workspace file", section.key)
+		}
+	}
+	return nil
+}
+
+// configExtensions returns the recommended extensions of the workspace
+// sections of the config
+func configExtensions(config *Config) []string {
+	if config.Workspace == nil || config.Workspace.Extensions == nil {
+		return nil
+	}
+	var extensions struct {
+		Recommendations []string `json:"recommendations"`
+	}
+	_ = json.Unmarshal(config.Workspace.Extensions, &extensions)
+	return extensions.Recommendations
+}
+
+// buildWorkspace completes the workspace document rendered from the
+// template: settings replace those with the same key, sections are merged
+// over the template's. Members keep their comments and position, new ones
+// are appended.
+func buildWorkspace(workspace *jsonc.Value, settings map[string]json.RawMessage, sections []workspaceSection) error {
+	target := memberValue(workspace, "settings")
+	if target == nil || target.Kind != jsonc.Object {
+		target = &jsonc.Value{Kind: jsonc.Object}
+		setMemberValue(workspace, "settings", target)
+	}
+	for _, key := range slices.Sorted(maps.Keys(settings)) {
+		value, err := jsonc.Parse(settings[key])
+		if err != nil {
+			return configErrorf("invalid value of setting '%s': %w", key, err)
+		}
+		setMemberValue(target, key, value)
+	}
+
+	for _, section := range sections {
+		value, err := toJSONC(section.value)
+		if err != nil {
+			return templateErrorf("failed to marshal workspace %s: %w", section.key, err)
+		}
+		if existing := memberValue(workspace, section.key); existing != nil && !section.reset {
+			value = mergeValue(existing, value)
+		}
+		setMemberValue(workspace, section.key, value)
+	}
+	return nil
+}
+
+// mergeValue merges src into dst: object members by key, array elements
+// appended unless already present, other values replaced by src
+func mergeValue(dst, src *jsonc.Value) *jsonc.Value {
+	if dst.Kind != src.Kind || src.Kind == jsonc.Scalar {
+		return src
+	}
+	for _, m := range src.Members {
+		if src.Kind == jsonc.Object {
+			if existing := dst.Get(m.Key); existing != nil {
+				existing.Value = mergeValue(existing.Value, m.Value)
+				continue
+			}
+		} else if slices.ContainsFunc(dst.Members, func(e *jsonc.Member) bool { return jsonc.Equal(e.Value, m.Value) }) {
+			continue
+		}
+		dst.Members = append(dst.Members, m)
+	}
+	return dst
+}
+
+// setMemberValue sets key of obj to value, keeping the comments of an
+// existing member
+func setMemberValue(obj *jsonc.Value, key string, value *jsonc.Value) {
+	if m := obj.Get(key); m != nil {
+		m.Value = value
+		return
+	}
+	obj.Members = append(obj.Members, &jsonc.Member{Key: key, Value: value})
+}
+
+// toJSONC returns value marshaled to JSON as a document value
+func toJSONC(value any) (*jsonc.Value, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return jsonc.Parse(data)
+}
@@ -0,0 +1,245 @@
+package setup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/mj41/stai-vscode/internal/jsonc"
+)
+
+// parseWorkspaceObject parses a JSONC object, comments and trailing
+// commas allowed in .code-workspace files are kept
+func parseWorkspaceObject(data []byte) (*jsonc.Value, error) {
+	v, err := jsonc.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if v.Kind != jsonc.Object {
+		return nil, errors.New("expected JSON object")
+	}
+	return v, nil
+}
+
+// workspaceFolderPaths returns the folder paths of a workspace file in
+// order, the URIs of remote folders included
+func workspaceFolderPaths(workspace *jsonc.Value) []string {
+	var paths []string
+	if folders := memberValue(workspace, "folders"); folders != nil {
+		for _, folder := range folders.Members {
+			path := memberValue(folder.Value, "path")
+			if path == nil {
+				path = memberValue(folder.Value, "uri")
+			}
+			if path != nil && path.Kind == jsonc.Scalar {
+				var value string
+				if json.Unmarshal(path.JSON(), &value) == nil {
+					paths = append(paths, value)
+				}
+			}
+		}
+	}
+	return paths
+}
+
+// workspaceSettings returns the settings of a workspace file as compact
+// JSON by key
+func workspaceSettings(workspace *jsonc.Value) map[string]json.RawMessage {
+	settings := memberValue(workspace, "settings")
+	if settings == nil || settings.Kind != jsonc.Object {
+		return nil
+	}
+	values := make(map[string]json.RawMessage, len(settings.Members))
+	for _, member := range settings.Members {
+		values[member.Key] = member.Value.JSON()
+	}
+	return values
+}
+
+// generatedBasePath returns where the last generated workspace content
+// is kept, it is the base for detecting user edits
+func generatedBasePath(workspacePath string) string {
+	return filepath.Join(filepath.Dir(workspacePath), "."+filepath.Base(workspacePath)+".generated")
+}
+
+// readOptional returns file content or nil when the file does not exist
+func (s *Setup) readOptional(path string) ([]byte, error) {
+	data, err := s.FS.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// mergeWorkspace merges user edits of the existing workspace file into the
+// newly generated content. Edits are what differs between the existing file
+// and the last generated content (base), without a base every existing key
+// or folder missing from the generated content counts as a user addition.
+// Top-level keys and settings are merged by key, folders by path. Comments
+// of the existing file are kept with the keys and folders they belong to.
+func mergeWorkspace(existing, base, generated []byte) ([]byte, error) {
+	current, err := parseWorkspaceObject(existing)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse existing workspace file: %w", err)
+	}
+	result, err := parseWorkspaceObject(generated)
+	if err != nil {
+		return nil, templateErrorf("failed to parse generated workspace: %w", err)
+	}
+	previous := &jsonc.Value{Kind: jsonc.Object}
+	if base != nil {
+		if parsed, err := parseWorkspaceObject(base); err == nil {
+			previous = parsed
+		}
+	}
+
+	for _, member := range current.Members {
+		switch member.Key {
+		case "settings":
+			merged, err := mergeSettings(member.Value, memberValue(previous, member.Key), memberValue(result, member.Key))
+			if err != nil {
+				return nil, err
+			}
+			result.Set(&jsonc.Member{Comments: member.Comments, Key: member.Key, Value: merged, LineComment: member.LineComment})
+		case "folders":
+			merged, err := mergeFolders(member.Value, memberValue(previous, member.Key), memberValue(result, member.Key))
+			if err != nil {
+				return nil, err
+			}
+			result.Set(&jsonc.Member{Comments: member.Comments, Key: member.Key, Value: merged, LineComment: member.LineComment})
+		default:
+			mergeMember(result, previous, member, base == nil)
+		}
+	}
+	keepEndComments(result, current)
+
+	return append(result.Marshal(), '\n'), nil
+}
+
+// memberValue returns the value of key in obj, nil when missing
+func memberValue(obj *jsonc.Value, key string) *jsonc.Value {
+	if m := obj.Get(key); m != nil {
+		return m.Value
+	}
+	return nil
+}
+
+// mergeMember keeps member of the existing object in result when the user
+// added or changed it. Without a base a differing value cannot be told
+// apart from a generator change, the generated value wins. Comments of a
+// kept generated member are taken over from the existing file.
+func mergeMember(result, base *jsonc.Value, member *jsonc.Member, noBase bool) {
+	generated := result.Get(member.Key)
+	if generated != nil && noBase || !userEdited(member.Value, base, member.Key) {
+		if generated != nil {
+			keepComments(generated, member)
+		}
+		return
+	}
+	result.Set(member)
+}
+
+// keepComments copies comments of the existing member to the generated one
+func keepComments(generated, existing *jsonc.Member) {
+	if len(existing.Comments) > 0 {
+		generated.Comments = existing.Comments
+	}
+	if existing.LineComment != "" {
+		generated.LineComment = existing.LineComment
+	}
+}
+
+// keepEndComments keeps comments after the last member and before the
+// document of the existing value
+func keepEndComments(result, existing *jsonc.Value) {
+	if len(existing.EndComments) > 0 {
+		result.EndComments = existing.EndComments
+	}
+	if len(existing.Comments) > 0 {
+		result.Comments = existing.Comments
+	}
+}
+
+// userEdited reports whether value of key was added or changed by the user
+func userEdited(value, base *jsonc.Value, key string) bool {
+	baseValue := memberValue(base, key)
+	return baseValue == nil || !jsonc.Equal(value, baseValue)
+}
+
+// mergeSettings keeps user added and user changed settings
+func mergeSettings(current, base, generated *jsonc.Value) (*jsonc.Value, error) {
+	if current.Kind != jsonc.Object {
+		return nil, errors.New("failed to parse existing workspace settings: expected JSON object")
+	}
+	result := &jsonc.Value{Kind: jsonc.Object}
+	if generated != nil {
+		if generated.Kind != jsonc.Object {
+			return nil, templateErrorf("failed to parse generated workspace settings: expected JSON object")
+		}
+		result = generated
+	}
+	noBase := base == nil
+	if noBase || base.Kind != jsonc.Object {
+		base = &jsonc.Value{Kind: jsonc.Object}
+	}
+
+	for _, member := range current.Members {
+		mergeMember(result, base, member, noBase)
+	}
+	keepEndComments(result, current)
+
+	return result, nil
+}
+
+// mergeFolders appends folders the user added to the generated folders,
+// comments of existing folders are kept
+func mergeFolders(current, base, generated *jsonc.Value) (*jsonc.Value, error) {
+	if current.Kind != jsonc.Array {
+		return nil, errors.New("failed to parse existing workspace folders: expected JSON array")
+	}
+	result := &jsonc.Value{Kind: jsonc.Array}
+	if generated != nil {
+		if generated.Kind != jsonc.Array {
+			return nil, templateErrorf("failed to parse generated workspace folders: expected JSON array")
+		}
+		result = generated
+	}
+
+	generatedFolders := map[string]*jsonc.Member{}
+	for _, folder := range result.Members {
+		generatedFolders[folderPath(folder)] = folder
+	}
+	known := map[string]bool{}
+	if base != nil && base.Kind == jsonc.Array {
+		for _, folder := range base.Members {
+			known[folderPath(folder)] = true
+		}
+	}
+
+	for _, folder := range current.Members {
+		path := folderPath(folder)
+		if generatedFolder, ok := generatedFolders[path]; ok {
+			keepComments(generatedFolder, folder)
+			continue
+		}
+		if !known[path] {
+			result.Members = append(result.Members, folder)
+		}
+	}
+	keepEndComments(result, current)
+
+	return result, nil
+}
+
+// folderPath returns the JSON of the path of a workspace folder element,
+// of the uri for remote folders
+func folderPath(folder *jsonc.Member) string {
+	for _, key := range []string{"path", "uri"} {
+		if path := memberValue(folder.Value, key); path != nil {
+			return key + ":" + string(path.JSON())
+		}
+	}
+	return ""
+}
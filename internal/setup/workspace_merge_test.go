@@ -0,0 +1,136 @@
+package setup
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mj41/stai-vscode/internal/jsonc"
+)
+
+func TestMergeWorkspace(t *testing.T) {
+	tests := []struct {
+		name      string
+		existing  string
+		base      string // Last generated content, empty for none
+		generated string
+		want      string // Compact JSON of the result
+	}{
+		{
+			name:      "user added setting is kept",
+			existing:  `{"settings": {"a": 1, "mine": true}}`,
+			base:      `{"settings": {"a": 1}}`,
+			generated: `{"settings": {"a": 1}}`,
+			want:      `{"settings":{"a":1,"mine":true}}`,
+		},
+		{
+			name:      "user changed setting wins",
+			existing:  `{"settings": {"a": 2}}`,
+			base:      `{"settings": {"a": 1}}`,
+			generated: `{"settings": {"a": 3}}`,
+			want:      `{"settings":{"a":2}}`,
+		},
+		{
+			name:      "generator change of unedited setting wins",
+			existing:  `{"settings": {"a": 1, "mine": true}}`,
+			base:      `{"settings": {"a": 1}}`,
+			generated: `{"settings": {"a": 3, "b": 4}}`,
+			want:      `{"settings":{"a":3,"b":4,"mine":true}}`,
+		},
+		{
+			name:      "generated value wins without base",
+			existing:  `{"settings": {"a": 2, "mine": true}}`,
+			generated: `{"settings": {"a": 3}}`,
+			want:      `{"settings":{"a":3,"mine":true}}`,
+		},
+		{
+			name:      "user added folder is appended",
+			existing:  `{"folders": [{"path": "../app"}, {"path": "/data"}]}`,
+			base:      `{"folders": [{"path": "../app"}]}`,
+			generated: `{"folders": [{"path": "../app"}, {"path": "../lib"}]}`,
+			want:      `{"folders":[{"path":"../app"},{"path":"../lib"},{"path":"/data"}]}`,
+		},
+		{
+			name:      "folder removed from config is dropped",
+			existing:  `{"folders": [{"path": "../app"}, {"path": "../old"}]}`,
+			base:      `{"folders": [{"path": "../app"}, {"path": "../old"}]}`,
+			generated: `{"folders": [{"path": "../app"}]}`,
+			want:      `{"folders":[{"path":"../app"}]}`,
+		},
+		{
+			name:      "remote folders are matched by uri",
+			existing:  `{"folders": [{"uri": "vscode-remote://ssh-remote+box/app", "name": "mine"}]}`,
+			base:      `{"folders": [{"uri": "vscode-remote://ssh-remote+box/app"}]}`,
+			generated: `{"folders": [{"uri": "vscode-remote://ssh-remote+box/app"}]}`,
+			want:      `{"folders":[{"uri":"vscode-remote://ssh-remote+box/app"}]}`,
+		},
+		{
+			name:      "user added top-level key is kept",
+			existing:  `{"settings": {}, "launch": {"configurations": []}}`,
+			base:      `{"settings": {}}`,
+			generated: `{"settings": {}}`,
+			want:      `{"settings":{},"launch":{"configurations":[]}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var base []byte
+			if tt.base != "" {
+				base = []byte(tt.base)
+			}
+			got, err := mergeWorkspace([]byte(tt.existing), base, []byte(tt.generated))
+			if err != nil {
+				t.Fatalf("mergeWorkspace: %v", err)
+			}
+			merged, err := jsonc.Parse(got)
+			if err != nil {
+				t.Fatalf("merged workspace is not valid JSONC: %v\n%s", err, got)
+			}
+			if json := string(merged.JSON()); json != tt.want {
+				t.Errorf("mergeWorkspace() = %s, want %s", json, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeWorkspaceKeepsComments(t *testing.T) {
+	existing := "// my workspace\n{\n\t\"settings\": {\n\t\t// generated\n\t\t\"a\": 1, // keep me\n\t\t\"mine\": true\n\t}\n}"
+	base := `{"settings": {"a": 1}}`
+	generated := `{"settings": {"a": 2}}`
+
+	got, err := mergeWorkspace([]byte(existing), []byte(base), []byte(generated))
+	if err != nil {
+		t.Fatalf("mergeWorkspace: %v", err)
+	}
+	for _, want := range []string{"// my workspace\n", "// generated\n", `"a": 2, // keep me`, `"mine": true`} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("merged workspace lacks %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestMergeWorkspaceErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		existing  string
+		generated string
+		template  bool // A template error is expected
+	}{
+		{"existing not JSON", `{"settings": `, `{}`, false},
+		{"existing not an object", `[]`, `{}`, false},
+		{"existing settings not an object", `{"settings": []}`, `{}`, false},
+		{"generated not JSON", `{}`, `{"folders": [}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := mergeWorkspace([]byte(tt.existing), nil, []byte(tt.generated))
+			if err == nil {
+				t.Fatal("mergeWorkspace succeeded, want an error")
+			}
+			var templateErr *TemplateError
+			if got := errors.As(err, &templateErr); got != tt.template {
+				t.Errorf("mergeWorkspace error %v: template error %t, want %t", err, got, tt.template)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package setup
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestGenerateWorkspace runs generateWorkspace three times on the fake
+// filesystem: create, merge a user edit and keep it on regeneration
+// without writing again
+func TestGenerateWorkspace(t *testing.T) {
+	ts := newTestSetup(t, Options{BackupKeep: -1}, Assets{
+		WorkspaceTemplate: "{\n\t\"folders\": [],\n\t\"settings\": {\n\t\t\"files.trimTrailingWhitespace\": true\n\t}\n}",
+	})
+	ts.runner.binaries = append(ts.runner.binaries, "code-insiders")
+	baseDir := "/home/stai/work-stai"
+	app := "https://example.com/app.git"
+	config := &Config{Repos: []Repository{{Name: "app", Type: "git-repo", GitRepo: &app}}}
+	path := workspaceFilePath(baseDir)
+
+	if err := ts.generateWorkspace(baseDir, config); err != nil {
+		t.Fatalf("generateWorkspace: %v", err)
+	}
+	if ts.summary.Workspace != workspaceCreated {
+		t.Errorf("workspace %s, want %s", ts.summary.Workspace, workspaceCreated)
+	}
+	created := ts.readFile(t, path)
+	if !strings.Contains(created, `"path": "../app"`) {
+		t.Fatalf("workspace lacks the app folder:\n%s", created)
+	}
+
+	// The user changes a generated setting and adds one
+	edited := strings.Replace(created, `"files.trimTrailingWhitespace": true`, "\"files.trimTrailingWhitespace\": false,\n\t\t\"editor.rulers\": [100] // mine", 1)
+	ts.writeFile(t, path, edited)
+
+	lib := "https://example.com/lib.git"
+	config.Repos = append(config.Repos, Repository{Name: "lib", Type: "git-repo", GitRepo: &lib})
+	ts.summary = newRunSummary()
+	if err := ts.generateWorkspace(baseDir, config); err != nil {
+		t.Fatalf("generateWorkspace: %v", err)
+	}
+	if ts.summary.Workspace != workspaceUpdated {
+		t.Errorf("workspace %s, want %s", ts.summary.Workspace, workspaceUpdated)
+	}
+	merged := ts.readFile(t, path)
+	for _, want := range []string{`"path": "../lib"`, `"files.trimTrailingWhitespace": false`, `"editor.rulers": [`, "// mine"} {
+		if !strings.Contains(merged, want) {
+			t.Errorf("merged workspace lacks %q:\n%s", want, merged)
+		}
+	}
+	backups, err := ts.FS.ReadDir(backupDirOf(t, ts, baseDir))
+	if err != nil || len(backups) != 1 {
+		t.Errorf("got backups %v (%v), want one", backups, err)
+	}
+
+	// Nothing is written, backed up or audited when nothing changed
+	auditPath, err := ts.auditPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	audited := ts.readFile(t, auditPath)
+	ts.summary = newRunSummary()
+	if err := ts.generateWorkspace(baseDir, config); err != nil {
+		t.Fatalf("generateWorkspace: %v", err)
+	}
+	if got := ts.readFile(t, auditPath); got != audited {
+		t.Errorf("unchanged workspace audited:\n%s", strings.TrimPrefix(got, audited))
+	}
+	if backups, _ := ts.FS.ReadDir(backupDirOf(t, ts, baseDir)); len(backups) != 1 {
+		t.Errorf("got %d backups after an unchanged run, want one", len(backups))
+	}
+	if ts.summary.Workspace != workspaceUnchanged {
+		t.Errorf("workspace %s, want %s", ts.summary.Workspace, workspaceUnchanged)
+	}
+	if again := ts.readFile(t, path); again != merged {
+		t.Errorf("regenerated workspace changed:\n%s\nwant:\n%s", again, merged)
+	}
+}
+
+// backupDirOf returns the workspace backup directory of baseDir
+func backupDirOf(t *testing.T, ts *testSetup, baseDir string) string {
+	t.Helper()
+	dir, err := ts.backupDir(baseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
@@ -0,0 +1,125 @@
+package setup
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// bareRepoDir returns the bare repository of a worktrees repository,
+// its worktrees are checked out next to it
+func bareRepoDir(repoDir string) string {
+	return filepath.Join(repoDir, ".bare")
+}
+
+// worktreeDirName returns the directory name of a branch worktree
+func worktreeDirName(branch string) string {
+	return strings.ReplaceAll(branch, "/", "-")
+}
+
+// worktreeDirs returns the checkout directories of all worktrees
+func worktreeDirs(repoDir string, repo Repository) []string {
+	dirs := make([]string, 0, len(repo.Worktrees))
+	for _, branch := range repo.Worktrees {
+		dirs = append(dirs, filepath.Join(repoDir, worktreeDirName(branch)))
+	}
+	return dirs
+}
+
+// validateWorktrees checks the worktrees option of a repository
+func validateWorktrees(repo Repository) error {
+	if len(repo.Worktrees) == 0 {
+		return nil
+	}
+	if repo.Type != "git-repo" {
+		return configErrorf("worktrees require git-repo type for %s", repo.Name)
+	}
+	if repo.Submodules || len(repo.SparsePaths) > 0 || repo.LFS {
+		return configErrorf("worktrees cannot be combined with submodules, sparse-paths or lfs for %s", repo.Name)
+	}
+
+	seen := map[string]string{}
+	for _, branch := range repo.Worktrees {
+		name := worktreeDirName(branch)
+		if branch == "" || name == ".bare" || strings.HasPrefix(branch, "-") {
+			return configErrorf("invalid worktree branch '%s' for %s", branch, repo.Name)
+		}
+		if prev, ok := seen[name]; ok {
+			return configErrorf("worktree branches '%s' and '%s' of %s use the same directory", prev, branch, repo.Name)
+		}
+		seen[name] = branch
+	}
+	return nil
+}
+
+// cloneWorktreeRepo clones a bare repository into repoDir/.bare and adds
+// one worktree per configured branch
+func (s *Setup) cloneWorktreeRepo(repoDir string, config *Config, repo Repository) error {
+	policy, err := s.cloneRetryPolicy(config)
+	if err != nil {
+		return err
+	}
+
+	source := *repo.GitRepo
+	fromMirror := s.hasMirror(s.opts.MirrorDir, repo)
+	if fromMirror {
+		source = mirrorPath(s.opts.MirrorDir, repo)
+		fmt.Fprintf(s.Out, "Cloning %s from mirror %s\n", repo.Name, source)
+	}
+
+	bareDir := bareRepoDir(repoDir)
+	args := append(proxyArgs(config.Proxy, *repo.GitRepo), "clone", "--bare", source, bareDir)
+	s.trackCreated(repoDir)
+	err = s.withRetry(policy, "clone of "+repo.Name, func() error {
+		// Remove leftovers of a failed attempt, repoDir did not exist before
+		if err := s.FS.RemoveAll(repoDir); err != nil {
+			return err
+		}
+		return s.runGit(opClone, "", args...)
+	})
+	s.audit(auditClone, bareDir, source, err)
+	if err != nil {
+		return gitErrorf("failed to clone repository %s: %w%s", repo.Name, err, sshHint(source))
+	}
+
+	if fromMirror {
+		// Point origin back to upstream so fetch and push work as usual
+		if err := s.runGit(opInit, bareDir, "remote", "set-url", "origin", *repo.GitRepo); err != nil {
+			return gitErrorf("failed to set origin URL for %s: %w", repo.Name, err)
+		}
+	}
+
+	// A bare clone fetches nothing later, track upstream branches as usual
+	if err := s.runGit(opInit, bareDir, "config", "remote.origin.fetch", "+refs/heads/*:refs/remotes/origin/*"); err != nil {
+		return gitErrorf("failed to configure fetch of %s: %w", repo.Name, err)
+	}
+
+	return s.addWorktrees(repoDir, config.Proxy, repo, false)
+}
+
+// addWorktrees adds missing branch worktrees, fetching first when the
+// repository was cloned by an earlier run
+func (s *Setup) addWorktrees(repoDir, proxy string, repo Repository, fetch bool) error {
+	bareDir := bareRepoDir(repoDir)
+	fetched := false
+	for i, dir := range worktreeDirs(repoDir, repo) {
+		if s.exists(dir) {
+			continue
+		}
+
+		if fetch && !fetched {
+			args := append(proxyArgs(proxy, *repo.GitRepo), "fetch", "origin")
+			if err := s.runGit(opClone, bareDir, args...); err != nil {
+				return gitErrorf("failed to fetch %s: %w", repo.Name, err)
+			}
+			fetched = true
+		}
+
+		branch := repo.Worktrees[i]
+		fmt.Fprintf(s.Out, "Adding worktree %s of %s\n", branch, repo.Name)
+		if err := s.runGit(opInit, bareDir, "worktree", "add", dir, branch); err != nil {
+			return gitErrorf("failed to add worktree %s of %s: %w", branch, repo.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package version
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// CycloneDX JSON document, only the fields needed to describe a Go binary
+// (https://cyclonedx.org/docs/1.5/json/)
+type cycloneDXBOM struct {
+	BOMFormat   string               `json:"bomFormat"`
+	SpecVersion string               `json:"specVersion"`
+	Version     int                  `json:"version"`
+	Metadata    cycloneDXMetadata    `json:"metadata"`
+	Components  []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXMetadata struct {
+	Component cycloneDXComponent `json:"component"`
+}
+
+type cycloneDXComponent struct {
+	Type       string              `json:"type"`
+	Name       string              `json:"name"`
+	Version    string              `json:"version,omitempty"`
+	PURL       string              `json:"purl,omitempty"`
+	Properties []cycloneDXProperty `json:"properties,omitempty"`
+}
+
+type cycloneDXProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// goPURL returns the package URL of a Go module
+func goPURL(path, version string) string {
+	purl := "pkg:golang/" + path
+	if version != "" && version != "(devel)" {
+		purl += "@" + version
+	}
+	return purl
+}
+
+// FormatCycloneDX returns a CycloneDX SBOM of the tool and its module
+// dependencies. go.sum hashes are reported as "go:sum" properties since
+// they are directory hashes, not file hashes.
+func FormatCycloneDX(toolName string) string {
+	info := GetBuildInfo()
+
+	tool := cycloneDXComponent{
+		Type:    "application",
+		Name:    toolName,
+		Version: info.Version,
+	}
+	if info.Module != "" {
+		tool.PURL = goPURL(info.Module, "v"+strings.TrimPrefix(info.Version, "v"))
+	}
+	if info.GitCommit != "" {
+		tool.Properties = append(tool.Properties, cycloneDXProperty{Name: "vcs:revision", Value: info.GitCommit})
+	}
+	tool.Properties = append(tool.Properties, cycloneDXProperty{Name: "go:version", Value: info.GoVersion})
+
+	bom := cycloneDXBOM{
+		BOMFormat:   "CycloneDX",
+		SpecVersion: "1.5",
+		Version:     1,
+		Metadata:    cycloneDXMetadata{Component: tool},
+		Components:  []cycloneDXComponent{},
+	}
+	for _, dep := range info.Dependencies {
+		c := cycloneDXComponent{
+			Type:    "library",
+			Name:    dep.Path,
+			Version: dep.Version,
+			PURL:    goPURL(dep.Path, dep.Version),
+		}
+		if dep.Sum != "" {
+			c.Properties = append(c.Properties, cycloneDXProperty{Name: "go:sum", Value: dep.Sum})
+		}
+		if dep.Replace != "" {
+			c.Properties = append(c.Properties, cycloneDXProperty{Name: "go:replace", Value: dep.Replace})
+		}
+		bom.Components = append(bom.Components, c)
+	}
+
+	data, err := json.MarshalIndent(bom, "", "  ")
+	if err != nil {
+		// Only plain strings and ints, marshaling cannot fail
+		panic(err)
+	}
+	return string(data)
+}
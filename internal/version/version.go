@@ -1,30 +1,64 @@
 package version
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"runtime"
 	"runtime/debug"
+	"strconv"
+	"strings"
 )
 
-// Version is the semantic version
-const Version = "0.1.0"
+// Build metadata, settable at build time, e.g.
+//
+//	go build -ldflags "-X github.com/mj41/stai-vscode/internal/version.Version=0.2.0
+//	  -X github.com/mj41/stai-vscode/internal/version.GitCommit=$(git rev-parse --short=12 HEAD)
+//	  -X github.com/mj41/stai-vscode/internal/version.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// GitCommit and BuildTime fall back to the VCS information recorded by
+// the Go toolchain (debug.ReadBuildInfo).
+var (
+	Version   = "0.1.0" // Semantic version
+	GitCommit = ""
+	BuildTime = ""
+)
+
+// Release channels, insiders releases carry a pre-release suffix
+// (e.g. "0.2.0-insiders.1") and are published as GitHub pre-releases
+const (
+	ChannelStable   = "stable"
+	ChannelInsiders = "insiders"
+)
 
 // BuildInfo contains version and build information
 type BuildInfo struct {
-	Version   string
-	GitCommit string
-	GitDirty  bool
-	BuildTime string
-	GoVersion string
-	Module    string
+	Version   string `json:"version"`
+	GitCommit string `json:"git-commit"`
+	GitDirty  bool   `json:"git-dirty"`
+	BuildTime string `json:"build-time"`
+	GoVersion string `json:"go-version"`
+	Module    string `json:"module"`
+	Channel   string `json:"channel"`
+
+	Dependencies []Dependency `json:"dependencies,omitempty"`
 }
 
-// GetBuildInfo returns build information using debug.ReadBuildInfo()
+// Dependency is a module linked into the binary
+type Dependency struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	Sum     string `json:"sum,omitempty"`     // go.sum hash, e.g. "h1:..."
+	Replace string `json:"replace,omitempty"` // Replacement module path and version
+}
+
+// GetBuildInfo returns build information, values injected via -ldflags
+// take precedence over debug.ReadBuildInfo()
 func GetBuildInfo() BuildInfo {
 	info := BuildInfo{
 		Version:   Version,
 		GoVersion: runtime.Version(),
+		Channel:   ChannelOf(Version),
 	}
 
 	if buildInfo, ok := debug.ReadBuildInfo(); ok {
@@ -44,11 +78,87 @@ func GetBuildInfo() BuildInfo {
 				info.BuildTime = setting.Value
 			}
 		}
+
+		for _, dep := range buildInfo.Deps {
+			d := Dependency{Path: dep.Path, Version: dep.Version, Sum: dep.Sum}
+			if dep.Replace != nil {
+				d.Replace = strings.TrimSpace(dep.Replace.Path + " " + dep.Replace.Version)
+				d.Sum = dep.Replace.Sum
+			}
+			info.Dependencies = append(info.Dependencies, d)
+		}
+	}
+
+	if GitCommit != "" {
+		info.GitCommit = GitCommit
+	}
+	if BuildTime != "" {
+		info.BuildTime = BuildTime
 	}
 
 	return info
 }
 
+// ChannelOf returns the release channel of a version
+func ChannelOf(v string) string {
+	if strings.Contains(strings.TrimPrefix(v, "v"), "-") {
+		return ChannelInsiders
+	}
+	return ChannelStable
+}
+
+// Compare compares two semantic versions (with optional "v" prefix) and
+// returns -1, 0 or 1. A pre-release sorts before its release.
+func Compare(a, b string) int {
+	aCore, aPre, _ := strings.Cut(strings.TrimPrefix(a, "v"), "-")
+	bCore, bPre, _ := strings.Cut(strings.TrimPrefix(b, "v"), "-")
+
+	if c := compareDotted(aCore, bCore); c != 0 {
+		return c
+	}
+	switch {
+	case aPre == bPre:
+		return 0
+	case aPre == "":
+		return 1
+	case bPre == "":
+		return -1
+	}
+	return compareDotted(aPre, bPre)
+}
+
+// compareDotted compares dot separated identifiers, numerically when
+// both are numbers
+func compareDotted(a, b string) int {
+	aParts := strings.Split(a, ".")
+	bParts := strings.Split(b, ".")
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		if i >= len(aParts) {
+			return -1
+		}
+		if i >= len(bParts) {
+			return 1
+		}
+		aNum, aErr := strconv.Atoi(aParts[i])
+		bNum, bErr := strconv.Atoi(bParts[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if aNum != bNum {
+				if aNum < bNum {
+					return -1
+				}
+				return 1
+			}
+		case aParts[i] != bParts[i]:
+			if aParts[i] < bParts[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
 // FormatVersion returns a formatted version string for a tool
 func FormatVersion(toolName string) string {
 	info := GetBuildInfo()
@@ -72,6 +182,65 @@ func FormatVersion(toolName string) string {
 	return versionStr
 }
 
+// FormatVersionJSON returns version information of a tool as indented JSON
+func FormatVersionJSON(toolName string) string {
+	out := struct {
+		Tool string `json:"tool"`
+		BuildInfo
+	}{
+		Tool:      toolName,
+		BuildInfo: GetBuildInfo(),
+	}
+
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		// Only plain strings and bools, marshaling cannot fail
+		panic(err)
+	}
+	return string(data)
+}
+
+// FormatDependencies returns one "path version sum" line per module
+// dependency
+func FormatDependencies() string {
+	info := GetBuildInfo()
+	if len(info.Dependencies) == 0 {
+		return "no module dependencies"
+	}
+
+	var lines []string
+	for _, dep := range info.Dependencies {
+		line := strings.TrimSpace(fmt.Sprintf("%s %s %s", dep.Path, dep.Version, dep.Sum))
+		if dep.Replace != "" {
+			line += " => " + dep.Replace
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// FormatVersionVerbose returns the version string followed by the
+// module dependencies linked into the binary
+func FormatVersionVerbose(toolName string) string {
+	info := GetBuildInfo()
+
+	var b strings.Builder
+	b.WriteString(FormatVersion(toolName))
+	if info.Module != "" {
+		fmt.Fprintf(&b, "\nmodule %s", info.Module)
+	}
+	if len(info.Dependencies) == 0 {
+		b.WriteString("\nno module dependencies")
+	}
+	for _, dep := range info.Dependencies {
+		fmt.Fprintf(&b, "\ndep %s %s", dep.Path, dep.Version)
+		if dep.Replace != "" {
+			fmt.Fprintf(&b, " => %s", dep.Replace)
+		}
+	}
+	return b.String()
+}
+
 // ShowVersionAndExit displays version information and exits
 func ShowVersionAndExit(toolName string) {
 	fmt.Println(FormatVersion(toolName))
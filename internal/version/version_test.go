@@ -0,0 +1,45 @@
+package version
+
+import "testing"
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"v1.2.3", "1.2.3", 0},
+		{"1.2.3", "1.2.4", -1},
+		{"1.10.0", "1.9.0", 1},
+		{"2.0", "1.99.99", 1},
+		{"1.2", "1.2.0", -1},
+		{"1.2.3-rc.1", "1.2.3", -1},
+		{"1.2.3", "1.2.3-rc.1", 1},
+		{"1.2.3-rc.2", "1.2.3-rc.10", -1},
+		{"1.2.3-alpha", "1.2.3-beta", -1},
+		{"1.2.3-rc.1", "1.2.3-rc.1", 0},
+	}
+	for _, tt := range tests {
+		if got := Compare(tt.a, tt.b); got != tt.want {
+			t.Errorf("Compare(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := Compare(tt.b, tt.a); got != -tt.want {
+			t.Errorf("Compare(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestChannelOf(t *testing.T) {
+	tests := []struct {
+		version string
+		want    string
+	}{
+		{"v1.2.3", ChannelStable},
+		{"1.2.3-insiders.1", ChannelInsiders},
+	}
+	for _, tt := range tests {
+		if got := ChannelOf(tt.version); got != tt.want {
+			t.Errorf("ChannelOf(%q) = %q, want %q", tt.version, got, tt.want)
+		}
+	}
+}